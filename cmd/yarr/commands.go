@@ -0,0 +1,321 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/archive"
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/server/opml"
+	"github.com/nkanaev/yarr/src/storage"
+	"github.com/nkanaev/yarr/src/worker"
+)
+
+// commandEnv is what the one-off commands (run instead of the server) need.
+type commandEnv struct {
+	db string
+	// auth cookie for the api of the running server, if any
+	cookie string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// lockStorage locks and opens the database. Returns *lockedError
+// if it's used by another process.
+func (env commandEnv) lockStorage() (*storage.Storage, func(), error) {
+	lock, err := lockDB(env.db, instance{PID: os.Getpid()})
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := storage.New(env.db)
+	if err != nil {
+		unlockDB(lock)
+		return nil, nil, err
+	}
+	closer := func() {
+		store.Close()
+		unlockDB(lock)
+	}
+	return store, closer, nil
+}
+
+// openStorage locks and opens the database. If it's held by the server,
+// the server's api client is returned instead.
+func (env commandEnv) openStorage() (*storage.Storage, func(), *remote, error) {
+	store, closer, err := env.lockStorage()
+	if locked, ok := err.(*lockedError); ok && locked.holder.Addr != "" {
+		return nil, nil, newRemote(locked.holder, env.cookie), nil
+	}
+	return store, closer, nil, err
+}
+
+// runAddFeed subscribes to the feed at the url and prints its id and title.
+// If the page links to several feeds, the candidates are printed instead.
+func runAddFeed(env commandEnv, url, folder string) int {
+	store, closer, api, err := env.openStorage()
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to open the database:", err)
+		return 1
+	}
+
+	var feed *storage.Feed
+	var sources []worker.FeedSource
+	if api != nil {
+		feed, sources, err = addFeedRemote(api, url, folder)
+	} else {
+		defer closer()
+		var folderID *int64
+		if folder != "" {
+			if f := store.CreateFolder(folder); f != nil {
+				folderID = &f.Id
+			}
+		}
+		feed, sources, err = worker.NewWorker(store).AddFeed(context.Background(), url, folderID, storage.FeedSettings{})
+	}
+
+	switch {
+	case err != nil:
+		fmt.Fprintln(env.stderr, "Failed to add the feed:", err)
+		return 1
+	case len(sources) > 0:
+		fmt.Fprintln(env.stderr, "The page links to several feeds, pick one:")
+		for _, source := range sources {
+			fmt.Fprintf(env.stdout, "%s\t%s\n", source.Url, source.Title)
+		}
+		return 1
+	}
+	fmt.Fprintf(env.stdout, "%d\t%s\n", feed.Id, feed.Title)
+	return 0
+}
+
+func addFeedRemote(api *remote, url, folder string) (*storage.Feed, []worker.FeedSource, error) {
+	form := map[string]interface{}{"url": url}
+	if folder != "" {
+		var f storage.Folder
+		if err := api.call("POST", "/api/folders", map[string]string{"title": folder}, &f); err != nil {
+			return nil, nil, err
+		}
+		form["folder_id"] = f.Id
+	}
+	var result struct {
+		Status string              `json:"status"`
+		Feed   *storage.Feed       `json:"feed"`
+		Choice []worker.FeedSource `json:"choice"`
+	}
+	if err := api.call("POST", "/api/feeds", form, &result); err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case result.Status == "multiple":
+		return nil, result.Choice, nil
+	case result.Status == "success" && result.Feed != nil:
+		return result.Feed, nil, nil
+	}
+	return nil, nil, errors.New("No feeds found at the given url")
+}
+
+// runExportOPML writes the subscriptions to the file.
+func runExportOPML(env commandEnv, path string) int {
+	store, closer, err := env.lockStorage()
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to open the database:", err)
+		return 1
+	}
+	defer closer()
+
+	feeds := store.ListFeeds()
+	doc := opml.Export(feeds, store.ListFolders())
+	if err := os.WriteFile(path, []byte(doc.OPML()), 0644); err != nil {
+		fmt.Fprintln(env.stderr, "Failed to write the file:", err)
+		return 1
+	}
+	fmt.Fprintf(env.stdout, "Exported %d feeds to %s\n", len(feeds), path)
+	return 0
+}
+
+// runImportOPML subscribes to the feeds of the file not subscribed to yet
+// (by the feed url) and, if asked, fetches them. Fails if any of the feeds
+// in the file are invalid.
+func runImportOPML(env commandEnv, path string, fetch bool) int {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to open the file:", err)
+		return 1
+	}
+	defer file.Close()
+	doc, err := opml.Parse(file)
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to parse the file:", err)
+		return 1
+	}
+
+	store, closer, err := env.lockStorage()
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to open the database:", err)
+		return 1
+	}
+	defer closer()
+
+	result := importOPML(store, doc)
+	fmt.Fprintf(env.stdout, "Added %d, skipped %d, invalid %d\n", len(result.added), result.skipped, result.invalid)
+
+	if fetch && len(result.added) > 0 {
+		w := worker.NewWorker(store)
+		summary, _ := w.RefreshFeedsAndWait(result.added)
+		for _, feed := range result.added {
+			w.FindFeedFavicon(feed)
+		}
+		w.Stop()
+		fmt.Fprintf(env.stdout, "Fetched %d feeds: %d new items, %d errors\n", summary.Feeds, summary.NewItems, summary.Errors)
+	}
+
+	if result.invalid > 0 {
+		return 1
+	}
+	return 0
+}
+
+type opmlImportResult struct {
+	added   []storage.Feed
+	skipped int
+	invalid int
+}
+
+func importOPML(store *storage.Storage, doc opml.Folder) opmlImportResult {
+	result := opmlImportResult{added: make([]storage.Feed, 0)}
+	known := make(map[string]bool)
+	for _, feed := range store.ListFeeds() {
+		known[feed.FeedLink] = true
+	}
+
+	add := func(feeds []opml.Feed, folderID *int64) {
+		for _, f := range feeds {
+			link := strings.TrimSpace(f.FeedUrl)
+			switch {
+			case !htmlutil.IsAPossibleLink(link):
+				result.invalid++
+			case known[link]:
+				result.skipped++
+			default:
+				feed := store.CreateFeed(f.Title, "", f.SiteUrl, link, folderID)
+				if feed == nil {
+					result.invalid++
+					continue
+				}
+				known[link] = true
+				result.added = append(result.added, *feed)
+			}
+		}
+	}
+
+	add(doc.Feeds, nil)
+	for _, f := range doc.Folders {
+		var folderID *int64
+		if title := strings.TrimSpace(f.Title); title != "" {
+			if folder := store.CreateFolder(title); folder != nil {
+				folderID = &folder.Id
+			}
+		}
+		// nested folders are flattened, same as in the web ui
+		add(f.AllFeeds(), folderID)
+	}
+	return result
+}
+
+// runExportArchive writes the whole instance to the archive file,
+// gzipped if the path ends with .gz.
+func runExportArchive(env commandEnv, path string) int {
+	store, closer, err := env.lockStorage()
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to open the database:", err)
+		return 1
+	}
+	defer closer()
+
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to create the file:", err)
+		return 1
+	}
+	var out io.WriteCloser = file
+	if strings.HasSuffix(path, ".gz") {
+		out = gzip.NewWriter(file)
+	}
+	counts, err := archive.Export(store, out)
+	if err == nil && out != file {
+		err = out.Close()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to write the file:", err)
+		return 1
+	}
+	fmt.Fprintf(env.stdout, "Exported %d folders, %d feeds, %d items to %s\n", counts.Folders, counts.Feeds, counts.Items, path)
+	return 0
+}
+
+// runImportArchive restores the archive file into the empty database.
+func runImportArchive(env commandEnv, path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to open the file:", err)
+		return 1
+	}
+	defer file.Close()
+
+	store, closer, err := env.lockStorage()
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to open the database:", err)
+		return 1
+	}
+	defer closer()
+
+	counts, err := archive.Import(store, file)
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to restore the archive:", err)
+		return 1
+	}
+	fmt.Fprintf(env.stdout, "Restored %d folders, %d feeds, %d items\n", counts.Folders, counts.Feeds, counts.Items)
+	return 0
+}
+
+// runRefreshOnce refreshes the feeds due for it and prints the summary.
+// Fails if more than failThreshold percent of the feeds failed.
+func runRefreshOnce(env commandEnv, failThreshold int) int {
+	store, closer, err := env.lockStorage()
+	if err != nil {
+		fmt.Fprintln(env.stderr, "Failed to open the database:", err)
+		return 1
+	}
+	defer closer()
+
+	start := time.Now()
+	w := worker.NewWorker(store)
+	feeds := store.ListFeeds()
+	due := w.DueFeeds(feeds, start)
+	summary, ok := w.RefreshFeedsAndWait(due)
+	w.Stop()
+	if !ok {
+		fmt.Fprintln(env.stderr, "Failed to refresh the feeds")
+		return 1
+	}
+
+	fmt.Fprintf(
+		env.stdout,
+		"Refreshed %d feeds (%d ok, %d failed, %d not due), %d new items in %s\n",
+		summary.Feeds, summary.Feeds-summary.Errors, summary.Errors, len(feeds)-len(due),
+		summary.NewItems, time.Since(start).Round(time.Millisecond),
+	)
+	if summary.Errors*100 > summary.Feeds*failThreshold {
+		return 1
+	}
+	return 0
+}
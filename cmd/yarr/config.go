@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/server/auth"
+	"github.com/nkanaev/yarr/src/worker"
+)
+
+// The config file is a subset of TOML: `key = value` pairs, one per line,
+// with the keys named after the command line flags (see doc/config.md).
+// The values are strings, integers or booleans. Double-quoted strings may
+// refer to the environment variables as ${NAME} or ${NAME:-default}.
+
+// commandFlags are the one-off commands, not allowed in the config file.
+var commandFlags = map[string]bool{
+	"config":         true,
+	"print-config":   true,
+	"version":        true,
+	"add-feed":       true,
+	"folder":         true,
+	"export-opml":    true,
+	"import-opml":    true,
+	"export-archive": true,
+	"import-archive": true,
+	"fetch":          true,
+	"refresh-once":   true,
+}
+
+// configChecks validate the values parsed after the flags, so that
+// the errors point to the offending key of the file.
+var configChecks = map[string]func(string) error{
+	"auth": func(value string) error {
+		_, _, err := parseAuthfile(strings.NewReader(value))
+		return err
+	},
+	"auth-max-attempts": checkPositiveInt,
+	"auth-lockout":      checkDuration,
+	"fetch-timeout":     checkDuration,
+	"workers":           checkPositiveInt,
+	"socket-mode": func(value string) error {
+		_, err := strconv.ParseUint(value, 8, 32)
+		return err
+	},
+	"log-level": func(value string) error {
+		_, err := logger.ParseLevel(value)
+		return err
+	},
+	"log-format": func(value string) error {
+		if value != "text" && value != "json" {
+			return fmt.Errorf("expected text or json")
+		}
+		return nil
+	},
+	"trusted-proxies": func(value string) error {
+		_, err := auth.ParseTrustedProxies(value)
+		return err
+	},
+	"proxy": func(value string) error {
+		if value == "" {
+			return nil
+		}
+		_, err := worker.ParseProxyURL(value)
+		return err
+	},
+}
+
+func checkPositiveInt(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n < 1 {
+		return fmt.Errorf("expected a positive integer")
+	}
+	return nil
+}
+
+func checkDuration(value string) error {
+	_, err := time.ParseDuration(value)
+	return err
+}
+
+type configEntry struct {
+	key   string
+	value string
+	line  int
+}
+
+var configKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseConfig reads the entries of the config file.
+func parseConfig(r io.Reader) ([]configEntry, error) {
+	entries := make([]configEntry, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", line)
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected `key = value`", line)
+		}
+		key := strings.TrimSpace(parts[0])
+		if !configKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", line, key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: %s: duplicate key", line, key)
+		}
+		seen[key] = true
+		value, err := parseConfigValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %s", line, key, err)
+		}
+		entries = append(entries, configEntry{key: key, value: value, line: line})
+	}
+	return entries, scanner.Err()
+}
+
+// parseConfigValue returns the value as the flag would take it.
+func parseConfigValue(raw string) (string, error) {
+	var value, rest string
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := 1
+		for ; end < len(raw) && raw[end] != '"'; end++ {
+			if raw[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(raw) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		unquoted, err := strconv.Unquote(raw[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid string: %s", err)
+		}
+		if value, err = expandEnv(unquoted); err != nil {
+			return "", err
+		}
+		rest = raw[end+1:]
+	case strings.HasPrefix(raw, "'"):
+		// literal string, no escapes or variables
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		value, rest = raw[1:end+1], raw[end+2:]
+	default:
+		value = raw
+		if i := strings.IndexByte(raw, '#'); i >= 0 {
+			value = strings.TrimSpace(raw[:i])
+		}
+		if value == "true" || value == "false" {
+			return value, nil
+		}
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "", fmt.Errorf("expected a quoted string, an integer or a boolean, got %q", value)
+		}
+		return value, nil
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after the value", rest)
+	}
+	return value, nil
+}
+
+var envRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${NAME} with the environment variable, failing if it's not set,
+// and ${NAME:-default} with the variable or the default if it's empty or not set.
+func expandEnv(value string) (string, error) {
+	var err error
+	expanded := envRegexp.ReplaceAllStringFunc(value, func(ref string) string {
+		match := envRegexp.FindStringSubmatch(ref)
+		env, ok := os.LookupEnv(match[1])
+		switch {
+		case match[2] != "" && env == "":
+			return match[3]
+		case !ok && err == nil:
+			err = fmt.Errorf("environment variable %s is not set", match[1])
+		}
+		return env
+	})
+	return expanded, err
+}
+
+// loadConfig sets the flags not given on the command line to the values of the config file.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	entries, err := parseConfig(file)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for _, entry := range entries {
+		if fs.Lookup(entry.key) == nil || commandFlags[entry.key] {
+			return fmt.Errorf("%s: line %d: unknown key %q", path, entry.line, entry.key)
+		}
+		if check := configChecks[entry.key]; check != nil {
+			if err := check(entry.value); err != nil {
+				return fmt.Errorf("%s: line %d: %s: %s", path, entry.line, entry.key, err)
+			}
+		}
+		if explicit[entry.key] {
+			continue
+		}
+		if err := fs.Set(entry.key, entry.value); err != nil {
+			return fmt.Errorf("%s: line %d: %s: %s", path, entry.line, entry.key, err)
+		}
+	}
+	return nil
+}
+
+// printConfig writes the effective configuration in the config file format,
+// with the secrets redacted.
+func printConfig(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "# effective configuration (flags > config file > environment variables > defaults)")
+	fs.VisitAll(func(f *flag.Flag) {
+		if commandFlags[f.Name] {
+			return
+		}
+		value := f.Value.String()
+		switch f.Name {
+		case "auth":
+			if value != "" {
+				value = "REDACTED"
+			}
+		case "proxy":
+			if u, err := url.Parse(value); err == nil {
+				value = u.Redacted()
+			}
+		}
+		if getter, ok := f.Value.(flag.Getter); ok {
+			switch getter.Get().(type) {
+			case bool, int:
+				fmt.Fprintf(w, "%s = %s\n", f.Name, value)
+				return
+			}
+		}
+		fmt.Fprintf(w, "%s = %s\n", f.Name, strconv.Quote(value))
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// instance describes the yarr process holding the database.
+// Addr is set if it's the server, so that the others can use its api.
+type instance struct {
+	PID      int    `json:"pid"`
+	Addr     string `json:"addr,omitempty"`
+	BasePath string `json:"base_path,omitempty"`
+	TLS      bool   `json:"tls,omitempty"`
+}
+
+type lockedError struct {
+	holder instance
+}
+
+func (e *lockedError) Error() string {
+	if e.holder.Addr != "" {
+		return fmt.Sprintf("the database is used by the yarr server at %s (pid %d)", e.holder.Addr, e.holder.PID)
+	}
+	return fmt.Sprintf("the database is used by another yarr process (pid %d)", e.holder.PID)
+}
+
+// lockDB takes the lock file next to the database, so that it isn't used
+// by several yarr processes at once. The lock is held until the file is closed
+// (or the process exits). Returns *lockedError if somebody else holds it.
+func lockDB(db string, self instance) (*os.File, error) {
+	path := db + ".lock"
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := tryLock(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !locked {
+		file.Close()
+		holder := instance{}
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, &holder)
+		}
+		return nil, &lockedError{holder: holder}
+	}
+
+	data, err := json.Marshal(self)
+	if err == nil {
+		if err = file.Truncate(0); err == nil {
+			_, err = file.WriteAt(data, 0)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// unlockDB releases the lock. The file itself is kept,
+// removing it would race with the processes waiting for it.
+func unlockDB(file *os.File) {
+	file.Truncate(0)
+	file.Close()
+}
@@ -2,17 +2,28 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	_ "time/tzdata" // for the feed time zones on systems without the database
 
+	"github.com/nkanaev/yarr/src/logger"
 	"github.com/nkanaev/yarr/src/platform"
 	"github.com/nkanaev/yarr/src/server"
+	"github.com/nkanaev/yarr/src/server/auth"
 	"github.com/nkanaev/yarr/src/storage"
+	"github.com/nkanaev/yarr/src/worker"
 )
 
 var Version string = "0.0"
@@ -47,8 +58,19 @@ func parseAuthfile(authfile io.Reader) (username, password string, err error) {
 func main() {
 	platform.FixConsoleIfNeeded()
 
-	var addr, db, authfile, auth, certfile, keyfile, basepath, logfile string
-	var ver, open bool
+	var addr, db, authfile, authstr, certfile, keyfile, basepath, logfile string
+	var authMaxAttempts, authLockout, trustedProxies string
+	var authHeader, logoutURL string
+	var socketMode, corsOrigins string
+	var logLevel, logFormat string
+	var addFeed, folder, exportOPML, importOPML string
+	var exportArchive, importArchive string
+	var fetch, refreshOnce bool
+	var refreshFailThreshold int
+	var proxy, fetchTimeout, discoverTimeout, numWorkers string
+	var clientCert, clientKey, dnsCache, dnsServer, network, maxRedirects, pageCacheMB string
+	var configFile string
+	var ver, open, showConfig, allowExecHooks, disableKeepAlives, debugHTTP, allowLocalFeeds bool
 
 	flag.CommandLine.SetOutput(os.Stdout)
 
@@ -60,14 +82,49 @@ func main() {
 		fmt.Fprintln(out, " ", strings.Join(OptList, ", "))
 	}
 
-	flag.StringVar(&addr, "addr", opt("YARR_ADDR", "127.0.0.1:7070"), "address to run server on")
+	flag.StringVar(&configFile, "config", opt("YARR_CONFIG", ""), "`path` to the config file, its values are overridden by the flags given")
+	flag.BoolVar(&showConfig, "print-config", false, "print the effective configuration (secrets redacted) and exit")
+	flag.StringVar(&addr, "addr", opt("YARR_ADDR", "127.0.0.1:7070"), "address to run server on (`host:port` or unix:/path/to/socket)")
+	flag.StringVar(&socketMode, "socket-mode", opt("YARR_SOCKET_MODE", "0660"), "file `mode` of the unix socket (when --addr is unix:/path/to/socket)")
 	flag.StringVar(&basepath, "base", opt("YARR_BASE", ""), "base path of the service url")
 	flag.StringVar(&authfile, "auth-file", opt("YARR_AUTHFILE", ""), "`path` to a file containing username:password. Takes precedence over --auth (or YARR_AUTH)")
-	flag.StringVar(&auth, "auth", opt("YARR_AUTH", ""), "string with username and password in the format `username:password`")
+	flag.StringVar(&authstr, "auth", opt("YARR_AUTH", ""), "string with username and password in the format `username:password`")
+	flag.StringVar(&authMaxAttempts, "auth-max-attempts", opt("YARR_AUTH_MAX_ATTEMPTS", "5"), "number of failed logins per ip/username before locking out")
+	flag.StringVar(&authLockout, "auth-lockout", opt("YARR_AUTH_LOCKOUT", "30s"), "initial lockout `duration`, doubled after every subsequent failure (up to 1h)")
+	flag.StringVar(&trustedProxies, "trusted-proxies", opt("YARR_TRUSTED_PROXIES", ""), "comma-separated list of proxy ips/cidrs allowed to set X-Forwarded-For")
+	flag.StringVar(&authHeader, "auth-header", opt("YARR_AUTH_HEADER", ""), "name of the `header` (e.g. Remote-User) with the user authenticated by a reverse proxy. Requires --trusted-proxies")
+	flag.StringVar(&logoutURL, "logout-url", opt("YARR_LOGOUT_URL", ""), "`url` to redirect to on logout when using --auth-header")
+	flag.StringVar(&corsOrigins, "cors-origins", opt("YARR_CORS_ORIGINS", ""), "comma-separated list of origins allowed to access the api (e.g. https://app.example.com,https://*.example.com)")
 	flag.StringVar(&certfile, "cert-file", opt("YARR_CERTFILE", ""), "`path` to cert file for https")
 	flag.StringVar(&keyfile, "key-file", opt("YARR_KEYFILE", ""), "`path` to key file for https")
 	flag.StringVar(&db, "db", opt("YARR_DB", ""), "storage file `path`")
 	flag.StringVar(&logfile, "log-file", opt("YARR_LOGFILE", ""), "`path` to log file to use instead of stdout")
+	flag.StringVar(&logLevel, "log-level", opt("YARR_LOG_LEVEL", "info"), "minimum log `level`: debug (includes http requests), info, warn or error")
+	flag.StringVar(&logFormat, "log-format", opt("YARR_LOG_FORMAT", "text"), "log `format`: text or json")
+	flag.StringVar(&discoverTimeout, "discover-timeout", opt("YARR_DISCOVER_TIMEOUT", "20s"), "time limit (`duration`) for looking for the feed to subscribe to, the pages linked to included")
+	flag.StringVar(&proxy, "proxy", opt("YARR_PROXY", ""), "proxy `url` for fetching feeds (http, https or socks5), instead of HTTP_PROXY/HTTPS_PROXY")
+	flag.StringVar(&fetchTimeout, "fetch-timeout", opt("YARR_FETCH_TIMEOUT", "30s"), "time limit (`duration`) for fetching a feed, a favicon or an image")
+	flag.StringVar(&clientCert, "client-cert", opt("YARR_CLIENT_CERT", ""), "`path` to the pem certificate for the feeds requiring one (mutual tls)")
+	flag.StringVar(&clientKey, "client-key", opt("YARR_CLIENT_KEY", ""), "`path` to the pem key of -client-cert")
+	flag.StringVar(&dnsCache, "dns-cache", opt("YARR_DNS_CACHE", "5m"), "how long (`duration`) the addresses of the hosts are cached, off to resolve them on every connection")
+	flag.StringVar(&dnsServer, "dns-server", opt("YARR_DNS_SERVER", ""), "dns server (`host:port`, e.g. 9.9.9.9:53) resolving the hosts fetched from, instead of the system's resolver")
+	flag.StringVar(&network, "network", opt("YARR_NETWORK", "auto"), "`network` to connect to the hosts over: ipv4 or ipv6 only, or auto for both")
+	flag.StringVar(&maxRedirects, "max-redirects", opt("YARR_MAX_REDIRECTS", "10"), "`number` of redirects followed before giving up on a request")
+	flag.BoolVar(&disableKeepAlives, "disable-keepalives", opt("YARR_DISABLE_KEEPALIVES", "") == "true", "open a new connection for every request instead of reusing them (and not using http/2)")
+	flag.BoolVar(&debugHTTP, "debug-http", opt("YARR_DEBUG_HTTP", "") == "true", "log the requests of every feed fetch (the headers, the status, the size and the timing), as with the feeds' debug setting")
+	flag.StringVar(&pageCacheMB, "page-cache-mb", opt("YARR_PAGE_CACHE_MB", "32"), "`size` (in MB) of the cache of the article pages fetched for their full content, 0 to turn it off")
+	flag.StringVar(&numWorkers, "workers", opt("YARR_WORKERS", ""), "`number` of feeds fetched at once, up to 64 (default: the number of cpus, at least 4)")
+	flag.BoolVar(&allowExecHooks, "allow-exec-hooks", opt("YARR_ALLOW_EXEC_HOOKS", "") == "true", "run the per-feed commands on new items (the commands are set via the api, enable only if it's trusted)")
+	flag.BoolVar(&allowLocalFeeds, "allow-local-feeds", opt("YARR_ALLOW_LOCAL_FEEDS", "") == "true", "read the feeds from the local files (file:// urls and absolute paths), enable only if the users are trusted with the server's files")
+	flag.StringVar(&addFeed, "add-feed", "", "subscribe to the feed at the `url` and exit (uses the api of the running server, if any)")
+	flag.StringVar(&folder, "folder", "", "folder `title` for --add-feed, created if missing")
+	flag.StringVar(&exportOPML, "export-opml", "", "write the subscriptions to the opml file at `path` and exit")
+	flag.StringVar(&importOPML, "import-opml", "", "import the subscriptions from the opml file at `path` and exit")
+	flag.StringVar(&exportArchive, "export-archive", "", "write everything (feeds, items, settings) to the archive at `path` (gzipped if it ends with .gz) and exit")
+	flag.StringVar(&importArchive, "import-archive", "", "restore the archive at `path` into the empty database and exit")
+	flag.BoolVar(&fetch, "fetch", false, "fetch the feeds imported with --import-opml")
+	flag.BoolVar(&refreshOnce, "refresh-once", false, "refresh the feeds and exit, e.g. when run by cron")
+	flag.IntVar(&refreshFailThreshold, "refresh-fail-threshold", 50, "exit with an error if more than `percent` of the feeds fail to refresh with --refresh-once")
 	flag.BoolVar(&ver, "version", false, "print application version")
 	flag.BoolVar(&open, "open", false, "open the server in browser")
 	flag.Parse()
@@ -77,17 +134,36 @@ func main() {
 		return
 	}
 
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	if configFile != "" {
+		if err := loadConfig(flag.CommandLine, configFile); err != nil {
+			log.Fatal("Failed to load config: ", err)
+		}
+	}
+
+	level, err := logger.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatal("Failed to parse log level: ", err)
+	}
+	if err := logger.SetFormat(logFormat); err != nil {
+		log.Fatal("Failed to parse log format: ", err)
+	}
+	logger.SetLevel(level)
 	if logfile != "" {
 		file, err := os.OpenFile(logfile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 		if err != nil {
 			log.Fatal("Failed to setup log file: ", err)
 		}
 		defer file.Close()
-		log.SetOutput(file)
+		logger.SetOutput(file)
+	} else if addFeed != "" || exportOPML != "" || importOPML != "" || exportArchive != "" || importArchive != "" || refreshOnce || showConfig {
+		// stdout is for the command output
+		logger.SetOutput(os.Stderr)
 	} else {
-		log.SetOutput(os.Stdout)
+		logger.SetOutput(os.Stdout)
 	}
+	// the rest of the standard logger output is mostly errors
+	log.SetFlags(log.Lshortfile)
+	log.SetOutput(logger.Writer(logger.LevelError))
 
 	configPath, err := os.UserConfigDir()
 	if err != nil {
@@ -102,7 +178,7 @@ func main() {
 		db = filepath.Join(storagePath, "storage.db")
 	}
 
-	log.Printf("using db file %s", db)
+	logger.Infof("using db file %s", db)
 
 	var username, password string
 	if authfile != "" {
@@ -115,28 +191,152 @@ func main() {
 		if err != nil {
 			log.Fatal("Failed to parse auth file: ", err)
 		}
-	} else if auth != "" {
-		username, password, err = parseAuthfile(strings.NewReader(auth))
+	} else if authstr != "" {
+		username, password, err = parseAuthfile(strings.NewReader(authstr))
 		if err != nil {
 			log.Fatal("Failed to parse auth literal: ", err)
 		}
 	}
 
+	maxAttempts, err := strconv.Atoi(authMaxAttempts)
+	if err != nil || maxAttempts < 1 {
+		log.Fatalf("Invalid auth max attempts: %s", authMaxAttempts)
+	}
+	lockout, err := time.ParseDuration(authLockout)
+	if err != nil {
+		log.Fatal("Failed to parse auth lockout: ", err)
+	}
+	proxies, err := auth.ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		log.Fatal("Failed to parse trusted proxies: ", err)
+	}
+
+	var socketFileMode os.FileMode
+	if strings.HasPrefix(addr, "unix:") {
+		if runtime.GOOS == "windows" {
+			log.Fatal("Unix sockets are not supported on Windows")
+		}
+		mode, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil {
+			log.Fatal("Failed to parse socket mode: ", err)
+		}
+		socketFileMode = os.FileMode(mode)
+	}
+
+	if authHeader != "" && len(proxies) == 0 {
+		log.Fatalf("Header authentication requires trusted proxies")
+	}
+
 	if (certfile != "" || keyfile != "") && (certfile == "" || keyfile == "") {
 		log.Fatalf("Both cert & key files are required")
 	}
 
+	if basepath != "" {
+		basepath = "/" + strings.Trim(basepath, "/")
+	}
+
+	if numWorkers != "" {
+		workers, err := strconv.Atoi(numWorkers)
+		if err != nil || workers < 1 {
+			log.Fatalf("Invalid number of workers: %s", numWorkers)
+		}
+		worker.SetNumWorkers(workers)
+	}
+	timeout, err := time.ParseDuration(fetchTimeout)
+	if err != nil {
+		log.Fatal("Failed to parse fetch timeout: ", err)
+	}
+	discoverTimeoutValue, err := time.ParseDuration(discoverTimeout)
+	if err != nil || discoverTimeoutValue <= 0 {
+		log.Fatalf("Invalid discover timeout: %s", discoverTimeout)
+	}
+	var dnsCacheTTL time.Duration
+	if dnsCache != "off" {
+		dnsCacheTTL, err = time.ParseDuration(dnsCache)
+		if err != nil || dnsCacheTTL <= 0 {
+			log.Fatalf("Invalid dns cache duration: %s", dnsCache)
+		}
+	}
+	redirects, err := strconv.Atoi(maxRedirects)
+	if err != nil || redirects < 1 {
+		log.Fatalf("Invalid number of redirects: %s", maxRedirects)
+	}
+	pageCacheSize, err := strconv.ParseInt(pageCacheMB, 10, 64)
+	if err != nil || pageCacheSize < 0 {
+		log.Fatalf("Invalid page cache size: %s", pageCacheMB)
+	}
+	clientOpts := worker.ClientOptions{
+		Proxy:      proxy,
+		Timeout:    timeout,
+		ClientCert: clientCert,
+		ClientKey:  clientKey,
+
+		DisableKeepAlives: disableKeepAlives,
+		DNSCacheTTL:       dnsCacheTTL,
+		DisableDNSCache:   dnsCache == "off",
+		DNSServer:         dnsServer,
+		Network:           network,
+		MaxRedirects:      redirects,
+		DebugHTTP:         debugHTTP,
+	}
+	if err := worker.SetClientOptions(clientOpts); err != nil {
+		log.Fatal("Failed to set up the http client: ", err)
+	}
+	worker.SetExecHooks(allowExecHooks)
+	worker.SetLocalFeeds(allowLocalFeeds)
+
+	if showConfig {
+		printConfig(os.Stdout, flag.CommandLine)
+		return
+	}
+
+	env := commandEnv{db: db, stdout: os.Stdout, stderr: os.Stderr}
+	if username != "" && password != "" {
+		env.cookie = auth.CookieValue(username, password)
+	}
+	switch {
+	case addFeed != "":
+		os.Exit(runAddFeed(env, addFeed, folder))
+	case exportOPML != "":
+		os.Exit(runExportOPML(env, exportOPML))
+	case importOPML != "":
+		os.Exit(runImportOPML(env, importOPML, fetch))
+	case exportArchive != "":
+		os.Exit(runExportArchive(env, exportArchive))
+	case importArchive != "":
+		os.Exit(runImportArchive(env, importArchive))
+	case refreshOnce:
+		os.Exit(runRefreshOnce(env, refreshFailThreshold))
+	}
+
+	lock, err := lockDB(db, instance{
+		PID:      os.Getpid(),
+		Addr:     addr,
+		BasePath: basepath,
+		TLS:      certfile != "" && keyfile != "",
+	})
+	if err != nil {
+		log.Fatal("Failed to lock database: ", err)
+	}
+	defer unlockDB(lock)
+
 	store, err := storage.New(db)
 	if err != nil {
 		log.Fatal("Failed to initialise database: ", err)
 	}
+	worker.SetPageCache(store, pageCacheSize<<20)
 
 	srv := server.NewServer(store, addr)
-
-	if basepath != "" {
-		srv.BasePath = "/" + strings.Trim(basepath, "/")
+	srv.SocketMode = socketFileMode
+	srv.DiscoverTimeout = discoverTimeoutValue
+	for _, origin := range strings.Split(corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			srv.CORSOrigins = append(srv.CORSOrigins, strings.TrimRight(origin, "/"))
+		}
 	}
 
+	srv.BasePath = basepath
+
 	if certfile != "" && keyfile != "" {
 		srv.CertFile = certfile
 		srv.KeyFile = keyfile
@@ -145,11 +345,70 @@ func main() {
 	if username != "" && password != "" {
 		srv.Username = username
 		srv.Password = password
+		srv.LoginLimiter = auth.NewLimiter(maxAttempts, lockout, time.Hour)
+		srv.TrustedProxies = proxies
 	}
 
-	log.Printf("starting server at %s", srv.GetAddr())
+	if authHeader != "" {
+		srv.AuthHeader = authHeader
+		srv.LogoutURL = logoutURL
+		srv.TrustedProxies = proxies
+	}
+
+	logger.Infof("starting server at %s", srv.GetAddr())
 	if open {
 		platform.Open(srv.GetAddr())
 	}
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	serve(srv, store, sigs, shutdownTimeout)
+}
+
+// How long to wait for the requests in flight and the refresh to finish.
+const shutdownTimeout = time.Second * 10
+
+// serve runs the server until a signal is received (or the gui quits),
+// then shuts it down, followed by the worker and the storage (unless the
+// worker is still running).
+// The second signal forces the exit.
+func serve(srv *server.Server, store *storage.Storage, sigs <-chan os.Signal, timeout time.Duration) {
+	var once sync.Once
+	shutdown := func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				logger.Errorf("shutdown: %s", err)
+				// closing the storage would cut the writes of the worker
+				if err, ok := err.(*server.ShutdownError); ok && err.Worker != nil {
+					logger.Warnf("shutdown: worker still running, leaving storage open")
+					return
+				}
+			}
+			logger.Infof("shutdown: closing storage")
+			if err := store.Close(); err != nil {
+				logger.Errorf("shutdown: %s", err)
+			}
+			logger.Infof("shutdown: done")
+		})
+	}
+
+	go func() {
+		sig, ok := <-sigs
+		if !ok {
+			return
+		}
+		logger.Infof("received %s, shutting down", sig)
+		go func() {
+			if sig, ok := <-sigs; ok {
+				logger.Warnf("received %s again, exiting immediately", sig)
+				os.Exit(1)
+			}
+		}()
+		shutdown()
+	}()
+
 	platform.Start(srv)
+	shutdown()
 }
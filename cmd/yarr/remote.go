@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// remote is the api client of the running server, used by the commands
+// instead of the database the server holds.
+type remote struct {
+	base   string
+	client *http.Client
+	cookie *http.Cookie
+}
+
+func newRemote(server instance, cookie string) *remote {
+	transport := &http.Transport{}
+	base := "http://"
+	if server.TLS {
+		base = "https://"
+		// the certificate is issued for the public name, not the listen address
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if socket := strings.TrimPrefix(server.Addr, "unix:"); socket != server.Addr {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		base += "unix"
+	} else {
+		base += dialAddr(server.Addr)
+	}
+	r := &remote{
+		base:   base + server.BasePath,
+		client: &http.Client{Transport: transport, Timeout: time.Minute * 5},
+	}
+	if cookie != "" {
+		r.cookie = &http.Cookie{Name: "auth", Value: cookie}
+	}
+	return r
+}
+
+// dialAddr replaces the wildcard host of the listen address with the loopback one.
+func dialAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func (r *remote) call(method, path string, body, result interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, r.base+path, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.cookie != nil {
+		req.AddCookie(r.cookie)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%s %s: not authorized, check the credentials", method, path)
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, path, res.Status)
+	}
+	if result != nil {
+		return json.NewDecoder(res.Body).Decode(result)
+	}
+	return nil
+}
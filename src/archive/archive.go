@@ -0,0 +1,335 @@
+// Package archive exports the whole instance to a portable file and restores
+// it. Unlike the database backup the format doesn't depend on the schema,
+// see doc/archive.md.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// Version of the format, bumped on the changes older versions can't read.
+// New record types and fields don't need it, unknown ones are skipped.
+const Version = 1
+
+const format = "yarr-archive"
+
+// Number of items written or stored at once.
+const batchSize = 500
+
+var ErrNotEmpty = errors.New("the database is not empty")
+
+// The archive is a sequence of json records, one per line, the header first.
+// The ids are only for the references within the archive.
+
+type Header struct {
+	Type    string    `json:"type"`
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+type Setting struct {
+	Type  string      `json:"type"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type Folder struct {
+	Type     string `json:"type"`
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Expanded bool   `json:"expanded"`
+}
+
+type Feed struct {
+	Type        string               `json:"type"`
+	ID          int64                `json:"id"`
+	FolderID    *int64               `json:"folder_id"`
+	Title       string               `json:"title"`
+	Description string               `json:"description"`
+	Link        string               `json:"link"`
+	FeedLink    string               `json:"feed_link"`
+	Icon        []byte               `json:"icon,omitempty"`
+	Settings    storage.FeedSettings `json:"settings"`
+}
+
+type Item struct {
+	Type       string    `json:"type"`
+	FeedID     int64     `json:"feed_id"`
+	GUID       string    `json:"guid"`
+	Title      string    `json:"title"`
+	Link       string    `json:"link"`
+	Content    string    `json:"content"`
+	Date       time.Time `json:"date"`
+	Status     string    `json:"status"`
+	ImageURL   *string   `json:"image"`
+	PodcastURL *string   `json:"podcast_url"`
+}
+
+type Counts struct {
+	Settings int64 `json:"settings"`
+	Folders  int64 `json:"folders"`
+	Feeds    int64 `json:"feeds"`
+	Items    int64 `json:"items"`
+}
+
+// Export writes the settings, the folders, the feeds with their settings
+// and icons, and the items with their content and status. The items are
+// read in batches, the memory use doesn't depend on the database size.
+func Export(db *storage.Storage, w io.Writer) (Counts, error) {
+	var counts Counts
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+
+	err := enc.Encode(Header{Type: "header", Format: format, Version: Version, Created: time.Now().UTC()})
+	if err != nil {
+		return counts, err
+	}
+	for key, val := range db.GetSettings() {
+		if err = enc.Encode(Setting{Type: "setting", Key: key, Value: val}); err != nil {
+			return counts, err
+		}
+		counts.Settings++
+	}
+	for _, f := range db.ListFolders() {
+		if err = enc.Encode(Folder{Type: "folder", ID: f.Id, Title: f.Title, Expanded: f.IsExpanded}); err != nil {
+			return counts, err
+		}
+		counts.Folders++
+	}
+	for _, f := range db.ListFeeds() {
+		feed := Feed{
+			Type:        "feed",
+			ID:          f.Id,
+			FolderID:    f.FolderId,
+			Title:       f.Title,
+			Description: f.Description,
+			Link:        f.Link,
+			FeedLink:    f.FeedLink,
+			Settings:    db.GetFeedSettings(f.Id),
+		}
+		if full := db.GetFeed(f.Id); full != nil && full.Icon != nil {
+			feed.Icon = *full.Icon
+		}
+		if err = enc.Encode(feed); err != nil {
+			return counts, err
+		}
+		counts.Feeds++
+	}
+	var lastID int64
+	for {
+		items := db.ListItems(storage.ItemFilter{SinceID: &lastID}, batchSize, false, true)
+		for _, i := range items {
+			err = enc.Encode(Item{
+				Type:       "item",
+				FeedID:     i.FeedId,
+				GUID:       i.GUID,
+				Title:      i.Title,
+				Link:       i.Link,
+				Content:    i.Content,
+				Date:       i.Date,
+				Status:     storage.StatusRepresentations[i.Status],
+				ImageURL:   i.ImageURL,
+				PodcastURL: i.AudioURL,
+			})
+			if err != nil {
+				return counts, err
+			}
+			counts.Items++
+			lastID = i.Id
+		}
+		if len(items) < batchSize {
+			break
+		}
+	}
+	return counts, buf.Flush()
+}
+
+// Import restores the archive (plain or gzipped) into the empty database.
+// Returns ErrNotEmpty if there're feeds or folders already.
+func Import(db *storage.Storage, r io.Reader) (Counts, error) {
+	var counts Counts
+	if len(db.ListFeeds()) > 0 || len(db.ListFolders()) > 0 {
+		return counts, ErrNotEmpty
+	}
+
+	buf := bufio.NewReader(r)
+	if magic, _ := buf.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buf)
+		if err != nil {
+			return counts, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = buf
+	}
+	dec := json.NewDecoder(r)
+
+	var header Header
+	if err := dec.Decode(&header); err != nil {
+		return counts, fmt.Errorf("invalid header: %w", err)
+	}
+	if header.Type != "header" || header.Format != format {
+		return counts, errors.New("not a yarr archive")
+	}
+	if header.Version > Version {
+		return counts, fmt.Errorf("unsupported archive version %d (up to %d)", header.Version, Version)
+	}
+
+	rs := restorer{
+		db:       db,
+		folders:  make(map[int64]int64),
+		feeds:    make(map[int64]int64),
+		batch:    make([]storage.Item, 0, batchSize),
+		statuses: make(map[itemKey]storage.ItemStatus),
+	}
+	for line := 2; ; line++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return rs.counts, fmt.Errorf("record %d: %w", line, err)
+		}
+		if err := rs.record(raw); err != nil {
+			return rs.counts, fmt.Errorf("record %d: %w", line, err)
+		}
+	}
+	if err := rs.flush(); err != nil {
+		return rs.counts, err
+	}
+	db.SyncSearch()
+	return rs.counts, nil
+}
+
+type itemKey struct {
+	feedID int64
+	guid   string
+}
+
+type restorer struct {
+	db     *storage.Storage
+	counts Counts
+	// new ids by the archive ones
+	folders map[int64]int64
+	feeds   map[int64]int64
+
+	batch    []storage.Item
+	statuses map[itemKey]storage.ItemStatus
+}
+
+func (rs *restorer) record(raw json.RawMessage) error {
+	var kind struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &kind); err != nil {
+		return err
+	}
+	switch kind.Type {
+	case "setting":
+		var s Setting
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		// the settings unknown to this version are dropped
+		if err := storage.ValidateSettings(map[string]interface{}{s.Key: s.Value}); err != nil {
+			return nil
+		}
+		if !rs.db.UpdateSettings(map[string]interface{}{s.Key: s.Value}) {
+			return errors.New("failed to store the setting")
+		}
+		rs.counts.Settings++
+	case "folder":
+		var f Folder
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return err
+		}
+		folder := rs.db.CreateFolder(f.Title)
+		if folder == nil {
+			return errors.New("failed to create the folder")
+		}
+		rs.db.ToggleFolderExpanded(folder.Id, f.Expanded)
+		rs.folders[f.ID] = folder.Id
+		rs.counts.Folders++
+	case "feed":
+		var f Feed
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return err
+		}
+		var folderID *int64
+		if f.FolderID != nil {
+			id, ok := rs.folders[*f.FolderID]
+			if !ok {
+				return fmt.Errorf("unknown folder %d", *f.FolderID)
+			}
+			folderID = &id
+		}
+		feed := rs.db.CreateFeed(f.Title, f.Description, f.Link, f.FeedLink, folderID)
+		if feed == nil {
+			return errors.New("failed to create the feed")
+		}
+		if len(f.Icon) > 0 {
+			rs.db.UpdateFeedIcon(feed.Id, &f.Icon)
+		}
+		if !reflect.DeepEqual(f.Settings, storage.FeedSettings{}) {
+			rs.db.UpdateFeedSettings(feed.Id, f.Settings)
+		}
+		rs.feeds[f.ID] = feed.Id
+		rs.counts.Feeds++
+	case "item":
+		var i Item
+		if err := json.Unmarshal(raw, &i); err != nil {
+			return err
+		}
+		feedID, ok := rs.feeds[i.FeedID]
+		if !ok {
+			return fmt.Errorf("unknown feed %d", i.FeedID)
+		}
+		rs.batch = append(rs.batch, storage.Item{
+			GUID:     i.GUID,
+			FeedId:   feedID,
+			Title:    i.Title,
+			Link:     i.Link,
+			Content:  i.Content,
+			Date:     i.Date,
+			ImageURL: i.ImageURL,
+			AudioURL: i.PodcastURL,
+		})
+		if status := storage.StatusValues[i.Status]; status != storage.UNREAD {
+			rs.statuses[itemKey{feedID, i.GUID}] = status
+		}
+		if len(rs.batch) == batchSize {
+			return rs.flush()
+		}
+	}
+	return nil
+}
+
+// flush stores the batch of items, they're created unread.
+func (rs *restorer) flush() error {
+	if len(rs.batch) == 0 {
+		return nil
+	}
+	created := rs.db.CreateItems(rs.batch)
+	if created == nil {
+		return errors.New("failed to store the items")
+	}
+	for _, item := range created {
+		if status, ok := rs.statuses[itemKey{item.FeedId, item.GUID}]; ok {
+			rs.db.UpdateItemStatus(item.Id, status)
+		}
+		rs.counts.Items++
+	}
+	rs.batch = rs.batch[:0]
+	rs.statuses = make(map[itemKey]storage.ItemStatus)
+	return nil
+}
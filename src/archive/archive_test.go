@@ -0,0 +1,152 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func testDB() *storage.Storage {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	return db
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := testDB()
+	folder := src.CreateFolder("News")
+	src.ToggleFolderExpanded(folder.Id, false)
+	news := src.CreateFeed("News", "daily", "https://news.example", "https://news.example/feed", &folder.Id)
+	blog := src.CreateFeed("Blog", "", "https://blog.example", "https://blog.example/feed", nil)
+	icon := []byte{0x89, 'P', 'N', 'G'}
+	src.UpdateFeedIcon(blog.Id, &icon)
+	src.UpdateFeedSettings(news.Id, storage.FeedSettings{RefreshInterval: 120, FullContent: true})
+	src.UpdateSettings(map[string]interface{}{"theme_name": "night", "refresh_rate": 30})
+
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	image := "https://news.example/1.png"
+	items := []storage.Item{
+		{GUID: "n1", FeedId: news.Id, Title: "first", Content: "<p>hello world</p>", Date: date, ImageURL: &image},
+		{GUID: "n2", FeedId: news.Id, Title: "second", Date: date.Add(time.Hour)},
+		{GUID: "b1", FeedId: blog.Id, Title: "third", Date: date.Add(2 * time.Hour)},
+	}
+	// more than a batch
+	for i := 0; i < batchSize; i++ {
+		items = append(items, storage.Item{GUID: strings.Repeat("x", i+1), FeedId: blog.Id, Date: date})
+	}
+	created := src.CreateItems(items)
+	statuses := map[string]storage.ItemStatus{"n1": storage.READ, "b1": storage.STARRED}
+	for _, item := range created {
+		if status, ok := statuses[item.GUID]; ok {
+			src.UpdateItemStatus(item.Id, status)
+		}
+	}
+
+	var buf bytes.Buffer
+	exported, err := Export(src, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.Folders != 1 || exported.Feeds != 2 || exported.Items != int64(len(items)) {
+		t.Fatalf("unexpected export counts: %#v", exported)
+	}
+
+	dst := testDB()
+	restored, err := Import(dst, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != exported {
+		t.Fatalf("expected %#v restored, got %#v", exported, restored)
+	}
+
+	if _, err = Import(dst, bytes.NewReader(buf.Bytes())); err != ErrNotEmpty {
+		t.Fatalf("expected the non-empty database refused, got %v", err)
+	}
+
+	folders := dst.ListFolders()
+	if len(folders) != 1 || folders[0].Title != "News" || folders[0].IsExpanded {
+		t.Fatalf("unexpected folders: %#v", folders)
+	}
+	feeds := make(map[string]storage.Feed)
+	for _, feed := range dst.ListFeeds() {
+		feeds[feed.FeedLink] = feed
+	}
+	restoredNews, restoredBlog := feeds[news.FeedLink], feeds[blog.FeedLink]
+	if restoredNews.FolderId == nil || *restoredNews.FolderId != folders[0].Id || restoredNews.Description != "daily" {
+		t.Fatalf("unexpected feed: %#v", restoredNews)
+	}
+	if restoredBlog.FolderId != nil || !restoredBlog.HasIcon {
+		t.Fatalf("unexpected feed: %#v", restoredBlog)
+	}
+	if settings := dst.GetFeedSettings(restoredNews.Id); settings.RefreshInterval != 120 || !settings.FullContent {
+		t.Fatalf("unexpected feed settings: %#v", settings)
+	}
+	if dst.GetSettingsValue("theme_name") != "night" || dst.GetSettingsValueInt64("refresh_rate") != 30 {
+		t.Fatalf("unexpected settings: %#v", dst.GetSettings())
+	}
+
+	for _, status := range []storage.ItemStatus{storage.UNREAD, storage.READ, storage.STARRED} {
+		filter := storage.ItemFilter{Status: &status}
+		if src.CountItems(filter) != dst.CountItems(filter) {
+			t.Fatalf("expected %d %s items, got %d", src.CountItems(filter), storage.StatusRepresentations[status], dst.CountItems(filter))
+		}
+	}
+	first := dst.ListItems(storage.ItemFilter{FeedID: &restoredNews.Id}, 10, false, true)
+	if len(first) != 2 || first[0].Status != storage.READ || first[0].Content != "<p>hello world</p>" ||
+		!first[0].Date.Equal(date) || first[0].ImageURL == nil || *first[0].ImageURL != image {
+		t.Fatalf("unexpected items: %#v", first)
+	}
+	search := "hello"
+	if len(dst.ListItems(storage.ItemFilter{Search: &search}, 10, false, false)) != 1 {
+		t.Fatal("expected the restored items indexed")
+	}
+}
+
+func TestImportGzip(t *testing.T) {
+	src := testDB()
+	feed := src.CreateFeed("", "", "", "https://example.com/feed", nil)
+	src.CreateItems([]storage.Item{{GUID: "1", FeedId: feed.Id}})
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	Export(src, gz)
+	gz.Close()
+
+	counts, err := Import(testDB(), &buf)
+	if err != nil || counts.Feeds != 1 || counts.Items != 1 {
+		t.Fatalf("unexpected result: %#v %v", counts, err)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	for _, doc := range []string{
+		``,
+		`{"type": "header", "format": "something else", "version": 1}`,
+		`{"type": "header", "format": "yarr-archive", "version": 99}`,
+		`{"type": "header", "format": "yarr-archive", "version": 1}
+		 {"type": "item", "feed_id": 1, "guid": "1"}`,
+	} {
+		if _, err := Import(testDB(), strings.NewReader(doc)); err == nil {
+			t.Errorf("expected %q rejected", doc)
+		}
+	}
+
+	// the record types unknown to this version are skipped
+	doc := `{"type": "header", "format": "yarr-archive", "version": 1}
+		{"type": "tag", "title": "later"}
+		{"type": "setting", "key": "from_the_future", "value": 1}
+		{"type": "feed", "id": 7, "feed_link": "https://example.com/feed"}`
+	counts, err := Import(testDB(), strings.NewReader(doc))
+	if err != nil || counts.Feeds != 1 || counts.Settings != 0 {
+		t.Fatalf("unexpected result: %#v %v", counts, err)
+	}
+}
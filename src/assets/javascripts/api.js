@@ -52,6 +52,15 @@
       list_errors: function() {
         return api('get', './api/feeds/errors').then(json)
       },
+      refresh_failed: function() {
+        return api('post', './api/feeds/errors/refresh')
+      },
+      reactivate: function(id) {
+        return api('delete', './api/feeds/suspended/' + id)
+      },
+      preview: function(data) {
+        return api('post', './api/feeds/preview', data).then(json)
+      },
     },
     folders: {
       list: function() {
@@ -80,10 +89,30 @@
       update: function(id, data) {
         return api('put', './api/items/' + id, data)
       },
+      save: function(id, integrationId) {
+        return api('post', './api/items/' + id + '/save', {integration_id: integrationId})
+      },
+      full_content: function(id) {
+        return api('get', './api/items/' + id + '/full').then(json)
+      },
       mark_read: function(query) {
         return api('put', './api/items' + param(query))
       },
     },
+    integrations: {
+      list: function() {
+        return api('get', './api/integrations').then(json)
+      },
+      create: function(data) {
+        return api('post', './api/integrations', data).then(json)
+      },
+      update: function(id, data) {
+        return api('put', './api/integrations/' + id, data)
+      },
+      delete: function(id) {
+        return api('delete', './api/integrations/' + id)
+      },
+    },
     settings: {
       get: function() {
         return api('get', './api/settings').then(json)
@@ -191,6 +191,10 @@ var vm = new Vue({
     api.feeds.list_errors().then(function(errors) {
       vm.feed_errors = errors
     })
+    api.integrations.list().then(function(integrations) {
+      vm.integrations = integrations
+    })
+    this.listenEvents()
   },
   data: function() {
     var s = app.settings
@@ -230,8 +234,11 @@ var vm = new Vue({
         'size': s.theme_size,
       },
       'refreshRate': s.refresh_rate,
+      'imageProxy': s.image_proxy,
       'authenticated': app.authenticated,
+      'logoutURL': app.logout_url,
       'feed_errors': {},
+      'integrations': [],
     }
   },
   computed: {
@@ -356,8 +363,24 @@ var vm = new Vue({
       if (oldVal === undefined) return  // do nothing, initial setup
       api.settings.update({refresh_rate: newVal})
     },
+    'imageProxy': function(newVal, oldVal) {
+      if (oldVal === undefined) return  // do nothing, initial setup
+      api.settings.update({image_proxy: newVal})
+    },
   },
   methods: {
+    listenEvents: function() {
+      if (!window.EventSource) return
+      var events = new EventSource('./api/events')
+      events.addEventListener('refresh-started', function() {
+        // polling takes it from here until the refresh is over
+        if (!vm.loading.feeds) vm.refreshStats(true)
+      })
+      events.addEventListener('new-items', function() {
+        vm.refreshFeeds()
+        vm.refreshStats()
+      })
+    },
     refreshStats: function(loopMode) {
       return api.status().then(function(data) {
         if (loopMode && !vm.itemSelected) vm.refreshItems()
@@ -539,6 +562,16 @@ var vm = new Vue({
         })
       }
     },
+    reactivateFeed: function(feed) {
+      api.feeds.reactivate(feed.id).then(function() {
+        feed.suspended = false
+      })
+    },
+    restoreFeed: function(feed) {
+      api.feeds.update(feed.id, {gone: false}).then(function() {
+        feed.gone = false
+      })
+    },
     deleteFeed: function(feed) {
       if (confirm('Are you sure you want to delete ' + feed.title + '?')) {
         api.feeds.delete(feed.id).then(function() {
@@ -554,6 +587,12 @@ var vm = new Vue({
         url: form.querySelector('input[name=url]').value,
         folder_id: parseInt(form.querySelector('select[name=folder_id]').value) || null,
       }
+      var username = form.querySelector('input[name=username]').value
+      var password = form.querySelector('input[name=password]').value
+      if (username || password) {
+        data.username = username
+        data.password = password
+      }
       if (this.feedNewChoiceSelected) {
         data.url = this.feedNewChoiceSelected
       }
@@ -567,6 +606,8 @@ var vm = new Vue({
         } else if (result.status === 'multiple') {
           vm.feedNewChoice = result.choice
           vm.feedNewChoiceSelected = result.choice[0].url
+        } else if (result.error) {
+          alert(result.error + (result.body ? '\n\n' + result.body : ''))
         } else {
           alert('No feeds found at the given url.')
         }
@@ -598,6 +639,14 @@ var vm = new Vue({
     toggleItemRead: function(item) {
       this.toggleItemStatus(item, 'unread', 'read')
     },
+    saveItemTo: function(item, integration) {
+      api.items.save(item.id, integration.id).then(function(res) {
+        if (res.ok) return
+        res.json().then(function(data) {
+          alert('Failed to save to ' + integration.title + ': ' + data.error)
+        })
+      })
+    },
     importOPML: function(event) {
       var input = event.target
       var form = document.querySelector('#opml-import-form')
@@ -609,6 +658,10 @@ var vm = new Vue({
       })
     },
     logout: function() {
+      if (this.logoutURL) {
+        document.location = this.logoutURL
+        return
+      }
       api.logout().then(function() {
         document.location.reload()
       })
@@ -622,8 +675,15 @@ var vm = new Vue({
       if (!item) return
       if (item.link) {
         this.loading.readability = true
-        api.crawl(item.link).then(function(data) {
-          vm.itemSelectedReadability = data && data.content
+        api.items.full_content(item.id).then(function(data) {
+          if (data && data.error) {
+            var error = document.createElement('p')
+            error.className = 'text-danger'
+            error.textContent = data.message
+            vm.itemSelectedReadability = error.outerHTML
+          } else {
+            vm.itemSelectedReadability = data && data.content
+          }
           vm.loading.readability = false
         })
       }
@@ -655,6 +715,12 @@ var vm = new Vue({
         vm.refreshStats()
       })
     },
+    fetchFailedFeeds: function() {
+      if (this.loading.feeds) return
+      api.feeds.refresh_failed().then(function() {
+        vm.refreshStats()
+      })
+    },
     computeStats: function() {
       var filter = this.filterSelected
       if (!filter) {
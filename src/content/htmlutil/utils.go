@@ -61,3 +61,63 @@ func ExtractText(content string) string {
 	text = whitespaceRegex.ReplaceAllLiteralString(text, " ")
 	return text
 }
+
+// tags separating the words of the text
+var snippetBreakTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "figcaption": true, "figure": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// tags with the text not meant to be read
+var snippetSkipTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+}
+
+// Snippet returns the plain text of the html content cut to maxLen characters
+// at the word boundary, with the ellipsis appended if cut.
+func Snippet(content string, maxLen int) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	buffer := bytes.Buffer{}
+	skip := ""
+	for {
+		token := tokenizer.Next()
+		if token == html.ErrorToken {
+			break
+		}
+		switch token {
+		case html.TextToken:
+			if skip == "" {
+				buffer.WriteString(html.UnescapeString(string(tokenizer.Text())))
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if token == html.StartTagToken && skip == "" && snippetSkipTags[tag] {
+				skip = tag
+			} else if token == html.EndTagToken && tag == skip {
+				skip = ""
+			}
+			if snippetBreakTags[tag] {
+				buffer.WriteString(" ")
+			}
+		}
+	}
+	text := whitespaceRegex.ReplaceAllLiteralString(buffer.String(), " ")
+	text = strings.TrimSpace(text)
+
+	runes := []rune(text)
+	if len(runes) <= maxLen {
+		return text
+	}
+	cut := maxLen
+	for i := maxLen; i > maxLen/2; i-- {
+		if runes[i] == ' ' {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRight(string(runes[:cut]), " ,.;:-–—") + "…"
+}
@@ -24,3 +24,26 @@ func TestExtractText(t *testing.T) {
 		}
 	}
 }
+
+func TestSnippet(t *testing.T) {
+	testcases := []struct {
+		content string
+		maxLen  int
+		want    string
+	}{
+		{"<p>hello</p><p>world</p>", 100, "hello world"},
+		{"<p>Tom &amp; Jerry&nbsp;&mdash; <b>cat</b>&#39;s   life\n</p>", 100, "Tom & Jerry — cat's life"},
+		{"<script>var x = 1;</script><style>p {}</style><p>text</p>", 100, "text"},
+		{"<p>one two three four</p>", 9, "one two…"},
+		{"<p>one two, three four</p>", 10, "one two…"},
+		{"<p>onetwothreefour</p>", 8, "onetwoth…"},
+		{"<p>привет мир</p>", 8, "привет…"},
+		{"<img src=x>", 100, ""},
+	}
+	for _, testcase := range testcases {
+		have := Snippet(testcase.content, testcase.maxLen)
+		if testcase.want != have {
+			t.Errorf("content: %#v\nwant: %#v\nhave: %#v", testcase.content, testcase.want, have)
+		}
+	}
+}
@@ -19,6 +19,20 @@ import (
 
 const (
 	defaultTagsToScore = "section,h2,h3,h4,h5,h6,p,td,pre,div"
+
+	// Blocks inside the article checked for being navigation, comments, etc.
+	cleanTagsConditionally = "div,section,header,ul,ol"
+	// Blocks inside the article removed regardless.
+	cleanTags = "nav,aside,footer,form"
+
+	// The article container must have at least this much text.
+	minArticleLength = 250
+	// Below this score the page is considered to have no article.
+	minCandidateScore = 20
+	// Candidates with less text per markup are penalized.
+	minTextDensity = 0.2
+
+	maxFallbackImages = 3
 )
 
 var (
@@ -30,6 +44,7 @@ var (
 	unlikelyCandidatesRegexp   = regexp.MustCompile(`(?i)banner|breadcrumbs|combx|comment|community|cover-wrap|disqus|extra|foot|header|legends|menu|modal|related|remark|replies|rss|shoutbox|sidebar|skyscraper|social|sponsor|supplemental|ad-break|agegate|pagination|pager|popup|yom-remote`)
 
 	negativeRegexp = regexp.MustCompile(`(?i)hidden|^hid$|hid$|hid|^hid |banner|combx|comment|com-|contact|foot|footer|footnote|masthead|media|meta|modal|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget|byline|author|dateline|writtenby|p-author`)
+	metadataRegexp = regexp.MustCompile(`(?i)byline|author|dateline|writtenby|entry-meta|post-meta|entry-utility|entry-footer|share`)
 	positiveRegexp = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|pagination|post|text|blog|story`)
 )
 
@@ -51,10 +66,20 @@ func ExtractContent(page io.Reader) (string, error) {
 	transformMisusedDivsIntoParagraphs(root)
 	removeUnlikelyCandidates(root)
 
+	if container := getArticleContainer(root); container != nil {
+		cleanArticle(container)
+		return "<div>" + htmlutil.InnerHTML(container) + "</div>", nil
+	}
+
 	scores := getCandidates(root)
 	//log.Printf("[Readability] Candidates: %v", candidates)
 
 	best := getTopCandidate(scores)
+	if best == nil || scores[best] < minCandidateScore {
+		if fallback := getFallback(root); fallback != "" {
+			return fallback, nil
+		}
+	}
 	if best == nil {
 		for _, body := range htmlutil.Query(root, "body") {
 			best = body
@@ -66,6 +91,7 @@ func ExtractContent(page io.Reader) (string, error) {
 	}
 	//log.Printf("[Readability] TopCandidate: %v", topCandidate)
 
+	cleanArticle(best)
 	output := getArticle(best, scores)
 	return output, nil
 }
@@ -76,15 +102,14 @@ func getArticle(best *html.Node, scores nodeScores) string {
 	output := bytes.NewBufferString("<div>")
 	siblingScoreThreshold := float32(math.Max(10, float64(scores[best]*.2)))
 
+	// Get the candidate's siblings in the document order
 	nodelist := make([]*html.Node, 0)
-	nodelist = append(nodelist, best)
-
-	// Get the candidate's siblings
-	for n := best.NextSibling; n != nil; n = n.NextSibling {
-		nodelist = append(nodelist, n)
-	}
-	for n := best.PrevSibling; n != nil; n = n.PrevSibling {
-		nodelist = append(nodelist, n)
+	if best.Parent != nil {
+		for n := best.Parent.FirstChild; n != nil; n = n.NextSibling {
+			nodelist = append(nodelist, n)
+		}
+	} else {
+		nodelist = append(nodelist, best)
 	}
 
 	for _, node := range nodelist {
@@ -129,7 +154,7 @@ func removeUnlikelyCandidates(root *html.Node) {
 	for _, node := range htmlutil.Query(body[0], "*") {
 		str := htmlutil.Attr(node, "class") + htmlutil.Attr(node, "id")
 
-		if htmlutil.Closest(node, "table,code") != nil {
+		if htmlutil.Closest(node, "table,code,figure") != nil {
 			continue
 		}
 
@@ -200,9 +225,14 @@ func getCandidates(root *html.Node) nodeScores {
 
 	// Scale the final candidates score based on link density. Good content
 	// should have a relatively small link density (5% or less) and be mostly
-	// unaffected by this operation
+	// unaffected by this operation.
+	// Navigation and comment blocks are mostly links and markup,
+	// so the candidates with little text per markup are scaled down too.
 	for node := range scores {
 		scores[node] *= (1 - getLinkDensity(node))
+		if density := getTextDensity(node); density < minTextDensity {
+			scores[node] *= density / minTextDensity
+		}
 	}
 
 	return scores
@@ -241,6 +271,15 @@ func getLinkDensity(n *html.Node) float32 {
 	return float32(linkLength) / float32(textLength)
 }
 
+// Get the amount of text relative to the markup of the node.
+func getTextDensity(n *html.Node) float32 {
+	htmlLength := len(htmlutil.InnerHTML(n))
+	if htmlLength == 0 {
+		return 0
+	}
+	return float32(len(htmlutil.Text(n))) / float32(htmlLength)
+}
+
 // Get an elements class/id weight. Uses regular expressions to tell if this
 // element looks good or bad.
 func getClassWeight(node *html.Node) float32 {
@@ -278,3 +317,158 @@ func transformMisusedDivsIntoParagraphs(root *html.Node) {
 		}
 	}
 }
+
+// getArticleContainer returns the element the page marks as the article:
+// the one with itemprop=articleBody, otherwise the largest <article>.
+func getArticleContainer(root *html.Node) *html.Node {
+	isArticleBody := func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return false
+		}
+		for _, prop := range strings.Fields(htmlutil.Attr(n, "itemprop")) {
+			if prop == "articleBody" {
+				return true
+			}
+		}
+		return false
+	}
+	if node := getLargestContainer(htmlutil.FindNodes(root, isArticleBody)); node != nil {
+		return node
+	}
+	return getLargestContainer(htmlutil.Query(root, "article"))
+}
+
+func getLargestContainer(nodes []*html.Node) *html.Node {
+	var best *html.Node
+	bestLength := 0
+	for _, node := range nodes {
+		length := len(htmlutil.Text(node))
+		if length < minArticleLength || getLinkDensity(node) > 0.5 {
+			continue
+		}
+		if length > bestLength {
+			best = node
+			bestLength = length
+		}
+	}
+	return best
+}
+
+// cleanArticle removes the blocks inside the article that look like
+// navigation, share buttons, bylines or comments.
+// Figures and code are kept intact.
+func cleanArticle(article *html.Node) {
+	remove := func(node *html.Node) {
+		if node.Parent != nil {
+			node.Parent.RemoveChild(node)
+		}
+	}
+	for _, node := range htmlutil.Query(article, cleanTags) {
+		if node != article && !isInsideKeptBlock(node, article) {
+			remove(node)
+		}
+	}
+	for _, node := range htmlutil.Query(article, cleanTagsConditionally) {
+		if node == article || node.Parent == nil || isInsideKeptBlock(node, article) {
+			continue
+		}
+		// bylines and share buttons are removed even with the avatars and icons
+		if metadataRegexp.MatchString(htmlutil.Attr(node, "class") + " " + htmlutil.Attr(node, "id")) {
+			remove(node)
+			continue
+		}
+		media := hasMedia(node)
+		if getClassWeight(node) < 0 && !media {
+			remove(node)
+			continue
+		}
+		linkDensity := getLinkDensity(node)
+		if linkDensity > 0.5 {
+			remove(node)
+			continue
+		}
+		if !media && linkDensity > 0.2 && len(htmlutil.Text(node)) < 25 {
+			remove(node)
+		}
+	}
+}
+
+func isInsideKeptBlock(node, article *html.Node) bool {
+	for cur := node; cur != nil && cur != article; cur = cur.Parent {
+		switch cur.Data {
+		case "figure", "pre", "code", "table":
+			return true
+		}
+	}
+	return false
+}
+
+func hasMedia(node *html.Node) bool {
+	return len(htmlutil.Query(node, "img,picture,video,audio,iframe,figure")) > 0
+}
+
+// getFallback returns the page description and the first images
+// for the pages without an article.
+func getFallback(root *html.Node) string {
+	description := getMeta(root, "og:description")
+	if description == "" {
+		description = getMeta(root, "description")
+	}
+	if description == "" {
+		return ""
+	}
+
+	var images []string
+	for _, img := range getImages(root) {
+		src := strings.TrimSpace(htmlutil.Attr(img, "src"))
+		if src == "" || strings.HasPrefix(src, "data:") || htmlutil.Closest(img, "header,nav,footer,aside") != nil {
+			continue
+		}
+		images = append(images, src)
+		if len(images) == maxFallbackImages {
+			break
+		}
+	}
+	if len(images) == 0 {
+		if image := getMeta(root, "og:image"); image != "" {
+			images = append(images, image)
+		}
+	}
+
+	output := bytes.NewBufferString("<div>")
+	fmt.Fprintf(output, "<p>%s</p>", html.EscapeString(description))
+	for _, src := range images {
+		fmt.Fprintf(output, `<img src="%s">`, html.EscapeString(src))
+	}
+	output.WriteString("</div>")
+	return output.String()
+}
+
+func getMeta(root *html.Node, name string) string {
+	for _, meta := range htmlutil.Query(root, "meta") {
+		if htmlutil.Attr(meta, "property") == name || htmlutil.Attr(meta, "name") == name {
+			if content := strings.TrimSpace(htmlutil.Attr(meta, "content")); content != "" {
+				return content
+			}
+		}
+	}
+	return ""
+}
+
+// getImages returns the images of the body in the document order.
+func getImages(root *html.Node) []*html.Node {
+	var images []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "img" {
+			images = append(images, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, body := range htmlutil.Query(root, "body") {
+		walk(body)
+	}
+	return images
+}
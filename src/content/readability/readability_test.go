@@ -0,0 +1,86 @@
+package readability
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// articleText returns the text of the extracted article, one line per block,
+// with the images written as [img src].
+func articleText(content string) string {
+	root, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return ""
+	}
+	var lines []string
+	var line []string
+	flush := func() {
+		if len(line) > 0 {
+			lines = append(lines, strings.Join(strings.Fields(strings.Join(line, " ")), " "))
+			line = nil
+		}
+	}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			if text := strings.TrimSpace(n.Data); text != "" {
+				line = append(line, text)
+			}
+		case n.Type == html.ElementNode && n.Data == "img":
+			flush()
+			for _, attr := range n.Attr {
+				if attr.Key == "src" {
+					lines = append(lines, "[img "+attr.Val+"]")
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "p", "div", "section", "h1", "h2", "h3", "h4", "figcaption", "li":
+				flush()
+			}
+		}
+	}
+	walk(root)
+	flush()
+	return strings.Join(lines, "\n")
+}
+
+func TestExtractContentCorpus(t *testing.T) {
+	pages, err := filepath.Glob("testdata/*.html")
+	if err != nil || len(pages) == 0 {
+		t.Fatal("no test pages", err)
+	}
+	for _, page := range pages {
+		name := strings.TrimSuffix(page, ".html")
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			f, err := os.Open(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			expected, err := os.ReadFile(name + ".txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := ExtractContent(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.TrimSpace(string(expected))
+			have := articleText(content)
+			if want != have {
+				t.Errorf("\nwant:\n%s\n\nhave:\n%s", want, have)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package sanitizer
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// inPageAnchors returns the ids (or old-style anchor names)
+// that are both present in the html and linked to from within it,
+// like footnotes and the references back to the text.
+func inPageAnchors(input string) map[string]bool {
+	ids := make(map[string]bool)
+	refs := make(map[string]bool)
+
+	tokenizer := html.NewTokenizer(strings.NewReader(input))
+	for {
+		if tokenizer.Next() == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return nil
+			}
+			break
+		}
+		token := tokenizer.Token()
+		if token.Type != html.StartTagToken && token.Type != html.SelfClosingTagToken {
+			continue
+		}
+		for _, attr := range token.Attr {
+			switch {
+			case attr.Key == "id", attr.Key == "name" && token.Data == "a":
+				ids[attr.Val] = true
+			case attr.Key == "href" && token.Data == "a" && strings.HasPrefix(attr.Val, "#"):
+				refs[attr.Val[1:]] = true
+			}
+		}
+	}
+
+	anchors := make(map[string]bool)
+	for id := range refs {
+		if id != "" && ids[id] {
+			anchors[id] = true
+		}
+	}
+	return anchors
+}
+
+// anchorID returns the namespaced id of the in-page anchor,
+// or an empty string if the id isn't one.
+func anchorID(id string, opts Options) string {
+	if opts.IDPrefix == "" || !opts.anchors[id] {
+		return ""
+	}
+	return opts.IDPrefix + id
+}
@@ -0,0 +1,50 @@
+package sanitizer
+
+import "testing"
+
+func TestCodeBlocks(t *testing.T) {
+	scenarios := map[string][2]string{
+		"whitespace": {
+			"<pre><code class=\"language-go\">func main() {\n\tfmt.Println(\"hi\")\n}\n</code></pre>",
+			"<pre><code class=\"language-go\">func main() {\n\tfmt.Println(&#34;hi&#34;)\n}\n</code></pre>",
+		},
+		"classes": {
+			`<pre class="hljs wp-block-code"><code class="lang-python highlight hljs-python">pass</code></pre><p class="language-go">text</p>`,
+			`<pre class="hljs"><code class="lang-python hljs-python">pass</code></pre><p>text</p>`,
+		},
+		"hugo": {
+			"<div class=\"highlight\"><pre tabindex=\"0\" class=\"chroma\"><code class=\"language-go\" data-lang=\"go\"><span class=\"line\"><span class=\"cl\"><span class=\"kd\">var</span> <span class=\"nx\">x</span> <span class=\"p\">=</span> <span class=\"mi\">1</span>\n</span></span><span class=\"line\"><span class=\"cl\"><span class=\"nx\">x</span><span class=\"o\">++</span>\n</span></span></code></pre></div>",
+			"<div><pre><code class=\"language-go\"><span><span><span>var</span> <span>x</span> <span>=</span> <span>1</span>\n</span></span><span><span><span>x</span><span>++</span>\n</span></span></code></pre></div>",
+		},
+		"hugo inline line numbers": {
+			"<pre class=\"chroma\"><code class=\"language-sh\"><span class=\"line\"><span class=\"ln\">1</span><span class=\"cl\">ls\n</span></span><span class=\"line\"><span class=\"ln\">2</span><span class=\"cl\">pwd\n</span></span></code></pre>",
+			"<pre><code class=\"language-sh\"><span><span>ls\n</span></span><span><span>pwd\n</span></span></code></pre>",
+		},
+		"hugo table line numbers": {
+			"<div class=\"highlight\"><div class=\"chroma\"><table class=\"lntable\"><tr><td class=\"lntd\"><pre class=\"chroma\"><code><span class=\"lnt\">1\n</span><span class=\"lnt\">2\n</span></code></pre></td><td class=\"lntd\"><pre class=\"chroma\"><code class=\"language-sh\" data-lang=\"sh\"><span class=\"line\"><span class=\"cl\">ls\n</span></span><span class=\"line\"><span class=\"cl\">pwd\n</span></span></code></pre></td></tr></table></div></div>",
+			"<div><div><table><tr><td><pre><code></code></pre></td><td><pre><code class=\"language-sh\"><span><span>ls\n</span></span><span><span>pwd\n</span></span></code></pre></td></tr></table></div></div>",
+		},
+		"jekyll": {
+			"<figure class=\"highlight\"><pre><code class=\"language-ruby\" data-lang=\"ruby\"><table class=\"rouge-table\"><tbody><tr><td class=\"gutter gl\"><pre class=\"lineno\">1\n2\n</pre></td><td class=\"code\"><pre><span class=\"k\">def</span> <span class=\"nf\">foo</span>\n<span class=\"k\">end</span>\n</pre></td></tr></tbody></table></code></pre></figure>",
+			"<figure><pre><code class=\"language-ruby\"><table><tbody><tr><td><pre><span>def</span> <span>foo</span>\n<span>end</span>\n</pre></td></tr></tbody></table></code></pre></figure>",
+		},
+		"wordpress syntaxhighlighter": {
+			"<div class=\"syntaxhighlighter php\"><table border=\"0\" cellpadding=\"0\" cellspacing=\"0\"><tbody><tr><td class=\"gutter\"><div class=\"line number1 index0 alt2\">1</div><div class=\"line number2 index1 alt1\">2</div></td><td class=\"code\"><div class=\"container\"><div class=\"line number1 index0 alt2\"><code class=\"php keyword\">if</code> <code class=\"php plain\">($x) {</code></div><div class=\"line number2 index1 alt1\"><code class=\"php spaces\">&nbsp;&nbsp;</code><code class=\"php keyword\">echo</code></div></div></td></tr></tbody></table></div>",
+			"<div><table><tbody><tr><td><div><div><code>if</code> <code>($x) {</code></div><div><code>\u00a0\u00a0</code><code>echo</code></div></div></td></tr></tbody></table></div>",
+		},
+		"gutter without end tag": {
+			`<table><tr><td class="gutter">1<td>code</table><p>after</p>`,
+			`<table><tr><td>code</table><p>after</p>`,
+		},
+		"gutter with nested table": {
+			`<table><tr><td class="gutter"><table><tr><td>1</td></tr></table></td><td>code</td></tr></table><p>after</p>`,
+			`<table><tr><td>code</td></tr></table><p>after</p>`,
+		},
+	}
+	for name, scenario := range scenarios {
+		output := Sanitize("http://example.org/", scenario[0])
+		if output != scenario[1] {
+			t.Errorf("%s:\nwant: %s\nhave: %s", name, scenario[1], output)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package sanitizer
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const lwnArticle = `<p>The patch set<a href="#fn1" id="fnref1"><sup>1</sup></a> was merged for 6.1.</p>
+<table class="OddEven">
+<tr><th>Release</th><th colspan="2">Changesets</th></tr>
+<tr><td>6.0</td><td>15,402</td><td rowspan="2">see <a href="/Articles/909001/">here</a></td></tr>
+<tr><td>6.1</td><td>13,942</td></tr>
+</table>
+<figure><img src="https://static.lwn.net/images/2022/kernel.png" alt="chart"><figcaption>Changesets per release<a href="#fn2" id="fnref2"><sup>2</sup></a></figcaption></figure>
+<hr>
+<ol class="footnotes">
+<li id="fn1">See <a href="/Articles/900000/">this article</a>. <a href="#fnref1">&#8617;</a></li>
+<li id="fn2">Counted with gitdm. <a href="#fnref2">&#8617;</a></li>
+</ol>
+<p><a href="#comments">Comments</a> and <a href="#top">back to top</a>.</p>`
+
+const wikipediaArticle = `<p>Paris is the capital of France.<sup id="cite_ref-1" class="reference"><a href="#cite_note-1">[1]</a></sup> It hosted the 1900 Olympics.<sup id="cite_ref-olympics_2-0" class="reference"><a href="#cite_note-olympics-2">[2]</a></sup></p>
+<table class="wikitable"><caption>Population</caption><thead><tr><th scope="col">Year</th><th scope="col">Pop.</th></tr></thead><tbody><tr><td>1900</td><td>2,714,068</td></tr></tbody></table>
+<h2><span class="mw-headline" id="References">References</span></h2>
+<ol class="references">
+<li id="cite_note-1"><span class="mw-cite-backlink"><b><a href="#cite_ref-1">^</a></b></span> <span class="reference-text">Constitution, article 2.</span></li>
+<li id="cite_note-olympics-2"><span class="mw-cite-backlink"><b><a href="#cite_ref-olympics_2-0">^</a></b></span> <span class="reference-text"><a name="olympics"></a>Official report, 1901.</span></li>
+</ol>`
+
+// anchors returns the ids and the in-page links of the html.
+func anchors(t *testing.T, content string) (ids map[string]int, links []string) {
+	ids = make(map[string]int)
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for tokenizer.Next() != html.ErrorToken {
+		token := tokenizer.Token()
+		for _, attr := range token.Attr {
+			if attr.Key == "id" {
+				ids[attr.Val]++
+			}
+			if attr.Key == "href" && strings.HasPrefix(attr.Val, "#") {
+				links = append(links, attr.Val[1:])
+			}
+			if attr.Key == "target" && token.Data == "a" {
+				for _, a := range token.Attr {
+					if a.Key == "href" && strings.HasPrefix(a.Val, "#") {
+						t.Errorf("in-page link opens in a new tab: %s", token)
+					}
+				}
+			}
+		}
+	}
+	return ids, links
+}
+
+func TestFootnotes(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		content string
+		links   int
+	}{
+		{"lwn", lwnArticle, 4},
+		{"wikipedia", wikipediaArticle, 4},
+	}
+	var combined strings.Builder
+	for i, scenario := range scenarios {
+		prefix := []string{"item-1-", "item-2-"}[i]
+		output := SanitizeWithOptions("https://example.org/article/", scenario.content, Options{IDPrefix: prefix})
+		combined.WriteString(output)
+
+		ids, links := anchors(t, output)
+		if len(links) != scenario.links {
+			t.Errorf("%s: want %d in-page links, have %v\n%s", scenario.name, scenario.links, links, output)
+		}
+		for _, link := range links {
+			if !strings.HasPrefix(link, prefix) || ids[link] != 1 {
+				t.Errorf("%s: broken in-page link #%s\n%s", scenario.name, link, output)
+			}
+		}
+		for id := range ids {
+			if !strings.HasPrefix(id, prefix) {
+				t.Errorf("%s: id %s is not namespaced", scenario.name, id)
+			}
+		}
+
+		// the structure survives
+		for _, tag := range []string{"<table>", "<th", "<td", "<sup", "<ol>", "<li "} {
+			if !strings.Contains(output, tag) {
+				t.Errorf("%s: %s is lost\n%s", scenario.name, tag, output)
+			}
+		}
+	}
+
+	// links to the anchors missing in the content point to the article
+	if !strings.Contains(combined.String(), `<a href="https://example.org/article/#comments" rel="noopener noreferrer" target="_blank" referrerpolicy="no-referrer">Comments</a>`) {
+		t.Errorf("unexpected link to a missing anchor:\n%s", combined.String())
+	}
+
+	ids, _ := anchors(t, combined.String())
+	for id, count := range ids {
+		if count > 1 {
+			t.Errorf("id %s collides between the items", id)
+		}
+	}
+}
+
+func TestFootnotesWithoutPrefix(t *testing.T) {
+	output := Sanitize("https://example.org/article/", `<p><a href="#fn1">1</a></p><p id="fn1">note</p>`)
+	want := `<p><a href="https://example.org/article/#fn1" rel="noopener noreferrer" target="_blank" referrerpolicy="no-referrer">1</a></p><p>note</p>`
+	if output != want {
+		t.Errorf("\nwant: %s\nhave: %s", want, output)
+	}
+}
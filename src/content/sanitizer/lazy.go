@@ -0,0 +1,94 @@
+package sanitizer
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// attributes used by lazy-loading scripts to hold the real image
+var (
+	lazySrcAttrs    = []string{"data-src", "data-lazy-src", "data-original", "data-lazy", "data-actualsrc"}
+	lazySrcsetAttrs = []string{"data-srcset", "data-lazy-srcset"}
+)
+
+var placeholderPatterns = []string{
+	"placeholder",
+	"lazy",
+	"blank.gif",
+	"spacer.gif",
+	"pixel.gif",
+	"transparent.gif",
+	"grey.gif",
+	"loading.gif",
+	"1x1",
+}
+
+// Data urls shorter than this are considered placeholders.
+const placeholderDataURLSize = 1024
+
+func isPlaceholderImage(src string) bool {
+	src = strings.TrimSpace(src)
+	if src == "" {
+		return true
+	}
+	if strings.HasPrefix(src, "data:") {
+		return len(src) < placeholderDataURLSize
+	}
+	lower := strings.ToLower(src)
+	for _, pattern := range placeholderPatterns {
+		if strings.Contains(lower, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// promoteLazyAttributes replaces the placeholder src/srcset
+// with the values of the lazy-loading attributes, if any.
+func promoteLazyAttributes(attrs []html.Attribute) []html.Attribute {
+	var src, srcset string
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "src":
+			src = attr.Val
+		case "srcset":
+			srcset = attr.Val
+		}
+	}
+
+	lazySrc := lookupAttr(attrs, lazySrcAttrs)
+	lazySrcset := lookupAttr(attrs, lazySrcsetAttrs)
+	if lazySrc != "" && isPlaceholderImage(src) {
+		attrs = setAttr(attrs, "src", lazySrc)
+	}
+	if lazySrcset != "" && (srcset == "" || isPlaceholderImage(srcset)) {
+		attrs = setAttr(attrs, "srcset", lazySrcset)
+		if lazySrc == "" && isPlaceholderImage(src) {
+			// the browser picks from srcset anyway
+			attrs = setAttr(attrs, "src", strings.Fields(lazySrcset)[0])
+		}
+	}
+	return attrs
+}
+
+func lookupAttr(attrs []html.Attribute, keys []string) string {
+	for _, key := range keys {
+		for _, attr := range attrs {
+			if attr.Key == key && strings.TrimSpace(attr.Val) != "" {
+				return strings.TrimSpace(attr.Val)
+			}
+		}
+	}
+	return ""
+}
+
+func setAttr(attrs []html.Attribute, key, val string) []html.Attribute {
+	for i := range attrs {
+		if attrs[i].Key == key {
+			attrs[i].Val = val
+			return attrs
+		}
+	}
+	return append(attrs, html.Attribute{Key: key, Val: val})
+}
@@ -0,0 +1,39 @@
+package sanitizer
+
+import "testing"
+
+func TestLazyImages(t *testing.T) {
+	gif := "data:image/gif;base64,R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7"
+	scenarios := map[string][2]string{
+		"wordpress": {
+			`<img src="` + gif + `" data-lazy-src="https://example.org/real.jpg" data-lazy-srcset="https://example.org/real-300.jpg 300w, https://example.org/real-600.jpg 600w" class="lazyload" loading="lazy" decoding="async"><noscript><img src="https://example.org/real.jpg"></noscript>`,
+			`<img src="https://example.org/real.jpg" srcset="https://example.org/real-300.jpg 300w, https://example.org/real-600.jpg 600w" loading="lazy">`,
+		},
+		"wordpress jetpack": {
+			`<img src="https://example.org/wp-content/plugins/jetpack/modules/lazy-images/images/1x1.trans.gif" data-lazy-src="https://example.org/real.jpg">`,
+			`<img src="https://example.org/real.jpg" loading="lazy">`,
+		},
+		"medium": {
+			`<figure><img class="progressiveMedia-image" data-src="https://cdn-images-1.medium.com/max/800/1*abc.jpeg"></figure>`,
+			`<figure><img src="https://cdn-images-1.medium.com/max/800/1*abc.jpeg" loading="lazy"></figure>`,
+		},
+		"ghost": {
+			`<img class="lazyload" data-srcset="/content/images/size/w300/photo.jpg 300w, /content/images/size/w600/photo.jpg 600w" data-sizes="auto" src="` + gif + `">`,
+			`<img src="http://example.org/content/images/size/w300/photo.jpg" srcset="http://example.org/content/images/size/w300/photo.jpg 300w, http://example.org/content/images/size/w600/photo.jpg 600w" loading="lazy">`,
+		},
+		"data-original": {
+			`<img src="/images/placeholder.png" data-original="/images/real.png">`,
+			`<img src="http://example.org/images/real.png" loading="lazy">`,
+		},
+		"real src kept": {
+			`<img src="https://example.org/real.jpg" data-src="https://example.org/other.jpg">`,
+			`<img src="https://example.org/real.jpg" loading="lazy">`,
+		},
+	}
+	for name, scenario := range scenarios {
+		output := Sanitize("http://example.org/", scenario[0])
+		if output != scenario[1] {
+			t.Errorf("%s:\nwant: %s\nhave: %s", name, scenario[1], output)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package sanitizer
+
+import (
+	"regexp"
+	"strings"
+)
+
+// LaTeX display and inline math: $$...$$, \[...\] and \(...\).
+// Single dollars are too common in the regular text to tell.
+var latexRegex = regexp.MustCompile(`(?s)\$\$.+?\$\$|\\\[.+?\\\]|\\\(.+?\\\)`)
+
+// HasMath reports whether the html contains MathML or LaTeX formulas,
+// so that the reader view knows to load a math renderer.
+func HasMath(content string) bool {
+	return strings.Contains(content, "<math") || latexRegex.MatchString(content)
+}
@@ -0,0 +1,58 @@
+package sanitizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nkanaev/yarr/src/parser"
+)
+
+func TestMath(t *testing.T) {
+	feed, err := parser.Parse(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel><title>cs.DS updates on arXiv.org</title><link>http://arxiv.org/</link>
+<item>
+<title>Faster Sorting of Nearly Sorted Sequences. (arXiv:2301.00001v1 [cs.DS])</title>
+<link>http://arxiv.org/abs/2301.00001</link>
+<description>&lt;p&gt;We show that a sequence with $k$ inversions can be sorted in $\mathcal{O}(n \log(1 + k/n))$ time, and give a matching lower bound \(\Omega(n \log(1 + k/n))\). For $k = n^{1+\epsilon}$ this improves on $$T(n) = \sum_{i=1}^{n} \log d_i.$$&lt;/p&gt;</description>
+</item>
+<item>
+<title>The Basel problem</title>
+<link>https://math.example.org/basel</link>
+<description><![CDATA[<p>Euler showed that</p><p>\[ \sum_{n=1}^{\infty} \frac{1}{n^2} = \frac{\pi^2}{6} \]</p><p>or, in MathML: <math xmlns="http://www.w3.org/1998/Math/MathML" display="block"><semantics><mrow><msup><mi>&pi;</mi><mn>2</mn></msup><mo stretchy="false" onclick="alert(1)">/</mo><mn>6</mn></mrow><annotation encoding="application/x-tex">\pi^2/6</annotation></semantics></math></p><p>Plain text with $5 and $10 prices.</p>]]></description>
+</item>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sanitize := func(item parser.Item) string {
+		content := RewriteURLs(item.Content, item.URL)
+		content = StripTrackers(content)
+		return Sanitize(item.URL, content)
+	}
+
+	arxiv := sanitize(feed.Items[0])
+	for _, latex := range []string{`$\mathcal{O}(n \log(1 + k/n))$`, `\(\Omega(n \log(1 + k/n))\)`, `$$T(n) = \sum_{i=1}^{n} \log d_i.$$`} {
+		if !strings.Contains(arxiv, latex) {
+			t.Errorf("latex %s is lost: %s", latex, arxiv)
+		}
+	}
+	if !HasMath(arxiv) {
+		t.Error("expected math in the arxiv abstract")
+	}
+
+	blog := sanitize(feed.Items[1])
+	want := `<p>Euler showed that</p><p>\[ \sum_{n=1}^{\infty} \frac{1}{n^2} = \frac{\pi^2}{6} \]</p>` +
+		`<p>or, in MathML: <math xmlns="http://www.w3.org/1998/Math/MathML" display="block"><semantics><mrow><msup><mi>π</mi><mn>2</mn></msup><mo stretchy="false">/</mo><mn>6</mn></mrow><annotation encoding="application/x-tex">\pi^2/6</annotation></semantics></math></p>` +
+		`<p>Plain text with $5 and $10 prices.</p>`
+	if blog != want {
+		t.Errorf("\nwant: %s\nhave: %s", want, blog)
+	}
+	if !HasMath(blog) {
+		t.Error("expected math in the blog post")
+	}
+
+	if HasMath(`<p>Plain text with $5 and $10 prices.</p>`) {
+		t.Error("prices are not math")
+	}
+}
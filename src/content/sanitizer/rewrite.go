@@ -0,0 +1,119 @@
+package sanitizer
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// RewriteImages replaces image sources (img src/srcset, picture source srcset)
+// in already sanitized html with the value returned by the rewrite func.
+func RewriteImages(input string, rewrite func(string) string) string {
+	var buffer bytes.Buffer
+
+	tokenizer := html.NewTokenizer(strings.NewReader(input))
+	for {
+		if tokenizer.Next() == html.ErrorToken {
+			if tokenizer.Err() == io.EOF {
+				return buffer.String()
+			}
+			return input
+		}
+
+		token := tokenizer.Token()
+		if (token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken) &&
+			(token.Data == "img" || token.Data == "source") {
+			for i, attr := range token.Attr {
+				switch attr.Key {
+				case "src":
+					if !strings.HasPrefix(attr.Val, "data:") {
+						token.Attr[i].Val = rewrite(attr.Val)
+					}
+				case "srcset":
+					token.Attr[i].Val = rewriteSrcset(attr.Val, rewrite)
+				}
+			}
+		}
+		buffer.WriteString(token.String())
+	}
+}
+
+func rewriteSrcset(value string, rewrite func(string) string) string {
+	var sources []string
+	for _, candidate := range parseSrcset(value) {
+		source := candidate.url
+		if !strings.HasPrefix(source, "data:") {
+			source = rewrite(source)
+		}
+		if candidate.descriptor != "" {
+			source += " " + candidate.descriptor
+		}
+		sources = append(sources, source)
+	}
+	return strings.Join(sources, ", ")
+}
+
+// RewriteURLs resolves the relative urls in href, src, srcset and poster
+// attributes against the base url. Protocol-relative urls get https,
+// fragment-only links are left as is.
+func RewriteURLs(input, base string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return input
+	}
+	resolve := func(link string) string {
+		trimmed := strings.TrimSpace(link)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			return link
+		case strings.HasPrefix(trimmed, "//"):
+			return "https:" + trimmed
+		}
+		ref, err := url.Parse(trimmed)
+		if err != nil || ref.IsAbs() {
+			return link
+		}
+		return baseURL.ResolveReference(ref).String()
+	}
+
+	var buffer bytes.Buffer
+	tokenizer := html.NewTokenizer(strings.NewReader(input))
+	for {
+		if tokenizer.Next() == html.ErrorToken {
+			if tokenizer.Err() == io.EOF {
+				return buffer.String()
+			}
+			return input
+		}
+
+		// keep the original markup of the tokens left intact
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+		if token.Type != html.StartTagToken && token.Type != html.SelfClosingTagToken {
+			buffer.WriteString(raw)
+			continue
+		}
+		changed := false
+		for i, attr := range token.Attr {
+			val := attr.Val
+			switch attr.Key {
+			case "href", "src", "poster":
+				val = resolve(attr.Val)
+			case "srcset":
+				val = rewriteSrcset(attr.Val, resolve)
+			}
+			if val != attr.Val {
+				token.Attr[i].Val = val
+				changed = true
+			}
+		}
+		if changed {
+			buffer.WriteString(token.String())
+		} else {
+			buffer.WriteString(raw)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package sanitizer
+
+import "testing"
+
+func TestRewriteImages(t *testing.T) {
+	input := `<p>text <img src="http://example.org/a.png" srcset="http://example.org/a.png 1x, http://example.org/b.png 2x" loading="lazy"> <img src="data:image/gif;base64,test"></p>`
+	expected := `<p>text <img src="/proxy/http://example.org/a.png" srcset="/proxy/http://example.org/a.png 1x, /proxy/http://example.org/b.png 2x" loading="lazy"> <img src="data:image/gif;base64,test"></p>`
+	output := RewriteImages(input, func(link string) string { return "/proxy/" + link })
+
+	if output != expected {
+		t.Errorf(`Wrong output: %s`, output)
+	}
+}
+
+func TestRewriteURLs(t *testing.T) {
+	base := "https://example.org/blog/post/"
+	scenarios := map[string]string{
+		`<a href="/about">a</a>`:                              `<a href="https://example.org/about">a</a>`,
+		`<a href="../other/">a</a>`:                           `<a href="https://example.org/blog/other/">a</a>`,
+		`<a href="page.html?x=1&amp;y=2">a</a>`:               `<a href="https://example.org/blog/post/page.html?x=1&amp;y=2">a</a>`,
+		`<a href="#footnote-1">1</a>`:                         `<a href="#footnote-1">1</a>`,
+		`<a href="mailto:me@example.org">me</a>`:              `<a href="mailto:me@example.org">me</a>`,
+		`<a href="http://other.org/x">x</a>`:                  `<a href="http://other.org/x">x</a>`,
+		`<img src="//cdn.example.org/a.png">`:                 `<img src="https://cdn.example.org/a.png">`,
+		`<img src="images/a.png" alt="A &amp; B">`:            `<img src="https://example.org/blog/post/images/a.png" alt="A &amp; B">`,
+		`<img src="data:image/gif;base64,test">`:              `<img src="data:image/gif;base64,test">`,
+		`<img srcset="a.png 1x, //cdn.example.org/b.png 2x">`: `<img srcset="https://example.org/blog/post/a.png 1x, https://cdn.example.org/b.png 2x">`,
+		`<video poster="/poster.jpg" src="/v.mp4"></video>`:   `<video poster="https://example.org/poster.jpg" src="https://example.org/v.mp4"></video>`,
+		`<p>untouched &nbsp;<b class=x>markup</b><br/></p>`:   `<p>untouched &nbsp;<b class=x>markup</b><br/></p>`,
+	}
+	for input, expected := range scenarios {
+		output := RewriteURLs(input, base)
+		if output != expected {
+			t.Errorf("%s\nwant: %s\nhave: %s", input, expected, output)
+		}
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"regexp"
 	"strconv"
 	"strings"
 
@@ -16,14 +15,58 @@ import (
 	"golang.org/x/net/html"
 )
 
-var splitSrcsetRegex = regexp.MustCompile(`,\s+`)
+// DefaultIframeHosts are the hosts (including subdomains)
+// iframes are allowed from, unless configured otherwise.
+var DefaultIframeHosts = []string{
+	"bandcamp.com",
+	"cdn.embedly.com",
+	"invidio.us",
+	"player.bilibili.com",
+	"player.vimeo.com",
+	"soundcloud.com",
+	"vk.com",
+	"www.dailymotion.com",
+	"youtube-nocookie.com",
+	"youtube.com",
+}
+
+// Options tune the sanitization. The zero value means the defaults.
+type Options struct {
+	// Hosts (including subdomains) iframes are allowed from.
+	// Iframes from other hosts are replaced with links.
+	IframeHosts []string
+
+	// Prefix of the ids kept for the in-page links (e.g. footnotes),
+	// unique per document so that the ids of the documents shown
+	// on the same page don't collide. No ids are kept if empty.
+	IDPrefix string
+
+	anchors map[string]bool
+}
 
 // Sanitize returns safe HTML.
 func Sanitize(baseURL, input string) string {
+	return SanitizeWithOptions(baseURL, input, Options{})
+}
+
+// SanitizeWithOptions returns safe HTML.
+func SanitizeWithOptions(baseURL, input string, opts Options) string {
+	if opts.IframeHosts == nil {
+		opts.IframeHosts = DefaultIframeHosts
+	}
+	if opts.IDPrefix != "" {
+		opts.anchors = inPageAnchors(input)
+	}
+
 	var buffer bytes.Buffer
 	var tagStack []string
 	var parentTag string
 	blacklistedTagDepth := 0
+	preDepth := 0
+	pictureDepth := 0
+	// line number gutter being skipped
+	var gutterTag string
+	gutterDepth := 0
 
 	tokenizer := html.NewTokenizer(bytes.NewBufferString(input))
 	for {
@@ -37,6 +80,21 @@ func Sanitize(baseURL, input string) string {
 		}
 
 		token := tokenizer.Token()
+		if gutterDepth > 0 && gutterTag == "td" {
+			if skipGutterCell(token, &gutterDepth) {
+				continue
+			}
+		} else if gutterDepth > 0 {
+			if token.Data == gutterTag {
+				switch token.Type {
+				case html.StartTagToken:
+					gutterDepth++
+				case html.EndTagToken:
+					gutterDepth--
+				}
+			}
+			continue
+		}
 		switch token.Type {
 		case html.TextToken:
 			if blacklistedTagDepth > 0 {
@@ -52,12 +110,31 @@ func Sanitize(baseURL, input string) string {
 			buffer.WriteString(html.EscapeString(token.Data))
 		case html.StartTagToken:
 			tagName := token.Data
+			if isLineNumbers(tagName, token.Attr, preDepth > 0) {
+				gutterTag = tagName
+				gutterDepth = 1
+				continue
+			}
 			parentTag = tagName
+			if tagName == "img" || tagName == "source" {
+				token.Attr = promoteLazyAttributes(token.Attr)
+			}
+			if tagName == "pre" {
+				preDepth++
+			}
+			if tagName == "picture" {
+				pictureDepth++
+			}
+			if tagName == "source" && pictureDepth > 0 && !isImageSource(token.Attr) {
+				continue
+			}
 
 			if isValidTag(tagName) {
-				attrNames, htmlAttributes := sanitizeAttributes(baseURL, tagName, token.Attr)
+				attrNames, htmlAttributes := sanitizeAttributes(baseURL, tagName, token.Attr, opts)
 
-				if hasRequiredAttributes(tagName, attrNames) {
+				if tagName == "iframe" && !hasRequiredAttributes(tagName, attrNames) {
+					buffer.WriteString(iframeLink(baseURL, token))
+				} else if hasRequiredAttributes(tagName, attrNames) {
 					wrap := isVideoIframe(token)
 					if wrap {
 						buffer.WriteString(`<div class="video-wrapper">`)
@@ -88,6 +165,12 @@ func Sanitize(baseURL, input string) string {
 			if tagName == "iframe" {
 				continue
 			}
+			if tagName == "pre" && preDepth > 0 {
+				preDepth--
+			}
+			if tagName == "picture" && pictureDepth > 0 {
+				pictureDepth--
+			}
 			if isValidTag(tagName) && inList(tagName, tagStack) {
 				buffer.WriteString(fmt.Sprintf("</%s>", tagName))
 			} else if isBlockedTag(tagName) {
@@ -95,8 +178,14 @@ func Sanitize(baseURL, input string) string {
 			}
 		case html.SelfClosingTagToken:
 			tagName := token.Data
+			if tagName == "img" || tagName == "source" {
+				token.Attr = promoteLazyAttributes(token.Attr)
+			}
+			if tagName == "source" && pictureDepth > 0 && !isImageSource(token.Attr) {
+				continue
+			}
 			if isValidTag(tagName) {
-				attrNames, htmlAttributes := sanitizeAttributes(baseURL, tagName, token.Attr)
+				attrNames, htmlAttributes := sanitizeAttributes(baseURL, tagName, token.Attr, opts)
 
 				if hasRequiredAttributes(tagName, attrNames) {
 					if len(attrNames) > 0 {
@@ -110,25 +199,58 @@ func Sanitize(baseURL, input string) string {
 	}
 }
 
-func sanitizeAttributes(baseURL, tagName string, attributes []html.Attribute) ([]string, string) {
+func sanitizeAttributes(baseURL, tagName string, attributes []html.Attribute, opts Options) ([]string, string) {
 	var htmlAttrs, attrNames []string
+	inPageLink := false
 
 	for _, attribute := range attributes {
 		value := attribute.Val
 
+		// in-page anchors of the html elements, svg ids are kept as is
+		isAnchor := attribute.Key == "id" || (attribute.Key == "name" && tagName == "a")
+		if isAnchor && (allowedTags.has(tagName) || !allowedSvgTags.has(tagName)) {
+			if id := anchorID(value, opts); id != "" && !inList("id", attrNames) {
+				attrNames = append(attrNames, "id")
+				htmlAttrs = append(htmlAttrs, fmt.Sprintf(`id="%s"`, html.EscapeString(id)))
+			}
+			continue
+		}
+		if tagName == "a" && attribute.Key == "href" && strings.HasPrefix(value, "#") {
+			if id := anchorID(value[1:], opts); id != "" {
+				inPageLink = true
+				attrNames = append(attrNames, attribute.Key)
+				htmlAttrs = append(htmlAttrs, fmt.Sprintf(`href="#%s"`, html.EscapeString(id)))
+				continue
+			}
+		}
+
 		if !isValidAttribute(tagName, attribute.Key) {
 			continue
 		}
 
 		if (tagName == "img" || tagName == "source") && attribute.Key == "srcset" {
 			value = sanitizeSrcsetAttr(baseURL, value)
+			if value == "" {
+				continue
+			}
+		}
+
+		// svg animations must not turn the links into scripts
+		if attribute.Key == "attributename" && strings.Contains(strings.ToLower(value), "href") {
+			continue
+		}
+
+		if attribute.Key == "class" {
+			value = codeClasses(value)
+			if value == "" {
+				continue
+			}
 		}
 
 		if isExternalResourceAttribute(attribute.Key) {
 			if tagName == "iframe" {
-				if isValidIframeSource(baseURL, attribute.Val) {
-					value = attribute.Val
-				} else {
+				value = iframeSource(attribute.Val)
+				if value == "" || !isValidIframeSource(baseURL, value, opts.IframeHosts) {
 					continue
 				}
 			} else if tagName == "img" && attribute.Key == "src" && isValidDataAttribute(attribute.Val) {
@@ -150,7 +272,7 @@ func sanitizeAttributes(baseURL, tagName string, attributes []html.Attribute) ([
 	}
 
 	extraAttrNames, extraHTMLAttributes := getExtraAttributes(tagName)
-	if len(extraAttrNames) > 0 {
+	if len(extraAttrNames) > 0 && !inPageLink {
 		attrNames = append(attrNames, extraAttrNames...)
 		htmlAttrs = append(htmlAttrs, extraHTMLAttributes...)
 	}
@@ -165,7 +287,11 @@ func getExtraAttributes(tagName string) ([]string, []string) {
 	case "video", "audio":
 		return []string{"controls"}, []string{"controls"}
 	case "iframe":
-		return []string{"sandbox", "loading"}, []string{`sandbox="allow-scripts allow-same-origin allow-popups"`, `loading="lazy"`}
+		return []string{"sandbox", "allow", "loading"}, []string{
+			`sandbox="allow-scripts allow-same-origin allow-popups"`,
+			`allow="encrypted-media; fullscreen; picture-in-picture"`,
+			`loading="lazy"`,
+		}
 	case "img":
 		return []string{"loading"}, []string{`loading="lazy"`}
 	default:
@@ -174,7 +300,7 @@ func getExtraAttributes(tagName string) ([]string, []string) {
 }
 
 func isValidTag(tagName string) bool {
-	x := allowedTags.has(tagName) || allowedSvgTags.has(tagName) || allowedSvgFilters.has(tagName)
+	x := allowedTags.has(tagName) || allowedSvgTags.has(tagName) || allowedSvgFilters.has(tagName) || allowedMathTags.has(tagName)
 	//fmt.Println(tagName, x)
 	return x
 }
@@ -186,6 +312,9 @@ func isValidAttribute(tagName, attributeName string) bool {
 	if allowedSvgTags.has(tagName) {
 		return allowedSvgAttrs.has(attributeName)
 	}
+	if allowedMathTags.has(tagName) {
+		return allowedMathAttrs.has(attributeName)
+	}
 	return false
 }
 
@@ -247,29 +376,15 @@ func isBlockedResource(src string) bool {
 	return false
 }
 
-func isValidIframeSource(baseURL, src string) bool {
-	whitelist := []string{
-		"bandcamp.com",
-		"cdn.embedly.com",
-		"invidio.us",
-		"player.bilibili.com",
-		"player.vimeo.com",
-		"soundcloud.com",
-		"vk.com",
-		"w.soundcloud.com",
-		"www.dailymotion.com",
-		"www.youtube-nocookie.com",
-		"www.youtube.com",
-	}
-
+func isValidIframeSource(baseURL, src string, hosts []string) bool {
 	domain := htmlutil.URLDomain(src)
 	// allow iframe from same origin
 	if htmlutil.URLDomain(baseURL) == domain {
 		return true
 	}
 
-	for _, safeDomain := range whitelist {
-		if safeDomain == domain {
+	for _, host := range hosts {
+		if domain == host || strings.HasSuffix(domain, "."+host) {
 			return true
 		}
 	}
@@ -277,6 +392,39 @@ func isValidIframeSource(baseURL, src string) bool {
 	return false
 }
 
+// iframeSource returns the https url of the iframe,
+// or an empty string if it's not a web page.
+func iframeSource(src string) string {
+	src = strings.TrimSpace(src)
+	switch {
+	case strings.HasPrefix(src, "//"):
+		return "https:" + src
+	case strings.HasPrefix(src, "http://"):
+		return "https://" + strings.TrimPrefix(src, "http://")
+	case strings.HasPrefix(src, "https://"):
+		return src
+	}
+	return ""
+}
+
+// iframeLink returns a link to the iframe's page
+// to show in place of the embed that's not allowed.
+func iframeLink(baseURL string, token html.Token) string {
+	for _, attr := range token.Attr {
+		if attr.Key != "src" {
+			continue
+		}
+		link := htmlutil.AbsoluteUrl(attr.Val, baseURL)
+		if link == "" || !htmlutil.IsAPossibleLink(link) || isBlockedResource(link) {
+			return ""
+		}
+		_, extra := getExtraAttributes("a")
+		return fmt.Sprintf(`<a href="%s" %s>%s</a>`,
+			html.EscapeString(link), strings.Join(extra, " "), html.EscapeString(link))
+	}
+	return ""
+}
+
 func getTagAllowList() map[string][]string {
 	whitelist := make(map[string][]string)
 	whitelist["img"] = []string{"alt", "title", "src", "srcset", "sizes"}
@@ -374,42 +522,97 @@ Each string is composed of:
 */
 func sanitizeSrcsetAttr(baseURL, value string) string {
 	var sanitizedSources []string
-	rawSources := splitSrcsetRegex.Split(value, -1)
-	for _, rawSource := range rawSources {
-		parts := strings.Split(strings.TrimSpace(rawSource), " ")
-		nbParts := len(parts)
-
-		if nbParts > 0 {
-			sanitizedSource := parts[0]
-			if !strings.HasPrefix(parts[0], "data:") {
-				sanitizedSource = htmlutil.AbsoluteUrl(parts[0], baseURL)
-				if sanitizedSource == "" {
-					continue
-				}
-			}
+	for _, candidate := range parseSrcset(value) {
+		if candidate.descriptor != "" && !isValidWidthOrDensityDescriptor(candidate.descriptor) {
+			continue
+		}
 
-			if nbParts == 2 && isValidWidthOrDensityDescriptor(parts[1]) {
-				sanitizedSource += " " + parts[1]
+		sanitizedSource := candidate.url
+		if strings.HasPrefix(sanitizedSource, "data:") {
+			if !isValidDataAttribute(sanitizedSource) {
+				continue
 			}
+		} else {
+			sanitizedSource = htmlutil.AbsoluteUrl(sanitizedSource, baseURL)
+			if sanitizedSource == "" || !hasValidURIScheme(sanitizedSource) || isBlockedResource(sanitizedSource) {
+				continue
+			}
+		}
 
-			sanitizedSources = append(sanitizedSources, sanitizedSource)
+		if candidate.descriptor != "" {
+			sanitizedSource += " " + candidate.descriptor
 		}
+		sanitizedSources = append(sanitizedSources, sanitizedSource)
 	}
 	return strings.Join(sanitizedSources, ", ")
 }
 
+// isImageSource reports whether the picture source is an image.
+// Sources without the type are.
+func isImageSource(attrs []html.Attribute) bool {
+	for _, attr := range attrs {
+		if attr.Key == "type" {
+			return strings.HasPrefix(strings.ToLower(strings.TrimSpace(attr.Val)), "image/")
+		}
+	}
+	return true
+}
+
+type srcsetCandidate struct {
+	url        string
+	descriptor string
+}
+
+// parseSrcset splits the srcset into the image candidates.
+// Unlike the plain split by commas, it keeps the commas inside the urls
+// (e.g. "data:" urls or image cdn parameters like "w_300,h_200").
+// See https://html.spec.whatwg.org/multipage/images.html#parse-a-srcset-attribute
+func parseSrcset(value string) []srcsetCandidate {
+	const whitespace = " \t\n\r\f"
+
+	var candidates []srcsetCandidate
+	for {
+		value = strings.TrimLeft(value, whitespace+",")
+		if value == "" {
+			return candidates
+		}
+
+		end := strings.IndexAny(value, whitespace)
+		if end < 0 {
+			end = len(value)
+		}
+		url := value[:end]
+		value = value[end:]
+		if strings.HasSuffix(url, ",") {
+			candidates = append(candidates, srcsetCandidate{url: strings.TrimRight(url, ",")})
+			continue
+		}
+
+		end = strings.IndexByte(value, ',')
+		if end < 0 {
+			end = len(value)
+		}
+		descriptor := strings.TrimSpace(value[:end])
+		value = value[end:]
+		candidates = append(candidates, srcsetCandidate{url: url, descriptor: descriptor})
+	}
+}
+
 func isValidWidthOrDensityDescriptor(value string) bool {
 	if value == "" {
 		return false
 	}
 
-	lastChar := value[len(value)-1:]
-	if lastChar != "w" && lastChar != "x" {
-		return false
+	number := value[0 : len(value)-1]
+	switch value[len(value)-1:] {
+	case "w":
+		width, err := strconv.Atoi(number)
+		return err == nil && width > 0
+	case "x":
+		density, err := strconv.ParseFloat(number, 32)
+		return err == nil && density > 0
 	}
-
-	_, err := strconv.ParseFloat(value[0:len(value)-1], 32)
-	return err == nil
+	return false
 }
 
 func isValidDataAttribute(value string) bool {
@@ -433,6 +636,82 @@ func isValidDataAttribute(value string) bool {
 	return false
 }
 
+// codeClasses keeps the classes used by the syntax highlighters
+// to tell the language of the code block.
+func codeClasses(value string) string {
+	var classes []string
+	for _, class := range strings.Fields(value) {
+		if strings.HasPrefix(class, "language-") ||
+			strings.HasPrefix(class, "lang-") ||
+			strings.HasPrefix(class, "hljs") {
+			classes = append(classes, class)
+		}
+	}
+	return strings.Join(classes, " ")
+}
+
+// classes of the line number gutters added by the syntax highlighters:
+// pygments, rouge (jekyll), chroma (hugo) and syntaxhighlighter (wordpress)
+var lineNumberClasses = map[string]bool{
+	"gutter":    true,
+	"linenos":   true,
+	"linenodiv": true,
+	"lineno":    true,
+	"lnt":       true,
+	"ln":        true,
+}
+
+// isLineNumbers reports whether the element is a line number gutter.
+// These are only looked for in the table cells and inside the code blocks.
+func isLineNumbers(tagName string, attrs []html.Attribute, inPre bool) bool {
+	if tagName != "td" && !inPre {
+		return false
+	}
+	for _, attr := range attrs {
+		if attr.Key != "class" {
+			continue
+		}
+		for _, class := range strings.Fields(attr.Val) {
+			if lineNumberClasses[class] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// skipGutterCell reports whether the token is a part of the table cell
+// being skipped, its end tag included. The end tag is optional, the cell
+// ends with the next one or the end of its row or table just as well, these
+// are kept. The depth counts the tables the token is in, the cell's own
+// included, and is zeroed once the cell ends.
+func skipGutterCell(token html.Token, depth *int) bool {
+	switch {
+	case token.Type == html.StartTagToken && token.Data == "table":
+		*depth++
+	case *depth > 1:
+		// in a table nested in the cell
+		if token.Type == html.EndTagToken && token.Data == "table" {
+			*depth--
+		}
+	case token.Type == html.StartTagToken:
+		switch token.Data {
+		case "td", "th", "tr", "tbody", "thead", "tfoot":
+			*depth = 0
+			return false
+		}
+	case token.Type == html.EndTagToken:
+		switch token.Data {
+		case "td", "th":
+			*depth = 0
+		case "tr", "tbody", "thead", "tfoot", "table":
+			*depth = 0
+			return false
+		}
+	}
+	return true
+}
+
 func isVideoIframe(token html.Token) bool {
 	videoWhitelist := map[string]bool{
 		"player.bilibili.com":      true,
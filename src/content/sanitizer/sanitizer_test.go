@@ -165,7 +165,7 @@ func TestInvalidNestedTag(t *testing.T) {
 
 func TestValidIFrame(t *testing.T) {
 	input := `<iframe src="http://example.org/"></iframe>`
-	expected := `<iframe src="http://example.org/" sandbox="allow-scripts allow-same-origin allow-popups" loading="lazy"></iframe>`
+	expected := `<iframe src="https://example.org/" sandbox="allow-scripts allow-same-origin allow-popups" allow="encrypted-media; fullscreen; picture-in-picture" loading="lazy"></iframe>`
 	output := Sanitize("http://example.org/", input)
 
 	if expected != output {
@@ -175,7 +175,7 @@ func TestValidIFrame(t *testing.T) {
 
 func TestInvalidIFrame(t *testing.T) {
 	input := `<iframe src="http://example.org/"></iframe>`
-	expected := ``
+	expected := `<a href="http://example.org/" rel="noopener noreferrer" target="_blank" referrerpolicy="no-referrer">http://example.org/</a>`
 	output := Sanitize("http://example.com/", input)
 
 	if expected != output {
@@ -185,7 +185,7 @@ func TestInvalidIFrame(t *testing.T) {
 
 func TestIFrameWithChildElements(t *testing.T) {
 	input := `<iframe src="https://www.youtube.com/"><p>test</p></iframe>`
-	expected := `<div class="video-wrapper"><iframe src="https://www.youtube.com/" sandbox="allow-scripts allow-same-origin allow-popups" loading="lazy"></iframe></div>`
+	expected := `<div class="video-wrapper"><iframe src="https://www.youtube.com/" sandbox="allow-scripts allow-same-origin allow-popups" allow="encrypted-media; fullscreen; picture-in-picture" loading="lazy"></iframe></div>`
 	output := Sanitize("http://example.com/", input)
 
 	if expected != output {
@@ -265,7 +265,7 @@ func TestEspaceAttributes(t *testing.T) {
 
 func TestReplaceIframeURL(t *testing.T) {
 	input := `<iframe src="https://player.vimeo.com/video/123456?title=0&amp;byline=0"></iframe>`
-	expected := `<div class="video-wrapper"><iframe src="https://player.vimeo.com/video/123456?title=0&amp;byline=0" sandbox="allow-scripts allow-same-origin allow-popups" loading="lazy"></iframe></div>`
+	expected := `<div class="video-wrapper"><iframe src="https://player.vimeo.com/video/123456?title=0&amp;byline=0" sandbox="allow-scripts allow-same-origin allow-popups" allow="encrypted-media; fullscreen; picture-in-picture" loading="lazy"></iframe></div>`
 	output := Sanitize("http://example.org/", input)
 
 	if expected != output {
@@ -305,10 +305,55 @@ func TestReplaceStyle(t *testing.T) {
 
 func TestWrapYoutubeIFrames(t *testing.T) {
 	input := `<iframe src="https://www.youtube.com/embed/foobar"></iframe>`
-	expected := `<div class="video-wrapper"><iframe src="https://www.youtube.com/embed/foobar" sandbox="allow-scripts allow-same-origin allow-popups" loading="lazy"></iframe></div>`
+	expected := `<div class="video-wrapper"><iframe src="https://www.youtube.com/embed/foobar" sandbox="allow-scripts allow-same-origin allow-popups" allow="encrypted-media; fullscreen; picture-in-picture" loading="lazy"></iframe></div>`
 	output := Sanitize("http://example.org/", input)
 
 	if expected != output {
 		t.Errorf("Wrong output:\nwant: %v\nhave: %v", expected, output)
 	}
 }
+
+func TestIFrameAllowList(t *testing.T) {
+	scenarios := []struct {
+		input    string
+		hosts    []string
+		expected string
+	}{
+		{
+			`<iframe src="//bandcamp.com/EmbeddedPlayer/album=1"></iframe>`,
+			nil,
+			`<iframe src="https://bandcamp.com/EmbeddedPlayer/album=1" sandbox="allow-scripts allow-same-origin allow-popups" allow="encrypted-media; fullscreen; picture-in-picture" loading="lazy"></iframe>`,
+		},
+		{
+			`<iframe src="http://www.youtube-nocookie.com/embed/x" allow="camera"></iframe>`,
+			nil,
+			`<div class="video-wrapper"><iframe src="https://www.youtube-nocookie.com/embed/x" sandbox="allow-scripts allow-same-origin allow-popups" allow="encrypted-media; fullscreen; picture-in-picture" loading="lazy"></iframe></div>`,
+		},
+		{
+			`<iframe src="https://player.vimeo.com/video/1"></iframe>`,
+			[]string{"example.net"},
+			`<a href="https://player.vimeo.com/video/1" rel="noopener noreferrer" target="_blank" referrerpolicy="no-referrer">https://player.vimeo.com/video/1</a>`,
+		},
+		{
+			`<iframe src="https://embed.example.net/1"></iframe>`,
+			[]string{"example.net"},
+			`<iframe src="https://embed.example.net/1" sandbox="allow-scripts allow-same-origin allow-popups" allow="encrypted-media; fullscreen; picture-in-picture" loading="lazy"></iframe>`,
+		},
+		{
+			`<iframe src="https://notyoutube.com/embed/x"></iframe>`,
+			nil,
+			`<a href="https://notyoutube.com/embed/x" rel="noopener noreferrer" target="_blank" referrerpolicy="no-referrer">https://notyoutube.com/embed/x</a>`,
+		},
+		{
+			`<iframe src="javascript:alert(1)"></iframe>`,
+			nil,
+			``,
+		},
+	}
+	for _, scenario := range scenarios {
+		output := SanitizeWithOptions("http://example.com/", scenario.input, Options{IframeHosts: scenario.hosts})
+		if output != scenario.expected {
+			t.Errorf("Wrong output:\nwant: %s\nhave: %s", scenario.expected, output)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package sanitizer
+
+import "testing"
+
+func TestResponsiveImages(t *testing.T) {
+	scenarios := map[string][2]string{
+		"malformed candidates dropped": {
+			`<img src="a.jpg" srcset="a-1x.jpg 1x, a-wide.jpg 12.5w, a-2x.jpg 2x 3x, javascript:alert(1) 3x, a-big.jpg 1600w" sizes="(max-width: 600px) 100vw, 600px">`,
+			`<img src="http://example.org/a.jpg" srcset="http://example.org/a-1x.jpg 1x, http://example.org/a-big.jpg 1600w" sizes="(max-width: 600px) 100vw, 600px" loading="lazy">`,
+		},
+		"commas in urls": {
+			`<img src="https://res.cloudinary.com/demo/w_300,h_200/a.jpg" srcset="https://res.cloudinary.com/demo/w_300,h_200/a.jpg 300w,https://res.cloudinary.com/demo/w_600,h_400/a.jpg 600w">`,
+			`<img src="https://res.cloudinary.com/demo/w_300,h_200/a.jpg" srcset="https://res.cloudinary.com/demo/w_300,h_200/a.jpg 300w, https://res.cloudinary.com/demo/w_600,h_400/a.jpg 600w" loading="lazy">`,
+		},
+		"all candidates malformed": {
+			`<img src="a.jpg" srcset="a.jpg 2q">`,
+			`<img src="http://example.org/a.jpg" loading="lazy">`,
+		},
+		"picture": {
+			`<picture><source type="image/avif" srcset="/a.avif 1x, /a@2x.avif 2x" sizes="50vw"><source type="video/mp4" srcset="/a.mp4"><source media="(min-width: 800px)" srcset="//cdn.example.org/a.webp"><img src="/a.jpg" srcset="/a.jpg 1x, /a@2x.jpg 2x" sizes="50vw" alt="A"></picture>`,
+			`<picture><source type="image/avif" srcset="http://example.org/a.avif 1x, http://example.org/a@2x.avif 2x" sizes="50vw"><source media="(min-width: 800px)" srcset="http://cdn.example.org/a.webp"><img src="http://example.org/a.jpg" srcset="http://example.org/a.jpg 1x, http://example.org/a@2x.jpg 2x" sizes="50vw" alt="A" loading="lazy"></picture>`,
+		},
+		"video sources kept": {
+			`<video src="/v.mp4"><source src="/v.webm" type="video/webm"></video>`,
+			`<video src="http://example.org/v.mp4" controls><source src="http://example.org/v.webm" type="video/webm"></video>`,
+		},
+	}
+	for name, scenario := range scenarios {
+		output := Sanitize("http://example.org/", scenario[0])
+		if output != scenario[1] {
+			t.Errorf("%s:\nwant: %s\nhave: %s", name, scenario[1], output)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package sanitizer
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"golang.org/x/net/html"
+)
+
+// hosts (including subdomains) serving tracking pixels only
+var trackerHosts = []string{
+	"ad.doubleclick.net",
+	"doubleclick.net",
+	"feeds.feedburner.com",
+	"feedproxy.google.com",
+	"feedsportal.com",
+	"google-analytics.com",
+	"list-manage.com",
+	"pixel.quantserve.com",
+	"pixel.wp.com",
+	"sb.scorecardresearch.com",
+	"stats.wordpress.com",
+}
+
+// Images this small (in px) in either dimension are considered pixels.
+// Legitimate icons are usually 8px and up.
+const trackerMaxSize = 1
+
+// elements without the closing tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// StripTrackers removes the tracking pixels from the html:
+// images of 0 or 1 px in size, images from the known tracker hosts
+// and images inside hidden containers.
+func StripTrackers(input string) string {
+	var buffer bytes.Buffer
+	// open elements, true if hidden
+	var stack []bool
+	hiddenDepth := 0
+
+	tokenizer := html.NewTokenizer(strings.NewReader(input))
+	for {
+		if tokenizer.Next() == html.ErrorToken {
+			if tokenizer.Err() == io.EOF {
+				return buffer.String()
+			}
+			return input
+		}
+
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+		switch token.Type {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if token.Data == "img" && (hiddenDepth > 0 || isTrackerImage(token.Attr)) {
+				continue
+			}
+			if token.Type == html.StartTagToken && !voidElements[token.Data] {
+				hidden := isHiddenElement(token.Attr)
+				if hidden {
+					hiddenDepth++
+				}
+				stack = append(stack, hidden)
+			}
+		case html.EndTagToken:
+			if !voidElements[token.Data] && len(stack) > 0 {
+				if stack[len(stack)-1] {
+					hiddenDepth--
+				}
+				stack = stack[:len(stack)-1]
+			}
+		}
+		buffer.WriteString(raw)
+	}
+}
+
+func isTrackerImage(attrs []html.Attribute) bool {
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "src":
+			if isTrackerURL(attr.Val) {
+				return true
+			}
+		case "width", "height":
+			if isPixelSize(attr.Val) {
+				return true
+			}
+		case "style":
+			style := parseStyle(attr.Val)
+			if isPixelSize(style["width"]) || isPixelSize(style["height"]) {
+				return true
+			}
+		}
+	}
+	return isHiddenElement(attrs)
+}
+
+func isTrackerURL(src string) bool {
+	src = strings.TrimSpace(src)
+	if strings.HasPrefix(src, "//") {
+		src = "https:" + src
+	}
+	domain := strings.ToLower(htmlutil.URLDomain(src))
+	if domain == "" {
+		return false
+	}
+	for _, host := range trackerHosts {
+		if domain == host || strings.HasSuffix(domain, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPixelSize reports whether the size ("1", "1px", "0") is tiny.
+// Relative and unknown sizes are not.
+func isPixelSize(val string) bool {
+	val = strings.TrimSuffix(strings.TrimSpace(strings.ToLower(val)), "px")
+	if val == "" {
+		return false
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	return err == nil && size >= 0 && size <= trackerMaxSize
+}
+
+func isHiddenElement(attrs []html.Attribute) bool {
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "hidden":
+			return true
+		case "style":
+			style := parseStyle(attr.Val)
+			if style["display"] == "none" || style["visibility"] == "hidden" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseStyle returns the declarations of the inline style, lowercased.
+func parseStyle(style string) map[string]string {
+	result := make(map[string]string)
+	for _, decl := range strings.Split(style, ";") {
+		parts := strings.SplitN(decl, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		val := strings.ToLower(strings.TrimSpace(parts[1]))
+		val = strings.TrimSpace(strings.TrimSuffix(val, "!important"))
+		result[key] = val
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+package sanitizer
+
+import "testing"
+
+func TestStripTrackers(t *testing.T) {
+	scenarios := map[string][2]string{
+		"feedburner": {
+			`<p>Story.</p><div class="feedflare"><a href="http://feeds.feedburner.com/~ff/Example?a=x"><img src="http://feeds.feedburner.com/~ff/Example?d=yIl2AUoC8zA" border="0"></a></div><img src="http://feeds.feedburner.com/~r/Example/~4/AbCdEf" height="1" width="1" alt=""/>`,
+			`<p>Story.</p><div class="feedflare"><a href="http://feeds.feedburner.com/~ff/Example?a=x"></a></div>`,
+		},
+		"mailchimp": {
+			`<table><tr><td><p>Hello subscriber</p><img src="https://example.us1.list-manage.com/track/open.php?u=abc&amp;id=def&amp;e=123" alt=""></td></tr></table>`,
+			`<table><tr><td><p>Hello subscriber</p></td></tr></table>`,
+		},
+		"doubleclick": {
+			`<p>Sponsored</p><img src="//ad.doubleclick.net/ddm/trackimp/N1234.abc;ord=1" border="0">`,
+			`<p>Sponsored</p>`,
+		},
+		"wordpress stats": {
+			`<p>Post</p><img src="https://pixel.wp.com/b.gif?host=example.org&amp;blog=1&amp;post=2" alt="" width="1" height="1" border="0" />`,
+			`<p>Post</p>`,
+		},
+		"inline style": {
+			`<p>Newsletter<img src="https://mail.example.org/open/abc.gif" style="width: 1px !important; height:1px; border:0"></p>`,
+			`<p>Newsletter</p>`,
+		},
+		"zero size": {
+			`<img src="https://mail.example.org/o.gif" width="0" height="0"><p>text</p>`,
+			`<p>text</p>`,
+		},
+		"hidden container": {
+			`<div style="display:none"><img src="https://mail.example.org/o.gif"><span>preheader</span></div><p>Body <img src="https://example.org/a.png"></p>`,
+			`<div style="display:none"><span>preheader</span></div><p>Body <img src="https://example.org/a.png"></p>`,
+		},
+		"hidden attribute": {
+			`<span hidden><img src="https://mail.example.org/o.gif"></span><img src="https://example.org/a.png">`,
+			`<span hidden></span><img src="https://example.org/a.png">`,
+		},
+		"small icons kept": {
+			`<p><img src="https://example.org/icons/rss.png" width="8" height="8"> <img src="https://example.org/emoji.png" style="height: 1em; width: 1em"> <img src="https://example.org/a.png" width="100%"></p>`,
+			`<p><img src="https://example.org/icons/rss.png" width="8" height="8"> <img src="https://example.org/emoji.png" style="height: 1em; width: 1em"> <img src="https://example.org/a.png" width="100%"></p>`,
+		},
+	}
+	for name, scenario := range scenarios {
+		output := StripTrackers(scenario[0])
+		if output != scenario[1] {
+			t.Errorf("%s:\nwant: %s\nhave: %s", name, scenario[1], output)
+		}
+	}
+}
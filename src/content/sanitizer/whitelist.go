@@ -18,6 +18,7 @@ func (s *set) has(val string) bool {
 }
 
 // taken from: https://github.com/cure53/DOMPurify/blob/e1c19cf6/src/tags.js
+// without the document, form and shadow dom elements
 var allowedTags = sset([]string{
 	"a",
 	"abbr",
@@ -33,9 +34,7 @@ var allowedTags = sset([]string{
 	"big",
 	"blink",
 	"blockquote",
-	"body",
 	"br",
-	"button",
 	"canvas",
 	"caption",
 	"center",
@@ -43,60 +42,44 @@ var allowedTags = sset([]string{
 	"code",
 	"col",
 	"colgroup",
-	"content",
 	"data",
-	"datalist",
 	"dd",
-	"decorator",
 	"del",
 	"details",
 	"dfn",
-	"dialog",
 	"dir",
 	"div",
 	"dl",
 	"dt",
-	"element",
 	"em",
-	"fieldset",
 	"figcaption",
 	"figure",
 	"font",
 	"footer",
-	"form",
 	"h1",
 	"h2",
 	"h3",
 	"h4",
 	"h5",
 	"h6",
-	"head",
 	"header",
 	"hgroup",
 	"hr",
-	"html",
 	"i",
 	"iframe",
 	"img",
-	"input",
 	"ins",
 	"kbd",
-	"label",
-	"legend",
 	"li",
 	"main",
 	"map",
 	"mark",
 	"marquee",
 	"menu",
-	"menuitem",
 	"meter",
 	"nav",
 	"nobr",
 	"ol",
-	"optgroup",
-	"option",
-	"output",
 	"p",
 	"picture",
 	"pre",
@@ -108,8 +91,6 @@ var allowedTags = sset([]string{
 	"s",
 	"samp",
 	"section",
-	"select",
-	"shadow",
 	"small",
 	"source",
 	"spacer",
@@ -122,8 +103,6 @@ var allowedTags = sset([]string{
 	"table",
 	"tbody",
 	"td",
-	"template",
-	"textarea",
 	"tfoot",
 	"th",
 	"thead",
@@ -211,18 +190,22 @@ var allowedSvgFilters = sset([]string{
 })
 
 var allowedAttrs = map[string]set{
-	"img":     sset([]string{"alt", "title", "src", "srcset", "sizes"}),
-	"audio":   sset([]string{"src"}),
-	"video":   sset([]string{"poster", "height", "width", "src"}),
-	"source":  sset([]string{"src", "type", "srcset", "sizes", "media"}),
-	"td":      sset([]string{"rowspan", "colspan"}),
-	"th":      sset([]string{"rowspan", "colspan"}),
-	"q":       sset([]string{"cite"}),
-	"a":       sset([]string{"href", "title"}),
-	"time":    sset([]string{"datetime"}),
-	"abbr":    sset([]string{"title"}),
-	"acronym": sset([]string{"title"}),
-	"iframe":  sset([]string{"width", "height", "frameborder", "src", "allowfullscreen"}),
+	"img":      sset([]string{"alt", "title", "src", "srcset", "sizes"}),
+	"audio":    sset([]string{"src"}),
+	"video":    sset([]string{"poster", "height", "width", "src"}),
+	"source":   sset([]string{"src", "type", "srcset", "sizes", "media"}),
+	"td":       sset([]string{"rowspan", "colspan"}),
+	"th":       sset([]string{"rowspan", "colspan", "scope"}),
+	"col":      sset([]string{"span"}),
+	"colgroup": sset([]string{"span"}),
+	"q":        sset([]string{"cite"}),
+	"a":        sset([]string{"href", "title"}),
+	"time":     sset([]string{"datetime"}),
+	"abbr":     sset([]string{"title"}),
+	"acronym":  sset([]string{"title"}),
+	"iframe":   sset([]string{"width", "height", "frameborder", "src", "allowfullscreen"}),
+	"pre":      sset([]string{"class"}),
+	"code":     sset([]string{"class"}),
 }
 
 var allowedSvgAttrs = sset([]string{
@@ -409,6 +392,85 @@ var allowedSvgAttrs = sset([]string{
 	"zoomandpan",
 })
 
+// presentation mathml, without the elements and attributes
+// able to link (href) or run actions (maction) and without annotation-xml,
+// which may contain html
+var allowedMathTags = sset([]string{
+	"math",
+	"annotation",
+	"menclose",
+	"merror",
+	"mfenced",
+	"mfrac",
+	"mi",
+	"mmultiscripts",
+	"mn",
+	"mo",
+	"mover",
+	"mpadded",
+	"mphantom",
+	"mprescripts",
+	"mroot",
+	"mrow",
+	"ms",
+	"mspace",
+	"msqrt",
+	"mstyle",
+	"msub",
+	"msubsup",
+	"msup",
+	"mtable",
+	"mtd",
+	"mtext",
+	"mtr",
+	"munder",
+	"munderover",
+	"none",
+	"semantics",
+})
+
+var allowedMathAttrs = sset([]string{
+	"accent",
+	"accentunder",
+	"align",
+	"alttext",
+	"close",
+	"columnalign",
+	"columnlines",
+	"columnspacing",
+	"columnspan",
+	"depth",
+	"display",
+	"displaystyle",
+	"encoding",
+	"fence",
+	"frame",
+	"height",
+	"largeop",
+	"linethickness",
+	"lspace",
+	"mathsize",
+	"mathvariant",
+	"maxsize",
+	"minsize",
+	"movablelimits",
+	"notation",
+	"open",
+	"rowalign",
+	"rowlines",
+	"rowspacing",
+	"rowspan",
+	"rspace",
+	"scriptlevel",
+	"separator",
+	"separators",
+	"stretchy",
+	"symmetric",
+	"voffset",
+	"width",
+	"xmlns",
+})
+
 var allowedURISchemes = sset([]string{
 	"http",
 	"https",
@@ -0,0 +1,155 @@
+package sanitizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/parser"
+	"golang.org/x/net/html"
+)
+
+// payloads from the common xss cheat sheets
+var xssPayloads = []string{
+	`<script>alert(1)</script>`,
+	`<scr<script>ipt>alert(1)</script>`,
+	`<img src=x onerror=alert(1)>`,
+	`<IMG SRC="javascript:alert('XSS');">`,
+	`<IMG SRC=JaVaScRiPt:alert('XSS')>`,
+	`<IMG SRC=&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;&#97;&#108;&#101;&#114;&#116;&#40;&#39;&#88;&#83;&#83;&#39;&#41;>`,
+	`<IMG SRC="jav&#x09;ascript:alert('XSS');">`,
+	`<IMG SRC=" &#14;  javascript:alert('XSS');">`,
+	`<img """><script>alert("XSS")</script>">`,
+	`<img src="data:image/svg+xml,<svg onload=alert(1)>">`,
+	`<img srcset="javascript:alert(1) 1x, data:text/html,<script>alert(1)</script> 2x">`,
+	`<a href="javascript:alert(1)">x</a>`,
+	`<a href="  javascript:alert(1)">x</a>`,
+	`<a href="&#x6A;avascript:alert(1)">x</a>`,
+	`<a href="vbscript:msgbox(1)">x</a>`,
+	`<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`,
+	`<a href="http://example.com/" onclick="alert(1)">x</a>`,
+	`<q cite="javascript:alert(1)">x</q>`,
+	`<video poster=javascript:alert(1)//></video>`,
+	`<div style="background-image: url(javascript:alert(1))">x</div>`,
+	`<div style="width: expression(alert(1))">x</div>`,
+	`<table background="javascript:alert(1)"><tr><td>x</td></tr></table>`,
+	`<body onload=alert(1)>`,
+	`<details open ontoggle=alert(1)>x</details>`,
+	`<marquee onstart=alert(1)>x</marquee>`,
+	`<input autofocus onfocus=alert(1)>`,
+	`<form action="javascript:alert(1)"><input type="submit" formaction="javascript:alert(1)"><button formaction=javascript:alert(1)>x</button></form>`,
+	`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+	`<base href="javascript:alert(1)//">`,
+	`<link rel="stylesheet" href="javascript:alert(1)">`,
+	`<style>@import 'javascript:alert(1)';</style>`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+	`<iframe srcdoc="<script>alert(1)</script>"></iframe>`,
+	`<object data="javascript:alert(1)"></object>`,
+	`<embed src="javascript:alert(1)">`,
+	`<svg onload=alert(1)>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<svg><a xlink:href="javascript:alert(1)"><text x="20" y="20">x</text></a></svg>`,
+	`<svg><a href="javascript:alert(1)"><text x="20" y="20">x</text></a></svg>`,
+	`<svg><a><animatetransform attributeName="href" values="javascript:alert(1)"/><text>x</text></a></svg>`,
+	`<svg><image href="javascript:alert(1)"/></svg>`,
+	`<math><a xlink:href="javascript:alert(1)">x</a></math>`,
+	`<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>`,
+	`<xmp><img src=x onerror=alert(1)></xmp>`,
+	`<!--<img src="--><img src=x onerror=alert(1)//">`,
+	`<"';alert(String.fromCharCode(88,83,83))//\';`,
+}
+
+var xssForbiddenTags = map[string]bool{
+	"script": true, "style": true, "object": true, "embed": true, "applet": true,
+	"form": true, "input": true, "button": true, "textarea": true, "select": true,
+	"meta": true, "base": true, "link": true, "html": true, "head": true, "body": true,
+	"frame": true, "frameset": true,
+}
+
+var xssForbiddenAttrs = map[string]bool{
+	"style": true, "srcdoc": true, "action": true, "formaction": true,
+	"background": true, "xlink:href": true, "data": true,
+}
+
+func isSafeURL(tagName, attrName, value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, prefix := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return (tagName == "img" || tagName == "source") && attrName != "href" && strings.HasPrefix(value, "data:image/")
+}
+
+// assertInert fails the test if the html could run scripts when rendered.
+func assertInert(t *testing.T, payload, output string) {
+	t.Helper()
+
+	tokenizer := html.NewTokenizer(strings.NewReader(output))
+	for {
+		if tokenizer.Next() == html.ErrorToken {
+			return
+		}
+		token := tokenizer.Token()
+		if token.Type != html.StartTagToken && token.Type != html.SelfClosingTagToken {
+			continue
+		}
+		if xssForbiddenTags[token.Data] {
+			t.Errorf("payload: %s\noutput: %s\n<%s> is not allowed", payload, output, token.Data)
+		}
+		for _, attr := range token.Attr {
+			switch {
+			case strings.HasPrefix(attr.Key, "on") || xssForbiddenAttrs[attr.Key]:
+				t.Errorf("payload: %s\noutput: %s\n%s attribute is not allowed", payload, output, attr.Key)
+			case attr.Key == "attributename" && strings.Contains(strings.ToLower(attr.Val), "href"):
+				t.Errorf("payload: %s\noutput: %s\nhref animation is not allowed", payload, output)
+			case attr.Key == "href" || attr.Key == "src" || attr.Key == "poster" || attr.Key == "cite":
+				if !isSafeURL(token.Data, attr.Key, attr.Val) {
+					t.Errorf("payload: %s\noutput: %s\nunsafe url: %s=%q", payload, output, attr.Key, attr.Val)
+				}
+			case attr.Key == "srcset":
+				for _, candidate := range parseSrcset(attr.Val) {
+					if !isSafeURL(token.Data, attr.Key, candidate.url) {
+						t.Errorf("payload: %s\noutput: %s\nunsafe url: srcset=%q", payload, output, attr.Val)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestXSSPayloads(t *testing.T) {
+	for _, payload := range xssPayloads {
+		output := Sanitize("http://example.org/", payload)
+		assertInert(t, payload, output)
+	}
+}
+
+func TestXSSPayloadsInFeed(t *testing.T) {
+	var items strings.Builder
+	for _, payload := range xssPayloads {
+		items.WriteString(`<item><title>item</title><link>http://example.org/item</link>`)
+		items.WriteString(`<description><![CDATA[<p>before</p>` + payload + `<p>after</p>]]></description>`)
+		items.WriteString(`</item>`)
+	}
+	feed, err := parser.Parse(strings.NewReader(`<?xml version="1.0"?>
+		<rss version="2.0"><channel><title>xss</title><link>http://example.org/</link>` +
+		items.String() + `</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != len(xssPayloads) {
+		t.Fatalf("want %d items, have %d", len(xssPayloads), len(feed.Items))
+	}
+	for i, item := range feed.Items {
+		// the same steps the content goes through at insert and read time
+		content := RewriteURLs(item.Content, item.URL)
+		content = StripTrackers(content)
+		content = Sanitize(item.URL, content)
+		assertInert(t, xssPayloads[i], content)
+
+		if text := htmlutil.ExtractText(content); !strings.HasPrefix(text, "before") {
+			t.Errorf("payload: %s\noutput: %s\nthe content around the payload is lost", xssPayloads[i], content)
+		}
+	}
+}
@@ -7,34 +7,69 @@ import (
 	"golang.org/x/net/html"
 )
 
+// feed link types, application/json only counts with rel=alternate
+var feedLinkTypes = map[string]bool{
+	"application/atom+xml":  true,
+	"application/rss+xml":   true,
+	"application/feed+json": true,
+}
+
+// FindFeeds returns the feed links (with the titles) of the page.
+// The page is tokenized rather than parsed, so that the links are found
+// anywhere in the document, however broken the markup around them is.
 func FindFeeds(body string, base string) map[string]string {
 	candidates := make(map[string]string)
 
-	doc, err := html.Parse(strings.NewReader(body))
-	if err != nil {
-		return candidates
-	}
+	type link struct{ href, title string }
+	var links, anchors []link
+	var anchorText strings.Builder
+	inAnchor := false
+	hasBase := false
 
-	// find direct links
-	// css: link[type=application/atom+xml]
-	linkTypes := []string{"application/atom+xml", "application/rss+xml", "application/json"}
-	isFeedLink := func(n *html.Node) bool {
-		if n.Type == html.ElementNode && n.Data == "link" {
-			t := htmlutil.Attr(n, "type")
-			for _, tt := range linkTypes {
-				if tt == t {
-					return true
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.Data {
+			case "base":
+				// only the first base element with href counts,
+				// applying to the whole document
+				if href := strings.TrimSpace(tokenAttr(token, "href")); href != "" && !hasBase {
+					hasBase = true
+					if link := htmlutil.AbsoluteUrl(href, base); htmlutil.IsAPossibleLink(link) {
+						base = link
+					}
+				}
+			case "link":
+				if isFeedLink(token) {
+					links = append(links, link{tokenAttr(token, "href"), tokenAttr(token, "title")})
 				}
+			case "a":
+				inAnchor = tokenType == html.StartTagToken
+				anchorText.Reset()
+				anchors = append(anchors, link{href: tokenAttr(token, "href")})
+			}
+		case html.EndTagToken:
+			if token.Data == "a" && inAnchor {
+				inAnchor = false
+				anchors[len(anchors)-1].title = strings.TrimSpace(anchorText.String())
+			}
+		case html.TextToken:
+			if inAnchor {
+				anchorText.WriteString(token.Data)
 			}
 		}
-		return false
 	}
-	for _, node := range htmlutil.FindNodes(doc, isFeedLink) {
-		href := htmlutil.Attr(node, "href")
-		name := htmlutil.Attr(node, "title")
-		link := htmlutil.AbsoluteUrl(href, base)
-		if link != "" {
-			candidates[link] = name
+
+	// direct links
+	for _, l := range links {
+		if href := htmlutil.AbsoluteUrl(l.href, base); href != "" && strings.TrimSpace(l.href) != "" {
+			candidates[href] = l.title
 		}
 	}
 
@@ -44,27 +79,23 @@ func FindFeeds(body string, base string) map[string]string {
 		// css: a:contains("rss")
 		feedHrefs := []string{"feed", "feed.xml", "rss.xml", "atom.xml"}
 		feedTexts := []string{"rss", "feed"}
-		isFeedHyperLink := func(n *html.Node) bool {
-			if n.Type == html.ElementNode && n.Data == "a" {
-				href := strings.Trim(htmlutil.Attr(n, "href"), "/")
-				for _, feedHref := range feedHrefs {
-					if strings.HasSuffix(href, feedHref) {
-						return true
-					}
+		for _, a := range anchors {
+			href := strings.Trim(a.href, "/")
+			match := false
+			for _, feedHref := range feedHrefs {
+				if strings.HasSuffix(href, feedHref) {
+					match = true
 				}
-				text := htmlutil.Text(n)
-				for _, feedText := range feedTexts {
-					if strings.EqualFold(text, feedText) {
-						return true
-					}
+			}
+			for _, feedText := range feedTexts {
+				if strings.EqualFold(a.title, feedText) {
+					match = true
 				}
 			}
-			return false
-		}
-		for _, node := range htmlutil.FindNodes(doc, isFeedHyperLink) {
-			href := htmlutil.Attr(node, "href")
-			link := htmlutil.AbsoluteUrl(href, base)
-			if link != "" {
+			if !match || strings.TrimSpace(a.href) == "" {
+				continue
+			}
+			if link := htmlutil.AbsoluteUrl(a.href, base); link != "" {
 				candidates[link] = ""
 			}
 		}
@@ -73,25 +104,96 @@ func FindFeeds(body string, base string) map[string]string {
 	return candidates
 }
 
-func FindIcons(body string, base string) []string {
-	icons := make([]string, 0)
+func isFeedLink(token html.Token) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(tokenAttr(token, "type"), ";", 2)[0]))
+	if feedLinkTypes[mediaType] {
+		return true
+	}
+	if mediaType == "application/json" {
+		for _, rel := range strings.Fields(tokenAttr(token, "rel")) {
+			if strings.EqualFold(rel, "alternate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func tokenAttr(token html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
 
+// FindIcons returns the icon links of the page, the best ones first.
+func FindIcons(body string, base string) []string {
 	doc, err := html.Parse(strings.NewReader(body))
 	if err != nil {
-		return icons
+		return make([]string, 0)
 	}
 
-	// css: link[rel=icon]
 	isLink := func(n *html.Node) bool {
 		return n.Type == html.ElementNode && n.Data == "link"
 	}
+	candidates := make([]iconCandidate, 0)
 	for _, node := range htmlutil.FindNodes(doc, isLink) {
-		rels := strings.Split(htmlutil.Attr(node, "rel"), " ")
-		for _, rel := range rels {
-			if strings.EqualFold(rel, "icon") {
-				icons = append(icons, htmlutil.AbsoluteUrl(htmlutil.Attr(node, "href"), base))
+		href := strings.TrimSpace(htmlutil.Attr(node, "href"))
+		if href == "" {
+			continue
+		}
+		candidates = append(candidates, iconCandidate{
+			Href:  htmlutil.AbsoluteUrl(href, base),
+			Rel:   htmlutil.Attr(node, "rel"),
+			Sizes: htmlutil.Attr(node, "sizes"),
+			Type:  htmlutil.Attr(node, "type"),
+		})
+	}
+	return rankIcons(candidates)
+}
+
+// FindImage returns the preview image of the page:
+// og:image, twitter:image or link[rel=image_src], in that order.
+func FindImage(body string, base string) string {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	candidates := make(map[string]string)
+	isImageTag := func(n *html.Node) bool {
+		return n.Type == html.ElementNode && (n.Data == "meta" || n.Data == "link")
+	}
+	for _, node := range htmlutil.FindNodes(doc, isImageTag) {
+		var key, val string
+		if node.Data == "meta" {
+			key = htmlutil.Attr(node, "property")
+			if key == "" {
+				key = htmlutil.Attr(node, "name")
 			}
+			val = htmlutil.Attr(node, "content")
+		} else if strings.EqualFold(htmlutil.Attr(node, "rel"), "image_src") {
+			key = "image_src"
+			val = htmlutil.Attr(node, "href")
+		}
+		key = strings.ToLower(key)
+		val = strings.TrimSpace(val)
+		if val != "" && candidates[key] == "" {
+			candidates[key] = val
+		}
+	}
+
+	keys := []string{"og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src", "image_src"}
+	for _, key := range keys {
+		if candidates[key] == "" {
+			continue
+		}
+		link := htmlutil.AbsoluteUrl(candidates[key], base)
+		if htmlutil.IsAPossibleLink(link) {
+			return link
 		}
 	}
-	return icons
+	return ""
 }
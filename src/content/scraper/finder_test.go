@@ -1,6 +1,8 @@
 package scraper
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -95,3 +97,41 @@ func TestFindIcons(t *testing.T) {
 		t.Fatal("invalid result")
 	}
 }
+
+func TestFindImage(t *testing.T) {
+	scenarios := map[string]string{
+		`<meta property="og:image" content="/og.png"><meta name="twitter:image" content="/tw.png">`:      base + "/og.png",
+		`<meta name="twitter:image" content="https://cdn.example.com/tw.png">`:                           "https://cdn.example.com/tw.png",
+		`<link rel="image_src" href="//cdn.example.com/src.png">`:                                        "http://cdn.example.com/src.png",
+		`<meta property="og:image" content="javascript:alert(1)"><link rel="image_src" href="/src.png">`: base + "/src.png",
+		`<meta property="og:title" content="no image">`:                                                  "",
+	}
+	for page, want := range scenarios {
+		have := FindImage(page, base+"/post/1")
+		if have != want {
+			t.Errorf("%s\nwant: %#v\nhave: %#v", page, want, have)
+		}
+	}
+}
+
+func TestFindFeedsMessyPages(t *testing.T) {
+	scenarios := map[string]map[string]string{
+		"unclosed-head.html": {base + "/blog/rss": "Small business blog"},
+		"link-in-body.html":  {"https://recipes.example.com/feed/atom/": "Recipes » Atom"},
+		"uppercase.html":     {base + "/news.rss": "News"},
+		"single-quoted.html": {base + "/atom.xml": "Atom feed", base + "/rss.xml": ""},
+		"json-feed.html":     {base + "/feed.json": "JSON Feed", base + "/legacy.json": "Legacy JSON"},
+		"base.html":          {"https://cdn.example.com/blog/feed.xml": "Posts"},
+		"guess.html":         {base + "/subscribe/": ""},
+	}
+	for name, want := range scenarios {
+		body, err := os.ReadFile(filepath.Join("testdata", "feeds", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := FindFeeds(string(body), base+"/")
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%s\nwant: %#v\nhave: %#v", name, want, have)
+		}
+	}
+}
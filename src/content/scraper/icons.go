@@ -0,0 +1,208 @@
+package scraper
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the preferred icon size range (in px), the icons are shown at 16px
+// but are downscaled nicely from the larger ones
+const (
+	iconMinSize = 32
+	iconMaxSize = 64
+)
+
+// apple-touch-icon without sizes is usually 180px
+const appleTouchIconSize = 180
+
+// iconCandidate is the icon link found on the page.
+type iconCandidate struct {
+	Href  string
+	Rel   string
+	Sizes string
+	Type  string
+}
+
+// iconRank is the sort key of the candidate, lower is better.
+type iconRank struct {
+	mask  bool
+	tier  int
+	shape int
+	kind  int
+	size  int
+}
+
+func (a iconRank) less(b iconRank) bool {
+	if a.mask != b.mask {
+		return !a.mask
+	}
+	if a.tier != b.tier {
+		return a.tier < b.tier
+	}
+	if a.shape != b.shape {
+		return a.shape < b.shape
+	}
+	if a.kind != b.kind {
+		return a.kind < b.kind
+	}
+	return a.size < b.size
+}
+
+// isIconRel reports whether the link rel value points to an icon.
+func isIconRel(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		switch r {
+		case "icon", "apple-touch-icon", "apple-touch-icon-precomposed", "mask-icon":
+			return true
+		}
+	}
+	return false
+}
+
+// rankIcons returns the icon links ordered from the best to the worst:
+// square png/ico icons of 32-64px first, then the larger ones, then the ones
+// of unknown size, then the smaller ones. Safari pinned tab icons (mask-icon)
+// are monochrome and only used if there's nothing else.
+// The document order is kept for the equally good icons.
+func rankIcons(candidates []iconCandidate) []string {
+	type rankedIcon struct {
+		href string
+		rank iconRank
+	}
+	icons := make([]rankedIcon, 0, len(candidates))
+	hasIcons := false
+	for _, c := range candidates {
+		if c.Href == "" || !isIconRel(c.Rel) {
+			continue
+		}
+		rank := rankIcon(c)
+		hasIcons = hasIcons || !rank.mask
+		icons = append(icons, rankedIcon{href: c.Href, rank: rank})
+	}
+	sort.SliceStable(icons, func(i, j int) bool {
+		return icons[i].rank.less(icons[j].rank)
+	})
+
+	links := make([]string, 0, len(icons))
+	for _, icon := range icons {
+		if icon.rank.mask && hasIcons {
+			continue
+		}
+		links = append(links, icon.href)
+	}
+	return links
+}
+
+func rankIcon(c iconCandidate) iconRank {
+	rels := strings.Fields(strings.ToLower(c.Rel))
+	rank := iconRank{mask: true}
+	apple := false
+	for _, rel := range rels {
+		switch rel {
+		case "icon":
+			rank.mask = false
+		case "apple-touch-icon", "apple-touch-icon-precomposed":
+			rank.mask = false
+			apple = true
+		}
+	}
+
+	width, height := iconSize(c.Sizes)
+	if width == 0 && apple {
+		width, height = appleTouchIconSize, appleTouchIconSize
+	}
+	size := width
+	if height < size {
+		size = height
+	}
+	switch {
+	case width < 0:
+		// sizes="any", scalable
+		rank.tier = 1
+	case size >= iconMinSize && size <= iconMaxSize:
+		rank.tier = 0
+		rank.size = iconMaxSize - size
+	case size > iconMaxSize:
+		rank.tier = 1
+		rank.size = size
+	case size == 0:
+		rank.tier = 2
+	default:
+		rank.tier = 3
+		rank.size = -size
+	}
+	if width != height {
+		rank.shape = 1
+	}
+	rank.kind = iconKind(c.Type, c.Href)
+	return rank
+}
+
+// iconSize returns the size closest to the preferred one out of the
+// sizes attribute ("16x16 32x32"), -1 for "any" and 0 if unknown.
+func iconSize(sizes string) (int, int) {
+	bestW, bestH := 0, 0
+	for _, size := range strings.Fields(strings.ToLower(sizes)) {
+		if size == "any" {
+			if bestW == 0 {
+				bestW, bestH = -1, -1
+			}
+			continue
+		}
+		parts := strings.SplitN(size, "x", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		w, errW := strconv.Atoi(parts[0])
+		h, errH := strconv.Atoi(parts[1])
+		if errW != nil || errH != nil || w <= 0 || h <= 0 {
+			continue
+		}
+		if bestW <= 0 || sizeDistance(w) < sizeDistance(bestW) {
+			bestW, bestH = w, h
+		}
+	}
+	return bestW, bestH
+}
+
+func sizeDistance(size int) int {
+	switch {
+	case size < iconMinSize:
+		// smaller ones are worse than any larger one
+		return 10000 + iconMinSize - size
+	case size > iconMaxSize:
+		return size - iconMaxSize
+	}
+	return 0
+}
+
+// iconKind ranks the icon format by the type attribute or the extension,
+// lower is better.
+func iconKind(mimeType, href string) int {
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	if mimeType == "" {
+		if i := strings.IndexAny(href, "?#"); i >= 0 {
+			href = href[:i]
+		}
+		switch strings.ToLower(path.Ext(href)) {
+		case ".png", ".ico":
+			mimeType = "image/png"
+		case ".gif", ".jpg", ".jpeg":
+			mimeType = "image/gif"
+		case ".svg":
+			mimeType = "image/svg+xml"
+		}
+	}
+	switch mimeType {
+	case "image/png", "image/x-icon", "image/vnd.microsoft.icon", "image/ico":
+		return 0
+	case "":
+		return 1
+	case "image/gif", "image/jpeg", "image/jpg", "image/webp":
+		return 2
+	}
+	// svg and the rest can't be stored as the favicon
+	return 3
+}
@@ -0,0 +1,98 @@
+package scraper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRankIcons(t *testing.T) {
+	scenarios := []struct {
+		name  string
+		links []iconCandidate
+		want  []string
+	}{
+		{
+			"document order for equal icons",
+			[]iconCandidate{
+				{Href: "/favicon.ico", Rel: "shortcut icon"},
+				{Href: "/favicon.png", Rel: "icon"},
+			},
+			[]string{"/favicon.ico", "/favicon.png"},
+		},
+		{
+			"apple touch icon over tiny gif",
+			[]iconCandidate{
+				{Href: "/favicon.gif", Rel: "icon", Type: "image/gif", Sizes: "16x16"},
+				{Href: "/apple-touch-icon.png", Rel: "apple-touch-icon"},
+			},
+			[]string{"/apple-touch-icon.png", "/favicon.gif"},
+		},
+		{
+			"preferred size first, then larger, then unknown, then smaller",
+			[]iconCandidate{
+				{Href: "/16.png", Rel: "icon", Sizes: "16x16"},
+				{Href: "/unknown.png", Rel: "icon"},
+				{Href: "/192.png", Rel: "icon", Sizes: "192x192"},
+				{Href: "/96.png", Rel: "icon", Sizes: "96x96"},
+				{Href: "/32.png", Rel: "icon", Sizes: "32x32"},
+			},
+			[]string{"/32.png", "/96.png", "/192.png", "/unknown.png", "/16.png"},
+		},
+		{
+			"best size of the sizes list",
+			[]iconCandidate{
+				{Href: "/large.png", Rel: "icon", Sizes: "128x128"},
+				{Href: "/favicon.ico", Rel: "icon", Sizes: "16x16 32x32 48x48"},
+			},
+			[]string{"/favicon.ico", "/large.png"},
+		},
+		{
+			"png and ico over svg",
+			[]iconCandidate{
+				{Href: "/icon.svg", Rel: "icon", Type: "image/svg+xml", Sizes: "any"},
+				{Href: "/icon.svg?v=2", Rel: "icon", Sizes: "48x48"},
+				{Href: "/icon-48.png", Rel: "icon", Sizes: "48x48"},
+			},
+			[]string{"/icon-48.png", "/icon.svg?v=2", "/icon.svg"},
+		},
+		{
+			"square over non-square",
+			[]iconCandidate{
+				{Href: "/wide.png", Rel: "icon", Sizes: "64x32"},
+				{Href: "/square.png", Rel: "icon", Sizes: "32x32"},
+			},
+			[]string{"/square.png", "/wide.png"},
+		},
+		{
+			"mask icon excluded",
+			[]iconCandidate{
+				{Href: "/safari-pinned-tab.svg", Rel: "mask-icon"},
+				{Href: "/favicon.ico", Rel: "icon"},
+			},
+			[]string{"/favicon.ico"},
+		},
+		{
+			"mask icon if nothing else",
+			[]iconCandidate{
+				{Href: "/safari-pinned-tab.svg", Rel: "mask-icon"},
+			},
+			[]string{"/safari-pinned-tab.svg"},
+		},
+		{
+			"not icons",
+			[]iconCandidate{
+				{Href: "/style.css", Rel: "stylesheet"},
+				{Href: "/manifest.json", Rel: "manifest"},
+				{Href: "", Rel: "icon"},
+				{Href: "/favicon.ico", Rel: "ICON"},
+			},
+			[]string{"/favicon.ico"},
+		},
+	}
+	for _, scenario := range scenarios {
+		have := rankIcons(scenario.links)
+		if !reflect.DeepEqual(have, scenario.want) {
+			t.Errorf("%s\nwant: %#v\nhave: %#v", scenario.name, scenario.want, have)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package silo
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Feed is the feed of a site told by the link of its page,
+// the page itself doesn't link to it (or not to all of them).
+type Feed struct {
+	Title string
+	URL   string
+}
+
+// the sites with the predictable feed links, by the exact host
+var feedRewriters = map[string]func(u *url.URL, path []string) []Feed{
+	"reddit.com":      redditFeeds,
+	"www.reddit.com":  redditFeeds,
+	"old.reddit.com":  redditFeeds,
+	"new.reddit.com":  redditFeeds,
+	"github.com":      githubFeeds,
+	"www.github.com":  githubFeeds,
+	"youtube.com":     youtubeFeeds,
+	"www.youtube.com": youtubeFeeds,
+	"m.youtube.com":   youtubeFeeds,
+	// the biggest instances, the others can't be told by the host
+	"mastodon.social":  mastodonFeeds,
+	"mastodon.online":  mastodonFeeds,
+	"mstdn.social":     mastodonFeeds,
+	"fosstodon.org":    mastodonFeeds,
+	"hachyderm.io":     mastodonFeeds,
+	"infosec.exchange": mastodonFeeds,
+	"mas.to":           mastodonFeeds,
+	"techhub.social":   mastodonFeeds,
+	"universeodon.com": mastodonFeeds,
+	"masto.ai":         mastodonFeeds,
+}
+
+// KnownFeeds returns the feeds of the page guessed by its link,
+// nil if the site isn't known or the link isn't of a page with the feeds.
+// The feeds aren't checked to exist.
+func KnownFeeds(link string) []Feed {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil
+	}
+	rewrite, ok := feedRewriters[strings.ToLower(u.Host)]
+	if !ok {
+		return nil
+	}
+	var path []string
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			path = append(path, segment)
+		}
+	}
+	return rewrite(u, path)
+}
+
+var redditListings = map[string]bool{"hot": true, "new": true, "top": true, "rising": true}
+
+// reddit.com/r/<subreddit>[/<listing>], reddit.com/user/<name>
+func redditFeeds(u *url.URL, path []string) []Feed {
+	if len(path) < 2 || (len(path) == 3 && !redditListings[path[2]]) || len(path) > 3 {
+		return nil
+	}
+	switch path[0] {
+	case "r":
+		return []Feed{{
+			Title: "Reddit r/" + path[1],
+			URL:   "https://www.reddit.com/" + strings.Join(path, "/") + "/.rss",
+		}}
+	case "u", "user":
+		if len(path) > 2 {
+			return nil
+		}
+		return []Feed{{Title: "Reddit u/" + path[1], URL: "https://www.reddit.com/user/" + path[1] + "/.rss"}}
+	}
+	return nil
+}
+
+// the github pages with the two segment paths not of the repositories
+var githubPages = map[string]bool{
+	"orgs": true, "settings": true, "features": true, "topics": true, "collections": true,
+	"marketplace": true, "sponsors": true, "notifications": true, "explore": true,
+	"login": true, "pulls": true, "issues": true, "apps": true, "enterprise": true,
+}
+
+// github.com/<owner>/<repo>[/releases|/commits]
+func githubFeeds(u *url.URL, path []string) []Feed {
+	if len(path) < 2 || githubPages[path[0]] {
+		return nil
+	}
+	repo := "https://github.com/" + path[0] + "/" + path[1]
+	releases := Feed{Title: "GitHub releases", URL: repo + "/releases.atom"}
+	commits := Feed{Title: "GitHub commits", URL: repo + "/commits.atom"}
+	switch {
+	case len(path) == 2:
+		return []Feed{releases, commits}
+	case path[2] == "releases" || path[2] == "tags":
+		return []Feed{releases}
+	case path[2] == "commits" && len(path) == 3:
+		return []Feed{commits}
+	}
+	return nil
+}
+
+// <instance>/@<name>
+func mastodonFeeds(u *url.URL, path []string) []Feed {
+	if len(path) != 1 || len(path[0]) < 2 || path[0][0] != '@' || strings.HasSuffix(path[0], ".rss") {
+		return nil
+	}
+	return []Feed{{Title: "Mastodon " + path[0], URL: "https://" + strings.ToLower(u.Host) + "/" + path[0] + ".rss"}}
+}
+
+// youtube.com/channel/<id>, youtube.com/playlist?list=<id>
+func youtubeFeeds(u *url.URL, path []string) []Feed {
+	link := YouTubeFeed(u.String())
+	switch {
+	case link == "":
+		return nil
+	case strings.Contains(link, "playlist_id="):
+		return []Feed{{Title: "YouTube playlist", URL: link}}
+	}
+	return []Feed{{Title: "YouTube videos", URL: link}}
+}
@@ -0,0 +1,42 @@
+package silo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKnownFeeds(t *testing.T) {
+	releases := Feed{"GitHub releases", "https://github.com/nkanaev/yarr/releases.atom"}
+	commits := Feed{"GitHub commits", "https://github.com/nkanaev/yarr/commits.atom"}
+	for link, want := range map[string][]Feed{
+		"https://www.reddit.com/r/golang":                  {{"Reddit r/golang", "https://www.reddit.com/r/golang/.rss"}},
+		"https://old.reddit.com/r/golang/new/":             {{"Reddit r/golang", "https://www.reddit.com/r/golang/new/.rss"}},
+		"https://reddit.com/u/spez":                        {{"Reddit u/spez", "https://www.reddit.com/user/spez/.rss"}},
+		"https://github.com/nkanaev/yarr":                  {releases, commits},
+		"https://github.com/nkanaev/yarr/":                 {releases, commits},
+		"https://github.com/nkanaev/yarr/releases":         {releases},
+		"https://github.com/nkanaev/yarr/commits":          {commits},
+		"https://mastodon.social/@Gargron":                 {{"Mastodon @Gargron", "https://mastodon.social/@Gargron.rss"}},
+		"https://www.youtube.com/channel/" + testChannelID: {{"YouTube videos", "https://www.youtube.com/feeds/videos.xml?channel_id=" + testChannelID}},
+
+		"https://www.reddit.com/r/golang/comments/abc/title": nil,
+		"https://github.com/nkanaev":                         nil,
+		"https://github.com/orgs/golang":                     nil,
+		"https://github.com/nkanaev/yarr/issues":             nil,
+		"https://github.com.example.com/nkanaev/yarr":        nil,
+		"https://gist.github.com/nkanaev/yarr":               nil,
+		"https://example.social/@Gargron":                    nil,
+		"https://example.com/r/golang":                       nil,
+	} {
+		have := KnownFeeds(link)
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: want %v, have %v", link, want, have)
+		}
+		// no loops discovering the feeds
+		for _, feed := range have {
+			if feeds := KnownFeeds(feed.URL); feeds != nil {
+				t.Errorf("%s: expected no feeds of the feed, have %v", feed.URL, feeds)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package silo
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	youtubeHosts = map[string]bool{
+		"youtube.com":     true,
+		"www.youtube.com": true,
+		"m.youtube.com":   true,
+		"youtu.be":        true,
+	}
+	youtubeChannelID   = regexp.MustCompile(`^UC[\w-]{22}$`)
+	youtubeChannelPath = regexp.MustCompile(`^/channel/(UC[\w-]{22})(?:/|$)`)
+	// the page data, the last resort
+	youtubeChannelJSON = regexp.MustCompile(`"(?:externalId|channelId)":"(UC[\w-]{22})"`)
+)
+
+// IsYouTube tells whether the link is of a youtube page.
+func IsYouTube(link string) bool {
+	l, err := url.Parse(link)
+	return err == nil && youtubeHosts[strings.ToLower(l.Host)]
+}
+
+// YouTubeFeed returns the feed of the youtube channel or playlist the link
+// is of, if told by the link itself: /channel/<id> or ?list=<id>. The other
+// pages (/user/, /c/, /@handle, the videos) are to be looked into instead,
+// see YouTubeChannelID.
+func YouTubeFeed(link string) string {
+	l, err := url.Parse(link)
+	if err != nil || !youtubeHosts[strings.ToLower(l.Host)] {
+		return ""
+	}
+	if list := l.Query().Get("list"); list != "" && (l.Path == "/playlist" || l.Path == "/watch") {
+		return "https://www.youtube.com/feeds/videos.xml?playlist_id=" + url.QueryEscape(list)
+	}
+	if matches := youtubeChannelPath.FindStringSubmatch(l.Path); matches != nil {
+		return YouTubeChannelFeed(matches[1])
+	}
+	return ""
+}
+
+// YouTubeChannelFeed returns the feed of the channel's videos.
+func YouTubeChannelFeed(channelID string) string {
+	return "https://www.youtube.com/feeds/videos.xml?channel_id=" + channelID
+}
+
+// YouTubeChannelID returns the id of the channel the youtube page is of
+// (or the channel of the video), found in the canonical link, the meta tags
+// or else the page data. Empty if none.
+func YouTubeChannelID(body string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+		token := tokenizer.Token()
+		attrs := make(map[string]string, len(token.Attr))
+		for _, attr := range token.Attr {
+			attrs[attr.Key] = attr.Val
+		}
+		var link string
+		switch {
+		case token.Data == "link" && attrs["rel"] == "canonical":
+			link = attrs["href"]
+		case token.Data == "meta" && attrs["property"] == "og:url":
+			link = attrs["content"]
+		case token.Data == "meta" && attrs["itemprop"] == "channelId":
+			if youtubeChannelID.MatchString(attrs["content"]) {
+				return attrs["content"]
+			}
+		}
+		if l, err := url.Parse(link); link != "" && err == nil {
+			if matches := youtubeChannelPath.FindStringSubmatch(l.Path); matches != nil {
+				return matches[1]
+			}
+		}
+	}
+	if matches := youtubeChannelJSON.FindStringSubmatch(body); matches != nil {
+		return matches[1]
+	}
+	return ""
+}
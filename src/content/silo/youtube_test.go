@@ -0,0 +1,42 @@
+package silo
+
+import "testing"
+
+const testChannelID = "UCuAXFkgsw1L7xaCfnd5JJOw"
+
+func TestYouTubeFeed(t *testing.T) {
+	channelFeed := "https://www.youtube.com/feeds/videos.xml?channel_id=" + testChannelID
+	playlistFeed := "https://www.youtube.com/feeds/videos.xml?playlist_id=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"
+	for link, want := range map[string]string{
+		"https://www.youtube.com/channel/" + testChannelID:                                    channelFeed,
+		"https://m.youtube.com/channel/" + testChannelID + "/videos":                          channelFeed,
+		"https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI":            playlistFeed,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI": playlistFeed,
+		"https://www.youtube.com/@rickastley":                                                 "",
+		"https://www.youtube.com/user/RickAstleyVEVO":                                         "",
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ":                                         "",
+		channelFeed: "",
+		"https://example.com/channel/" + testChannelID: "",
+	} {
+		if have := YouTubeFeed(link); have != want {
+			t.Errorf("%s: want %q, have %q", link, want, have)
+		}
+	}
+}
+
+func TestYouTubeChannelID(t *testing.T) {
+	pages := []string{
+		`<html><head><link rel="canonical" href="https://www.youtube.com/channel/` + testChannelID + `"></head></html>`,
+		`<html><head><meta property="og:url" content="https://www.youtube.com/channel/` + testChannelID + `"></head></html>`,
+		`<html><body><meta itemprop="channelId" content="` + testChannelID + `"></body></html>`,
+		`<html><script>var ytInitialData = {"metadata":{"externalId":"` + testChannelID + `"}};</script></html>`,
+	}
+	for _, page := range pages {
+		if have := YouTubeChannelID(page); have != testChannelID {
+			t.Errorf("%s: want %s, have %q", page, testChannelID, have)
+		}
+	}
+	if have := YouTubeChannelID(`<link rel="canonical" href="https://www.youtube.com/@rickastley">`); have != "" {
+		t.Errorf("want no channel, have %q", have)
+	}
+}
@@ -0,0 +1,184 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const greaderPageSize = 100
+
+const (
+	greaderRead    = "user/-/state/com.google/read"
+	greaderStarred = "user/-/state/com.google/starred"
+	greaderAll     = "user/-/state/com.google/reading-list"
+)
+
+// GReader reads from the Google Reader compatible API (FreshRSS, among others).
+// The endpoint is the API root, e.g. https://example.com/api/greader.php
+// See: https://freshrss.github.io/FreshRSS/en/developers/06_GoogleReader_API.html
+type GReader struct {
+	Endpoint string
+	Username string
+	Password string
+
+	auth string
+	// feed urls by stream ids
+	feeds map[string]string
+}
+
+func (g *GReader) login(ctx context.Context) error {
+	form := url.Values{"Email": {g.Username}, "Passwd": {g.Password}}
+	req, err := http.NewRequestWithContext(ctx, "POST", g.Endpoint+"/accounts/ClientLogin", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if auth := strings.TrimPrefix(scanner.Text(), "Auth="); auth != scanner.Text() {
+			g.auth = auth
+			return nil
+		}
+	}
+	return errors.New("no auth token in the response")
+}
+
+func (g *GReader) get(ctx context.Context, path string, query url.Values, dst interface{}) error {
+	if g.auth == "" {
+		if err := g.login(ctx); err != nil {
+			return err
+		}
+	}
+	query.Set("output", "json")
+	req, err := http.NewRequestWithContext(ctx, "GET", g.Endpoint+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "GoogleLogin auth="+g.auth)
+
+	res, err := do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(dst)
+}
+
+func (g *GReader) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	var data struct {
+		Subscriptions []struct {
+			ID         string `json:"id"`
+			Title      string `json:"title"`
+			URL        string `json:"url"`
+			HTMLURL    string `json:"htmlUrl"`
+			Categories []struct {
+				Label string `json:"label"`
+			} `json:"categories"`
+		} `json:"subscriptions"`
+	}
+	if err := g.get(ctx, "/reader/api/0/subscription/list", url.Values{}, &data); err != nil {
+		return nil, err
+	}
+	g.feeds = make(map[string]string)
+	result := make([]Subscription, 0, len(data.Subscriptions))
+	for _, s := range data.Subscriptions {
+		sub := Subscription{Title: s.Title, FeedURL: s.URL, SiteURL: s.HTMLURL}
+		if sub.FeedURL == "" {
+			sub.FeedURL = strings.TrimPrefix(s.ID, "feed/")
+		}
+		// yarr has no nested folders or tags, the first label is the folder
+		if len(s.Categories) > 0 {
+			sub.Folder = s.Categories[0].Label
+		}
+		g.feeds[s.ID] = sub.FeedURL
+		result = append(result, sub)
+	}
+	return result, nil
+}
+
+// Entries pages through the stream with the continuation token as the cursor.
+func (g *GReader) Entries(ctx context.Context, stage string, since time.Time, cursor string) ([]Entry, string, error) {
+	if g.feeds == nil {
+		if _, err := g.Subscriptions(ctx); err != nil {
+			return nil, "", err
+		}
+	}
+	query := url.Values{"n": {strconv.Itoa(greaderPageSize)}}
+	if cursor != "" {
+		query.Set("c", cursor)
+	}
+	stream := greaderStarred
+	if stage == stageRecent {
+		stream = greaderAll
+		query.Set("ot", strconv.FormatInt(since.Unix(), 10))
+	}
+
+	type text struct {
+		Content string `json:"content"`
+	}
+	type link struct {
+		Href string `json:"href"`
+	}
+	var data struct {
+		Items []struct {
+			ID         string   `json:"id"`
+			Title      string   `json:"title"`
+			Published  int64    `json:"published"`
+			Canonical  []link   `json:"canonical"`
+			Alternate  []link   `json:"alternate"`
+			Summary    text     `json:"summary"`
+			Content    text     `json:"content"`
+			Categories []string `json:"categories"`
+			Origin     struct {
+				StreamID string `json:"streamId"`
+			} `json:"origin"`
+		} `json:"items"`
+		Continuation string `json:"continuation"`
+	}
+	if err := g.get(ctx, "/reader/api/0/stream/contents/"+stream, query, &data); err != nil {
+		return nil, "", err
+	}
+	result := make([]Entry, 0, len(data.Items))
+	for _, item := range data.Items {
+		entry := Entry{
+			ID:        item.ID,
+			FeedURL:   g.feeds[item.Origin.StreamID],
+			Title:     item.Title,
+			Content:   item.Content.Content,
+			Published: time.Unix(item.Published, 0),
+		}
+		if entry.Content == "" {
+			entry.Content = item.Summary.Content
+		}
+		for _, links := range [][]link{item.Canonical, item.Alternate} {
+			if len(links) > 0 && entry.URL == "" {
+				entry.URL = links[0].Href
+			}
+		}
+		// the states may come with the user id in place of the dash
+		for _, category := range item.Categories {
+			switch {
+			case strings.HasSuffix(category, strings.TrimPrefix(greaderRead, "user/-")):
+				entry.Read = true
+			case strings.HasSuffix(category, strings.TrimPrefix(greaderStarred, "user/-")):
+				entry.Starred = true
+			}
+		}
+		result = append(result, entry)
+	}
+	return result, data.Continuation, nil
+}
@@ -0,0 +1,263 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// The entries are imported in stages, each one paged with a cursor.
+const (
+	stageStarred = "starred"
+	stageRecent  = "recent"
+)
+
+var nextStage = map[string]string{
+	stageStarred: stageRecent,
+	stageRecent:  "",
+}
+
+// How far back the read state is brought over by default.
+const defaultDays = 30
+
+type Subscription struct {
+	Title   string
+	FeedURL string
+	SiteURL string
+	Folder  string
+}
+
+type Entry struct {
+	ID        string
+	FeedURL   string
+	Title     string
+	URL       string
+	Content   string
+	Published time.Time
+	Read      bool
+	Starred   bool
+}
+
+// Source reads the subscriptions and the entries from another reader's API.
+type Source interface {
+	Subscriptions(ctx context.Context) ([]Subscription, error)
+	// Entries returns the page of the stage's entries starting at the cursor
+	// and the cursor of the next page, empty after the last one.
+	// The recent stage lists the entries published after `since`.
+	Entries(ctx context.Context, stage string, since time.Time, cursor string) ([]Entry, string, error)
+}
+
+// Credential fields expected by each kind of source.
+var Kinds = map[string][]string{
+	"miniflux": {"token"},
+	"freshrss": {"username", "password"},
+}
+
+type Options struct {
+	Kind        string
+	Endpoint    string
+	Credentials map[string]string
+	// the read state is imported for the entries published within the days
+	Days int
+}
+
+// Importer brings over everything from another reader. It's one-way:
+// the remote is never modified.
+type Importer struct {
+	source Source
+	// the state of the unfinished import is kept by the key
+	key  string
+	days int
+}
+
+func New(opts Options) (*Importer, error) {
+	endpoint := strings.TrimRight(opts.Endpoint, "/")
+	var source Source
+	switch opts.Kind {
+	case "miniflux":
+		source = &Miniflux{Endpoint: endpoint, Token: opts.Credentials["token"]}
+	case "freshrss":
+		source = &GReader{
+			Endpoint: endpoint,
+			Username: opts.Credentials["username"],
+			Password: opts.Credentials["password"],
+		}
+	default:
+		return nil, fmt.Errorf("unknown source: %s", opts.Kind)
+	}
+	days := opts.Days
+	if days <= 0 {
+		days = defaultDays
+	}
+	return &Importer{source: source, key: opts.Kind + " " + endpoint, days: days}, nil
+}
+
+// Run imports the subscriptions (the remote categories become folders)
+// and the entries: the starred ones and the recent ones with their read state.
+// The feeds are matched by url and the items by guid or link, so it's safe
+// to run again. The interrupted import picks up at the last page done.
+// The counters are reported to progress after each page.
+func (im *Importer) Run(ctx context.Context, db *storage.Storage, progress func(map[string]int64)) (map[string]int64, error) {
+	result := map[string]int64{
+		"feeds_created":   0,
+		"folders_created": 0,
+		"items_created":   0,
+		"items_updated":   0,
+		"items_skipped":   0,
+	}
+	subscriptions, err := im.source.Subscriptions(ctx)
+	if err != nil {
+		return result, err
+	}
+	feeds := importFeeds(db, subscriptions, result)
+	progress(result)
+
+	state := db.GetImportState(im.key)
+	if state == nil {
+		state = &storage.ImportState{Stage: stageStarred, Since: time.Now().AddDate(0, 0, -im.days)}
+	}
+	for state.Stage != "" {
+		entries, cursor, err := im.source.Entries(ctx, state.Stage, state.Since, state.Cursor)
+		if err != nil {
+			db.SetImportState(im.key, *state)
+			return result, err
+		}
+		items := make([]storage.Item, 0, len(entries))
+		for _, entry := range entries {
+			feedID, ok := feeds[feedKey(entry.FeedURL)]
+			if !ok {
+				result["items_skipped"]++
+				continue
+			}
+			items = append(items, entryItem(feedID, entry))
+		}
+		created := db.ImportItems(items)
+		result["items_created"] += created
+		result["items_updated"] += int64(len(items)) - created
+		progress(result)
+
+		state.Cursor = cursor
+		if cursor == "" {
+			state.Stage = nextStage[state.Stage]
+		}
+		if state.Stage == "" {
+			db.DeleteImportState(im.key)
+		} else {
+			db.SetImportState(im.key, *state)
+		}
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+	}
+	db.SyncSearch()
+	return result, nil
+}
+
+// importFeeds subscribes to the feeds not present locally and returns
+// the ids of all the feeds by their keys. The existing feeds are left
+// in their folders.
+func importFeeds(db *storage.Storage, subscriptions []Subscription, result map[string]int64) map[string]int64 {
+	feeds := make(map[string]int64)
+	for _, feed := range db.ListFeeds() {
+		feeds[feedKey(feed.FeedLink)] = feed.Id
+	}
+	folders := make(map[string]int64)
+	for _, folder := range db.ListFolders() {
+		folders[folder.Title] = folder.Id
+	}
+	for _, sub := range subscriptions {
+		key := feedKey(sub.FeedURL)
+		if _, ok := feeds[key]; ok || key == "" {
+			continue
+		}
+		var folderID *int64
+		if sub.Folder != "" {
+			id, ok := folders[sub.Folder]
+			if !ok {
+				folder := db.CreateFolder(sub.Folder)
+				if folder == nil {
+					continue
+				}
+				id = folder.Id
+				folders[sub.Folder] = id
+				result["folders_created"]++
+			}
+			folderID = &id
+		}
+		if feed := db.CreateFeed(sub.Title, "", sub.SiteURL, sub.FeedURL, folderID); feed != nil {
+			feeds[key] = feed.Id
+			result["feeds_created"]++
+		}
+	}
+	return feeds
+}
+
+// feedKey is the feed url with the differences insignificant
+// for matching (the scheme, the trailing slash, the credentials) dropped.
+func feedKey(feedURL string) string {
+	key, _ := storage.StripCredentials(strings.TrimSpace(feedURL), storage.FeedSettings{})
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	return strings.TrimRight(key, "/")
+}
+
+func entryItem(feedID int64, entry Entry) storage.Item {
+	// feeds mostly use the link as the guid, the item is likely
+	// to be recognized when the feed is refreshed
+	guid := entry.URL
+	if guid == "" {
+		guid = entry.ID
+	}
+	status := storage.UNREAD
+	if entry.Starred {
+		status = storage.STARRED
+	} else if entry.Read {
+		status = storage.READ
+	}
+	return storage.Item{
+		GUID:    guid,
+		FeedId:  feedID,
+		Title:   entry.Title,
+		Link:    entry.URL,
+		Content: entry.Content,
+		Date:    entry.Published.UTC(),
+		Status:  status,
+	}
+}
+
+var httpClient = &http.Client{Timeout: time.Minute}
+
+// SourceError is returned when the source responded with an error status.
+type SourceError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *SourceError) Error() string {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "invalid credentials"
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("status code %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("status code %d", e.StatusCode)
+}
+
+func do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", "Yarr/1.0")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("source unavailable: %w", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, &SourceError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return res, nil
+}
@@ -0,0 +1,190 @@
+package importer
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func testDB() *storage.Storage {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	return db
+}
+
+func noProgress(map[string]int64) {}
+
+func TestMiniflux(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Auth-Token") != "secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case req.URL.Path == "/v1/feeds":
+			rw.Write([]byte(`[
+				{"title": "A", "site_url": "http://a.example", "feed_url": "http://a.example/feed/", "category": {"title": "Tech"}},
+				{"title": "B", "site_url": "https://b.example", "feed_url": "https://b.example/rss", "category": {"title": "Tech"}}
+			]`))
+		case req.URL.Path == "/v1/entries" && req.URL.Query().Get("starred") == "true":
+			rw.Write([]byte(`{"entries": [
+				{"id": 1, "status": "read", "starred": true, "title": "one", "url": "https://b.example/1", "content": "<p>first</p>",
+				 "published_at": "2020-01-02T03:04:05+02:00", "feed": {"feed_url": "https://b.example/rss"}}
+			]}`))
+		case req.URL.Path == "/v1/entries" && req.URL.Query().Get("after") != "":
+			rw.Write([]byte(`{"entries": [
+				{"id": 1, "status": "read", "starred": true, "title": "one", "url": "https://b.example/1",
+				 "published_at": "2020-01-02T03:04:05+02:00", "feed": {"feed_url": "https://b.example/rss"}},
+				{"id": 2, "status": "read", "starred": false, "title": "two", "url": "https://a.example/2",
+				 "published_at": "2020-01-03T00:00:00Z", "feed": {"feed_url": "http://a.example/feed/"}},
+				{"id": 3, "status": "unread", "starred": false, "title": "three", "url": "https://c.example/3",
+				 "published_at": "2020-01-03T00:00:00Z", "feed": {"feed_url": "https://c.example/rss"}}
+			]}`))
+		}
+	}))
+	defer server.Close()
+
+	db := testDB()
+	existing := db.CreateFeed("A", "", "", "https://a.example/feed", nil)
+	db.CreateItems([]storage.Item{{GUID: "a2", FeedId: existing.Id, Link: "https://a.example/2", Title: "two"}})
+
+	im, _ := New(Options{Kind: "miniflux", Endpoint: server.URL + "/", Credentials: map[string]string{"token": "secret"}})
+	result, err := im.Run(context.Background(), db, noProgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"feeds_created": 1, "folders_created": 1, "items_created": 1, "items_updated": 2, "items_skipped": 1}
+	for key, val := range want {
+		if result[key] != val {
+			t.Fatalf("expected %s = %d, got %#v", key, val, result)
+		}
+	}
+
+	feeds := db.ListFeeds()
+	if len(feeds) != 2 || feeds[0].FolderId != nil || feeds[1].FolderId == nil {
+		t.Fatalf("unexpected feeds: %#v", feeds)
+	}
+	items := db.ListItems(storage.ItemFilter{}, 10, false, true)
+	if len(items) != 2 {
+		t.Fatalf("unexpected items: %#v", items)
+	}
+	local, imported := items[0], items[1]
+	if imported.Status != storage.STARRED || imported.Content != "<p>first</p>" || imported.GUID != "https://b.example/1" {
+		t.Fatalf("unexpected imported item: %#v", imported)
+	}
+	if !imported.Date.Equal(time.Date(2020, 1, 2, 1, 4, 5, 0, time.UTC)) {
+		t.Fatalf("expected the published date, got %s", imported.Date)
+	}
+	if local.GUID != "a2" || local.Status != storage.READ {
+		t.Fatalf("expected the local item marked read: %#v", local)
+	}
+
+	result, err = im.Run(context.Background(), db, noProgress)
+	if err != nil || result["feeds_created"] != 0 || result["items_created"] != 0 || result["items_updated"] != 3 {
+		t.Fatalf("expected nothing new on re-run, got %#v %v", result, err)
+	}
+
+	im, _ = New(Options{Kind: "miniflux", Endpoint: server.URL, Credentials: map[string]string{"token": "wrong"}})
+	if _, err = im.Run(context.Background(), db, noProgress); err == nil || err.Error() != "invalid credentials" {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+}
+
+func TestGReaderResume(t *testing.T) {
+	requests := make(map[string]int)
+	broken := true
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/accounts/ClientLogin" {
+			if req.FormValue("Email") != "user" || req.FormValue("Passwd") != "pass" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			rw.Write([]byte("SID=none\nLSID=none\nAuth=token\n"))
+			return
+		}
+		if req.Header.Get("Authorization") != "GoogleLogin auth=token" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		page := req.URL.Path + "?c=" + req.URL.Query().Get("c")
+		requests[page]++
+		switch page {
+		case "/reader/api/0/subscription/list?c=":
+			rw.Write([]byte(`{"subscriptions": [
+				{"id": "feed/1", "title": "Blog", "url": "https://blog.example/feed", "htmlUrl": "https://blog.example",
+				 "categories": [{"id": "user/-/label/News", "label": "News"}]}
+			]}`))
+		case "/reader/api/0/stream/contents/user/-/state/com.google/starred?c=":
+			rw.Write([]byte(`{"items": [
+				{"id": "tag:1", "title": "one", "published": 1577836800, "canonical": [{"href": "https://blog.example/1"}],
+				 "summary": {"content": "first"}, "origin": {"streamId": "feed/1"},
+				 "categories": ["user/1/state/com.google/starred", "user/1/state/com.google/read"]}
+			], "continuation": "page2"}`))
+		case "/reader/api/0/stream/contents/user/-/state/com.google/starred?c=page2":
+			if broken {
+				rw.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			rw.Write([]byte(`{"items": [
+				{"id": "tag:2", "title": "two", "published": 1577923200, "alternate": [{"href": "https://blog.example/2"}],
+				 "origin": {"streamId": "feed/1"}, "categories": ["user/-/state/com.google/starred"]}
+			]}`))
+		case "/reader/api/0/stream/contents/user/-/state/com.google/reading-list?c=":
+			rw.Write([]byte(`{"items": [
+				{"id": "tag:3", "title": "three", "published": 1578009600, "alternate": [{"href": "https://blog.example/3"}],
+				 "origin": {"streamId": "feed/1"}, "categories": []}
+			]}`))
+		}
+	}))
+	defer server.Close()
+
+	db := testDB()
+	credentials := map[string]string{"username": "user", "password": "pass"}
+	im, _ := New(Options{Kind: "freshrss", Endpoint: server.URL, Credentials: credentials})
+	var reported map[string]int64
+	if _, err := im.Run(context.Background(), db, func(p map[string]int64) { reported = p }); err == nil {
+		t.Fatal("expected the error on the second page")
+	}
+	if reported["items_created"] != 1 {
+		t.Fatalf("expected the first page reported, got %#v", reported)
+	}
+
+	broken = false
+	im, _ = New(Options{Kind: "freshrss", Endpoint: server.URL, Credentials: credentials})
+	result, err := im.Run(context.Background(), db, noProgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["items_created"] != 2 || result["feeds_created"] != 0 {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+	if requests["/reader/api/0/stream/contents/user/-/state/com.google/starred?c="] != 1 {
+		t.Fatalf("expected the import resumed at the second page, got %#v", requests)
+	}
+	if db.GetImportState("freshrss "+server.URL) != nil {
+		t.Fatal("expected the finished import forgotten")
+	}
+
+	items := db.ListItems(storage.ItemFilter{}, 10, false, false)
+	if len(items) != 3 {
+		t.Fatalf("unexpected items: %#v", items)
+	}
+	statuses := []storage.ItemStatus{storage.STARRED, storage.STARRED, storage.UNREAD}
+	for i, item := range items {
+		if item.Status != statuses[i] {
+			t.Fatalf("unexpected status of %s: %s", item.Title, storage.StatusRepresentations[item.Status])
+		}
+	}
+	folders := db.ListFolders()
+	if len(folders) != 1 || folders[0].Title != "News" {
+		t.Fatalf("unexpected folders: %#v", folders)
+	}
+}
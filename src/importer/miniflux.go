@@ -0,0 +1,111 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const minifluxPageSize = 100
+
+// See: https://miniflux.app/docs/api.html
+type Miniflux struct {
+	Endpoint string
+	Token    string
+}
+
+func (m *Miniflux) get(ctx context.Context, path string, query url.Values, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", m.Endpoint+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", m.Token)
+
+	res, err := do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(dst)
+}
+
+func (m *Miniflux) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	var feeds []struct {
+		Title    string `json:"title"`
+		SiteURL  string `json:"site_url"`
+		FeedURL  string `json:"feed_url"`
+		Category struct {
+			Title string `json:"title"`
+		} `json:"category"`
+	}
+	if err := m.get(ctx, "/v1/feeds", nil, &feeds); err != nil {
+		return nil, err
+	}
+	result := make([]Subscription, 0, len(feeds))
+	for _, feed := range feeds {
+		result = append(result, Subscription{
+			Title:   feed.Title,
+			FeedURL: feed.FeedURL,
+			SiteURL: feed.SiteURL,
+			Folder:  feed.Category.Title,
+		})
+	}
+	return result, nil
+}
+
+// Entries pages through the entries by id, the cursor is the last id seen.
+func (m *Miniflux) Entries(ctx context.Context, stage string, since time.Time, cursor string) ([]Entry, string, error) {
+	query := url.Values{
+		"order":     {"id"},
+		"direction": {"asc"},
+		"limit":     {strconv.Itoa(minifluxPageSize)},
+	}
+	if cursor != "" {
+		query.Set("after_entry_id", cursor)
+	}
+	switch stage {
+	case stageStarred:
+		query.Set("starred", "true")
+	case stageRecent:
+		query.Set("after", strconv.FormatInt(since.Unix(), 10))
+	}
+
+	var data struct {
+		Entries []struct {
+			ID          int64     `json:"id"`
+			Status      string    `json:"status"`
+			Title       string    `json:"title"`
+			URL         string    `json:"url"`
+			Content     string    `json:"content"`
+			PublishedAt time.Time `json:"published_at"`
+			Starred     bool      `json:"starred"`
+			Feed        struct {
+				FeedURL string `json:"feed_url"`
+			} `json:"feed"`
+		} `json:"entries"`
+	}
+	if err := m.get(ctx, "/v1/entries", query, &data); err != nil {
+		return nil, "", err
+	}
+	result := make([]Entry, 0, len(data.Entries))
+	for _, e := range data.Entries {
+		result = append(result, Entry{
+			ID:        strconv.FormatInt(e.ID, 10),
+			FeedURL:   e.Feed.FeedURL,
+			Title:     e.Title,
+			URL:       e.URL,
+			Content:   e.Content,
+			Published: e.PublishedAt,
+			Read:      e.Status != "unread",
+			Starred:   e.Starred,
+		})
+	}
+	next := ""
+	if len(result) == minifluxPageSize {
+		next = result[len(result)-1].ID
+	}
+	return result, next, nil
+}
@@ -0,0 +1,81 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+type Entry struct {
+	URL   string
+	Title string
+}
+
+// Service saves links to a third-party read-it-later/bookmarking app.
+type Service interface {
+	Save(ctx context.Context, entry Entry) error
+}
+
+// Credential fields expected by each kind of service.
+var Kinds = map[string][]string{
+	"wallabag": {"client_id", "client_secret", "username", "password"},
+	"linkding": {"token"},
+}
+
+func New(i storage.Integration) (Service, error) {
+	endpoint := strings.TrimRight(i.Endpoint, "/")
+	switch i.Kind {
+	case "wallabag":
+		return &Wallabag{
+			Endpoint:     endpoint,
+			ClientID:     i.Credentials["client_id"],
+			ClientSecret: i.Credentials["client_secret"],
+			Username:     i.Credentials["username"],
+			Password:     i.Credentials["password"],
+		}, nil
+	case "linkding":
+		return &Linkding{
+			Endpoint: endpoint,
+			Token:    i.Credentials["token"],
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown integration: %s", i.Kind)
+}
+
+var httpClient = &http.Client{Timeout: time.Second * 30}
+
+// ServiceError is returned when the service responded with an error status.
+type ServiceError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ServiceError) Error() string {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "invalid credentials"
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("status code %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("status code %d", e.StatusCode)
+}
+
+func do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", "Yarr/1.0")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("service unavailable: %w", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, &ServiceError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return res, nil
+}
@@ -0,0 +1,72 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestLinkding(t *testing.T) {
+	var saved map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/bookmarks/" || req.Header.Get("Authorization") != "Token secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(req.Body).Decode(&saved)
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	service, _ := New(storage.Integration{
+		Kind:        "linkding",
+		Endpoint:    server.URL + "/",
+		Credentials: map[string]string{"token": "secret"},
+	})
+	entry := Entry{URL: "http://example.com", Title: "Example"}
+	if err := service.Save(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	if saved["url"] != entry.URL || saved["title"] != entry.Title {
+		t.Fatalf("invalid payload: %#v", saved)
+	}
+
+	service, _ = New(storage.Integration{Kind: "linkding", Endpoint: server.URL})
+	if err := service.Save(context.Background(), entry); err == nil || err.Error() != "invalid credentials" {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+}
+
+func TestWallabag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/oauth/v2/token":
+			if req.FormValue("password") != "pass" {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			rw.Write([]byte(`{"access_token": "token"}`))
+		case "/api/entries.json":
+			if req.Header.Get("Authorization") != "Bearer token" {
+				rw.WriteHeader(http.StatusUnauthorized)
+			}
+		}
+	}))
+	defer server.Close()
+
+	credentials := map[string]string{"client_id": "id", "client_secret": "secret", "username": "user", "password": "pass"}
+	service, _ := New(storage.Integration{Kind: "wallabag", Endpoint: server.URL, Credentials: credentials})
+	if err := service.Save(context.Background(), Entry{URL: "http://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	credentials["password"] = "wrong"
+	service, _ = New(storage.Integration{Kind: "wallabag", Endpoint: server.URL, Credentials: credentials})
+	if err := service.Save(context.Background(), Entry{URL: "http://example.com"}); err == nil || err.Error() != "invalid credentials" {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// See: https://github.com/sissbruecker/linkding/blob/master/docs/API.md
+type Linkding struct {
+	Endpoint string
+	Token    string
+}
+
+func (l *Linkding) Save(ctx context.Context, entry Entry) error {
+	body, err := json.Marshal(map[string]string{
+		"url":   entry.URL,
+		"title": entry.Title,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", l.Endpoint+"/api/bookmarks/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+l.Token)
+
+	res, err := do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
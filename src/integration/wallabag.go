@@ -0,0 +1,84 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// See: https://doc.wallabag.org/en/developer/api/oauth.html
+type Wallabag struct {
+	Endpoint     string
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+}
+
+func (w *Wallabag) token(ctx context.Context) (string, error) {
+	form := url.Values{
+		"grant_type":    {"password"},
+		"client_id":     {w.ClientID},
+		"client_secret": {w.ClientSecret},
+		"username":      {w.Username},
+		"password":      {w.Password},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", w.Endpoint+"/oauth/v2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := do(req)
+	if err != nil {
+		var serr *ServiceError
+		// wallabag responds with 400 to invalid client/user credentials
+		if errors.As(err, &serr) && serr.StatusCode == http.StatusBadRequest {
+			serr.StatusCode = http.StatusUnauthorized
+		}
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var data struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return "", err
+	}
+	if data.AccessToken == "" {
+		return "", errors.New("no access token in the response")
+	}
+	return data.AccessToken, nil
+}
+
+func (w *Wallabag) Save(ctx context.Context, entry Entry) error {
+	token, err := w.token(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{
+		"url":   entry.URL,
+		"title": entry.Title,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", w.Endpoint+"/api/entries.json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
@@ -0,0 +1,163 @@
+// Package logger is a minimal leveled logger with text and json output.
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %#v", name)
+}
+
+type Fields map[string]interface{}
+
+var (
+	mu     sync.Mutex
+	out    io.Writer = os.Stderr
+	level            = LevelInfo
+	asJSON           = false
+	now              = time.Now
+)
+
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	out = w
+	mu.Unlock()
+}
+
+func SetLevel(l Level) {
+	mu.Lock()
+	level = l
+	mu.Unlock()
+}
+
+// SetFormat switches between "text" (default) and "json" output.
+func SetFormat(format string) error {
+	switch format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unknown log format %#v", format)
+	}
+	mu.Lock()
+	asJSON = format == "json"
+	mu.Unlock()
+	return nil
+}
+
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l >= level
+}
+
+func write(l Level, msg string, fields Fields) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l < level {
+		return
+	}
+	t := now()
+	if asJSON {
+		entry := make(map[string]interface{}, len(fields)+3)
+		for key, val := range fields {
+			if err, ok := val.(error); ok {
+				val = err.Error()
+			}
+			entry[key] = val
+		}
+		entry["time"] = t.Format(time.RFC3339Nano)
+		entry["level"] = l.String()
+		entry["msg"] = msg
+		line, err := json.Marshal(entry)
+		if err != nil {
+			line, _ = json.Marshal(map[string]string{"level": l.String(), "msg": msg})
+		}
+		out.Write(append(line, '\n'))
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(t.Format("2006/01/02 15:04:05 "))
+	b.WriteString(strings.ToUpper(l.String()))
+	b.WriteString(" ")
+	b.WriteString(msg)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		val := fmt.Sprint(fields[key])
+		if val == "" || strings.ContainsAny(val, " \t\n\"=") {
+			val = fmt.Sprintf("%q", val)
+		}
+		b.WriteString(" " + key + "=" + val)
+	}
+	b.WriteString("\n")
+	io.WriteString(out, b.String())
+}
+
+// Entry is a log entry with the fields attached.
+type Entry struct {
+	fields Fields
+}
+
+func With(fields Fields) *Entry {
+	return &Entry{fields: fields}
+}
+
+func (e *Entry) Debug(msg string) { write(LevelDebug, msg, e.fields) }
+func (e *Entry) Info(msg string)  { write(LevelInfo, msg, e.fields) }
+func (e *Entry) Warn(msg string)  { write(LevelWarn, msg, e.fields) }
+func (e *Entry) Error(msg string) { write(LevelError, msg, e.fields) }
+
+func Debugf(format string, v ...interface{}) { write(LevelDebug, fmt.Sprintf(format, v...), nil) }
+func Infof(format string, v ...interface{})  { write(LevelInfo, fmt.Sprintf(format, v...), nil) }
+func Warnf(format string, v ...interface{})  { write(LevelWarn, fmt.Sprintf(format, v...), nil) }
+func Errorf(format string, v ...interface{}) { write(LevelError, fmt.Sprintf(format, v...), nil) }
+
+type levelWriter Level
+
+func (l levelWriter) Write(p []byte) (int, error) {
+	write(Level(l), strings.TrimRight(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+// Writer returns a writer logging each write as a message of the given level.
+// Meant for redirecting the standard logger.
+func Writer(l Level) io.Writer {
+	return levelWriter(l)
+}
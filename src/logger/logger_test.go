@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func setup(t *testing.T, l Level, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetLevel(l)
+	SetFormat(format)
+	now = func() time.Time { return time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC) }
+	t.Cleanup(func() {
+		SetLevel(LevelInfo)
+		SetFormat("text")
+		now = time.Now
+	})
+	return &buf
+}
+
+func TestText(t *testing.T) {
+	buf := setup(t, LevelInfo, "text")
+
+	Debugf("hidden %d", 1)
+	With(Fields{"feed_id": 1, "url": "http://example.com", "error": errors.New("oh no")}).Warn("feed failed")
+
+	want := `2021/01/02 03:04:05 WARN feed failed error="oh no" feed_id=1 url=http://example.com` + "\n"
+	if buf.String() != want {
+		t.Fatalf("\nwant: %q\nhave: %q", want, buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	buf := setup(t, LevelDebug, "json")
+
+	With(Fields{"feed_id": 1, "error": errors.New("oh no")}).Debug("feed failed")
+
+	var have map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &have); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"time":    "2021-01-02T03:04:05Z",
+		"level":   "debug",
+		"msg":     "feed failed",
+		"feed_id": 1.0,
+		"error":   "oh no",
+	}
+	for key, val := range want {
+		if have[key] != val {
+			t.Errorf("%s: want %v, have %v", key, val, have[key])
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARN"); err != nil || l != LevelWarn {
+		t.Fatal(l, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -1,6 +1,9 @@
 package parser
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // taken from github.com/mjibson/goread
 var dateFormats = []string{
@@ -205,14 +208,88 @@ var dateFormats = []string{
 
 var defaultTime = time.Time{}
 
+// floating is the location of the dates without the time zone,
+// until normalizeDates places them in the feed's one.
+var floating = time.FixedZone("", 0)
+
+// Offsets of the zone abbreviations seen in the feeds. Go only knows
+// the abbreviations of the local zone, the rest are parsed as UTC.
+var zoneOffsets = map[string]int{
+	"UT":   0,
+	"UTC":  0,
+	"GMT":  0,
+	"Z":    0,
+	"EST":  -5 * 3600,
+	"EDT":  -4 * 3600,
+	"CST":  -6 * 3600,
+	"CDT":  -5 * 3600,
+	"MST":  -7 * 3600,
+	"MDT":  -6 * 3600,
+	"PST":  -8 * 3600,
+	"PDT":  -7 * 3600,
+	"AKST": -9 * 3600,
+	"AKDT": -8 * 3600,
+	"HST":  -10 * 3600,
+	"WET":  0,
+	"WEST": 1 * 3600,
+	"BST":  1 * 3600,
+	"CET":  1 * 3600,
+	"CEST": 2 * 3600,
+	"MET":  1 * 3600,
+	"MEST": 2 * 3600,
+	"EET":  2 * 3600,
+	"EEST": 3 * 3600,
+	"MSK":  3 * 3600,
+	"IST":  5*3600 + 1800,
+	"SGT":  8 * 3600,
+	"HKT":  8 * 3600,
+	"JST":  9 * 3600,
+	"KST":  9 * 3600,
+	"AWST": 8 * 3600,
+	"ACST": 9*3600 + 1800,
+	"ACDT": 10*3600 + 1800,
+	"AEST": 10 * 3600,
+	"AEDT": 11 * 3600,
+	"NZST": 12 * 3600,
+	"NZDT": 13 * 3600,
+}
+
+// dateParse parses the date in the first matching format. The dates with
+// an offset or a zone abbreviation are returned in UTC, the ones without
+// in the floating location.
 func dateParse(line string) time.Time {
 	if line == "" {
 		return defaultTime
 	}
 	for _, layout := range dateFormats {
-		if t, err := time.Parse(layout, line); err == nil {
-			return t
+		t, err := time.Parse(layout, line)
+		if err != nil {
+			continue
+		}
+		switch {
+		case hasOffset(layout):
+			return t.UTC()
+		case strings.Contains(layout, "MST"):
+			name, _ := t.Zone()
+			if offset, ok := zoneOffsets[strings.ToUpper(name)]; ok {
+				return inLocation(t, time.FixedZone(name, offset)).UTC()
+			}
+			// unknown abbreviation, taken as UTC
+			return inLocation(t, time.UTC)
+		case strings.HasSuffix(layout, " Z"):
+			return t.UTC()
 		}
+		return inLocation(t, floating)
 	}
 	return defaultTime
 }
+
+// hasOffset reports whether the layout has the numeric zone offset.
+func hasOffset(layout string) bool {
+	return strings.Contains(layout, "-07") || strings.Contains(layout, "Z07") || strings.Contains(layout, "-7")
+}
+
+// inLocation returns the time with the same wall clock in the location.
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDateParseTimezones(t *testing.T) {
+	testcases := []struct {
+		line string
+		want time.Time
+	}{
+		{"Fri, 01 Jan 2021 14:00:00 +0100", time.Date(2021, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{"2021-01-01T14:00:00+01:00", time.Date(2021, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{"2021-01-01T14:00:00Z", time.Date(2021, 1, 1, 14, 0, 0, 0, time.UTC)},
+		{"Fri, 01 Jan 2021 14:00:00 CET", time.Date(2021, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{"Fri, 01 Jan 2021 09:00:00 EST", time.Date(2021, 1, 1, 14, 0, 0, 0, time.UTC)},
+		{"Fri, 01 Jan 2021 14:00:00 GMT", time.Date(2021, 1, 1, 14, 0, 0, 0, time.UTC)},
+		{"Fri, 01 Jan 2021 14:00:00 XYZ", time.Date(2021, 1, 1, 14, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range testcases {
+		have := dateParse(tc.line)
+		if !have.Equal(tc.want) || have.Location() != time.UTC {
+			t.Errorf("%s\nwant: %s\nhave: %s", tc.line, tc.want, have)
+		}
+	}
+
+	if have := dateParse("2021-01-01T14:00:00"); have.Location() != floating {
+		t.Errorf("expected the date without a zone to be floating, got %s", have)
+	}
+}
+
+func TestParseAndFixDatesInLocation(t *testing.T) {
+	feed := `
+		<rss version="2.0"><channel>
+			<item><guid>utc</guid><pubDate>Fri, 01 Jan 2021 15:00:00 +0000</pubDate></item>
+			<item><guid>cet</guid><pubDate>Fri, 01 Jan 2021 14:00:00 CET</pubDate></item>
+			<item><guid>none</guid><dc:date xmlns:dc="http://purl.org/dc/elements/1.1/">2021-01-01T15:30:00</dc:date></item>
+		</channel></rss>
+	`
+	tokyo := time.FixedZone("JST", 9*3600)
+
+	have, err := ParseAndFix(strings.NewReader(feed), "http://example.com/", "", tokyo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Time{
+		"utc":  time.Date(2021, 1, 1, 15, 0, 0, 0, time.UTC),
+		"cet":  time.Date(2021, 1, 1, 13, 0, 0, 0, time.UTC),
+		"none": time.Date(2021, 1, 1, 6, 30, 0, 0, time.UTC),
+	}
+	for _, item := range have.Items {
+		if !item.Date.Equal(want[item.GUID]) || item.Date.Location() != time.UTC {
+			t.Errorf("%s\nwant: %s\nhave: %s", item.GUID, want[item.GUID], item.Date)
+		}
+	}
+
+	have, _ = ParseAndFix(strings.NewReader(feed), "http://example.com/", "", nil)
+	if date := have.Items[2].Date; !date.Equal(time.Date(2021, 1, 1, 15, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected the date without a zone in UTC, got %s", date)
+	}
+}
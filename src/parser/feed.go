@@ -69,11 +69,24 @@ func sniff(lookup string) (out feedProbe) {
 	return
 }
 
+// DetectFormat returns the feed format (rss, rdf, atom, json)
+// judging by the beginning of the data, or an empty string if unknown.
+func DetectFormat(data []byte) string {
+	if len(data) > 2048 {
+		data = data[:2048]
+	}
+	return sniff(string(data)).feedType
+}
+
 func Parse(r io.Reader) (*Feed, error) {
 	return ParseWithEncoding(r, "")
 }
 
 func ParseWithEncoding(r io.Reader, fallbackEncoding string) (*Feed, error) {
+	return parse(r, fallbackEncoding, time.UTC)
+}
+
+func parse(r io.Reader, fallbackEncoding string, loc *time.Location) (*Feed, error) {
 	lookup := make([]byte, 2048)
 	n, err := io.ReadFull(r, lookup)
 	switch {
@@ -108,17 +121,23 @@ func ParseWithEncoding(r io.Reader, fallbackEncoding string) (*Feed, error) {
 	feed, err := out.callback(r)
 	if feed != nil {
 		feed.cleanup()
+		feed.normalizeDates(loc)
 	}
 	return feed, err
 }
 
-func ParseAndFix(r io.Reader, baseURL, fallbackEncoding string) (*Feed, error) {
-	feed, err := ParseWithEncoding(r, fallbackEncoding)
+// ParseAndFix parses the feed, resolving the relative urls against baseURL.
+// The dates without the time zone are taken to be in loc (UTC if nil).
+func ParseAndFix(r io.Reader, baseURL, fallbackEncoding string, loc *time.Location) (*Feed, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	feed, err := parse(r, fallbackEncoding, loc)
 	if err != nil {
 		return nil, err
 	}
 	feed.TranslateURLs(baseURL)
-	feed.SetMissingDatesTo(time.Now())
+	feed.SetMissingDatesTo(time.Now().UTC())
 	return feed, nil
 }
 
@@ -141,6 +160,17 @@ func (feed *Feed) cleanup() {
 	}
 }
 
+// normalizeDates converts the dates to UTC, placing the ones without
+// the time zone in loc.
+func (feed *Feed) normalizeDates(loc *time.Location) {
+	for i, item := range feed.Items {
+		if item.Date.Location() == floating {
+			feed.Items[i].Date = inLocation(item.Date, loc)
+		}
+		feed.Items[i].Date = feed.Items[i].Date.UTC()
+	}
+}
+
 func (feed *Feed) SetMissingDatesTo(newdate time.Time) {
 	for i, item := range feed.Items {
 		if item.Date.IsZero() {
@@ -6,6 +6,8 @@ type Feed struct {
 	Title   string
 	SiteURL string
 	Items   []Item
+	// minutes the feed may be cached for (rss <ttl>), 0 if not told
+	TTL int
 }
 
 type Item struct {
@@ -70,7 +70,7 @@ func TestRDFExtensions(t *testing.T) {
 	date, _ := time.Parse(time.RFC1123Z, time.RFC1123Z)
 	want := &Feed{
 		Items: []Item{
-			{Content: "test", Date: date},
+			{Content: "test", Date: date.UTC()},
 		},
 	}
 	if !reflect.DeepEqual(want, have) {
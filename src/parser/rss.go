@@ -8,6 +8,7 @@ import (
 	"encoding/xml"
 	"io"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -16,6 +17,7 @@ type rssFeed struct {
 	Version string    `xml:"version,attr"`
 	Title   string    `xml:"channel>title"`
 	Link    string    `xml:"channel>link"`
+	TTL     string    `xml:"channel>ttl"`
 	Items   []rssItem `xml:"channel>item"`
 }
 
@@ -73,6 +75,9 @@ func ParseRSS(r io.Reader) (*Feed, error) {
 		Title:   srcfeed.Title,
 		SiteURL: srcfeed.Link,
 	}
+	if ttl, err := strconv.Atoi(strings.TrimSpace(srcfeed.TTL)); err == nil && ttl > 0 {
+		dstfeed.TTL = ttl
+	}
 	for _, srcitem := range srcfeed.Items {
 		podcastURL := ""
 		for _, e := range srcitem.Enclosures {
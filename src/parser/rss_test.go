@@ -228,3 +228,17 @@ func TestRSSIsPermalink(t *testing.T) {
 		}
 	}
 }
+
+func TestRSSTTL(t *testing.T) {
+	feed, _ := Parse(strings.NewReader(`
+		<?xml version="1.0" encoding="UTF-8"?>
+		<rss version="2.0">
+			<channel>
+				<ttl> 60 </ttl>
+			</channel>
+		</rss>
+	`))
+	if feed.TTL != 60 {
+		t.Fatalf("expected the ttl of 60 minutes, got %d", feed.TTL)
+	}
+}
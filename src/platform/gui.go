@@ -4,6 +4,8 @@
 package platform
 
 import (
+	"fmt"
+
 	"github.com/nkanaev/yarr/src/server"
 	"github.com/nkanaev/yarr/src/systray"
 )
@@ -12,6 +14,10 @@ func Start(s *server.Server) {
 	systrayOnReady := func() {
 		systray.SetIcon(Icon)
 
+		// shown once the first refresh is done
+		menuUnread := systray.AddMenuItem("", "")
+		menuUnread.Disable()
+		menuUnread.Hide()
 		menuOpen := systray.AddMenuItem("Open", "")
 		systray.AddSeparator()
 		menuQuit := systray.AddMenuItem("Quit", "")
@@ -27,6 +33,23 @@ func Start(s *server.Server) {
 			}
 		}()
 
+		go s.WatchNotifications(func(n server.Notification) {
+			unread := "No unread items"
+			if n.Unread > 0 {
+				unread = fmt.Sprintf("%d unread", n.Unread)
+				// shown next to the icon on macOS
+				systray.SetTitle(fmt.Sprint(n.Unread))
+			} else {
+				systray.SetTitle("")
+			}
+			menuUnread.SetTitle(unread)
+			menuUnread.Show()
+			systray.SetTooltip("yarr: " + unread)
+			if n.Message != "" {
+				systray.ShowNotification(n.Title, n.Message)
+			}
+		})
+
 		s.Start()
 	}
 	systray.Run(systrayOnReady, nil)
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/importer"
+	"github.com/nkanaev/yarr/src/server/router"
+	"github.com/nkanaev/yarr/src/worker"
+)
+
+func (s *Server) handleAdminJobStart(c *router.Context) {
+	if c.Req.Method != "POST" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	job, err := s.worker.StartJob(c.Vars["kind"])
+	jobStarted(c, job, err)
+}
+
+// handleAdminImport starts the import from another reader. The credentials
+// are used for this run only, resuming the import requires them again.
+func (s *Server) handleAdminImport(c *router.Context) {
+	if c.Req.Method != "POST" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var form ImportForm
+	if err := json.NewDecoder(c.Req.Body).Decode(&form); err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	fields, ok := importer.Kinds[form.Kind]
+	if !ok {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown source."})
+		return
+	}
+	if !htmlutil.IsAPossibleLink(form.Endpoint) {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid endpoint."})
+		return
+	}
+	for _, field := range fields {
+		if form.Credentials[field] == "" {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Missing %s.", field)})
+			return
+		}
+	}
+	im, err := importer.New(importer.Options{
+		Kind:        form.Kind,
+		Endpoint:    form.Endpoint,
+		Credentials: form.Credentials,
+		Days:        form.Days,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	job, err := s.worker.StartImport(im)
+	jobStarted(c, job, err)
+}
+
+func jobStarted(c *router.Context, job worker.Job, err error) {
+	switch err {
+	case nil:
+		c.JSON(http.StatusAccepted, job)
+	case worker.ErrUnknownJob:
+		c.Out.WriteHeader(http.StatusNotFound)
+	case worker.ErrBusy:
+		c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case worker.ErrStopped:
+		c.Out.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		c.Out.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleAdminJobList(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	c.JSON(http.StatusOK, s.worker.ListJobs())
+}
+
+func (s *Server) handleAdminJob(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	job := s.worker.GetJob(id)
+	if job == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nkanaev/yarr/src/archive"
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/server/router"
+)
+
+// handleArchive streams the whole instance as the portable archive (GET)
+// or restores one into the empty instance (POST, the file as the body).
+func (s *Server) handleArchive(c *router.Context) {
+	switch c.Req.Method {
+	case "GET":
+		filename := fmt.Sprintf("yarr-%s.jsonl", time.Now().Format("2006-01-02"))
+		c.Out.Header().Set("Content-Type", "application/x-ndjson")
+		c.Out.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		// the status is sent already, the client gets a truncated archive
+		if _, err := archive.Export(s.db, c.Out); err != nil {
+			logger.With(logger.Fields{"error": err}).Warn("failed to export the archive")
+		}
+	case "POST":
+		counts, err := archive.Import(s.db, c.Req.Body)
+		switch {
+		case err == archive.ErrNotEmpty:
+			c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error(), "restored": counts})
+			return
+		}
+		s.worker.SetWorkers(s.db.GetSettingsValueInt64("workers"))
+		s.worker.SetRefreshRate(s.db.GetSettingsValueInt64("refresh_rate"))
+		s.worker.RefreshFeeds()
+		c.JSON(http.StatusOK, counts)
+	default:
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
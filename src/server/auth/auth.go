@@ -22,10 +22,15 @@ func IsAuthenticated(req *http.Request, username, password string) bool {
 	return StringsEqual(parts[1], secret(username, password))
 }
 
+// CookieValue returns the value of the auth cookie for the credentials.
+func CookieValue(username, password string) string {
+	return username + ":" + secret(username, password)
+}
+
 func Authenticate(rw http.ResponseWriter, username, password, basepath string) {
 	http.SetCookie(rw, &http.Cookie{
 		Name:    "auth",
-		Value:   username + ":" + secret(username, password),
+		Value:   CookieValue(username, password),
 		Expires: time.Now().Add(time.Hour * 24 * 7), // 1 week,
 		Path:    basepath,
 	})
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/server/router"
+)
+
+// RemoteUser returns the username set by the reverse proxy in the header,
+// provided the request came directly from one of the trusted proxies.
+func RemoteUser(req *http.Request, header string, trusted []*net.IPNet) string {
+	addr := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || !isTrusted(ip, trusted) {
+		return ""
+	}
+	return strings.TrimSpace(req.Header.Get(header))
+}
+
+// HeaderMiddleware delegates authentication to a reverse proxy (Authelia, Authentik, etc.),
+// which sets the name of the authenticated user in the header.
+type HeaderMiddleware struct {
+	Header         string
+	TrustedProxies []*net.IPNet
+	BasePath       string
+	Public         []string
+}
+
+func (m *HeaderMiddleware) Handler(c *router.Context) {
+	for _, path := range m.Public {
+		if strings.HasPrefix(c.Req.URL.Path, m.BasePath+path) {
+			c.Next()
+			return
+		}
+	}
+	if RemoteUser(c.Req, m.Header, m.TrustedProxies) != "" {
+		c.Next()
+		return
+	}
+	logger.With(logger.Fields{"header": m.Header, "remote_addr": c.Req.RemoteAddr}).Warn("auth: missing header")
+	c.Out.WriteHeader(http.StatusUnauthorized)
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteUser(t *testing.T) {
+	trusted, _ := ParseTrustedProxies("10.0.0.0/8")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Remote-User", "john")
+
+	req.RemoteAddr = "10.1.2.3:1234"
+	if have := RemoteUser(req, "Remote-User", trusted); have != "john" {
+		t.Errorf("expected user from trusted proxy, got %q", have)
+	}
+
+	req.RemoteAddr = "1.2.3.4:1234"
+	req.Header.Set("X-Forwarded-For", "10.1.2.3")
+	if have := RemoteUser(req, "Remote-User", trusted); have != "" {
+		t.Errorf("header honored from untrusted source: %q", have)
+	}
+}
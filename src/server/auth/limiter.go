@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Delay applied to every failed login regardless of the lockout state,
+// so that response timing doesn't reveal whether the credentials were close.
+var FailureDelay = time.Millisecond * 500
+
+type attempts struct {
+	failures    int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+// Limiter keeps track of failed logins per key (client ip, username)
+// and locks the key out with exponential backoff once the number of
+// failures exceeds MaxAttempts.
+type Limiter struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*attempts
+	now     func() time.Time
+}
+
+func NewLimiter(maxAttempts int, baseDelay, maxDelay time.Duration) *Limiter {
+	return &Limiter{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   baseDelay,
+		MaxDelay:    maxDelay,
+		entries:     make(map[string]*attempts),
+		now:         time.Now,
+	}
+}
+
+// Locked returns how long the caller has to wait before trying again.
+// Zero means none of the keys is locked.
+func (l *Limiter) Locked(keys ...string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var wait time.Duration
+	for _, key := range keys {
+		if entry, ok := l.entries[key]; ok {
+			if d := entry.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// Fail registers a failed attempt for each of the keys.
+func (l *Limiter) Fail(keys ...string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.cleanup(now)
+	for _, key := range keys {
+		entry, ok := l.entries[key]
+		if !ok {
+			entry = &attempts{}
+			l.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+		if excess := entry.failures - l.MaxAttempts; excess > 0 {
+			entry.lockedUntil = now.Add(l.backoff(excess))
+		}
+	}
+}
+
+// Reset forgets all the failed attempts for the keys.
+func (l *Limiter) Reset(keys ...string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+}
+
+func (l *Limiter) backoff(excess int) time.Duration {
+	delay := l.BaseDelay
+	for i := 1; i < excess && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.MaxDelay {
+		delay = l.MaxDelay
+	}
+	return delay
+}
+
+// drop the entries which haven't failed for a while, so that
+// the map doesn't grow indefinitely under a distributed attack.
+func (l *Limiter) cleanup(now time.Time) {
+	for key, entry := range l.entries {
+		if now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > l.MaxDelay {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterBackoff(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(2, time.Second, time.Second*3)
+	l.now = func() time.Time { return now }
+
+	l.Fail("ip:1")
+	l.Fail("ip:1")
+	if wait := l.Locked("ip:1"); wait != 0 {
+		t.Fatalf("expected no lockout within max attempts, got %s", wait)
+	}
+
+	want := []time.Duration{time.Second, time.Second * 2, time.Second * 3, time.Second * 3}
+	for _, w := range want {
+		l.Fail("ip:1")
+		if have := l.Locked("ip:1", "user:foo"); have != w {
+			t.Fatalf("want lockout %s, have %s", w, have)
+		}
+	}
+
+	l.Reset("ip:1")
+	if wait := l.Locked("ip:1"); wait != 0 {
+		t.Fatalf("expected reset, got %s", wait)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.1, 192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6")
+	if have := ClientIP(req, trusted); have != "1.2.3.4" {
+		t.Errorf("untrusted forwarded-for honored: %s", have)
+	}
+
+	req.RemoteAddr = "10.0.0.1:5678"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 5.5.5.5, 192.168.1.1")
+	if have := ClientIP(req, trusted); have != "5.5.5.5" {
+		t.Errorf("invalid client ip: %s", have)
+	}
+}
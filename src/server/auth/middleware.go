@@ -1,10 +1,14 @@
 package auth
 
 import (
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/nkanaev/yarr/src/assets"
+	"github.com/nkanaev/yarr/src/logger"
 	"github.com/nkanaev/yarr/src/server/router"
 	"github.com/nkanaev/yarr/src/storage"
 )
@@ -15,6 +19,9 @@ type Middleware struct {
 	BasePath string
 	Public   []string
 	DB       *storage.Storage
+
+	Limiter        *Limiter
+	TrustedProxies []*net.IPNet
 }
 
 func unsafeMethod(method string) bool {
@@ -43,11 +50,33 @@ func (m *Middleware) Handler(c *router.Context) {
 	if c.Req.Method == "POST" {
 		username := c.Req.FormValue("username")
 		password := c.Req.FormValue("password")
+		clientIP := ClientIP(c.Req, m.TrustedProxies)
+		keys := []string{"ip:" + clientIP, "user:" + username}
+
+		if wait := m.Limiter.Locked(keys...); wait > 0 {
+			logger.With(logger.Fields{
+				"username": username,
+				"ip":       clientIP,
+				"wait":     wait.Round(time.Second),
+			}).Warn("auth: login rejected, locked out")
+			c.Out.Header().Set("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+			c.HTML(http.StatusTooManyRequests, assets.Template("login.html"), map[string]interface{}{
+				"username": username,
+				"error":    "Too many failed attempts. Try again later",
+				"settings": m.DB.GetSettings(),
+			})
+			return
+		}
+
 		if StringsEqual(username, m.Username) && StringsEqual(password, m.Password) {
+			m.Limiter.Reset(keys...)
 			Authenticate(c.Out, m.Username, m.Password, m.BasePath)
 			c.Redirect(rootUrl)
 			return
 		} else {
+			m.Limiter.Fail(keys...)
+			logger.With(logger.Fields{"username": username, "ip": clientIP}).Warn("auth: failed login")
+			time.Sleep(FailureDelay)
 			c.HTML(http.StatusOK, assets.Template("login.html"), map[string]interface{}{
 				"username": username,
 				"error":    "Invalid username/password",
@@ -0,0 +1,66 @@
+package cors
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nkanaev/yarr/src/server/router"
+)
+
+type Middleware struct {
+	// exact origins (https://example.com) or wildcard subdomains (https://*.example.com)
+	Origins  []string
+	BasePath string
+	Paths    []string
+}
+
+func (m *Middleware) allowed(origin string) bool {
+	for _, pattern := range m.Origins {
+		if pattern == origin {
+			return true
+		}
+		if i := strings.Index(pattern, "://*."); i != -1 {
+			scheme, domain := pattern[:i+3], pattern[i+4:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) &&
+				len(origin) > len(scheme)+len(domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *Middleware) Handler(c *router.Context) {
+	origin := c.Req.Header.Get("Origin")
+	matchesPath := false
+	for _, path := range m.Paths {
+		if strings.HasPrefix(c.Req.URL.Path, m.BasePath+path) {
+			matchesPath = true
+			break
+		}
+	}
+	if origin == "" || !matchesPath {
+		c.Next()
+		return
+	}
+
+	header := c.Out.Header()
+	header.Add("Vary", "Origin")
+	if !m.allowed(origin) {
+		c.Next()
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Set("Access-Control-Allow-Credentials", "true")
+
+	if c.Req.Method == "OPTIONS" && c.Req.Header.Get("Access-Control-Request-Method") != "" {
+		header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+		if reqHeaders := c.Req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		header.Set("Access-Control-Max-Age", "3600")
+		c.Out.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.Next()
+}
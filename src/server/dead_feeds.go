@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nkanaev/yarr/src/server/router"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// DeadFeedResponse is the dead feed report entry along with the feed.
+type DeadFeedResponse struct {
+	storage.DeadFeed
+	Title    string `json:"title"`
+	Link     string `json:"link"`
+	FeedLink string `json:"feed_link"`
+}
+
+// handleDeadFeedList lists the feeds found dead on the last daily cleanup
+// (or the `deadfeeds` admin job) with the evidence.
+func (s *Server) handleDeadFeedList(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	feeds := make(map[int64]storage.Feed)
+	for _, feed := range s.db.ListFeeds() {
+		feeds[feed.Id] = feed
+	}
+	list := make([]DeadFeedResponse, 0)
+	for _, dead := range s.db.ListDeadFeeds() {
+		feed, ok := feeds[dead.FeedID]
+		if !ok {
+			continue
+		}
+		list = append(list, DeadFeedResponse{
+			DeadFeed: dead,
+			Title:    feed.Title,
+			Link:     feed.Link,
+			FeedLink: feed.FeedLink,
+		})
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"inactive_months": s.db.GetSettingsValueInt64("dead_feed_inactive_months"),
+		"failing_weeks":   s.db.GetSettingsValueInt64("dead_feed_failing_weeks"),
+		"feeds":           list,
+	})
+}
+
+// handleDeadFeed applies the action to the reported feed:
+// "pause" stops refreshing it, "keep" excludes it from the reports, "delete" unsubscribes.
+func (s *Server) handleDeadFeed(c *router.Context) {
+	if c.Req.Method != "POST" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.db.GetFeed(id) == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var form DeadFeedForm
+	if err := json.NewDecoder(c.Req.Body).Decode(&form); err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	settings := s.db.GetFeedSettings(id)
+	switch form.Action {
+	case "pause":
+		settings.Paused = true
+	case "keep":
+		settings.KeepIfDead = true
+	case "delete":
+		s.db.DeleteFeed(id)
+		c.Out.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "action must be pause, keep or delete"})
+		return
+	}
+	if !s.db.UpdateFeedSettings(id, settings) {
+		c.Out.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, feedSettingsResponse(settings))
+}
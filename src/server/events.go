@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nkanaev/yarr/src/server/router"
+)
+
+var eventsKeepAlive = time.Second * 30
+
+func (s *Server) handleEvents(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := c.Out.(http.Flusher)
+	if !ok {
+		c.Out.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	events := s.worker.Events()
+	sub := events.Subscribe()
+	defer events.Unsubscribe(sub)
+
+	header := c.Out.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	c.Out.WriteHeader(http.StatusOK)
+	fmt.Fprint(c.Out, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventsKeepAlive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-c.Req.Context().Done():
+			return
+		case <-s.shutdown:
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// dropped for falling behind, the client will reconnect
+				return
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(c.Out, "event: %s\ndata: %s\n\n", event.Name, data)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(c.Out, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/server/router"
+	"github.com/nkanaev/yarr/src/storage"
+	"github.com/nkanaev/yarr/src/worker"
+)
+
+const (
+	feedRefreshIntervalMin = 10          // minutes
+	feedRefreshIntervalMax = 60 * 24 * 7 // a week
+	feedTimeoutMax         = 60 * 10     // seconds
+	feedRetentionDaysMax   = 365 * 10
+	feedRetentionItemsMax  = 100000
+	feedMaxItemsMax        = 10000
+	feedMaxItemAgeDaysMax  = 365 * 10
+	feedHookTimeoutMax     = 60 * 10 // seconds
+	feedHeadersMax         = 20
+)
+
+// The headers of the connection rather than the request,
+// or set by the client itself.
+var reservedHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Host":                true,
+	"Content-Length":      true,
+}
+
+// validHeaderName reports whether the name is an http token (RFC 7230).
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// feedHeaders validates the extra request headers of the feed
+// and returns them with the names canonicalized, nil if there's none.
+func feedHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	if len(headers) > feedHeadersMax {
+		return nil, errors.New("too many headers")
+	}
+	result := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if reservedHeaders[name] {
+			return nil, fmt.Errorf("header %s can't be set", name)
+		}
+		if len(value) > 1024 || strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf("invalid value of header %s", name)
+		}
+		result[name] = strings.TrimSpace(value)
+	}
+	return result, nil
+}
+
+// FeedSettingsResponse is the feed settings as returned by the api.
+// The password is write-only: it's never sent back,
+// `has_password` tells whether one is set instead.
+type FeedSettingsResponse struct {
+	storage.FeedSettings
+	Password    string `json:"password,omitempty"`
+	HasPassword bool   `json:"has_password"`
+}
+
+func feedSettingsResponse(settings storage.FeedSettings) FeedSettingsResponse {
+	return FeedSettingsResponse{
+		FeedSettings: settings,
+		HasPassword:  settings.Password != "",
+	}
+}
+
+// apply updates the settings with the fields present in the form.
+func (form FeedSettingsForm) apply(settings *storage.FeedSettings) error {
+	if form.UserAgent != nil {
+		if len(*form.UserAgent) > 512 || strings.ContainsAny(*form.UserAgent, "\r\n") {
+			return errors.New("invalid user_agent")
+		}
+		settings.UserAgent = strings.TrimSpace(*form.UserAgent)
+	}
+	if form.Username != nil {
+		if strings.ContainsAny(*form.Username, ":\r\n") {
+			return errors.New("invalid username")
+		}
+		settings.Username = *form.Username
+	}
+	if form.Password != nil {
+		settings.Password = *form.Password
+	}
+	if form.Headers != nil {
+		headers, err := feedHeaders(form.Headers)
+		if err != nil {
+			return err
+		}
+		settings.Headers = headers
+	}
+	if form.Proxy != nil {
+		proxy := strings.TrimSpace(*form.Proxy)
+		if proxy != "" {
+			if _, err := worker.ParseProxyURL(proxy); err != nil {
+				return fmt.Errorf("invalid proxy: %s", err)
+			}
+		}
+		settings.Proxy = proxy
+	}
+	if form.ClientCert != nil || form.ClientKey != nil {
+		if form.ClientCert != nil {
+			settings.ClientCert = strings.TrimSpace(*form.ClientCert)
+		}
+		if form.ClientKey != nil {
+			settings.ClientKey = strings.TrimSpace(*form.ClientKey)
+		}
+		if settings.ClientCert != "" || settings.ClientKey != "" {
+			if !filepath.IsAbs(settings.ClientCert) || !filepath.IsAbs(settings.ClientKey) {
+				return errors.New("client_cert and client_key must be absolute paths")
+			}
+			if err := worker.CheckClientCert(settings.ClientCert, settings.ClientKey); err != nil {
+				return err
+			}
+		}
+	}
+	if form.Network != nil {
+		network := strings.TrimSpace(*form.Network)
+		if network != "" && !worker.ValidNetwork(network) {
+			return errors.New("network must be auto, ipv4 or ipv6")
+		}
+		settings.Network = network
+	}
+	if form.Timezone != nil {
+		tz := strings.TrimSpace(*form.Timezone)
+		if _, err := time.LoadLocation(tz); err != nil || strings.EqualFold(tz, "local") {
+			return errors.New("invalid timezone")
+		}
+		settings.Timezone = tz
+	}
+	if form.RefreshInterval != nil {
+		val := *form.RefreshInterval
+		if val != 0 && (val < feedRefreshIntervalMin || val > feedRefreshIntervalMax) {
+			return errors.New("refresh_interval must be 0 or between 10 minutes and a week")
+		}
+		settings.RefreshInterval = val
+	}
+	if form.Timeout != nil {
+		val := *form.Timeout
+		if val < 0 || val > feedTimeoutMax {
+			return errors.New("timeout must be between 0 and 600 seconds")
+		}
+		settings.Timeout = val
+	}
+	if form.RetentionDays != nil {
+		val := *form.RetentionDays
+		if val < -1 || val > feedRetentionDaysMax {
+			return errors.New("invalid retention_days")
+		}
+		settings.RetentionDays = val
+	}
+	if form.RetentionMaxItems != nil {
+		val := *form.RetentionMaxItems
+		if val < -1 || val > feedRetentionItemsMax {
+			return errors.New("invalid retention_max_items")
+		}
+		settings.RetentionMaxItems = val
+	}
+	if form.MaxItems != nil {
+		val := *form.MaxItems
+		if val < 0 || val > feedMaxItemsMax {
+			return errors.New("invalid max_items")
+		}
+		settings.MaxItems = val
+	}
+	if form.MaxItemAgeDays != nil {
+		val := *form.MaxItemAgeDays
+		if val < 0 || val > feedMaxItemAgeDaysMax {
+			return errors.New("invalid max_item_age_days")
+		}
+		settings.MaxItemAgeDays = val
+	}
+	if form.FullContent != nil {
+		settings.FullContent = *form.FullContent
+	}
+	if form.Notify != nil {
+		settings.Notify = *form.Notify
+	}
+	if form.FetchImages != nil {
+		settings.FetchImages = *form.FetchImages
+	}
+	if form.Math != nil {
+		settings.Math = *form.Math
+	}
+	if form.TrackUpdates != nil {
+		settings.TrackUpdates = *form.TrackUpdates
+	}
+	if form.Paused != nil {
+		settings.Paused = *form.Paused
+	}
+	if form.KeepIfDead != nil {
+		settings.KeepIfDead = *form.KeepIfDead
+	}
+	if form.KeepHTTP != nil {
+		settings.KeepHTTP = *form.KeepHTTP
+	}
+	if form.IgnoreCaching != nil {
+		settings.IgnoreCaching = *form.IgnoreCaching
+	}
+	if form.Debug != nil {
+		settings.Debug = *form.Debug
+	}
+	if form.HookCommand != nil {
+		command := strings.TrimSpace(*form.HookCommand)
+		if command != "" && !worker.ExecHooksEnabled() {
+			return errors.New("hook_command requires yarr to be started with --allow-exec-hooks")
+		}
+		if len(command) > 4096 {
+			return errors.New("invalid hook_command")
+		}
+		settings.HookCommand = command
+	}
+	if form.HookDir != nil {
+		dir := strings.TrimSpace(*form.HookDir)
+		if dir != "" && !filepath.IsAbs(dir) {
+			return errors.New("hook_dir must be an absolute path")
+		}
+		settings.HookDir = dir
+	}
+	if form.HookTimeout != nil {
+		val := *form.HookTimeout
+		if val < 0 || val > feedHookTimeoutMax {
+			return errors.New("hook_timeout must be between 0 and 600 seconds")
+		}
+		settings.HookTimeout = val
+	}
+	return nil
+}
+
+func (s *Server) handleFeedSettings(c *router.Context) {
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.db.GetFeed(id) == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch c.Req.Method {
+	case "GET":
+		c.JSON(http.StatusOK, feedSettingsResponse(s.db.GetFeedSettings(id)))
+	case "PATCH":
+		var form FeedSettingsForm
+		decoder := json.NewDecoder(c.Req.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&form); err != nil {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		settings := s.db.GetFeedSettings(id)
+		if err := form.apply(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if !s.db.UpdateFeedSettings(id, settings) {
+			c.Out.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, feedSettingsResponse(settings))
+	default:
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleFeedValidators forgets the etag, the last modified time and the body hash
+// of the feed, for it to be fetched in full once without ignoring the caching for good.
+func (s *Server) handleFeedValidators(c *router.Context) {
+	if c.Req.Method != "DELETE" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.db.GetFeed(id) == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !s.db.ClearHTTPState(id) {
+		c.Out.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.Out.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleFeedDebug(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.db.GetFeed(id) == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	trace := s.worker.FeedTrace(id)
+	if trace == nil {
+		c.JSON(http.StatusNotFound, map[string]string{
+			"error": "not fetched with debug on since started, turn on the feed's debug setting (or -debug-http) and refresh it",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, trace)
+}
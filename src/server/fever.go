@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nkanaev/yarr/src/logger"
 	"github.com/nkanaev/yarr/src/server/auth"
 	"github.com/nkanaev/yarr/src/server/router"
 	"github.com/nkanaev/yarr/src/storage"
@@ -78,11 +79,19 @@ func (s *Server) feverAuth(c *router.Context) bool {
 	if s.Username != "" && s.Password != "" {
 		apiKey := c.Req.FormValue("api_key")
 		apiKey = strings.ToLower(apiKey)
+		clientIP := auth.ClientIP(c.Req, s.TrustedProxies)
+		if s.LoginLimiter.Locked("ip:"+clientIP) > 0 {
+			return false
+		}
 		md5HashValue := md5.Sum([]byte(fmt.Sprintf("%s:%s", s.Username, s.Password)))
 		hexMD5HashValue := fmt.Sprintf("%x", md5HashValue[:])
 		if !auth.StringsEqual(apiKey, hexMD5HashValue) {
+			s.LoginLimiter.Fail("ip:" + clientIP)
+			logger.With(logger.Fields{"ip": clientIP}).Warn("fever: invalid api key")
+			time.Sleep(auth.FailureDelay)
 			return false
 		}
+		s.LoginLimiter.Reset("ip:" + clientIP)
 	}
 	return true
 }
@@ -360,6 +369,10 @@ func (s *Server) feverMarkHandler(c *router.Context) {
 			return
 		}
 		s.db.UpdateItemStatus(id, status)
+		if status == storage.STARRED {
+			s.saveStarredItem(id)
+			s.worker.CacheItemImages(id)
+		}
 	case "feed":
 		if c.Req.Form.Get("as") != "read" {
 			c.Out.WriteHeader(http.StatusBadRequest)
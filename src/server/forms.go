@@ -16,6 +16,79 @@ type FolderUpdateForm struct {
 }
 
 type FeedCreateForm struct {
+	Url      string            `json:"url"`
+	FolderID *int64            `json:"folder_id,omitempty"`
+	Username *string           `json:"username,omitempty"`
+	Password *string           `json:"password,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Proxy    *string           `json:"proxy,omitempty"`
+}
+
+type FeedPreviewForm struct {
 	Url      string `json:"url"`
-	FolderID *int64 `json:"folder_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type IntegrationForm struct {
+	Kind        string            `json:"kind"`
+	Title       *string           `json:"title,omitempty"`
+	Endpoint    *string           `json:"endpoint,omitempty"`
+	Credentials map[string]string `json:"credentials,omitempty"`
+	SaveStarred *bool             `json:"save_starred,omitempty"`
+}
+
+type ImportForm struct {
+	Kind        string            `json:"kind"`
+	Endpoint    string            `json:"endpoint"`
+	Credentials map[string]string `json:"credentials"`
+	Days        int               `json:"days"`
+}
+
+type ItemSaveForm struct {
+	IntegrationID int64 `json:"integration_id"`
+}
+
+type SavedSearchForm struct {
+	Name      *string `json:"name,omitempty"`
+	Query     *string `json:"query,omitempty"`
+	ShowCount *bool   `json:"show_count,omitempty"`
+}
+
+type DeadFeedForm struct {
+	Action string `json:"action"`
+}
+
+type FeedSettingsForm struct {
+	UserAgent         *string `json:"user_agent,omitempty"`
+	Username          *string `json:"username,omitempty"`
+	Password          *string `json:"password,omitempty"`
+	Timezone          *string `json:"timezone,omitempty"`
+	RefreshInterval   *int64  `json:"refresh_interval,omitempty"`
+	Timeout           *int64  `json:"timeout,omitempty"`
+	RetentionDays     *int64  `json:"retention_days,omitempty"`
+	RetentionMaxItems *int64  `json:"retention_max_items,omitempty"`
+	MaxItems          *int64  `json:"max_items,omitempty"`
+	MaxItemAgeDays    *int64  `json:"max_item_age_days,omitempty"`
+	FullContent       *bool   `json:"full_content,omitempty"`
+	Notify            *bool   `json:"notify,omitempty"`
+	FetchImages       *bool   `json:"fetch_images,omitempty"`
+	Math              *bool   `json:"math,omitempty"`
+	TrackUpdates      *bool   `json:"track_updates,omitempty"`
+	Paused            *bool   `json:"paused,omitempty"`
+	KeepIfDead        *bool   `json:"keep_if_dead,omitempty"`
+	KeepHTTP          *bool   `json:"keep_http,omitempty"`
+	IgnoreCaching     *bool   `json:"ignore_caching,omitempty"`
+	Debug             *bool   `json:"debug,omitempty"`
+	HookCommand       *string `json:"hook_command,omitempty"`
+	HookDir           *string `json:"hook_dir,omitempty"`
+	HookTimeout       *int64  `json:"hook_timeout,omitempty"`
+
+	// replaces the headers set before, if present
+	Headers map[string]string `json:"headers,omitempty"`
+	Proxy   *string           `json:"proxy,omitempty"`
+	// pem files, both set or neither
+	ClientCert *string `json:"client_cert,omitempty"`
+	ClientKey  *string `json:"client_key,omitempty"`
+	Network    *string `json:"network,omitempty"`
 }
@@ -13,6 +13,8 @@ type gzipResponseWriter struct {
 
 	out *gzip.Writer
 	src http.ResponseWriter
+
+	wroteHeader bool
 }
 
 func (rw *gzipResponseWriter) Header() http.Header {
@@ -20,23 +22,73 @@ func (rw *gzipResponseWriter) Header() http.Header {
 }
 
 func (rw *gzipResponseWriter) Write(x []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.out == nil {
+		return rw.src.Write(x)
+	}
 	return rw.out.Write(x)
 }
 
 func (rw *gzipResponseWriter) WriteHeader(statusCode int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	if compressible(rw.src.Header(), statusCode) {
+		rw.src.Header().Set("Content-Encoding", "gzip")
+		rw.src.Header().Del("Content-Length")
+		rw.out = gzip.NewWriter(rw.src)
+	}
 	rw.src.WriteHeader(statusCode)
 }
 
+func (rw *gzipResponseWriter) Flush() {
+	if rw.out != nil {
+		rw.out.Flush()
+	}
+	if flusher, ok := rw.src.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *gzipResponseWriter) Close() {
+	if rw.out != nil {
+		rw.out.Close()
+	}
+}
+
+// don't compress partial content, already compressed
+// or media files and event streams, which are streamed as is.
+func compressible(header http.Header, statusCode int) bool {
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	ctype := header.Get("Content-Type")
+	if strings.HasPrefix(ctype, "text/event-stream") {
+		return false
+	}
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(ctype, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
 func Middleware(c *router.Context) {
 	if !strings.Contains(c.Req.Header.Get("Accept-Encoding"), "gzip") {
 		c.Next()
 		return
 	}
 
-	gz := &gzipResponseWriter{out: gzip.NewWriter(c.Out), src: c.Out}
-	defer gz.out.Close()
+	gz := &gzipResponseWriter{src: c.Out}
+	defer gz.Close()
 
-	c.Out.Header().Set("Content-Encoding", "gzip")
 	c.Out = gz
 
 	c.Next()
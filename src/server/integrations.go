@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/integration"
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/server/router"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func (s *Server) handleIntegrationList(c *router.Context) {
+	if c.Req.Method == "GET" {
+		c.JSON(http.StatusOK, s.db.ListIntegrations())
+	} else if c.Req.Method == "POST" {
+		var form IntegrationForm
+		if err := json.NewDecoder(c.Req.Body).Decode(&form); err != nil {
+			log.Print(err)
+			c.Out.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, ok := integration.Kinds[form.Kind]; !ok {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown integration."})
+			return
+		}
+		if form.Endpoint == nil || !htmlutil.IsAPossibleLink(*form.Endpoint) {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid endpoint."})
+			return
+		}
+		title := form.Kind
+		if form.Title != nil && *form.Title != "" {
+			title = *form.Title
+		}
+		saveStarred := form.SaveStarred != nil && *form.SaveStarred
+		i := s.db.CreateIntegration(form.Kind, title, *form.Endpoint, form.Credentials, saveStarred)
+		if i == nil {
+			c.Out.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusCreated, i)
+	} else {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleIntegration(c *router.Context) {
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if c.Req.Method == "PUT" {
+		i := s.db.GetIntegration(id)
+		if i == nil {
+			c.Out.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var form IntegrationForm
+		if err := json.NewDecoder(c.Req.Body).Decode(&form); err != nil {
+			log.Print(err)
+			c.Out.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if form.Title != nil {
+			i.Title = *form.Title
+		}
+		if form.Endpoint != nil {
+			if !htmlutil.IsAPossibleLink(*form.Endpoint) {
+				c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid endpoint."})
+				return
+			}
+			i.Endpoint = *form.Endpoint
+		}
+		// credentials are never sent back to the client,
+		// so only overwrite the ones provided
+		for key, val := range form.Credentials {
+			i.Credentials[key] = val
+		}
+		if form.SaveStarred != nil {
+			i.SaveStarred = *form.SaveStarred
+		}
+		s.db.UpdateIntegration(*i)
+		c.Out.WriteHeader(http.StatusOK)
+	} else if c.Req.Method == "DELETE" {
+		s.db.DeleteIntegration(id)
+		c.Out.WriteHeader(http.StatusNoContent)
+	} else {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) saveItemTo(ctx context.Context, i storage.Integration, item *storage.Item) error {
+	service, err := integration.New(i)
+	if err != nil {
+		return err
+	}
+	return service.Save(ctx, integration.Entry{URL: item.Link, Title: item.Title})
+}
+
+func (s *Server) handleItemSave(c *router.Context) {
+	if c.Req.Method != "POST" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var form ItemSaveForm
+	if err := json.NewDecoder(c.Req.Body).Decode(&form); err != nil {
+		log.Print(err)
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	item := s.db.GetItem(id)
+	i := s.db.GetIntegration(form.IntegrationID)
+	if item == nil || i == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := s.saveItemTo(c.Req.Context(), *i, item); err != nil {
+		logger.With(logger.Fields{"item_id": id, "integration": i.Title, "error": err}).Warn("failed to save item")
+		c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	c.Out.WriteHeader(http.StatusOK)
+}
+
+// saveStarredItem sends the item to all the services
+// configured to receive starred items.
+func (s *Server) saveStarredItem(id int64) {
+	integrations := make([]storage.Integration, 0)
+	for _, i := range s.db.ListIntegrations() {
+		if i.SaveStarred {
+			integrations = append(integrations, i)
+		}
+	}
+	if len(integrations) == 0 {
+		return
+	}
+	item := s.db.GetItem(id)
+	if item == nil {
+		return
+	}
+	go func() {
+		for _, i := range integrations {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := s.saveItemTo(ctx, i, item); err != nil {
+				logger.With(logger.Fields{"item_id": id, "integration": i.Title, "error": err}).Warn("failed to save starred item")
+			}
+			cancel()
+		}
+	}()
+}
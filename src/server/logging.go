@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/server/router"
+)
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// requestLogger logs the requests at the debug level.
+// Server errors are logged regardless.
+func requestLogger(c *router.Context) {
+	start := time.Now()
+	out := &statusWriter{ResponseWriter: c.Out}
+	c.Out = out
+
+	c.Next()
+
+	if out.status == 0 {
+		out.status = http.StatusOK
+	}
+	entry := logger.With(logger.Fields{
+		"method":      c.Req.Method,
+		"path":        c.Req.URL.Path,
+		"status":      out.status,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+	if out.status >= 500 {
+		entry.Error("request")
+	} else {
+		entry.Debug("request")
+	}
+}
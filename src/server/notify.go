@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/worker"
+)
+
+// Notification is the summary of the refresh run for the desktop builds.
+// Message is empty if there's nothing to notify about.
+type Notification struct {
+	Title   string
+	Message string
+	Unread  int64
+}
+
+// WatchNotifications calls fn once per refresh run until the server is shut down.
+func (s *Server) WatchNotifications(fn func(Notification)) {
+	events := s.worker.Events()
+	sub := events.Subscribe()
+	defer func() { events.Unsubscribe(sub) }()
+
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// dropped for falling behind, start over
+				sub = events.Subscribe()
+				continue
+			}
+			if summary, ok := event.Data.(worker.RefreshFinishedEvent); ok {
+				fn(s.notification(summary, time.Now()))
+			}
+		}
+	}
+}
+
+// notification sums up the new items of the feeds with the notify flag on,
+// unless the notifications are disabled or it's quiet hours.
+func (s *Server) notification(summary worker.RefreshFinishedEvent, now time.Time) Notification {
+	n := Notification{Title: "yarr"}
+	for _, stat := range s.db.FeedStats() {
+		n.Unread += stat.UnreadCount
+	}
+
+	if !s.db.GetSettingsValueBool("notifications") {
+		return n
+	}
+	start, _ := s.db.GetSettingsValue("quiet_hours_start").(string)
+	end, _ := s.db.GetSettingsValue("quiet_hours_end").(string)
+	if worker.InQuietHours(start, end, now) {
+		return n
+	}
+
+	titles := make([]string, 0)
+	count := 0
+	for _, updated := range summary.Updated {
+		if !s.db.GetFeedSettings(updated.FeedID).Notify {
+			continue
+		}
+		feed := s.db.GetFeed(updated.FeedID)
+		if feed == nil {
+			continue
+		}
+		titles = append(titles, feed.Title)
+		count += updated.Count
+	}
+	n.Message = notificationMessage(titles, count)
+	return n
+}
+
+// notificationMessage returns "3 new items in Feed A, Feed B and 2 more".
+func notificationMessage(titles []string, count int) string {
+	if count == 0 || len(titles) == 0 {
+		return ""
+	}
+	items := "new items"
+	if count == 1 {
+		items = "new item"
+	}
+	var feeds string
+	switch len(titles) {
+	case 1:
+		feeds = titles[0]
+	case 2:
+		feeds = titles[0] + " and " + titles[1]
+	default:
+		feeds = fmt.Sprintf("%s and %d more", strings.Join(titles[:2], ", "), len(titles)-2)
+	}
+	return fmt.Sprintf("%d %s in %s", count, items, feeds)
+}
@@ -0,0 +1,48 @@
+package opml
+
+import (
+	"net/url"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// Export builds the document of the subscriptions grouped by folder.
+// Empty folders are left out, and so are the credentials in the links.
+func Export(feeds []storage.Feed, folders []storage.Folder) Folder {
+	doc := Folder{}
+
+	feedsByFolderID := make(map[int64][]Feed)
+	for _, feed := range feeds {
+		f := Feed{
+			Title:   feed.Title,
+			FeedUrl: withoutCredentials(feed.FeedLink),
+			SiteUrl: withoutCredentials(feed.Link),
+		}
+		if feed.FolderId == nil {
+			doc.Feeds = append(doc.Feeds, f)
+		} else {
+			id := *feed.FolderId
+			feedsByFolderID[id] = append(feedsByFolderID[id], f)
+		}
+	}
+
+	for _, folder := range folders {
+		if len(feedsByFolderID[folder.Id]) == 0 {
+			continue
+		}
+		doc.Folders = append(doc.Folders, Folder{
+			Title: folder.Title,
+			Feeds: feedsByFolderID[folder.Id],
+		})
+	}
+	return doc
+}
+
+func withoutCredentials(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.User == nil {
+		return link
+	}
+	u.User = nil
+	return u.String()
+}
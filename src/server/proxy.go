@@ -0,0 +1,198 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/content/sanitizer"
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/server/router"
+	"github.com/nkanaev/yarr/src/worker"
+)
+
+const proxyImageMaxSize = 10 << 20 // 10MB
+
+func (s *Server) proxySignature(link string) string {
+	mac := hmac.New(sha256.New, s.proxyKey)
+	mac.Write([]byte(link))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) proxyURL(link string) string {
+	if !htmlutil.IsAPossibleLink(link) {
+		return link
+	}
+	return s.BasePath + "/proxy?url=" + url.QueryEscape(link) + "&sig=" + s.proxySignature(link)
+}
+
+func (s *Server) imageProxyEnabled() bool {
+	enabled, _ := s.db.GetSettingsValue("image_proxy").(bool)
+	return enabled
+}
+
+// proxyContent rewrites the images in the content to go through
+// the image proxy, if enabled in the settings.
+func (s *Server) proxyContent(content string) string {
+	if !s.imageProxyEnabled() {
+		return content
+	}
+	return sanitizer.RewriteImages(content, s.proxyURL)
+}
+
+// offlineContent points the images of the starred item cached for reading
+// offline to the local copies.
+func (s *Server) offlineContent(itemID int64, content string) string {
+	keys := s.db.ItemImageKeys(itemID)
+	if len(keys) == 0 {
+		return content
+	}
+	return sanitizer.RewriteImages(content, func(link string) string {
+		if key, ok := keys[link]; ok {
+			return s.BasePath + "/api/images/" + key
+		}
+		return link
+	})
+}
+
+// handleCachedImage serves the image cached for reading offline.
+func (s *Server) handleCachedImage(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	image := s.db.GetCachedImage(c.Vars["key"])
+	if image == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	out := c.Out.Header()
+	out.Set("Content-Type", image.ContentType)
+	out.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	out.Set("X-Content-Type-Options", "nosniff")
+	// keyed by the content, never changes
+	out.Set("Cache-Control", "private, max-age=31536000, immutable")
+	c.Out.WriteHeader(http.StatusOK)
+	c.Out.Write(image.Data)
+}
+
+func (s *Server) handleImageProxy(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := c.Req.URL.Query()
+	link := query.Get("url")
+	sig := query.Get("sig")
+	if !htmlutil.IsAPossibleLink(link) || !hmac.Equal([]byte(sig), []byte(s.proxySignature(link))) {
+		c.Out.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	header := make(http.Header)
+	for _, key := range []string{"If-None-Match", "If-Modified-Since"} {
+		if val := c.Req.Header.Get(key); val != "" {
+			header.Set(key, val)
+		}
+	}
+	res, err := worker.Fetch(c.Req.Context(), link, header)
+	if err != nil {
+		logger.With(logger.Fields{"url": link, "error": err}).Warn("failed to proxy image")
+		c.Out.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		c.Out.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		c.Out.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if res.ContentLength > proxyImageMaxSize {
+		c.Out.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	ctype := res.Header.Get("Content-Type")
+	if !strings.HasPrefix(ctype, "image/") {
+		c.Out.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	out := c.Out.Header()
+	out.Set("Content-Type", ctype)
+	// images like svg may contain scripts, don't let them run in our origin
+	out.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	out.Set("X-Content-Type-Options", "nosniff")
+	out.Set("Cache-Control", "private, max-age=604800")
+	for _, key := range []string{"Etag", "Last-Modified"} {
+		if val := res.Header.Get(key); val != "" {
+			out.Set(key, val)
+		}
+	}
+	c.Out.WriteHeader(http.StatusOK)
+	io.Copy(c.Out, io.LimitReader(res.Body, proxyImageMaxSize))
+}
+
+func (s *Server) handleAudioProxy(c *router.Context) {
+	if c.Req.Method != "GET" && c.Req.Method != "HEAD" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	item := s.db.GetItem(id)
+	if item == nil || item.AudioURL == nil || !htmlutil.IsAPossibleLink(*item.AudioURL) {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	link := *item.AudioURL
+
+	// private podcasts need the feed's credentials and connection
+	settings := s.db.GetFeedSettings(item.FeedId)
+	feedLink := ""
+	if feed := s.db.GetFeed(item.FeedId); feed != nil {
+		feedLink = feed.FeedLink
+	}
+	header := worker.EnclosureHeader(settings, feedLink, link)
+	for _, key := range []string{"Range", "If-Range"} {
+		if val := c.Req.Header.Get(key); val != "" {
+			header.Set(key, val)
+		}
+	}
+	res, err := worker.Fetch(worker.WithFeedConnection(c.Req.Context(), settings), link, header)
+	if err != nil {
+		logger.With(logger.Fields{"url": link, "error": err}).Warn("failed to proxy audio")
+		c.Out.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+	default:
+		c.Out.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	out := c.Out.Header()
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Etag", "Last-Modified"} {
+		if val := res.Header.Get(key); val != "" {
+			out.Set(key, val)
+		}
+	}
+	c.Out.WriteHeader(res.StatusCode)
+	if c.Req.Method == "GET" {
+		io.Copy(c.Out, res.Body)
+	}
+}
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"context"
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,13 +13,17 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/nkanaev/yarr/src/assets"
 	"github.com/nkanaev/yarr/src/content/htmlutil"
 	"github.com/nkanaev/yarr/src/content/readability"
 	"github.com/nkanaev/yarr/src/content/sanitizer"
 	"github.com/nkanaev/yarr/src/content/silo"
+	"github.com/nkanaev/yarr/src/logger"
 	"github.com/nkanaev/yarr/src/server/auth"
+	"github.com/nkanaev/yarr/src/server/cors"
 	"github.com/nkanaev/yarr/src/server/gzip"
 	"github.com/nkanaev/yarr/src/server/opml"
 	"github.com/nkanaev/yarr/src/server/router"
@@ -27,15 +34,41 @@ import (
 func (s *Server) handler() http.Handler {
 	r := router.NewRouter(s.BasePath)
 
+	r.Use(requestLogger)
 	r.Use(gzip.Middleware)
 
-	if s.Username != "" && s.Password != "" {
+	if len(s.CORSOrigins) > 0 {
+		c := &cors.Middleware{
+			Origins:  s.CORSOrigins,
+			BasePath: s.BasePath,
+			Paths:    []string{"/api/"},
+		}
+		r.Use(c.Handler)
+	}
+
+	if s.AuthHeader != "" {
+		public := []string{"/static"}
+		if s.Username != "" && s.Password != "" {
+			// fever clients authenticate with the api key
+			public = append(public, "/fever")
+		}
+		a := &auth.HeaderMiddleware{
+			Header:         s.AuthHeader,
+			TrustedProxies: s.TrustedProxies,
+			BasePath:       s.BasePath,
+			Public:         public,
+		}
+		r.Use(a.Handler)
+	} else if s.Username != "" && s.Password != "" {
 		a := &auth.Middleware{
 			BasePath: s.BasePath,
 			Username: s.Username,
 			Password: s.Password,
 			Public:   []string{"/static", "/fever"},
             DB:       s.db,
+
+			Limiter:        s.LoginLimiter,
+			TrustedProxies: s.TrustedProxies,
 		}
 		r.Use(a.Handler)
 	}
@@ -44,19 +77,55 @@ func (s *Server) handler() http.Handler {
 	r.For("/manifest.json", s.handleManifest)
 	r.For("/static/*path", s.handleStatic)
 	r.For("/api/status", s.handleStatus)
+	r.For("/api/status/refresh", s.handleRefreshStatus)
+	r.For("/api/events", s.handleEvents)
 	r.For("/api/folders", s.handleFolderList)
+	r.For("/api/folders/:id/refresh", s.handleFolderRefresh)
 	r.For("/api/folders/:id", s.handleFolder)
 	r.For("/api/feeds", s.handleFeedList)
 	r.For("/api/feeds/refresh", s.handleFeedRefresh)
 	r.For("/api/feeds/errors", s.handleFeedErrors)
+	r.For("/api/feeds/errors/kinds", s.handleFeedErrorKinds)
+	r.For("/api/feeds/errors/refresh", s.handleFailedFeedsRefresh)
+	r.For("/api/feeds/preview", s.handleFeedPreview)
+	r.For("/api/feeds/dead", s.handleDeadFeedList)
+	r.For("/api/feeds/transfers", s.handleFeedTransfers)
+	r.For("/api/feeds/dead/:id", s.handleDeadFeed)
+	r.For("/api/feeds/suspended", s.handleSuspendedFeedList)
+	r.For("/api/feeds/suspended/:id", s.handleSuspendedFeed)
 	r.For("/api/feeds/:id/icon", s.handleFeedIcon)
+	r.For("/api/feeds/:id/settings", s.handleFeedSettings)
+	r.For("/api/feeds/:id/refresh", s.handleFeedRefreshOne)
+	r.For("/api/feeds/:id/errors", s.handleFeedErrorHistory)
+	r.For("/api/feeds/:id/validators", s.handleFeedValidators)
+	r.For("/api/feeds/:id/debug", s.handleFeedDebug)
 	r.For("/api/feeds/:id", s.handleFeed)
 	r.For("/api/items", s.handleItemList)
+	r.For("/api/searches", s.handleSavedSearchList)
+	r.For("/api/searches/history", s.handleSearchHistory)
+	r.For("/api/searches/:id", s.handleSavedSearch)
+	r.For("/api/items/mark_older", s.handleItemsMarkOlder)
+	r.For("/api/items/random", s.handleItemRandom)
+	r.For("/api/items/:id/full", s.handleItemFullContent)
+	r.For("/api/items/:id/audio", s.handleAudioProxy)
+	r.For("/api/items/:id/save", s.handleItemSave)
+	r.For("/api/items/:id/duplicates", s.handleItemDuplicates)
+	r.For("/api/images/:key", s.handleCachedImage)
 	r.For("/api/items/:id", s.handleItem)
 	r.For("/api/settings", s.handleSettings)
+	r.For("/api/settings/export", s.handleSettingsExport)
+	r.For("/api/settings/import", s.handleSettingsImport)
+	r.For("/api/integrations", s.handleIntegrationList)
+	r.For("/api/integrations/:id", s.handleIntegration)
+	r.For("/api/admin/jobs", s.handleAdminJobList)
+	r.For("/api/admin/jobs/:id", s.handleAdminJob)
+	r.For("/api/admin/import", s.handleAdminImport)
+	r.For("/api/admin/:kind", s.handleAdminJobStart)
+	r.For("/api/archive", s.handleArchive)
 	r.For("/opml/import", s.handleOPMLImport)
 	r.For("/opml/export", s.handleOPMLExport)
 	r.For("/page", s.handlePageCrawl)
+	r.For("/proxy", s.handleImageProxy)
 	r.For("/logout", s.handleLogout)
 	r.For("/fever/", s.handleFever)
 
@@ -66,7 +135,8 @@ func (s *Server) handler() http.Handler {
 func (s *Server) handleIndex(c *router.Context) {
 	c.HTML(http.StatusOK, assets.Template("index.html"), map[string]interface{}{
 		"settings":      s.db.GetSettings(),
-		"authenticated": s.Username != "" && s.Password != "",
+		"authenticated": s.AuthHeader == "" && s.Username != "" && s.Password != "",
+		"logout_url":    s.LogoutURL,
 	})
 }
 
@@ -100,11 +170,23 @@ func (s *Server) handleManifest(c *router.Context) {
 
 func (s *Server) handleStatus(c *router.Context) {
 	c.JSON(http.StatusOK, map[string]interface{}{
-		"running": s.worker.FeedsPending(),
-		"stats":   s.db.FeedStats(),
+		"running":  s.worker.FeedsPending(),
+		"favicons": s.worker.FaviconsPending(),
+		"stats":    s.db.FeedStats(),
+		"searches": s.savedSearches(),
 	})
 }
 
+// handleRefreshStatus reports the state of each feed in the last refresh,
+// the one in progress if any.
+func (s *Server) handleRefreshStatus(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	c.JSON(http.StatusOK, s.worker.RefreshProgress())
+}
+
 func (s *Server) handleFolderList(c *router.Context) {
 	if c.Req.Method == "GET" {
 		list := s.db.ListFolders()
@@ -153,20 +235,150 @@ func (s *Server) handleFolder(c *router.Context) {
 	}
 }
 
+func (s *Server) handleFolderRefresh(c *router.Context) {
+	if c.Req.Method != "POST" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch err := s.worker.RefreshFolder(id); err {
+	case nil:
+		c.Out.WriteHeader(http.StatusOK)
+	case worker.ErrUnknownFolder:
+		c.Out.WriteHeader(http.StatusNotFound)
+	case worker.ErrBusy:
+		c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case worker.ErrStopped:
+		c.Out.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		c.Out.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 func (s *Server) handleFeedRefresh(c *router.Context) {
 	if c.Req.Method == "POST" {
 		s.worker.RefreshFeeds()
 		c.Out.WriteHeader(http.StatusOK)
+	} else if c.Req.Method == "DELETE" {
+		// returns once the refresh is stopped, a new one can be started right away
+		c.JSON(http.StatusOK, map[string]bool{"stopped": s.worker.StopRefresh()})
 	} else {
 		c.Out.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+func (s *Server) handleFeedRefreshOne(c *router.Context) {
+	if c.Req.Method != "POST" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// returns once the feed is fetched, with the number of new items and the error
+	done, err := s.worker.RefreshFeed(id)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, done)
+	case worker.ErrUnknownFeed:
+		c.Out.WriteHeader(http.StatusNotFound)
+	case worker.ErrBusy:
+		c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case worker.ErrStopped:
+		c.Out.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		c.Out.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 func (s *Server) handleFeedErrors(c *router.Context) {
 	errors := s.db.GetFeedErrors()
 	c.JSON(http.StatusOK, errors)
 }
 
+// handleFeedErrorKinds returns the number of the failing feeds by the kind of the error
+// (dns, timeout, tls, conn_refused, too_many_redirects, redirect_loop or other).
+func (s *Server) handleFeedErrorKinds(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	c.JSON(http.StatusOK, s.db.CountFeedErrors())
+}
+
+// handleFeedErrorHistory returns the last errors of the feed, the latest first,
+// kept even after the feed recovers.
+func (s *Server) handleFeedErrorHistory(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.db.GetFeed(id) == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, s.db.ListFeedErrorHistory(id))
+}
+
+// handleFailedFeedsRefresh refreshes only the feeds with an error,
+// refusing to if another refresh is in progress.
+func (s *Server) handleFailedFeedsRefresh(c *router.Context) {
+	if c.Req.Method != "POST" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	switch err := s.worker.RefreshFailedFeeds(); err {
+	case nil:
+		c.Out.WriteHeader(http.StatusOK)
+	case worker.ErrBusy:
+		c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case worker.ErrStopped:
+		c.Out.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		c.Out.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleFeedPreview(c *router.Context) {
+	if c.Req.Method != "POST" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var form FeedPreviewForm
+	if err := json.NewDecoder(c.Req.Body).Decode(&form); err != nil {
+		log.Print(err)
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !htmlutil.IsAPossibleLink(form.Url) {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid feed url."})
+		return
+	}
+
+	header := make(http.Header)
+	if form.Username != "" || form.Password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(form.Username + ":" + form.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+	preview, err := worker.PreviewFeed(c.Req.Context(), form.Url, header)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
+
 type feedicon struct {
 	ctype string
 	bytes []byte
@@ -230,35 +442,33 @@ func (s *Server) handleFeedList(c *router.Context) {
 			return
 		}
 
-		result, err := worker.DiscoverFeed(form.Url)
+		var settings storage.FeedSettings
+		settingsForm := FeedSettingsForm{Username: form.Username, Password: form.Password, Headers: form.Headers, Proxy: form.Proxy}
+		if err := settingsForm.apply(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Req.Context(), s.DiscoverTimeout)
+		defer cancel()
+		feed, sources, err := s.worker.AddFeed(ctx, form.Url, form.FolderID, settings)
+		var parseErr *worker.FeedParseError
 		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			logger.With(logger.Fields{"url": form.Url, "timeout": s.DiscoverTimeout.String()}).Warn("timed out discovering feed")
+			c.JSON(http.StatusOK, map[string]string{"status": "notfound", "error": "Timed out looking for the feed at the given url."})
+		case errors.As(err, &parseErr):
+			logger.With(logger.Fields{"url": form.Url, "error": err}).Warn("failed to parse feed")
+			c.JSON(http.StatusOK, map[string]string{"status": "notfound", "error": err.Error(), "body": parseErr.Body})
 		case err != nil:
-			log.Printf("Faild to discover feed for %s: %s", form.Url, err)
+			logger.With(logger.Fields{"url": form.Url, "error": err}).Warn("failed to discover feed")
 			c.JSON(http.StatusOK, map[string]string{"status": "notfound"})
-		case len(result.Sources) > 0:
-			c.JSON(http.StatusOK, map[string]interface{}{"status": "multiple", "choice": result.Sources})
-		case result.Feed != nil:
-			feed := s.db.CreateFeed(
-				result.Feed.Title,
-				"",
-				result.Feed.SiteURL,
-				result.FeedLink,
-				form.FolderID,
-			)
-			items := worker.ConvertItems(result.Feed.Items, *feed)
-			if len(items) > 0 {
-				s.db.CreateItems(items)
-				s.db.SetFeedSize(feed.Id, len(items))
-				s.db.SyncSearch()
-			}
-			s.worker.FindFeedFavicon(*feed)
-
+		case len(sources) > 0:
+			c.JSON(http.StatusOK, map[string]interface{}{"status": "multiple", "choice": sources})
+		default:
 			c.JSON(http.StatusOK, map[string]interface{}{
 				"status": "success",
 				"feed":   feed,
 			})
-		default:
-			c.JSON(http.StatusOK, map[string]string{"status": "notfound"})
 		}
 	}
 }
@@ -299,6 +509,11 @@ func (s *Server) handleFeed(c *router.Context) {
 				s.db.UpdateFeedLink(id, link.(string))
 			}
 		}
+		if gone, ok := body["gone"]; ok {
+			if reflect.TypeOf(gone).Kind() == reflect.Bool {
+				s.db.SetFeedGone(id, gone.(bool))
+			}
+		}
 		c.Out.WriteHeader(http.StatusOK)
 	} else if c.Req.Method == "DELETE" {
 		s.db.DeleteFeed(id)
@@ -328,9 +543,18 @@ func (s *Server) handleItem(c *router.Context) {
 			}
 		}
 
-		item.Content = sanitizer.Sanitize(item.Link, item.Content)
+		item.Content = s.proxyContent(s.offlineContent(item.Id, s.sanitize(item.Link, item.Content, itemIDPrefix(item.Id))))
+		if item.ImageURL != nil && s.imageProxyEnabled() {
+			imageURL := s.proxyURL(*item.ImageURL)
+			item.ImageURL = &imageURL
+		}
 
-		c.JSON(http.StatusOK, item)
+		// tells the reader view to load the math renderer
+		hasMath := sanitizer.HasMath(item.Content) || s.db.GetFeedSettings(item.FeedId).Math
+		c.JSON(http.StatusOK, struct {
+			*storage.Item
+			Math bool `json:"math"`
+		}{item, hasMath})
 	} else if c.Req.Method == "PUT" {
 		var body ItemUpdateForm
 		if err := json.NewDecoder(c.Req.Body).Decode(&body); err != nil {
@@ -340,6 +564,11 @@ func (s *Server) handleItem(c *router.Context) {
 		}
 		if body.Status != nil {
 			s.db.UpdateItemStatus(id, *body.Status)
+			s.resetSearchCounts()
+			if *body.Status == storage.STARRED {
+				s.saveStarredItem(id)
+				s.worker.CacheItemImages(id)
+			}
 		}
 		c.Out.WriteHeader(http.StatusOK)
 	} else {
@@ -347,6 +576,99 @@ func (s *Server) handleItem(c *router.Context) {
 	}
 }
 
+// handleItemDuplicates lists the other items in the item's duplicate group,
+// DELETE takes the item out of the group for good.
+func (s *Server) handleItemDuplicates(c *router.Context) {
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.db.GetItem(id) == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if c.Req.Method == "GET" {
+		c.JSON(http.StatusOK, s.db.ListDuplicates(id))
+	} else if c.Req.Method == "DELETE" {
+		if !s.db.UngroupItem(id) {
+			c.Out.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c.Out.WriteHeader(http.StatusNoContent)
+	} else {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleItemFullContent(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if content := s.db.GetItemFullContent(id); content != "" {
+		c.JSON(http.StatusOK, map[string]string{"content": s.proxyContent(s.offlineContent(id, content))})
+		return
+	}
+
+	item := s.db.GetItem(id)
+	if item == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	link := item.Link
+	if !htmlutil.IsAPossibleLink(link) {
+		if feed := s.db.GetFeed(item.FeedId); feed != nil {
+			link = htmlutil.AbsoluteUrl(link, feed.Link)
+		}
+	}
+	if !htmlutil.IsAPossibleLink(link) {
+		c.JSON(http.StatusUnprocessableEntity, map[string]string{
+			"error":   "no_link",
+			"message": "The article has no link to fetch.",
+		})
+		return
+	}
+	link = silo.RedirectURL(link)
+
+	var content string
+	if iframe := silo.VideoIFrame(link); iframe != "" {
+		content = iframe
+	} else {
+		ctx, meter := worker.WithTransferMeter(worker.WithFeedConnection(c.Req.Context(), s.db.GetFeedSettings(item.FeedId)))
+		body, err := worker.GetBodyWithContext(ctx, link)
+		s.db.AddFeedTransfer(item.FeedId, meter.Bytes(), time.Now())
+		if err != nil {
+			logger.With(logger.Fields{"item_id": id, "url": link, "error": err}).Warn("failed to fetch full content")
+			c.JSON(http.StatusBadGateway, map[string]string{
+				"error":   "fetch_failed",
+				"message": "Failed to fetch the article: " + err.Error(),
+			})
+			return
+		}
+		content, err = readability.ExtractContent(strings.NewReader(body))
+		if err != nil || htmlutil.ExtractText(content) == "" {
+			c.JSON(http.StatusUnprocessableEntity, map[string]string{
+				"error":   "extraction_failed",
+				"message": "Couldn't find the article content on the page.",
+			})
+			return
+		}
+	}
+
+	content = s.sanitize(link, content, itemIDPrefix(id))
+	s.db.UpdateItemFullContent(id, content)
+	if item.Status == storage.STARRED {
+		s.worker.CacheItemImages(id)
+	}
+	c.JSON(http.StatusOK, map[string]string{"content": s.proxyContent(content)})
+}
+
 func (s *Server) handleItemList(c *router.Context) {
 	if c.Req.Method == "GET" {
 		perPage := 20
@@ -368,8 +690,24 @@ func (s *Server) handleItemList(c *router.Context) {
 		}
 		if search := query.Get("search"); len(search) != 0 {
 			filter.Search = &search
+			// the first page only, not every scroll
+			if filter.After == nil {
+				s.db.AddSearchHistory(strings.TrimSpace(search))
+			}
+		}
+		if searchID, err := c.QueryInt64("saved_search"); err == nil {
+			saved := s.db.GetSavedSearch(searchID)
+			if saved == nil {
+				c.JSON(http.StatusBadRequest, map[string]string{"error": "Saved search not found."})
+				return
+			}
+			filter.Search = &saved.Query
 		}
 		newestFirst := query.Get("oldest_first") != "true"
+		filter.CollapseDuplicates = s.db.GetSettingsValueBool("group_similar_titles")
+		if collapse := query.Get("collapse_duplicates"); collapse != "" {
+			filter.CollapseDuplicates = collapse == "true"
+		}
 
 		items := s.db.ListItems(filter, perPage+1, newestFirst, false)
 		hasMore := false
@@ -391,12 +729,78 @@ func (s *Server) handleItemList(c *router.Context) {
 			filter.FeedID = &feedID
 		}
 		s.db.MarkItemsRead(filter)
+		s.resetSearchCounts()
 		c.Out.WriteHeader(http.StatusOK)
 	} else {
 		c.Out.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+// handleItemRandom returns a random unread item in the folder or the feed (if given),
+// the older ones more likely with `weight=older`.
+func (s *Server) handleItemRandom(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	unread := storage.UNREAD
+	filter := storage.ItemFilter{Status: &unread}
+	if folderID, err := c.QueryInt64("folder_id"); err == nil {
+		filter.FolderID = &folderID
+	}
+	if feedID, err := c.QueryInt64("feed_id"); err == nil {
+		filter.FeedID = &feedID
+	}
+	olderFirst := false
+	switch c.Req.URL.Query().Get("weight") {
+	case "":
+	case "older":
+		olderFirst = true
+	default:
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "weight must be older or empty"})
+		return
+	}
+
+	item := s.db.RandomItem(filter, olderFirst)
+	if item == nil {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+func (s *Server) handleItemsMarkOlder(c *router.Context) {
+	if c.Req.Method != "POST" && c.Req.Method != "PUT" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var olderThan time.Time
+	if days, err := c.QueryInt64("days"); err == nil && days >= 0 {
+		olderThan = time.Now().Add(-time.Hour * 24 * time.Duration(days))
+	} else if itemID, err := c.QueryInt64("item_id"); err == nil {
+		item := s.db.GetItem(itemID)
+		if item == nil {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": "Item not found."})
+			return
+		}
+		olderThan = item.Date
+	} else {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "Either days or item_id is required."})
+		return
+	}
+
+	filter := storage.MarkFilter{}
+	if folderID, err := c.QueryInt64("folder_id"); err == nil {
+		filter.FolderID = &folderID
+	}
+	if feedID, err := c.QueryInt64("feed_id"); err == nil {
+		filter.FeedID = &feedID
+	}
+	affected := s.db.MarkItemsReadOlderThan(filter, olderThan)
+	s.resetSearchCounts()
+	c.JSON(http.StatusOK, map[string]int64{"affected": affected})
+}
+
 func (s *Server) handleSettings(c *router.Context) {
 	if c.Req.Method == "GET" {
 		c.JSON(http.StatusOK, s.db.GetSettings())
@@ -410,6 +814,9 @@ func (s *Server) handleSettings(c *router.Context) {
 			if _, ok := settings["refresh_rate"]; ok {
 				s.worker.SetRefreshRate(s.db.GetSettingsValueInt64("refresh_rate"))
 			}
+			if _, ok := settings["workers"]; ok {
+				s.worker.SetWorkers(s.db.GetSettingsValueInt64("workers"))
+			}
 			c.Out.WriteHeader(http.StatusOK)
 		} else {
 			c.Out.WriteHeader(http.StatusBadRequest)
@@ -454,39 +861,7 @@ func (s *Server) handleOPMLExport(c *router.Context) {
 		c.Out.Header().Set("Content-Type", "application/xml; charset=utf-8")
 		c.Out.Header().Set("Content-Disposition", `attachment; filename="subscriptions.opml"`)
 
-		doc := opml.Folder{}
-
-		feedsByFolderID := make(map[int64][]*storage.Feed)
-		for _, feed := range s.db.ListFeeds() {
-			feed := feed
-			if feed.FolderId == nil {
-				doc.Feeds = append(doc.Feeds, opml.Feed{
-					Title:   feed.Title,
-					FeedUrl: feed.FeedLink,
-					SiteUrl: feed.Link,
-				})
-			} else {
-				id := *feed.FolderId
-				feedsByFolderID[id] = append(feedsByFolderID[id], &feed)
-			}
-		}
-
-		for _, folder := range s.db.ListFolders() {
-			folderFeeds := feedsByFolderID[folder.Id]
-			if len(folderFeeds) == 0 {
-				continue
-			}
-			opmlfolder := opml.Folder{Title: folder.Title}
-			for _, feed := range folderFeeds {
-				opmlfolder.Feeds = append(opmlfolder.Feeds, opml.Feed{
-					Title:   feed.Title,
-					FeedUrl: feed.FeedLink,
-					SiteUrl: feed.Link,
-				})
-			}
-			doc.Folders = append(doc.Folders, opmlfolder)
-		}
-
+		doc := opml.Export(s.db.ListFeeds(), s.db.ListFolders())
 		c.Out.Write([]byte(doc.OPML()))
 	}
 }
@@ -499,7 +874,7 @@ func (s *Server) handlePageCrawl(c *router.Context) {
 	}
 	if content := silo.VideoIFrame(url); content != "" {
 		c.JSON(http.StatusOK, map[string]string{
-			"content": sanitizer.Sanitize(url, content),
+			"content": s.sanitize(url, content, ""),
 		})
 		return
 	}
@@ -517,7 +892,7 @@ func (s *Server) handlePageCrawl(c *router.Context) {
 		})
 		return
 	}
-	content = sanitizer.Sanitize(url, content)
+	content = s.proxyContent(s.sanitize(url, content, "page-"))
 	c.JSON(http.StatusOK, map[string]string{
 		"content": content,
 	})
@@ -527,3 +902,22 @@ func (s *Server) handleLogout(c *router.Context) {
 	auth.Logout(c.Out, s.BasePath)
 	c.Out.WriteHeader(http.StatusNoContent)
 }
+
+// sanitize cleans up the content using the sanitizer settings.
+// The iframe_hosts setting (a list of hosts separated by spaces or commas)
+// replaces the default iframe hosts, if not empty.
+// The ids of the footnotes are prefixed with idPrefix.
+func (s *Server) sanitize(link, content, idPrefix string) string {
+	opts := sanitizer.Options{IDPrefix: idPrefix}
+	if hosts, _ := s.db.GetSettingsValue("iframe_hosts").(string); strings.TrimSpace(hosts) != "" {
+		opts.IframeHosts = strings.FieldsFunc(hosts, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+	}
+	return sanitizer.SanitizeWithOptions(link, content, opts)
+}
+
+// itemIDPrefix keeps the ids of the items shown on the same page apart.
+func itemIDPrefix(id int64) string {
+	return fmt.Sprintf("item-%d-", id)
+}
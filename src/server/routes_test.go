@@ -1,6 +1,9 @@
 package server
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -8,9 +11,12 @@ import (
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nkanaev/yarr/src/storage"
+	"github.com/nkanaev/yarr/src/worker"
 )
 
 func TestStatic(t *testing.T) {
@@ -112,3 +118,1100 @@ func TestFeedIcons(t *testing.T) {
 		t.Fatal("got", response2.StatusCode)
 	}
 }
+
+func TestItemFullContentCached(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+	db.CreateItems([]storage.Item{{GUID: "item", FeedId: feed.Id, Link: "http://example.com/item"}})
+	item := db.ListItems(storage.ItemFilter{}, 1, true, false)[0]
+	db.UpdateItemFullContent(item.Id, "<p>full</p>")
+	log.SetOutput(os.Stderr)
+
+	recorder := httptest.NewRecorder()
+	url := fmt.Sprintf("/api/items/%d/full", item.Id)
+	request := httptest.NewRequest("GET", url, nil)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	handler.ServeHTTP(recorder, request)
+	response := recorder.Result()
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatal("got", response.StatusCode)
+	}
+	var body map[string]string
+	json.NewDecoder(response.Body).Decode(&body)
+	if body["content"] != "<p>full</p>" {
+		t.Fatalf("unexpected body: %#v", body)
+	}
+}
+
+func TestItemMathFlag(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+	items := db.CreateItems([]storage.Item{
+		{GUID: "1", FeedId: feed.Id, Link: "http://example.com/1", Content: `<p>\( e^{i\pi} = -1 \)</p>`},
+		{GUID: "2", FeedId: feed.Id, Link: "http://example.com/2", Content: `<p>$x$ costs $5</p>`},
+	})
+	log.SetOutput(os.Stderr)
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+
+	hasMath := func(id int64) bool {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", fmt.Sprintf("/api/items/%d", id), nil))
+		var body struct {
+			Id   int64 `json:"id"`
+			Math bool  `json:"math"`
+		}
+		if err := json.NewDecoder(recorder.Result().Body).Decode(&body); err != nil || body.Id != id {
+			t.Fatal("unexpected response", err, body.Id)
+		}
+		return body.Math
+	}
+	if !hasMath(items[0].Id) || hasMath(items[1].Id) {
+		t.Fatal("unexpected math flags")
+	}
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{Math: true})
+	if !hasMath(items[1].Id) {
+		t.Fatal("expected the feed setting to enable math")
+	}
+}
+
+func TestImageProxySignature(t *testing.T) {
+	server := NewServer(nil, "127.0.0.1:8000")
+	handler := server.handler()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/proxy?url=http%3A%2F%2Fexample.com%2Fa.png&sig=invalid", nil)
+	handler.ServeHTTP(recorder, request)
+	if recorder.Result().StatusCode != http.StatusForbidden {
+		t.Fatal("got", recorder.Result().StatusCode)
+	}
+
+	if server.proxyURL("http://example.com/a.png") != "/proxy?url=http%3A%2F%2Fexample.com%2Fa.png&sig="+server.proxySignature("http://example.com/a.png") {
+		t.Fatal("invalid proxy url")
+	}
+}
+
+func TestAudioProxyRange(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "audio/mpeg")
+		http.ServeContent(rw, req, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer origin.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+	audioURL := origin.URL + "/episode.mp3"
+	db.CreateItems([]storage.Item{{GUID: "item", FeedId: feed.Id, AudioURL: &audioURL}})
+	item := db.ListItems(storage.ItemFilter{}, 1, true, false)[0]
+	log.SetOutput(os.Stderr)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", fmt.Sprintf("/api/items/%d/audio", item.Id), nil)
+	request.Header.Set("Range", "bytes=2-5")
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	handler.ServeHTTP(recorder, request)
+	response := recorder.Result()
+
+	if response.StatusCode != http.StatusPartialContent {
+		t.Fatal("got", response.StatusCode)
+	}
+	if response.Header.Get("Content-Range") != "bytes 2-5/10" || response.Header.Get("Content-Length") != "4" {
+		t.Fatalf("invalid headers: %#v", response.Header)
+	}
+	body, _ := io.ReadAll(response.Body)
+	if string(body) != "2345" {
+		t.Fatalf("invalid body: %q", body)
+	}
+}
+
+func TestAudioProxyFeedAuth(t *testing.T) {
+	var cdnAuth []string
+	cdn := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		cdnAuth = append(cdnAuth, req.Header.Get("Authorization")+req.Header.Get("X-Api-Key"))
+		rw.Header().Set("Content-Type", "audio/mpeg")
+		rw.Write([]byte("public"))
+	}))
+	defer cdn.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if user, pass, _ := req.BasicAuth(); user != "user" || pass != "pass" || req.Header.Get("X-Api-Key") != "key" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Header().Set("Content-Type", "audio/mpeg")
+		rw.Write([]byte("private"))
+	}))
+	defer origin.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	feed := db.CreateFeed("", "", "", origin.URL+"/feed.xml", nil)
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{
+		Username: "user",
+		Password: "pass",
+		Headers:  map[string]string{"X-Api-Key": "key"},
+	})
+	privateURL := origin.URL + "/private.mp3"
+	publicURL := cdn.URL + "/public.mp3"
+	db.CreateItems([]storage.Item{
+		{GUID: "private", FeedId: feed.Id, AudioURL: &privateURL},
+		{GUID: "public", FeedId: feed.Id, AudioURL: &publicURL},
+	})
+	items := db.ListItems(storage.ItemFilter{}, 2, true, false)
+	log.SetOutput(os.Stderr)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	for _, item := range items {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", fmt.Sprintf("/api/items/%d/audio", item.Id), nil)
+		handler.ServeHTTP(recorder, request)
+		response := recorder.Result()
+		body, _ := io.ReadAll(response.Body)
+		if response.StatusCode != http.StatusOK || string(body) != item.GUID {
+			t.Fatalf("%s: got %d %q", item.GUID, response.StatusCode, body)
+		}
+	}
+	if len(cdnAuth) != 1 || cdnAuth[0] != "" {
+		t.Fatalf("expected no credentials sent to the other host, got %q", cdnAuth)
+	}
+}
+
+func TestFeedPreview(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if user, pass, _ := req.BasicAuth(); user != "user" || pass != "pass" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write([]byte(`<?xml version="1.0"?>
+			<rss version="2.0"><channel>
+				<title>Preview</title>
+				<link>http://example.com</link>
+				<item><title>Item 1</title><link>http://example.com/1</link><description>&lt;p&gt;hello&lt;/p&gt;</description></item>
+			</channel></rss>`))
+	}))
+	defer origin.Close()
+
+	handler := NewServer(nil, "127.0.0.1:8000").handler()
+
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader(`{"url": "` + origin.URL + `", "username": "user", "password": "pass"}`)
+	request := httptest.NewRequest("POST", "/api/feeds/preview", body)
+	handler.ServeHTTP(recorder, request)
+	response := recorder.Result()
+	if response.StatusCode != http.StatusOK {
+		t.Fatal("got", response.StatusCode)
+	}
+
+	var preview map[string]interface{}
+	json.NewDecoder(response.Body).Decode(&preview)
+	if preview["title"] != "Preview" || preview["format"] != "rss" {
+		t.Fatalf("invalid preview: %#v", preview)
+	}
+	items := preview["items"].([]interface{})
+	if len(items) != 1 || items[0].(map[string]interface{})["summary"] != "hello" {
+		t.Fatalf("invalid preview items: %#v", items)
+	}
+
+	recorder = httptest.NewRecorder()
+	body = strings.NewReader(`{"url": "` + origin.URL + `"}`)
+	request = httptest.NewRequest("POST", "/api/feeds/preview", body)
+	handler.ServeHTTP(recorder, request)
+	if recorder.Result().StatusCode != http.StatusUnprocessableEntity {
+		t.Fatal("expected error without credentials, got", recorder.Result().StatusCode)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	server := NewServer(nil, "127.0.0.1:8000")
+	server.CORSOrigins = []string{"https://app.example.com", "https://*.example.org"}
+	handler := server.handler()
+
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":  true,
+		"https://sub.example.org":  true,
+		"https://evilexample.org":  false,
+		"https://evil.example.com": false,
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("OPTIONS", "/api/feeds", nil)
+		request.Header.Set("Origin", origin)
+		request.Header.Set("Access-Control-Request-Method", "PUT")
+		handler.ServeHTTP(recorder, request)
+		response := recorder.Result()
+
+		have := response.Header.Get("Access-Control-Allow-Origin")
+		if allowed && (have != origin || response.StatusCode != http.StatusNoContent) {
+			t.Errorf("expected %s to be allowed", origin)
+		}
+		if !allowed && have != "" {
+			t.Errorf("expected %s to be denied", origin)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/static/javascripts/app.js", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	handler.ServeHTTP(recorder, request)
+	if recorder.Result().Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Error("cors headers outside of the api")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	server := NewServer(nil, "127.0.0.1:8000")
+	ts := httptest.NewServer(server.handler())
+	defer ts.Close()
+
+	request, _ := http.NewRequest("GET", ts.URL+"/api/events", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if ctype := response.Header.Get("Content-Type"); ctype != "text/event-stream" {
+		t.Fatalf("unexpected content type: %s", ctype)
+	}
+	if response.Header.Get("Content-Encoding") != "" {
+		t.Fatal("event stream must not be compressed")
+	}
+
+	reader := bufio.NewReader(response.Body)
+	if line, _ := reader.ReadString('\n'); line != "retry: 5000\n" {
+		t.Fatalf("unexpected line: %q", line)
+	}
+	reader.ReadString('\n')
+
+	server.worker.Events().Publish(worker.EventFeedDone, worker.FeedDoneEvent{FeedID: 1, NewItems: 2})
+	want := []string{
+		"event: feed-done\n",
+		`data: {"feed_id":1,"new_items":2}` + "\n",
+	}
+	for _, w := range want {
+		if line, _ := reader.ReadString('\n'); line != w {
+			t.Fatalf("want %q, have %q", w, line)
+		}
+	}
+}
+
+func TestSettingsExportImport(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	db.CreateIntegration("linkding", "bookmarks", "https://links.example.com", map[string]string{"token": "secret"}, false)
+	log.SetOutput(os.Stderr)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/settings/export", nil))
+	body, _ := io.ReadAll(recorder.Result().Body)
+	if strings.Contains(string(body), "secret") {
+		t.Fatal("secrets must not be exported by default")
+	}
+
+	for _, doc := range []string{
+		`{"version": 1, "settings": {"unknown": 1}}`,
+		`{"version": 1, "settings": {"refresh_rate": "60"}}`,
+		`{"version": 2, "settings": {}}`,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/settings/import", strings.NewReader(doc)))
+		if recorder.Result().StatusCode != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected", doc)
+		}
+	}
+
+	doc := `{
+		"version": 1,
+		"settings": {"theme_name": "night", "feed_list_width": 300},
+		"integrations": [{"kind": "linkding", "title": "links", "endpoint": "https://links.example.com"}]
+	}`
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/settings/import", strings.NewReader(doc)))
+	var report SettingsImportReport
+	json.NewDecoder(recorder.Result().Body).Decode(&report)
+
+	if len(report.Settings) != 1 || report.Settings["theme_name"].New != "night" {
+		t.Fatalf("unexpected settings report: %#v", report.Settings)
+	}
+	if !reflect.DeepEqual(report.Integrations.Updated, []string{"links"}) {
+		t.Fatalf("unexpected integrations report: %#v", report.Integrations)
+	}
+	if db.GetSettingsValue("theme_name") != "night" {
+		t.Fatal("settings not imported")
+	}
+	integrations := db.ListIntegrations()
+	if len(integrations) != 1 || integrations[0].Title != "links" || integrations[0].Credentials["token"] != "secret" {
+		t.Fatalf("unexpected integrations: %#v", integrations)
+	}
+}
+
+func TestAdminJobs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	feed := db.CreateFeed("", "", "", "", nil)
+	db.CreateItems([]storage.Item{{GUID: "item", FeedId: feed.Id, Title: "hello world"}})
+	log.SetOutput(os.Stderr)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/admin/unknown", nil))
+	if recorder.Result().StatusCode != http.StatusNotFound {
+		t.Fatal("expected unknown job to be rejected")
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/admin/reindex", nil))
+	if recorder.Result().StatusCode != http.StatusAccepted {
+		t.Fatal("got", recorder.Result().StatusCode)
+	}
+	var job worker.Job
+	json.NewDecoder(recorder.Result().Body).Decode(&job)
+
+	for i := 0; i < 100 && job.Status != worker.JobDone; i++ {
+		time.Sleep(time.Millisecond * 10)
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", fmt.Sprintf("/api/admin/jobs/%d", job.Id), nil))
+		json.NewDecoder(recorder.Result().Body).Decode(&job)
+	}
+	if job.Status != worker.JobDone || job.Result["indexed"] != 1 {
+		t.Fatalf("unexpected job: %#v", job)
+	}
+
+	search := "hello"
+	if len(db.ListItems(storage.ItemFilter{Search: &search}, 10, false, false)) != 1 {
+		t.Fatal("expected the item to be found after reindexing")
+	}
+}
+
+func TestAdminImport(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v1/feeds":
+			rw.Write([]byte(`[{"title": "Blog", "feed_url": "https://blog.example/feed", "category": {"title": "News"}}]`))
+		case "/v1/entries":
+			rw.Write([]byte(`{"entries": []}`))
+		}
+	}))
+	defer remote.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+
+	for _, body := range []string{
+		`{"kind": "unknown", "endpoint": "` + remote.URL + `", "credentials": {"token": "secret"}}`,
+		`{"kind": "miniflux", "endpoint": "not a url", "credentials": {"token": "secret"}}`,
+		`{"kind": "freshrss", "endpoint": "` + remote.URL + `", "credentials": {"username": "user"}}`,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/admin/import", strings.NewReader(body)))
+		if recorder.Result().StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected, got %d", body, recorder.Result().StatusCode)
+		}
+	}
+
+	body := `{"kind": "miniflux", "endpoint": "` + remote.URL + `", "credentials": {"token": "secret"}}`
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/admin/import", strings.NewReader(body)))
+	if recorder.Result().StatusCode != http.StatusAccepted {
+		t.Fatal("got", recorder.Result().StatusCode)
+	}
+	var job worker.Job
+	json.NewDecoder(recorder.Result().Body).Decode(&job)
+
+	for i := 0; i < 100 && job.Status == worker.JobRunning; i++ {
+		time.Sleep(time.Millisecond * 10)
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", fmt.Sprintf("/api/admin/jobs/%d", job.Id), nil))
+		json.NewDecoder(recorder.Result().Body).Decode(&job)
+	}
+	if job.Kind != "import" || job.Status != worker.JobDone || job.Result["feeds_created"] != 1 {
+		t.Fatalf("unexpected job: %#v", job)
+	}
+	if feeds := db.ListFeeds(); len(feeds) != 1 || feeds[0].FeedLink != "https://blog.example/feed" {
+		t.Fatalf("unexpected feeds: %#v", feeds)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	restored, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", "https://example.com/feed.xml", nil)
+	db.CreateItems([]storage.Item{{GUID: "1", FeedId: feed.Id}})
+
+	recorder := httptest.NewRecorder()
+	NewServer(db, "127.0.0.1:8000").handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/archive", nil))
+	if recorder.Result().StatusCode != http.StatusOK {
+		t.Fatal("got", recorder.Result().StatusCode)
+	}
+	archive := recorder.Body.String()
+
+	handler := NewServer(restored, "127.0.0.1:8000").handler()
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/archive", strings.NewReader(archive)))
+	if recorder.Result().StatusCode != http.StatusOK {
+		t.Fatal("got", recorder.Result().StatusCode, recorder.Body.String())
+	}
+	if len(restored.ListFeeds()) != 1 || restored.CountItems(storage.ItemFilter{}) != 1 {
+		t.Fatal("expected the feed and the item restored")
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/archive", strings.NewReader(archive)))
+	if recorder.Result().StatusCode != http.StatusConflict {
+		t.Fatal("expected the non-empty instance to be refused, got", recorder.Result().StatusCode)
+	}
+}
+
+func TestFeedRefreshStop(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+
+	recorder := httptest.NewRecorder()
+	NewServer(db, "127.0.0.1:8000").handler().ServeHTTP(recorder, httptest.NewRequest("DELETE", "/api/feeds/refresh", nil))
+	if recorder.Result().StatusCode != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != `{"stopped":false}` {
+		t.Fatal("unexpected response:", recorder.Result().StatusCode, recorder.Body.String())
+	}
+}
+
+func TestFeedRefreshOne(t *testing.T) {
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<rss><channel><item><guid>1</guid></item></channel></rss>`))
+	}))
+	defer feedServer.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", feedServer.URL, nil)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", fmt.Sprintf("/api/feeds/%d/refresh", feed.Id), nil))
+	var done worker.FeedDoneEvent
+	json.NewDecoder(recorder.Body).Decode(&done)
+	if recorder.Code != http.StatusOK || done.FeedID != feed.Id || done.NewItems != 1 {
+		t.Fatalf("unexpected response: %d %#v", recorder.Code, done)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/feeds/100/refresh", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatal("got", recorder.Code)
+	}
+}
+
+func TestFolderRefresh(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	folder := db.CreateFolder("empty")
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	for url, code := range map[string]int{
+		fmt.Sprintf("/api/folders/%d/refresh", folder.Id): http.StatusOK,
+		"/api/folders/100/refresh":                        http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", url, nil))
+		if recorder.Code != code {
+			t.Fatalf("%s: expected %d, got %d", url, code, recorder.Code)
+		}
+	}
+}
+
+func TestRefreshStatus(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+
+	recorder := httptest.NewRecorder()
+	NewServer(db, "127.0.0.1:8000").handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/status/refresh", nil))
+	if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != `{"started":null,"finished":null,"feeds":[]}` {
+		t.Fatal("unexpected response:", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestFeedSettingsPartialUpdate(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	feed := db.CreateFeed("", "", "", "", nil)
+	log.SetOutput(os.Stderr)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	url := fmt.Sprintf("/api/feeds/%d/settings", feed.Id)
+	patch := func(body string) *http.Response {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("PATCH", url, strings.NewReader(body)))
+		return recorder.Result()
+	}
+
+	if res := patch(`{"user_agent": "custom", "username": "user", "password": "secret"}`); res.StatusCode != http.StatusOK {
+		t.Fatal("got", res.StatusCode)
+	}
+	if res := patch(`{"refresh_interval": 60}`); res.StatusCode != http.StatusOK {
+		t.Fatal("got", res.StatusCode)
+	}
+	if res := patch(`{"timezone": "Europe/Berlin"}`); res.StatusCode != http.StatusOK {
+		t.Fatal("got", res.StatusCode)
+	}
+	if res := patch(`{"headers": {"x-api-key": "key"}}`); res.StatusCode != http.StatusOK {
+		t.Fatal("got", res.StatusCode)
+	}
+	for _, body := range []string{
+		`{"refresh_interval": 1}`,
+		`{"user_agent": "a\nb"}`,
+		`{"unknown": true}`,
+		`{"hook_command": "notify-send new"}`,
+		`{"hook_dir": "relative/dir"}`,
+		`{"hook_timeout": 3600}`,
+		`{"timeout": 3600}`,
+		`{"proxy": "ftp://127.0.0.1"}`,
+		`{"client_cert": "/etc/hostname"}`,
+		`{"network": "tcp4"}`,
+		`{"client_cert": "cert.pem", "client_key": "key.pem"}`,
+		`{"client_cert": "/nonexistent/cert.pem", "client_key": "/nonexistent/key.pem"}`,
+		`{"timezone": "Mars/Olympus_Mons"}`,
+		`{"timezone": "Local"}`,
+		`{"headers": {"Connection": "close"}}`,
+		`{"headers": {"X Api Key": "key"}}`,
+		`{"headers": {"X-Api-Key": "a\nb"}}`,
+	} {
+		if res := patch(body); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected", body)
+		}
+	}
+
+	want := storage.FeedSettings{
+		UserAgent:       "custom",
+		Username:        "user",
+		Password:        "secret",
+		Timezone:        "Europe/Berlin",
+		RefreshInterval: 60,
+		Headers:         map[string]string{"X-Api-Key": "key"},
+	}
+	if have := db.GetFeedSettings(feed.Id); !reflect.DeepEqual(have, want) {
+		t.Fatalf("\nwant: %#v\nhave: %#v", want, have)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+	body, _ := io.ReadAll(recorder.Result().Body)
+	if strings.Contains(string(body), "secret") || !strings.Contains(string(body), `"has_password":true`) {
+		t.Fatalf("password must be redacted: %s", body)
+	}
+}
+
+func TestNotification(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	news := db.CreateFeed("News", "", "", "http://example.com/news.xml", nil)
+	blog := db.CreateFeed("Blog", "", "", "http://example.com/blog.xml", nil)
+	spam := db.CreateFeed("Spam", "", "", "http://example.com/spam.xml", nil)
+	db.UpdateFeedSettings(news.Id, storage.FeedSettings{Notify: true})
+	db.UpdateFeedSettings(blog.Id, storage.FeedSettings{Notify: true})
+	db.CreateItems([]storage.Item{
+		{GUID: "1", FeedId: news.Id},
+		{GUID: "2", FeedId: news.Id},
+		{GUID: "3", FeedId: blog.Id},
+		{GUID: "4", FeedId: spam.Id},
+	})
+
+	server := NewServer(db, "127.0.0.1:8000")
+	summary := worker.RefreshFinishedEvent{Updated: []worker.NewItemsEvent{
+		{FeedID: news.Id, Count: 2},
+		{FeedID: spam.Id, Count: 1},
+		{FeedID: blog.Id, Count: 1},
+	}}
+	noon := time.Date(2021, 1, 1, 12, 0, 0, 0, time.Local)
+
+	have := server.notification(summary, noon)
+	want := Notification{Title: "yarr", Message: "3 new items in News and Blog", Unread: 4}
+	if have != want {
+		t.Fatalf("want: %#v\nhave: %#v", want, have)
+	}
+
+	summary.Updated = summary.Updated[1:2]
+	if have := server.notification(summary, noon); have.Message != "" {
+		t.Fatalf("feeds without the notify flag must not notify: %#v", have)
+	}
+
+	summary.Updated = []worker.NewItemsEvent{{FeedID: news.Id, Count: 1}}
+	db.UpdateSettings(map[string]interface{}{"quiet_hours_start": "11:00", "quiet_hours_end": "13:00"})
+	if have := server.notification(summary, noon); have.Message != "" || have.Unread != 4 {
+		t.Fatalf("expected quiet hours: %#v", have)
+	}
+	db.UpdateSettings(map[string]interface{}{"quiet_hours_end": "12:00"})
+	if have := server.notification(summary, noon); have.Message != "1 new item in News" {
+		t.Fatalf("unexpected message: %#v", have)
+	}
+	db.UpdateSettings(map[string]interface{}{"notifications": false})
+	if have := server.notification(summary, noon); have.Message != "" {
+		t.Fatalf("expected notifications to be disabled: %#v", have)
+	}
+}
+
+func TestDeadFeeds(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	parked := db.CreateFeed("Parked", "", "", "http://parked.example.com/feed.xml", nil)
+	kept := db.CreateFeed("Kept", "", "", "http://kept.example.com/feed.xml", nil)
+	gone := db.CreateFeed("Gone", "", "", "http://gone.example.com/feed.xml", nil)
+	for _, feed := range []*storage.Feed{parked, kept, gone} {
+		db.SetFeedHealth(feed.Id, 200, "http://parking.example.net/", true, true)
+	}
+	db.ReportDeadFeeds(time.Now())
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return recorder
+	}
+	list := func() []DeadFeedResponse {
+		var result struct {
+			Feeds []DeadFeedResponse `json:"feeds"`
+		}
+		json.NewDecoder(request("GET", "/api/feeds/dead", "").Body).Decode(&result)
+		return result.Feeds
+	}
+
+	feeds := list()
+	if len(feeds) != 3 || feeds[0].Title != "Parked" || feeds[0].FinalURL != "http://parking.example.net/" {
+		t.Fatalf("unexpected report: %#v", feeds)
+	}
+
+	if res := request("POST", fmt.Sprintf("/api/feeds/dead/%d", parked.Id), `{"action": "pause"}`); res.Code != http.StatusOK {
+		t.Fatal("got", res.Code)
+	}
+	if !db.GetFeedSettings(parked.Id).Paused {
+		t.Fatal("expected the feed to be paused")
+	}
+	if res := request("POST", fmt.Sprintf("/api/feeds/dead/%d", kept.Id), `{"action": "keep"}`); res.Code != http.StatusOK {
+		t.Fatal("got", res.Code)
+	}
+	if res := request("POST", fmt.Sprintf("/api/feeds/dead/%d", gone.Id), `{"action": "delete"}`); res.Code != http.StatusNoContent {
+		t.Fatal("got", res.Code)
+	}
+	if db.GetFeed(gone.Id) != nil {
+		t.Fatal("expected the feed to be deleted")
+	}
+	if res := request("POST", fmt.Sprintf("/api/feeds/dead/%d", parked.Id), `{"action": "archive"}`); res.Code != http.StatusBadRequest {
+		t.Fatal("got", res.Code)
+	}
+
+	// paused feeds stay in the report until unsubscribed or kept
+	if feeds := list(); len(feeds) != 1 || feeds[0].FeedID != parked.Id {
+		t.Fatalf("unexpected report: %#v", feeds)
+	}
+}
+
+func TestSuspendedFeeds(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	db.UpdateSettings(map[string]interface{}{"suspend_after_failures": 1})
+	feed := db.CreateFeed("Gone", "", "", "http://gone.example.com/feed.xml", nil)
+	db.SetFeedHealth(feed.Id, 404, feed.FeedLink, false, true)
+	db.SetFeedError(feed.Id, fmt.Errorf("feed not found"))
+	db.SuspendFailingFeed(feed.Id)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	request := func(method, url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, url, nil))
+		return recorder
+	}
+	var result struct {
+		Feeds []SuspendedFeedResponse `json:"feeds"`
+	}
+	json.NewDecoder(request("GET", "/api/feeds/suspended").Body).Decode(&result)
+	if len(result.Feeds) != 1 || result.Feeds[0].Title != "Gone" || result.Feeds[0].LastError != "feed not found" {
+		t.Fatalf("unexpected suspended feeds: %#v", result.Feeds)
+	}
+
+	url := fmt.Sprintf("/api/feeds/suspended/%d", feed.Id)
+	if res := request("DELETE", url); res.Code != http.StatusNoContent {
+		t.Fatal("got", res.Code)
+	}
+	if db.GetFeed(feed.Id).Suspended {
+		t.Fatal("expected the feed reactivated")
+	}
+	if res := request("DELETE", url); res.Code != http.StatusNotFound {
+		t.Fatal("got", res.Code)
+	}
+}
+
+func TestItemDuplicates(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed1 := db.CreateFeed("", "", "", "http://one.example.com/feed.xml", nil)
+	feed2 := db.CreateFeed("", "", "", "http://two.example.com/feed.xml", nil)
+	db.UpdateSettings(map[string]interface{}{"group_similar_titles": true})
+	first := db.CreateItems([]storage.Item{{GUID: "1", FeedId: feed1.Id, Title: "Court rules against the airline merger"}})[0]
+	second := db.CreateItems([]storage.Item{{GUID: "2", FeedId: feed2.Id, Title: "Court rules against the airline merger"}})[0]
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	request := func(method, url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, url, nil))
+		return recorder
+	}
+	listItems := func(query string) []storage.Item {
+		var result struct {
+			List []storage.Item `json:"list"`
+		}
+		json.NewDecoder(request("GET", "/api/items"+query).Body).Decode(&result)
+		return result.List
+	}
+
+	var dups []storage.Item
+	json.NewDecoder(request("GET", fmt.Sprintf("/api/items/%d/duplicates", first.Id)).Body).Decode(&dups)
+	if len(dups) != 1 || dups[0].Id != second.Id {
+		t.Fatalf("unexpected duplicates: %#v", dups)
+	}
+	if items := listItems(""); len(items) != 1 || items[0].Id != first.Id {
+		t.Fatalf("expected the duplicate to be collapsed, got %#v", items)
+	}
+	if items := listItems("?collapse_duplicates=false"); len(items) != 2 {
+		t.Fatalf("expected both items, got %#v", items)
+	}
+
+	if res := request("DELETE", fmt.Sprintf("/api/items/%d/duplicates", second.Id)); res.Code != http.StatusNoContent {
+		t.Fatal("got", res.Code)
+	}
+	if items := listItems(""); len(items) != 2 {
+		t.Fatalf("expected the ungrouped item to be listed, got %#v", items)
+	}
+	if res := request("GET", "/api/items/999/duplicates"); res.Code != http.StatusNotFound {
+		t.Fatal("got", res.Code)
+	}
+}
+
+func TestSavedSearches(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("LWN.net", "", "", "http://lwn.net/feed.xml", nil)
+	items := db.CreateItems([]storage.Item{
+		{GUID: "1", FeedId: feed.Id, Title: "kernel release"},
+		{GUID: "2", FeedId: feed.Id, Title: "sqlite release"},
+	})
+	db.SyncSearch()
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return recorder
+	}
+	status := func() []SavedSearchResponse {
+		var result struct {
+			Searches []SavedSearchResponse `json:"searches"`
+		}
+		json.NewDecoder(request("GET", "/api/status", "").Body).Decode(&result)
+		return result.Searches
+	}
+
+	res := request("POST", "/api/searches", `{"name": "Kernel", "query": "feed:LWN kernel", "show_count": true}`)
+	if res.Code != http.StatusCreated {
+		t.Fatal("got", res.Code)
+	}
+	var search storage.SavedSearch
+	json.NewDecoder(res.Body).Decode(&search)
+	if res := request("POST", "/api/searches", `{"name": " ", "query": "x"}`); res.Code != http.StatusBadRequest {
+		t.Fatal("expected the empty name to be rejected, got", res.Code)
+	}
+
+	searches := status()
+	if len(searches) != 1 || searches[0].Unread == nil || *searches[0].Unread != 1 {
+		t.Fatalf("unexpected searches: %#v", searches)
+	}
+	// the count is cached until the items change
+	db.UpdateItemStatus(items[0].Id, storage.READ)
+	if searches := status(); *searches[0].Unread != 1 {
+		t.Fatal("expected the cached count")
+	}
+	request("PUT", fmt.Sprintf("/api/items/%d", items[0].Id), `{"status": "read"}`)
+	if searches := status(); *searches[0].Unread != 0 {
+		t.Fatal("expected the count to be updated")
+	}
+
+	var list struct {
+		List []storage.Item `json:"list"`
+	}
+	json.NewDecoder(request("GET", fmt.Sprintf("/api/items?saved_search=%d", search.Id), "").Body).Decode(&list)
+	if len(list.List) != 1 || list.List[0].Id != items[0].Id {
+		t.Fatalf("unexpected items: %#v", list.List)
+	}
+	if res := request("GET", "/api/items?saved_search=999", ""); res.Code != http.StatusBadRequest {
+		t.Fatal("got", res.Code)
+	}
+
+	if res := request("PUT", fmt.Sprintf("/api/searches/%d", search.Id), `{"show_count": false}`); res.Code != http.StatusOK {
+		t.Fatal("got", res.Code)
+	}
+	if searches := status(); searches[0].Unread != nil || searches[0].Query != "feed:LWN kernel" {
+		t.Fatalf("unexpected searches: %#v", searches)
+	}
+	if res := request("DELETE", fmt.Sprintf("/api/searches/%d", search.Id), ""); res.Code != http.StatusNoContent {
+		t.Fatal("got", res.Code)
+	}
+	if searches := status(); len(searches) != 0 {
+		t.Fatalf("expected no searches, got %#v", searches)
+	}
+
+	request("GET", "/api/items?search=sqlite", "")
+	request("GET", fmt.Sprintf("/api/items?search=kernel&after=%d", items[1].Id), "")
+	var history []string
+	json.NewDecoder(request("GET", "/api/searches/history", "").Body).Decode(&history)
+	if !reflect.DeepEqual(history, []string{"sqlite"}) {
+		t.Fatalf("unexpected history: %v", history)
+	}
+	request("DELETE", "/api/searches/history", "")
+	json.NewDecoder(request("GET", "/api/searches/history", "").Body).Decode(&history)
+	if len(history) != 0 {
+		t.Fatalf("expected the history to be cleared, got %v", history)
+	}
+}
+
+func TestFeedTransfers(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	small := db.CreateFeed("Small", "", "", "http://small.example.com/feed.xml", nil)
+	large := db.CreateFeed("Large", "", "", "http://large.example.com/feed.xml", nil)
+	db.AddFeedTransfer(small.Id, 100, time.Now())
+	db.AddFeedTransfer(large.Id, 5000, time.Now())
+	db.AddFeedTransfer(large.Id, 7000, time.Now().AddDate(0, 0, -10))
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	get := func(url string) (*httptest.ResponseRecorder, []FeedTransferResponse, int64) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+		var result struct {
+			Bytes int64                  `json:"bytes"`
+			Feeds []FeedTransferResponse `json:"feeds"`
+		}
+		json.NewDecoder(recorder.Body).Decode(&result)
+		return recorder, result.Feeds, result.Bytes
+	}
+
+	_, feeds, total := get("/api/feeds/transfers")
+	if total != 12100 || len(feeds) != 2 || feeds[0].Title != "Large" || feeds[0].Bytes != 12000 {
+		t.Fatalf("unexpected transfers: %d %#v", total, feeds)
+	}
+	_, feeds, total = get("/api/feeds/transfers?days=7&top=1")
+	if total != 5100 || len(feeds) != 1 || feeds[0].FeedID != large.Id {
+		t.Fatalf("unexpected transfers: %d %#v", total, feeds)
+	}
+	if res, _, _ := get("/api/feeds/transfers?days=365"); res.Code != http.StatusBadRequest {
+		t.Fatal("got", res.Code)
+	}
+}
+
+func TestFeedErrorHistory(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+	db.SetFeedHealth(feed.Id, 500, feed.FeedLink, false, true)
+	db.SetFeedError(feed.Id, errors.New("status code 500"))
+	db.ResetFeedErrors([]int64{feed.Id})
+	db.SetFeedHealth(feed.Id, 0, feed.FeedLink, false, true)
+	db.SetFeedError(feed.Id, errors.New("timed out"))
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", fmt.Sprintf("/api/feeds/%d/errors", feed.Id), nil))
+	var history []storage.FeedErrorEntry
+	json.NewDecoder(recorder.Body).Decode(&history)
+	if recorder.Code != http.StatusOK || len(history) != 2 {
+		t.Fatalf("unexpected history: %d %#v", recorder.Code, history)
+	}
+	if history[0].Error != "timed out" || history[0].Status != 0 || history[0].Failures != 2 ||
+		history[1].Error != "status code 500" || history[1].Status != 500 || history[1].Failures != 1 {
+		t.Fatalf("unexpected history: %#v", history)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/feeds/100/errors", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatal("got", recorder.Code)
+	}
+}
+
+func TestOfflineImages(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+	item := db.CreateItems([]storage.Item{{
+		GUID:    "1",
+		FeedId:  feed.Id,
+		Link:    "http://example.com/post",
+		Content: `<p><img src="http://example.com/a.png"><img src="http://example.com/b.png"></p>`,
+	}})[0]
+	db.UpdateItemStatus(item.Id, storage.STARRED)
+	key := db.CacheItemImage(item.Id, "http://example.com/a.png", "image/png", []byte("png"))
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return recorder
+	}
+	content := func() string {
+		var result storage.Item
+		json.NewDecoder(request("GET", fmt.Sprintf("/api/items/%d", item.Id), "").Body).Decode(&result)
+		return result.Content
+	}
+
+	have := content()
+	if !strings.Contains(have, `src="/api/images/`+key+`"`) || !strings.Contains(have, `src="http://example.com/b.png"`) {
+		t.Fatalf("expected the cached image to be served locally: %s", have)
+	}
+	res := request("GET", "/api/images/"+key, "")
+	if res.Code != http.StatusOK || res.Body.String() != "png" || res.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("unexpected response: %d %q", res.Code, res.Body.String())
+	}
+
+	request("PUT", fmt.Sprintf("/api/items/%d", item.Id), `{"status": "read"}`)
+	if have := content(); strings.Contains(have, "/api/images/") {
+		t.Fatalf("expected the images to be hot-linked after unstarring: %s", have)
+	}
+	if res := request("GET", "/api/images/"+key, ""); res.Code != http.StatusNotFound {
+		t.Fatal("got", res.Code)
+	}
+}
+
+func TestItemRandom(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed1 := db.CreateFeed("", "", "", "http://one.example.com/feed.xml", nil)
+	feed2 := db.CreateFeed("", "", "", "http://two.example.com/feed.xml", nil)
+	items := db.CreateItems([]storage.Item{
+		{GUID: "1", FeedId: feed1.Id, Title: "unread", Content: "content"},
+		{GUID: "2", FeedId: feed2.Id, Title: "read"},
+	})
+	db.UpdateItemStatus(items[1].Id, storage.READ)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	request := func(url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+		return recorder
+	}
+
+	for _, url := range []string{"/api/items/random", "/api/items/random?weight=older", fmt.Sprintf("/api/items/random?feed_id=%d", feed1.Id)} {
+		res := request(url)
+		var item storage.Item
+		json.NewDecoder(res.Body).Decode(&item)
+		if res.Code != http.StatusOK || item.Id != items[0].Id || item.Title != "unread" || item.Content != "" {
+			t.Fatalf("%s: unexpected item %d %#v", url, res.Code, item)
+		}
+	}
+	if res := request(fmt.Sprintf("/api/items/random?feed_id=%d", feed2.Id)); res.Code != http.StatusNotFound {
+		t.Fatal("got", res.Code)
+	}
+	if res := request("/api/items/random?weight=newer"); res.Code != http.StatusBadRequest {
+		t.Fatal("got", res.Code)
+	}
+}
+
+func TestFeedValidators(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+	db.SetHTTPState(feed.Id, "Mon, 02 Jan 2006 15:04:05 GMT", `"v1"`, "hash")
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	request := func(method, url string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, url, nil))
+		return recorder.Code
+	}
+	url := fmt.Sprintf("/api/feeds/%d/validators", feed.Id)
+	if code := request("GET", url); code != http.StatusMethodNotAllowed {
+		t.Fatal("got", code)
+	}
+	if code := request("DELETE", "/api/feeds/999/validators"); code != http.StatusNotFound {
+		t.Fatal("got", code)
+	}
+	if code := request("DELETE", url); code != http.StatusNoContent {
+		t.Fatal("got", code)
+	}
+	state := db.GetHTTPState(feed.Id)
+	if state.LastModified != "" || state.Etag != "" || state.BodyHash != "" || state.LastRefreshed.IsZero() {
+		t.Fatalf("expected the validators cleared only, got %#v", state)
+	}
+}
+
+func TestFeedDebug(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+	request := func(method, url string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, url, nil))
+		return recorder.Code
+	}
+	url := fmt.Sprintf("/api/feeds/%d/debug", feed.Id)
+	if code := request("POST", url); code != http.StatusMethodNotAllowed {
+		t.Fatal("got", code)
+	}
+	if code := request("GET", "/api/feeds/999/debug"); code != http.StatusNotFound {
+		t.Fatal("got", code)
+	}
+	// not fetched with the debug on yet
+	if code := request("GET", url); code != http.StatusNotFound {
+		t.Fatal("got", code)
+	}
+}
+
+func TestIntegrationCredentials(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	handler := NewServer(db, "127.0.0.1:8000").handler()
+
+	recorder := httptest.NewRecorder()
+	body := `{"kind": "linkding", "title": "links", "endpoint": "https://links.example.com"}`
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/integrations", strings.NewReader(body)))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected the integration created, got %d", recorder.Code)
+	}
+	var created storage.Integration
+	json.Unmarshal(recorder.Body.Bytes(), &created)
+
+	recorder = httptest.NewRecorder()
+	body = `{"credentials": {"token": "secret"}}`
+	handler.ServeHTTP(recorder, httptest.NewRequest("PUT", fmt.Sprintf("/api/integrations/%d", created.Id), strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected the credentials set, got %d", recorder.Code)
+	}
+	if i := db.GetIntegration(created.Id); i == nil || i.Credentials["token"] != "secret" {
+		t.Fatalf("unexpected integration: %#v", i)
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/server/router"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// searchCountsTTL is how long the unread counts of the saved searches are cached.
+const searchCountsTTL = time.Minute
+
+const searchCountsKey = "search_counts"
+
+type searchCounts struct {
+	counts    map[int64]int64
+	expiresAt time.Time
+}
+
+// SavedSearchResponse is the saved search along with the unread count,
+// if it's to be shown.
+type SavedSearchResponse struct {
+	storage.SavedSearch
+	Unread *int64 `json:"unread,omitempty"`
+}
+
+// savedSearches lists the saved searches for the sidebar. The unread counts
+// are cached, running the full-text search on every status poll is too costly.
+func (s *Server) savedSearches() []SavedSearchResponse {
+	searches := s.db.ListSavedSearches()
+
+	s.cache_mutex.Lock()
+	cached, ok := s.cache[searchCountsKey].(searchCounts)
+	s.cache_mutex.Unlock()
+	if !ok || time.Now().After(cached.expiresAt) {
+		cached = searchCounts{
+			counts:    make(map[int64]int64),
+			expiresAt: time.Now().Add(searchCountsTTL),
+		}
+		unread := storage.UNREAD
+		for _, search := range searches {
+			if search.ShowCount {
+				query := search.Query
+				count := s.db.CountItems(storage.ItemFilter{Search: &query, Status: &unread})
+				cached.counts[search.Id] = int64(count)
+			}
+		}
+		s.cache_mutex.Lock()
+		s.cache[searchCountsKey] = cached
+		s.cache_mutex.Unlock()
+	}
+
+	result := make([]SavedSearchResponse, len(searches))
+	for i, search := range searches {
+		result[i].SavedSearch = search
+		if count, ok := cached.counts[search.Id]; ok && search.ShowCount {
+			result[i].Unread = &count
+		}
+	}
+	return result
+}
+
+// resetSearchCounts drops the cached counts once the searches or the item statuses change.
+func (s *Server) resetSearchCounts() {
+	s.cache_mutex.Lock()
+	delete(s.cache, searchCountsKey)
+	s.cache_mutex.Unlock()
+}
+
+func (form SavedSearchForm) validate() string {
+	if form.Name != nil && strings.TrimSpace(*form.Name) == "" {
+		return "Search name missing."
+	}
+	if form.Query != nil && strings.TrimSpace(*form.Query) == "" {
+		return "Search query missing."
+	}
+	return ""
+}
+
+func (s *Server) handleSavedSearchList(c *router.Context) {
+	if c.Req.Method == "GET" {
+		c.JSON(http.StatusOK, s.savedSearches())
+	} else if c.Req.Method == "POST" {
+		var form SavedSearchForm
+		if err := json.NewDecoder(c.Req.Body).Decode(&form); err != nil {
+			log.Print(err)
+			c.Out.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if form.Name == nil || form.Query == nil {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": "Search name and query are required."})
+			return
+		}
+		if msg := form.validate(); msg != "" {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+			return
+		}
+		showCount := form.ShowCount != nil && *form.ShowCount
+		search := s.db.CreateSavedSearch(strings.TrimSpace(*form.Name), strings.TrimSpace(*form.Query), showCount)
+		if search == nil {
+			c.Out.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.resetSearchCounts()
+		c.JSON(http.StatusCreated, search)
+	} else {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSavedSearch(c *router.Context) {
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if c.Req.Method == "PUT" {
+		search := s.db.GetSavedSearch(id)
+		if search == nil {
+			c.Out.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var form SavedSearchForm
+		if err := json.NewDecoder(c.Req.Body).Decode(&form); err != nil {
+			log.Print(err)
+			c.Out.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if msg := form.validate(); msg != "" {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+			return
+		}
+		if form.Name != nil {
+			search.Name = strings.TrimSpace(*form.Name)
+		}
+		if form.Query != nil {
+			search.Query = strings.TrimSpace(*form.Query)
+		}
+		if form.ShowCount != nil {
+			search.ShowCount = *form.ShowCount
+		}
+		s.db.UpdateSavedSearch(*search)
+		s.resetSearchCounts()
+		c.JSON(http.StatusOK, search)
+	} else if c.Req.Method == "DELETE" {
+		s.db.DeleteSavedSearch(id)
+		s.resetSearchCounts()
+		c.Out.WriteHeader(http.StatusNoContent)
+	} else {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSearchHistory lists the recent queries for the suggestions,
+// DELETE clears them.
+func (s *Server) handleSearchHistory(c *router.Context) {
+	if c.Req.Method == "GET" {
+		c.JSON(http.StatusOK, s.db.ListSearchHistory())
+	} else if c.Req.Method == "DELETE" {
+		s.db.ClearSearchHistory()
+		c.Out.WriteHeader(http.StatusNoContent)
+	} else {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -1,10 +1,19 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/server/auth"
 	"github.com/nkanaev/yarr/src/storage"
 	"github.com/nkanaev/yarr/src/worker"
 )
@@ -15,28 +24,64 @@ type Server struct {
 	worker      *worker.Worker
 	cache       map[string]interface{}
 	cache_mutex *sync.Mutex
+	proxyKey    []byte
+
+	httpserver   *http.Server
+	httplock     sync.Mutex
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
 
 	BasePath string
 
 	// auth
-	Username string
-	Password string
+	Username       string
+	Password       string
+	LoginLimiter   *auth.Limiter
+	TrustedProxies []*net.IPNet
+	// auth via reverse proxy
+	AuthHeader string
+	LogoutURL  string
 	// https
 	CertFile string
 	KeyFile  string
+	// file mode of the unix socket, if listening on one
+	SocketMode os.FileMode
+	// origins allowed to access the api from the browser
+	CORSOrigins []string
+	// time limit for looking for the feed to subscribe to
+	DiscoverTimeout time.Duration
 }
 
 func NewServer(db *storage.Storage, addr string) *Server {
+	proxyKey := make([]byte, 32)
+	if _, err := rand.Read(proxyKey); err != nil {
+		log.Fatal(err)
+	}
 	return &Server{
 		db:          db,
 		Addr:        addr,
 		worker:      worker.NewWorker(db),
 		cache:       make(map[string]interface{}),
 		cache_mutex: &sync.Mutex{},
+		proxyKey:    proxyKey,
+		shutdown:    make(chan struct{}),
+
+		LoginLimiter:    auth.NewLimiter(5, time.Second*30, time.Hour),
+		DiscoverTimeout: time.Second * 20,
 	}
 }
 
+func (h *Server) socketPath() string {
+	if strings.HasPrefix(h.Addr, "unix:") {
+		return strings.TrimPrefix(h.Addr, "unix:")
+	}
+	return ""
+}
+
 func (h *Server) GetAddr() string {
+	if h.socketPath() != "" {
+		return h.Addr + h.BasePath
+	}
 	proto := "http"
 	if h.CertFile != "" && h.KeyFile != "" {
 		proto = "https"
@@ -48,20 +93,113 @@ func (s *Server) Start() {
 	refreshRate := s.db.GetSettingsValueInt64("refresh_rate")
 	s.worker.FindFavicons()
 	s.worker.StartFeedCleaner()
+	s.worker.SetWorkers(s.db.GetSettingsValueInt64("workers"))
 	s.worker.SetRefreshRate(refreshRate)
-	if refreshRate > 0 {
+	if refreshRate > 0 && !s.worker.RefreshQuiet(time.Now()) {
 		s.worker.RefreshFeeds()
 	}
 
 	httpserver := &http.Server{Addr: s.Addr, Handler: s.handler()}
+	s.httplock.Lock()
+	s.httpserver = httpserver
+	s.httplock.Unlock()
+
+	ln, err := s.listen()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ln.Close()
 
-	var err error
 	if s.CertFile != "" && s.KeyFile != "" {
-		err = httpserver.ListenAndServeTLS(s.CertFile, s.KeyFile)
+		err = httpserver.ServeTLS(ln, s.CertFile, s.KeyFile)
 	} else {
-		err = httpserver.ListenAndServe()
+		err = httpserver.Serve(ln)
 	}
 	if err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
 }
+
+// How long to wait for the worker once the active requests used up
+// the deadline of the shutdown.
+var workerShutdownTimeout = time.Second * 5
+
+// ShutdownError tells which of the shutdown phases didn't finish in time.
+type ShutdownError struct {
+	// the requests still active
+	HTTP error
+	// the refresh and the jobs still running, using the storage
+	Worker error
+}
+
+func (e *ShutdownError) Error() string {
+	var errs []string
+	if e.HTTP != nil {
+		errs = append(errs, "http server: "+e.HTTP.Error())
+	}
+	if e.Worker != nil {
+		errs = append(errs, "worker: "+e.Worker.Error())
+	}
+	return strings.Join(errs, ", ")
+}
+
+// Shutdown stops accepting new connections, waits for the active requests
+// to finish and then stops the worker, whether the requests finished or not.
+// Gives up once the ctx is done, the worker being given workerShutdownTimeout
+// of its own if the requests used up the ctx.
+func (s *Server) Shutdown(ctx context.Context) error {
+	// event streams never finish on their own
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+
+	s.httplock.Lock()
+	httpserver := s.httpserver
+	s.httplock.Unlock()
+
+	var shutdownErr ShutdownError
+	if httpserver != nil {
+		logger.Infof("shutdown: stopping http server")
+		shutdownErr.HTTP = httpserver.Shutdown(ctx)
+	}
+
+	logger.Infof("shutdown: stopping worker")
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), workerShutdownTimeout)
+		defer cancel()
+	}
+	shutdownErr.Worker = s.worker.Shutdown(ctx)
+
+	if shutdownErr.HTTP != nil || shutdownErr.Worker != nil {
+		return &shutdownErr
+	}
+	return nil
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	path := s.socketPath()
+	if path == "" {
+		return net.Listen("tcp", s.Addr)
+	}
+
+	// remove the socket left after unclean shutdown
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	// the socket file is removed once the listener is closed
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if s.SocketMode != 0 {
+		if err := os.Chmod(path, s.SocketMode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/integration"
+	"github.com/nkanaev/yarr/src/server/router"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+const settingsExportVersion = 1
+
+type SettingsExport struct {
+	Version      int                         `json:"version"`
+	Settings     map[string]interface{}      `json:"settings"`
+	Integrations []SettingsExportIntegration `json:"integrations"`
+}
+
+type SettingsExportIntegration struct {
+	Kind        string            `json:"kind"`
+	Title       string            `json:"title"`
+	Endpoint    string            `json:"endpoint"`
+	SaveStarred bool              `json:"save_starred"`
+	Credentials map[string]string `json:"credentials,omitempty"`
+}
+
+type SettingsChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type SettingsImportReport struct {
+	Settings     map[string]SettingsChange `json:"settings"`
+	Integrations struct {
+		Created []string `json:"created"`
+		Updated []string `json:"updated"`
+	} `json:"integrations"`
+}
+
+func (s *Server) handleSettingsExport(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	secrets := c.Req.URL.Query().Get("secrets") == "true"
+
+	doc := SettingsExport{
+		Version:      settingsExportVersion,
+		Settings:     s.db.GetSettings(),
+		Integrations: make([]SettingsExportIntegration, 0),
+	}
+	for _, i := range s.db.ListIntegrations() {
+		entry := SettingsExportIntegration{
+			Kind:        i.Kind,
+			Title:       i.Title,
+			Endpoint:    i.Endpoint,
+			SaveStarred: i.SaveStarred,
+		}
+		if secrets {
+			entry.Credentials = i.Credentials
+		}
+		doc.Integrations = append(doc.Integrations, entry)
+	}
+	c.Out.Header().Set("Content-Disposition", `attachment; filename="settings.json"`)
+	c.JSON(http.StatusOK, doc)
+}
+
+func (s *Server) handleSettingsImport(c *router.Context) {
+	if c.Req.Method != "POST" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var doc SettingsExport
+	decoder := json.NewDecoder(c.Req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if doc.Version != settingsExportVersion {
+		c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("unsupported version %d", doc.Version),
+		})
+		return
+	}
+	if err := storage.ValidateSettings(doc.Settings); err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	report := SettingsImportReport{Settings: make(map[string]SettingsChange)}
+	report.Integrations.Created = make([]string, 0)
+	report.Integrations.Updated = make([]string, 0)
+
+	current := s.db.GetSettings()
+	changed := make(map[string]interface{})
+	for key, val := range doc.Settings {
+		if !jsonEqual(current[key], val) {
+			changed[key] = val
+			report.Settings[key] = SettingsChange{Old: current[key], New: val}
+		}
+	}
+
+	existing := make(map[string]storage.Integration)
+	for _, i := range s.db.ListIntegrations() {
+		existing[i.Kind+" "+i.Endpoint] = i
+	}
+	integrations := make([]storage.Integration, 0)
+	for _, entry := range doc.Integrations {
+		if _, ok := integration.Kinds[entry.Kind]; !ok {
+			c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("unknown integration %#v", entry.Kind),
+			})
+			return
+		}
+		if !htmlutil.IsAPossibleLink(entry.Endpoint) {
+			c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("invalid endpoint %#v", entry.Endpoint),
+			})
+			return
+		}
+		i := storage.Integration{
+			Kind:        entry.Kind,
+			Title:       entry.Title,
+			Endpoint:    entry.Endpoint,
+			Credentials: entry.Credentials,
+			SaveStarred: entry.SaveStarred,
+		}
+		if i.Title == "" {
+			i.Title = i.Kind
+		}
+		old, ok := existing[i.Kind+" "+i.Endpoint]
+		if !ok {
+			if len(i.Credentials) == 0 {
+				c.JSON(http.StatusBadRequest, map[string]string{
+					"error": fmt.Sprintf("missing credentials for integration %#v", i.Title),
+				})
+				return
+			}
+			integrations = append(integrations, i)
+			report.Integrations.Created = append(report.Integrations.Created, i.Title)
+			continue
+		}
+		i.Id = old.Id
+		if old.Title != i.Title || old.SaveStarred != i.SaveStarred ||
+			(len(i.Credentials) > 0 && !jsonEqual(old.Credentials, i.Credentials)) {
+			integrations = append(integrations, i)
+			report.Integrations.Updated = append(report.Integrations.Updated, i.Title)
+		}
+	}
+	sort.Strings(report.Integrations.Created)
+	sort.Strings(report.Integrations.Updated)
+
+	if !s.db.ImportSettings(changed, integrations) {
+		c.Out.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if _, ok := changed["refresh_rate"]; ok {
+		s.worker.SetRefreshRate(s.db.GetSettingsValueInt64("refresh_rate"))
+	}
+	if _, ok := changed["workers"]; ok {
+		s.worker.SetWorkers(s.db.GetSettingsValueInt64("workers"))
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// jsonEqual compares the values the way they're stored,
+// so that int and float64 of the same value are equal.
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
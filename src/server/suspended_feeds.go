@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/nkanaev/yarr/src/server/router"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// SuspendedFeedResponse is the suspended feed along with its title and links.
+type SuspendedFeedResponse struct {
+	storage.SuspendedFeed
+	Title    string `json:"title"`
+	Link     string `json:"link"`
+	FeedLink string `json:"feed_link"`
+}
+
+// handleSuspendedFeedList lists the feeds suspended after failing
+// `suspend_after_failures` times in a row, with the last error.
+func (s *Server) handleSuspendedFeedList(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	feeds := make(map[int64]storage.Feed)
+	for _, feed := range s.db.ListFeeds() {
+		feeds[feed.Id] = feed
+	}
+	list := make([]SuspendedFeedResponse, 0)
+	for _, suspended := range s.db.ListSuspendedFeeds() {
+		feed := feeds[suspended.FeedID]
+		list = append(list, SuspendedFeedResponse{
+			SuspendedFeed: suspended,
+			Title:         feed.Title,
+			Link:          feed.Link,
+			FeedLink:      feed.FeedLink,
+		})
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"after_failures": s.db.GetSettingsValueInt64("suspend_after_failures"),
+		"feeds":          list,
+	})
+}
+
+// handleSuspendedFeed reactivates the feed on DELETE,
+// it's refreshed again starting with the next refresh.
+func (s *Server) handleSuspendedFeed(c *router.Context) {
+	if c.Req.Method != "DELETE" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := c.VarInt64("id")
+	if err != nil {
+		c.Out.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.db.ReactivateFeed(id) {
+		c.Out.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.Out.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nkanaev/yarr/src/server/router"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+const (
+	transferDaysDefault = 30
+	transferDaysMax     = 90 // as long as the daily totals are kept
+	transferTopDefault  = 10
+)
+
+// FeedTransferResponse is the traffic of the feed along with its title.
+type FeedTransferResponse struct {
+	storage.FeedTransfer
+	Title string `json:"title"`
+}
+
+// handleFeedTransfers returns the bytes downloaded over the last `days`
+// (30 by default): the total and the `top` feeds costing the most (10 by default, 0 for all).
+func (s *Server) handleFeedTransfers(c *router.Context) {
+	if c.Req.Method != "GET" {
+		c.Out.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	days := int64(transferDaysDefault)
+	if c.Req.URL.Query().Has("days") {
+		val, err := c.QueryInt64("days")
+		if err != nil || val < 1 || val > transferDaysMax {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": "days must be between 1 and 90"})
+			return
+		}
+		days = val
+	}
+	top := int64(transferTopDefault)
+	if c.Req.URL.Query().Has("top") {
+		val, err := c.QueryInt64("top")
+		if err != nil || val < 0 {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid top"})
+			return
+		}
+		top = val
+	}
+
+	titles := make(map[int64]string)
+	for _, feed := range s.db.ListFeeds() {
+		titles[feed.Id] = feed.Title
+	}
+	// today is included
+	since := time.Now().UTC().AddDate(0, 0, -int(days-1))
+	total := int64(0)
+	feeds := make([]FeedTransferResponse, 0)
+	for _, transfer := range s.db.ListFeedTransfers(since) {
+		total += transfer.Bytes
+		if top == 0 || int64(len(feeds)) < top {
+			feeds = append(feeds, FeedTransferResponse{FeedTransfer: transfer, Title: titles[transfer.FeedID]})
+		}
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"days":  days,
+		"bytes": total,
+		"feeds": feeds,
+	})
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The new items are compared with the ones arrived within the window.
+const similarTitleWindow = 48 * time.Hour
+
+// The shorter titles ("Weekly links") are too generic to compare.
+const similarTitleMinWords = 4
+
+type titleCandidate struct {
+	id      int64
+	feedID  int64
+	groupID int64
+	words   map[string]bool
+}
+
+// titleWords returns the set of the lowercased title words, punctuation stripped.
+func titleWords(title string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[word] = true
+	}
+	return words
+}
+
+// titleSimilarity returns the share of the words the titles have in common
+// (the Dice coefficient of the word sets) in percents.
+func titleSimilarity(a, b map[string]bool) int {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for word := range a {
+		if b[word] {
+			common++
+		}
+	}
+	return 200 * common / (len(a) + len(b))
+}
+
+// groupSimilarItems links each of the new items to the item of another feed
+// arrived within the window with the most similar title, if the similarity
+// is at least `similar_title_threshold`. The item joins the other one's
+// duplicate group, unless there's an item of the same feed in it already.
+// The items ungrouped by the user are left out.
+func (s *Storage) groupSimilarItems(items []Item, now time.Time) {
+	threshold := int(s.GetSettingsValueInt64("similar_title_threshold"))
+	if threshold <= 0 || threshold > 100 {
+		threshold = settingsDefaults()["similar_title_threshold"].(int)
+	}
+
+	rows, err := s.db.Query(`
+		select i.id, i.feed_id, i.title, coalesce(d.group_id, i.id)
+		from items i
+		left join item_duplicates d on d.item_id = i.id
+		where i.date_arrived >= ? and (d.item_id is null or d.group_id is not null)`,
+		now.Add(-similarTitleWindow),
+	)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	candidates := make([]titleCandidate, 0)
+	for rows.Next() {
+		var c titleCandidate
+		var title string
+		if err = rows.Scan(&c.id, &c.feedID, &title, &c.groupID); err != nil {
+			log.Print(err)
+			rows.Close()
+			return
+		}
+		if c.words = titleWords(title); len(c.words) >= similarTitleMinWords {
+			candidates = append(candidates, c)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Print(err)
+		return
+	}
+	groupFeeds := make(map[int64]map[int64]bool)
+	for _, c := range candidates {
+		if groupFeeds[c.groupID] == nil {
+			groupFeeds[c.groupID] = make(map[int64]bool)
+		}
+		groupFeeds[c.groupID][c.feedID] = true
+	}
+
+	for _, item := range items {
+		words := titleWords(item.Title)
+		if len(words) < similarTitleMinWords {
+			continue
+		}
+		var match *titleCandidate
+		best := threshold - 1
+		for i, c := range candidates {
+			if c.id == item.Id || groupFeeds[c.groupID][item.FeedId] {
+				continue
+			}
+			if score := titleSimilarity(words, c.words); score > best {
+				match, best = &candidates[i], score
+			}
+		}
+		if match == nil {
+			continue
+		}
+		_, err = s.db.Exec(`
+			insert into item_duplicates (item_id, group_id) values (?, ?), (?, ?)
+			on conflict (item_id) do nothing`,
+			match.id, match.groupID, item.Id, match.groupID,
+		)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		groupFeeds[match.groupID][item.FeedId] = true
+		for i := range candidates {
+			if candidates[i].id == item.Id {
+				candidates[i].groupID = match.groupID
+			}
+		}
+	}
+}
+
+// ListDuplicates returns the other items in the item's duplicate group.
+func (s *Storage) ListDuplicates(id int64) []Item {
+	rows, err := s.db.Query(`
+		select item_id from item_duplicates
+		where group_id = (select group_id from item_duplicates where item_id = ?) and item_id != ?`,
+		id, id,
+	)
+	if err != nil {
+		log.Print(err)
+		return make([]Item, 0)
+	}
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var itemID int64
+		if err = rows.Scan(&itemID); err != nil {
+			log.Print(err)
+			rows.Close()
+			return make([]Item, 0)
+		}
+		ids = append(ids, itemID)
+	}
+	if len(ids) == 0 {
+		return make([]Item, 0)
+	}
+	return s.ListItems(ItemFilter{IDs: &ids}, len(ids), false, false)
+}
+
+// UngroupItem takes the item out of its duplicate group for good,
+// it's never grouped again. The group left with a single item is dropped.
+func (s *Storage) UngroupItem(id int64) bool {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	defer tx.Rollback()
+
+	var group sql.NullInt64
+	err = tx.QueryRow(`select group_id from item_duplicates where item_id = ?`, id).Scan(&group)
+	if err != nil && err != sql.ErrNoRows {
+		log.Print(err)
+		return false
+	}
+	_, err = tx.Exec(`
+		insert into item_duplicates (item_id, group_id) values (?, null)
+		on conflict (item_id) do update set group_id = null`,
+		id,
+	)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	if group.Valid {
+		_, err = tx.Exec(`
+			delete from item_duplicates
+			where group_id = ? and (select count(*) from item_duplicates where group_id = ?) < 2`,
+			group.Int64, group.Int64,
+		)
+		if err != nil {
+			log.Print(err)
+			return false
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Print(err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestTitleSimilarity(t *testing.T) {
+	testcases := []struct {
+		a, b string
+		want int
+	}{
+		{"Court rules against airline merger", "COURT RULES AGAINST AIRLINE MERGER!", 100},
+		{"Court rules against airline merger", "Court rules against the airline merger", 90},
+		{"Court rules against airline merger", "Airline merger approved by court", 60},
+		{"", "Court rules", 0},
+	}
+	for _, tc := range testcases {
+		if have := titleSimilarity(titleWords(tc.a), titleWords(tc.b)); have != tc.want {
+			t.Errorf("%q vs %q\nwant: %d\nhave: %d", tc.a, tc.b, tc.want, have)
+		}
+	}
+}
+
+func TestGroupSimilarItems(t *testing.T) {
+	db := testDB()
+	feed1 := db.CreateFeed("feed1", "", "", "http://test.com/feed1.xml", nil)
+	feed2 := db.CreateFeed("feed2", "", "", "http://test.com/feed2.xml", nil)
+	feed3 := db.CreateFeed("feed3", "", "", "http://test.com/feed3.xml", nil)
+
+	storm := "Storm closes schools across the region"
+	db.CreateItems([]Item{{GUID: "off1", FeedId: feed1.Id, Title: storm}})
+	off := db.CreateItems([]Item{{GUID: "off2", FeedId: feed2.Id, Title: storm}})[0]
+	if dups := db.ListDuplicates(off.Id); len(dups) != 0 {
+		t.Fatalf("expected no grouping unless enabled, got %#v", dups)
+	}
+	db.UpdateSettings(map[string]interface{}{"group_similar_titles": true})
+
+	title := "Court rules against the airline merger"
+
+	first := db.CreateItems([]Item{{GUID: "1", FeedId: feed1.Id, Title: title}})[0]
+	created := db.CreateItems([]Item{
+		{GUID: "2", FeedId: feed2.Id, Title: "Court Rules Against the Airline Merger - Wire"},
+		{GUID: "3", FeedId: feed2.Id, Title: "Weather: rain all week"},
+		{GUID: "4", FeedId: feed2.Id, Title: "Court rules"},
+	})
+	same := db.CreateItems([]Item{{GUID: "5", FeedId: feed1.Id, Title: title + "!"}})[0]
+
+	dups := db.ListDuplicates(first.Id)
+	if len(dups) != 1 || dups[0].Id != created[0].Id {
+		t.Fatalf("unexpected duplicates: %#v", dups)
+	}
+	// the group has an item of the feed already
+	if dup := db.GetItem(same.Id).DuplicateGroup; dup != nil {
+		t.Error("expected the item of the same feed not to be grouped")
+	}
+	for _, item := range created[1:] {
+		if dup := db.GetItem(item.Id).DuplicateGroup; dup != nil {
+			t.Errorf("expected %q not to be grouped", item.Title)
+		}
+	}
+
+	collapsed := db.ListItems(ItemFilter{CollapseDuplicates: true}, 10, false, false)
+	for _, item := range collapsed {
+		if item.Id == created[0].Id {
+			t.Fatal("expected the duplicate to be collapsed")
+		}
+	}
+	if len(collapsed) != 6 {
+		t.Fatalf("expected 6 items, got %d", len(collapsed))
+	}
+
+	if !db.UngroupItem(created[0].Id) {
+		t.Fatal("failed to ungroup")
+	}
+	if dups := db.ListDuplicates(created[0].Id); len(dups) != 0 {
+		t.Fatalf("expected no duplicates after ungrouping, got %#v", dups)
+	}
+	if dup := db.GetItem(first.Id).DuplicateGroup; dup != nil {
+		t.Fatal("expected the group of one to be dropped")
+	}
+	// the item isn't pulled back by the new duplicates
+	last := db.CreateItems([]Item{{GUID: "6", FeedId: feed3.Id, Title: title}})[0]
+	if dup := db.GetItem(created[0].Id).DuplicateGroup; dup != nil {
+		t.Fatal("expected the ungrouped item to stay apart")
+	}
+	if dups := db.ListDuplicates(last.Id); len(dups) != 1 || dups[0].FeedId != feed1.Id {
+		t.Fatalf("expected the new item to be grouped with the first feed's one, got %#v", dups)
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"log"
+	"time"
+)
+
+// The errors kept per feed and for how long.
+var (
+	feedErrorHistorySize = 20
+	feedErrorHistoryDays = 30
+)
+
+// FeedErrorEntry is one of the failed fetches of the feed.
+type FeedErrorEntry struct {
+	Time time.Time `json:"time"`
+	// http status of the response, 0 if there was none
+	Status int    `json:"status"`
+	Kind   string `json:"kind"`
+	Error  string `json:"error"`
+	// the failures in a row so far, this one included
+	Failures int64 `json:"failures"`
+}
+
+// addFeedErrorHistory appends the error to the feed's history, along with
+// the status and the failure count recorded by the fetch, and drops the
+// entries beyond `feedErrorHistorySize`.
+func (s *Storage) addFeedErrorHistory(feedID int64, message, kind string, now time.Time) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		insert into feed_error_history (feed_id, time, status, kind, error, failures)
+		select ?, ?, coalesce(h.last_status, 0), ?, ?, coalesce(h.failures, 0)
+		from (select 1) left join feed_health h on h.feed_id = ?`,
+		feedID, now.UTC(), kind, message, feedID,
+	)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	_, err = tx.Exec(`
+		delete from feed_error_history
+		where feed_id = ? and id not in (
+			select id from feed_error_history
+			where feed_id = ?
+			order by id desc
+			limit ?
+		)`,
+		feedID, feedID, feedErrorHistorySize,
+	)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Print(err)
+	}
+}
+
+// ListFeedErrorHistory returns the last errors of the feed, the latest first.
+func (s *Storage) ListFeedErrorHistory(feedID int64) []FeedErrorEntry {
+	result := make([]FeedErrorEntry, 0)
+	rows, err := s.db.Query(`
+		select time, status, kind, error, failures
+		from feed_error_history
+		where feed_id = ?
+		order by id desc`,
+		feedID,
+	)
+	if err != nil {
+		log.Print(err)
+		return result
+	}
+	for rows.Next() {
+		var entry FeedErrorEntry
+		if err = rows.Scan(&entry.Time, &entry.Status, &entry.Kind, &entry.Error, &entry.Failures); err != nil {
+			log.Print(err)
+			return result
+		}
+		result = append(result, entry)
+	}
+	if err = rows.Err(); err != nil {
+		log.Print(err)
+	}
+	return result
+}
+
+// DeleteOldFeedErrors drops the errors older than `feedErrorHistoryDays`
+// and returns the number of rows deleted.
+func (s *Storage) DeleteOldFeedErrors(now time.Time) int64 {
+	before := now.UTC().AddDate(0, 0, -feedErrorHistoryDays)
+	res, err := s.db.Exec(`delete from feed_error_history where time < ?`, before)
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
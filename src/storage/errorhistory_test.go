@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFeedErrorHistory(t *testing.T) {
+	db := testDB()
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+	other := db.CreateFeed("", "", "", "http://example.com/other.xml", nil)
+
+	now := time.Now()
+	for i := feedErrorHistorySize + 4; i >= 0; i-- {
+		db.addFeedErrorHistory(feed.Id, "error", "", now.AddDate(0, 0, -i))
+	}
+	db.addFeedErrorHistory(other.Id, "error", "", now)
+	history := db.ListFeedErrorHistory(feed.Id)
+	if len(history) != feedErrorHistorySize || !history[0].Time.After(history[1].Time) {
+		t.Fatalf("expected the last %d errors, latest first, got %#v", feedErrorHistorySize, history)
+	}
+	if len(db.ListFeedErrorHistory(other.Id)) != 1 {
+		t.Fatal("expected the other feed's error kept")
+	}
+
+	// the ones from 10 days ago and older
+	if deleted := db.DeleteOldFeedErrors(now.Add(12*time.Hour).AddDate(0, 0, feedErrorHistoryDays-10)); deleted != 10 {
+		t.Fatalf("expected 10 old errors deleted, got %d", deleted)
+	}
+	if len(db.ListFeedErrorHistory(feed.Id)) != 10 || len(db.ListFeedErrorHistory(other.Id)) != 1 {
+		t.Fatal("expected the recent errors kept")
+	}
+}
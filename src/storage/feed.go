@@ -2,7 +2,10 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"log"
+	"strings"
+	"time"
 )
 
 type Feed struct {
@@ -14,9 +17,20 @@ type Feed struct {
 	FeedLink    string  `json:"feed_link"`
 	Icon        *[]byte `json:"icon,omitempty"`
 	HasIcon     bool    `json:"has_icon"`
+	// not refreshed after failing too many times in a row
+	Suspended bool `json:"suspended"`
+	// removed by the publisher (410 Gone), not refreshed until cleared
+	Gone bool `json:"gone"`
+	// the last successful refresh, not-modified included
+	LastRefreshed         *time.Time `json:"last_refreshed"`
+	LastRefreshDurationMs int64      `json:"last_refresh_duration_ms"`
+	LastNewItems          int        `json:"last_new_items"`
 }
 
+// CreateFeed subscribes to the feed (or moves it to the folder if subscribed already).
+// The credentials embedded in the link are moved to the feed settings.
 func (s *Storage) CreateFeed(title, description, link, feedLink string, folderId *int64) *Feed {
+	feedLink, credentials := StripCredentials(feedLink, FeedSettings{})
 	if title == "" {
 		title = feedLink
 	}
@@ -35,6 +49,7 @@ func (s *Storage) CreateFeed(title, description, link, feedLink string, folderId
 		log.Print(err)
 		return nil
 	}
+	s.moveFeedCredentials(id, credentials)
 	return &Feed{
 		Id:          id,
 		Title:       title,
@@ -71,13 +86,64 @@ func (s *Storage) UpdateFeedFolder(feedId int64, newFolderId *int64) bool {
 	return err == nil
 }
 
+// UpdateFeedLink changes the link of the feed, moving the credentials
+// embedded in it to the feed settings.
 func (s *Storage) UpdateFeedLink(feedId int64, newLink string) bool {
+	newLink, credentials := StripCredentials(newLink, FeedSettings{})
 	_, err := s.db.Exec(`update feeds set feed_link = ? where id = ?`, newLink, feedId)
-	return err == nil
+	if err != nil {
+		return false
+	}
+	s.moveFeedCredentials(feedId, credentials)
+	return true
 }
 
 func (s *Storage) UpdateFeedIcon(feedId int64, icon *[]byte) bool {
-	_, err := s.db.Exec(`update feeds set icon = ? where id = ?`, icon, feedId)
+	_, err := s.db.Exec(`update feeds set icon = ?, icon_fetched = ? where id = ?`, icon, time.Now().UTC(), feedId)
+	return err == nil
+}
+
+// TouchFeedIcon keeps the icon of the feed, marking it as fetched now.
+func (s *Storage) TouchFeedIcon(feedId int64) bool {
+	_, err := s.db.Exec(`update feeds set icon_fetched = ? where id = ?`, time.Now().UTC(), feedId)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+// StartFeedHTTPSCheck records the feed being tried over https now, unless
+// it was tried since `before`, returning false then.
+func (s *Storage) StartFeedHTTPSCheck(feedId int64, now, before time.Time) bool {
+	result, err := s.db.Exec(`
+		update feeds set https_checked = ?
+		where id = ? and (https_checked is null or https_checked < ?)`,
+		now.UTC(), feedId, before.UTC(),
+	)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	n, _ := result.RowsAffected()
+	return n == 1
+}
+
+// GetFeedCookies returns the cookies the feed set, as a Cookie header value.
+func (s *Storage) GetFeedCookies(feedId int64) string {
+	var cookies string
+	err := s.db.QueryRow(`select cookies from feeds where id = ?`, feedId).Scan(&cookies)
+	if err != nil && err != sql.ErrNoRows {
+		log.Print(err)
+	}
+	return cookies
+}
+
+// SetFeedCookies stores the cookies the feed set, sent on the next fetch.
+func (s *Storage) SetFeedCookies(feedId int64, cookies string) bool {
+	_, err := s.db.Exec(`update feeds set cookies = ? where id = ?`, cookies, feedId)
+	if err != nil {
+		log.Print(err)
+	}
 	return err == nil
 }
 
@@ -85,7 +151,8 @@ func (s *Storage) ListFeeds() []Feed {
 	result := make([]Feed, 0)
 	rows, err := s.db.Query(`
 		select id, folder_id, title, description, link, feed_link,
-		       ifnull(length(icon), 0) > 0 as has_icon
+		       ifnull(length(icon), 0) > 0 as has_icon, suspended, gone,
+		       last_refreshed, last_refresh_duration, last_new_items
 		from feeds
 		order by title collate nocase
 	`)
@@ -95,6 +162,7 @@ func (s *Storage) ListFeeds() []Feed {
 	}
 	for rows.Next() {
 		var f Feed
+		var lastRefreshed sql.NullTime
 		err = rows.Scan(
 			&f.Id,
 			&f.FolderId,
@@ -103,23 +171,41 @@ func (s *Storage) ListFeeds() []Feed {
 			&f.Link,
 			&f.FeedLink,
 			&f.HasIcon,
+			&f.Suspended,
+			&f.Gone,
+			&lastRefreshed,
+			&f.LastRefreshDurationMs,
+			&f.LastNewItems,
 		)
 		if err != nil {
 			log.Print(err)
 			return result
 		}
+		if lastRefreshed.Valid {
+			f.LastRefreshed = &lastRefreshed.Time
+		}
 		result = append(result, f)
 	}
 	return result
 }
 
 func (s *Storage) ListFeedsMissingIcons() []Feed {
+	return s.listFeedsIcons(`icon is null`)
+}
+
+// ListFeedsStaleIcons returns the feeds with the icon fetched before the time given
+// and the ones without an icon found last time.
+func (s *Storage) ListFeedsStaleIcons(before time.Time) []Feed {
+	return s.listFeedsIcons(`icon is not null and (length(icon) = 0 or icon_fetched is null or icon_fetched < ?)`, before.UTC())
+}
+
+func (s *Storage) listFeedsIcons(cond string, args ...interface{}) []Feed {
 	result := make([]Feed, 0)
 	rows, err := s.db.Query(`
-		select id, folder_id, title, description, link, feed_link
+		select id, folder_id, title, description, link, feed_link,
+		       ifnull(length(icon), 0) > 0 as has_icon
 		from feeds
-		where icon is null
-	`)
+		where `+cond, args...)
 	if err != nil {
 		log.Print(err)
 		return result
@@ -133,6 +219,7 @@ func (s *Storage) ListFeedsMissingIcons() []Feed {
 			&f.Description,
 			&f.Link,
 			&f.FeedLink,
+			&f.HasIcon,
 		)
 		if err != nil {
 			log.Print(err)
@@ -148,11 +235,11 @@ func (s *Storage) GetFeed(id int64) *Feed {
 	err := s.db.QueryRow(`
 		select
 			id, folder_id, title, link, feed_link,
-			icon, ifnull(icon, '') != '' as has_icon
+			icon, ifnull(icon, '') != '' as has_icon, suspended, gone
 		from feeds where id = ?
 	`, id).Scan(
 		&f.Id, &f.FolderId, &f.Title, &f.Link, &f.FeedLink,
-		&f.Icon, &f.HasIcon,
+		&f.Icon, &f.HasIcon, &f.Suspended, &f.Gone,
 	)
 	if err != nil {
 		if err != sql.ErrNoRows {
@@ -163,22 +250,47 @@ func (s *Storage) GetFeed(id int64) *Feed {
 	return &f
 }
 
-func (s *Storage) ResetFeedErrors() {
-	if _, err := s.db.Exec(`delete from feed_errors`); err != nil {
+// ResetFeedErrors forgets the errors of the feeds refreshed successfully.
+func (s *Storage) ResetFeedErrors(feedIDs []int64) {
+	if len(feedIDs) == 0 {
+		return
+	}
+	qmarks := make([]string, len(feedIDs))
+	args := make([]interface{}, len(feedIDs))
+	for i, id := range feedIDs {
+		qmarks[i] = "?"
+		args[i] = id
+	}
+	query := `delete from feed_errors where feed_id in (` + strings.Join(qmarks, ",") + `)`
+	if _, err := s.db.Exec(query, args...); err != nil {
 		log.Print(err)
 	}
 }
 
+// errorKind is implemented by the errors telling the kind of the failure,
+// e.g. a dns lookup or a timeout.
+type errorKind interface {
+	ErrorKind() string
+}
+
+// SetFeedError stores the last error of the feed along with its kind, if known,
+// and appends it to the feed's error history.
 func (s *Storage) SetFeedError(feedID int64, lastError error) {
+	kind := ""
+	var kinded errorKind
+	if errors.As(lastError, &kinded) {
+		kind = kinded.ErrorKind()
+	}
 	_, err := s.db.Exec(`
-		insert into feed_errors (feed_id, error)
-		values (?, ?)
-		on conflict (feed_id) do update set error = excluded.error`,
-		feedID, lastError.Error(),
+		insert into feed_errors (feed_id, error, kind)
+		values (?, ?, ?)
+		on conflict (feed_id) do update set error = excluded.error, kind = excluded.kind`,
+		feedID, lastError.Error(), kind,
 	)
 	if err != nil {
 		log.Print(err)
 	}
+	s.addFeedErrorHistory(feedID, lastError.Error(), kind, time.Now())
 }
 
 func (s *Storage) GetFeedErrors() map[int64]string {
@@ -201,6 +313,43 @@ func (s *Storage) GetFeedErrors() map[int64]string {
 	return errors
 }
 
+// CountFeedErrors returns the number of the failing feeds by the kind of the error,
+// "other" for the ones of unknown kind.
+func (s *Storage) CountFeedErrors() map[string]int64 {
+	result := make(map[string]int64)
+	rows, err := s.db.Query(`
+		select case when kind = '' then 'other' else kind end as k, count(*)
+		from feed_errors
+		group by k
+	`)
+	if err != nil {
+		log.Print(err)
+		return result
+	}
+	for rows.Next() {
+		var kind string
+		var count int64
+		if err = rows.Scan(&kind, &count); err != nil {
+			log.Print(err)
+			return result
+		}
+		result[kind] = count
+	}
+	return result
+}
+
+// SetFeedRefreshed records the successful refresh of the feed.
+func (s *Storage) SetFeedRefreshed(feedID int64, at time.Time, duration time.Duration, newItems int) {
+	_, err := s.db.Exec(`
+		update feeds set last_refreshed = ?, last_refresh_duration = ?, last_new_items = ?
+		where id = ?`,
+		at.UTC(), duration.Milliseconds(), newItems, feedID,
+	)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
 func (s *Storage) SetFeedSize(feedId int64, size int) {
 	_, err := s.db.Exec(`
 		insert into feed_sizes (feed_id, size)
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/url"
+)
+
+// FeedSettings are the per-feed options.
+// Zero values mean "use the global defaults".
+type FeedSettings struct {
+	UserAgent string `json:"user_agent"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	// extra request headers (e.g. an api key), may override the user agent too
+	Headers map[string]string `json:"headers"`
+
+	// time zone (e.g. Europe/Berlin) of the item dates without one, UTC if empty
+	Timezone string `json:"timezone"`
+
+	// refresh interval in minutes
+	RefreshInterval int64 `json:"refresh_interval"`
+	// time limit for fetching the feed in seconds, the global one if 0
+	Timeout int64 `json:"timeout"`
+	// proxy url the feed is fetched through, along with its favicon
+	// and the article pages, the global one (if any) if empty
+	Proxy string `json:"proxy"`
+	// pem files of the certificate the feed authenticates with (mutual tls),
+	// the global one (if any) if empty
+	ClientCert string `json:"client_cert"`
+	ClientKey  string `json:"client_key"`
+	// connecting over `ipv4` or `ipv6` only, or both (`auto`),
+	// the global preference if empty
+	Network string `json:"network"`
+	// how long to keep the items and how many at most, -1 for no limit
+	RetentionDays     int64 `json:"retention_days"`
+	RetentionMaxItems int64 `json:"retention_max_items"`
+	// the most items accepted per refresh, the newest ones
+	MaxItems int64 `json:"max_items"`
+	// the items older than that are dropped
+	MaxItemAgeDays int64 `json:"max_item_age_days"`
+
+	FullContent bool `json:"full_content"`
+	Notify      bool `json:"notify"`
+	// look up the preview image on the article page
+	// for the new items without one
+	FetchImages bool `json:"fetch_images"`
+	// the items have math in them, e.g. LaTeX with single dollar delimiters
+	// that can't be detected reliably
+	Math bool `json:"math"`
+	// the stored items are updated when changed in the feed
+	TrackUpdates bool `json:"track_updates"`
+	// not refreshed until unpaused
+	Paused bool `json:"paused"`
+	// never reported as dead
+	KeepIfDead bool `json:"keep_if_dead"`
+	// the http link isn't tried over https
+	KeepHTTP bool `json:"keep_http"`
+	// always fetched and parsed in full, for the servers answering 304
+	// (or serving a stale copy) despite the changes
+	IgnoreCaching bool `json:"ignore_caching"`
+	// the requests logged and the last fetch kept for /api/feeds/:id/debug
+	Debug bool `json:"debug"`
+
+	// shell command run with the new items (as json) on stdin,
+	// if enabled with --allow-exec-hooks
+	HookCommand string `json:"hook_command"`
+	// working directory of the command
+	HookDir string `json:"hook_dir"`
+	// time limit for the command in seconds
+	HookTimeout int64 `json:"hook_timeout"`
+}
+
+func (s *Storage) GetFeedSettings(feedId int64) FeedSettings {
+	var settings FeedSettings
+	var val []byte
+	err := s.db.QueryRow(`select settings from feed_settings where feed_id = ?`, feedId).Scan(&val)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Print(err)
+		}
+		return settings
+	}
+	if err = json.Unmarshal(val, &settings); err != nil {
+		log.Print(err)
+	}
+	return settings
+}
+
+func (s *Storage) UpdateFeedSettings(feedId int64, settings FeedSettings) bool {
+	val, err := json.Marshal(settings)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	_, err = s.db.Exec(`
+		insert into feed_settings (feed_id, settings) values (?, ?)
+		on conflict (feed_id) do update set settings = excluded.settings`,
+		feedId, val,
+	)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	// parsed anew on the next fetch even if unchanged, for the settings to apply
+	_, err = s.db.Exec(`update http_states set body_hash = '' where feed_id = ?`, feedId)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	// the cookies set by the feed give way to the ones in the headers, if changed
+	_, err = s.db.Exec(`update feeds set cookies = '' where id = ?`, feedId)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+// StripCredentials moves the credentials embedded in the link (user:pass@host)
+// to the feed settings, unless they have their own.
+func StripCredentials(link string, settings FeedSettings) (string, FeedSettings) {
+	u, err := url.Parse(link)
+	if err != nil || u.User == nil {
+		return link, settings
+	}
+	if settings.Username == "" && settings.Password == "" {
+		settings.Username = u.User.Username()
+		settings.Password, _ = u.User.Password()
+	}
+	u.User = nil
+	return u.String(), settings
+}
+
+// moveFeedCredentials stores the credentials stripped from the link
+// of the feed in its settings, unless it has its own.
+func (s *Storage) moveFeedCredentials(feedId int64, credentials FeedSettings) {
+	if credentials.Username == "" && credentials.Password == "" {
+		return
+	}
+	settings := s.GetFeedSettings(feedId)
+	if settings.Username != "" || settings.Password != "" {
+		return
+	}
+	settings.Username, settings.Password = credentials.Username, credentials.Password
+	s.UpdateFeedSettings(feedId, settings)
+}
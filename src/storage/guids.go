@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"database/sql"
+	"log"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/nkanaev/yarr/src/logger"
+)
+
+// normalizeLink returns the link with the scheme, the fragment, the default
+// port and the trailing slash left out and the host lowercased.
+func normalizeLink(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(link)
+	}
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	link = host + strings.TrimRight(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		link += "?" + u.RawQuery
+	}
+	return link
+}
+
+// republishedKey identifies the article regardless of its guid:
+// the normalized link along with the lowercased title words.
+// Empty if the item has neither.
+func republishedKey(link, title string) string {
+	link = normalizeLink(link)
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if link == "" && len(words) == 0 {
+		return ""
+	}
+	return link + "\n" + strings.Join(words, " ")
+}
+
+// DropRepublishedItems leaves out the items of the feed with a new guid but
+// the same link as one of the stored ones, if the feed regenerates its guids.
+// The title has to match too if several of the stored items share the link
+// (e.g. all linking to the home page), otherwise the updated articles match as well.
+// Whether it does is detected once, on the first refresh with both the stored
+// items and the ones not seen before: the guids are unstable if more than half
+// of the latter match the former.
+func (s *Storage) DropRepublishedItems(feedID int64, items []Item) []Item {
+	var unstable sql.NullBool
+	err := s.db.QueryRow(`select unstable_guids from feeds where id = ?`, feedID).Scan(&unstable)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Print(err)
+		}
+		return items
+	}
+	if unstable.Valid && !unstable.Bool {
+		return items
+	}
+
+	rows, err := s.db.Query(`select guid, link, title from items where feed_id = ?`, feedID)
+	if err != nil {
+		log.Print(err)
+		return items
+	}
+	guids := make(map[string]bool)
+	links := make(map[string]int)
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var guid, link, title string
+		if err = rows.Scan(&guid, &link, &title); err != nil {
+			log.Print(err)
+			rows.Close()
+			return items
+		}
+		guids[guid] = true
+		if link := normalizeLink(link); link != "" {
+			links[link]++
+		}
+		if key := republishedKey(link, title); key != "" {
+			keys[key] = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Print(err)
+		return items
+	}
+
+	result := make([]Item, 0, len(items))
+	fresh, republished := 0, 0
+	for _, item := range items {
+		if !guids[item.GUID] {
+			fresh++
+			if links[normalizeLink(item.Link)] == 1 || keys[republishedKey(item.Link, item.Title)] {
+				republished++
+				continue
+			}
+		}
+		result = append(result, item)
+	}
+
+	if !unstable.Valid {
+		if len(guids) == 0 || fresh == 0 {
+			// nothing to tell by yet
+			return items
+		}
+		unstable.Bool = republished*2 > fresh
+		if _, err = s.db.Exec(`update feeds set unstable_guids = ? where id = ?`, unstable.Bool, feedID); err != nil {
+			log.Print(err)
+		}
+		if !unstable.Bool {
+			return items
+		}
+		logger.With(logger.Fields{"feed_id": feedID, "republished": republished, "new": fresh}).Info("feed regenerates the item guids, matching the items by link instead")
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeLink(t *testing.T) {
+	testcases := [][2]string{
+		{"https://Example.com/post/1/", "example.com/post/1"},
+		{"http://example.com:80/post/1#comments", "example.com/post/1"},
+		{"https://example.com:8443/post?id=1", "example.com:8443/post?id=1"},
+		{"/post/1", "/post/1"},
+	}
+	for _, tc := range testcases {
+		if got := normalizeLink(tc[0]); got != tc[1] {
+			t.Errorf("%s: expected %s, got %s", tc[0], tc[1], got)
+		}
+	}
+}
+
+func TestDropRepublishedItems(t *testing.T) {
+	db := testDB()
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+	stable := db.CreateFeed("", "", "", "http://example.com/stable.xml", nil)
+	for _, f := range []*Feed{feed, stable} {
+		db.CreateItems([]Item{
+			{GUID: "1@100", FeedId: f.Id, Link: "https://example.com/1", Title: "First"},
+			{GUID: "2@100", FeedId: f.Id, Link: "https://example.com/2", Title: "Second"},
+			{GUID: "3@100", FeedId: f.Id, Link: "https://example.com/", Title: "Third"},
+			{GUID: "4@100", FeedId: f.Id, Link: "https://example.com/", Title: "Fourth"},
+		})
+	}
+	guids := func(items []Item) []string {
+		result := make([]string, 0)
+		for _, item := range items {
+			result = append(result, item.GUID)
+		}
+		return result
+	}
+
+	// nothing new, nothing to tell by
+	if got := db.DropRepublishedItems(feed.Id, []Item{{GUID: "1@100", Link: "https://example.com/1"}}); len(got) != 1 {
+		t.Fatalf("expected the item kept, got %v", guids(got))
+	}
+
+	items := []Item{
+		{GUID: "1@200", Link: "http://example.com/1/", Title: "First"},
+		// updated article
+		{GUID: "2@200", Link: "https://example.com/2", Title: "Second, updated"},
+		// the shared link matches along with the title only
+		{GUID: "3@200", Link: "https://example.com/", Title: "Third"},
+		{GUID: "5@200", Link: "https://example.com/", Title: "Fifth"},
+		{GUID: "6@200", Link: "https://example.com/6", Title: "Sixth"},
+	}
+	if got := guids(db.DropRepublishedItems(feed.Id, items)); !reflect.DeepEqual(got, []string{"5@200", "6@200"}) {
+		t.Fatalf("unexpected items: %v", got)
+	}
+	// detected once
+	db.CreateItems([]Item{{GUID: "7@300", FeedId: feed.Id, Link: "https://example.com/7", Title: "Seventh"}})
+	if got := guids(db.DropRepublishedItems(feed.Id, []Item{{GUID: "7@400", Link: "https://example.com/7"}})); len(got) != 0 {
+		t.Fatalf("expected the feed still flagged, got %v", got)
+	}
+
+	// a new article detects the guids as stable
+	if got := db.DropRepublishedItems(stable.Id, []Item{{GUID: "5", Link: "https://example.com/5"}}); len(got) != 1 {
+		t.Fatalf("expected the new item kept, got %v", guids(got))
+	}
+	if got := db.DropRepublishedItems(stable.Id, []Item{{GUID: "1@200", Link: "https://example.com/1"}}); len(got) != 1 {
+		t.Fatalf("expected the feed with stable guids left alone, got %v", guids(got))
+	}
+}
@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// Reasons for the feed to be reported as dead.
+const (
+	DeadInactive = "inactive" // no new items for months
+	DeadFailing  = "failing"  // every fetch failed for weeks
+	DeadParked   = "parked"   // redirected to a parked domain page
+)
+
+// DeadFeed is the feed reported as dead along with the evidence.
+type DeadFeed struct {
+	FeedID       int64      `json:"feed_id"`
+	Reasons      []string   `json:"reasons"`
+	LastItemDate *time.Time `json:"last_item_date"`
+	// http status of the last fetch, 0 if there was no response
+	LastStatus   int        `json:"last_status"`
+	FinalURL     string     `json:"final_url"`
+	Failures     int64      `json:"failures"`
+	FailingSince *time.Time `json:"failing_since"`
+	ReportedAt   time.Time  `json:"reported_at"`
+}
+
+// SetFeedHealth records the outcome of the feed fetch. The failures are
+// counted until the next successful fetch.
+func (s *Storage) SetFeedHealth(feedID int64, status int, finalURL string, parked, failed bool) {
+	var err error
+	if failed {
+		_, err = s.db.Exec(`
+			insert into feed_health (feed_id, last_status, final_url, parked, failures, failing_since)
+			values (?, ?, ?, ?, 1, datetime())
+			on conflict (feed_id) do update set
+				last_status = excluded.last_status,
+				final_url = excluded.final_url,
+				parked = excluded.parked,
+				failures = failures + 1,
+				failing_since = coalesce(failing_since, excluded.failing_since)`,
+			feedID, status, finalURL, parked,
+		)
+	} else {
+		_, err = s.db.Exec(`
+			insert into feed_health (feed_id, last_status, final_url, parked, failures, failing_since)
+			values (?, ?, ?, false, 0, null)
+			on conflict (feed_id) do update set
+				last_status = excluded.last_status,
+				final_url = excluded.final_url,
+				parked = false,
+				failures = 0,
+				failing_since = null`,
+			feedID, status, finalURL,
+		)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// ReportDeadFeeds finds the feeds without new items for `dead_feed_inactive_months`,
+// failing for `dead_feed_failing_weeks` or parked, and stores them as the report,
+// replacing the previous one. The feeds marked to keep are left out.
+// The thresholds set to 0 are not checked.
+func (s *Storage) ReportDeadFeeds(now time.Time) []DeadFeed {
+	inactiveMonths := s.GetSettingsValueInt64("dead_feed_inactive_months")
+	failingWeeks := s.GetSettingsValueInt64("dead_feed_failing_weeks")
+
+	rows, err := s.db.Query(`
+		select
+			f.id,
+			i.date,
+			coalesce(h.last_status, 0),
+			coalesce(h.final_url, ''),
+			coalesce(h.parked, false),
+			coalesce(h.failures, 0),
+			h.failing_since
+		from feeds f
+		left join items i on i.id = (
+			select id from items where feed_id = f.id order by date desc limit 1
+		)
+		left join feed_health h on h.feed_id = f.id
+	`)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	report := make([]DeadFeed, 0)
+	for rows.Next() {
+		var feed DeadFeed
+		var lastItemDate, failingSince sql.NullTime
+		var parked bool
+		err = rows.Scan(
+			&feed.FeedID,
+			&lastItemDate,
+			&feed.LastStatus,
+			&feed.FinalURL,
+			&parked,
+			&feed.Failures,
+			&failingSince,
+		)
+		if err != nil {
+			log.Print(err)
+			rows.Close()
+			return nil
+		}
+		if lastItemDate.Valid {
+			feed.LastItemDate = &lastItemDate.Time
+		}
+		if failingSince.Valid {
+			feed.FailingSince = &failingSince.Time
+		}
+
+		feed.Reasons = make([]string, 0)
+		if inactiveMonths > 0 && feed.LastItemDate != nil && feed.LastItemDate.Before(now.AddDate(0, -int(inactiveMonths), 0)) {
+			feed.Reasons = append(feed.Reasons, DeadInactive)
+		}
+		if failingWeeks > 0 && feed.FailingSince != nil && feed.FailingSince.Before(now.AddDate(0, 0, -7*int(failingWeeks))) {
+			feed.Reasons = append(feed.Reasons, DeadFailing)
+		}
+		if parked {
+			feed.Reasons = append(feed.Reasons, DeadParked)
+		}
+		if len(feed.Reasons) > 0 {
+			feed.ReportedAt = now
+			report = append(report, feed)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	report = s.withoutKept(report)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`delete from dead_feeds`); err != nil {
+		log.Print(err)
+		return nil
+	}
+	for _, feed := range report {
+		_, err = tx.Exec(`
+			insert into dead_feeds (feed_id, reasons, last_item_date, last_status, final_url, failures, failing_since, reported_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?)`,
+			feed.FeedID, strings.Join(feed.Reasons, ","), feed.LastItemDate,
+			feed.LastStatus, feed.FinalURL, feed.Failures, feed.FailingSince, feed.ReportedAt,
+		)
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Print(err)
+		return nil
+	}
+	return report
+}
+
+// ListDeadFeeds returns the last report, without the feeds marked to keep since.
+func (s *Storage) ListDeadFeeds() []DeadFeed {
+	report := make([]DeadFeed, 0)
+	rows, err := s.db.Query(`
+		select feed_id, reasons, last_item_date, last_status, final_url, failures, failing_since, reported_at
+		from dead_feeds
+		order by feed_id
+	`)
+	if err != nil {
+		log.Print(err)
+		return report
+	}
+	for rows.Next() {
+		var feed DeadFeed
+		var reasons string
+		var lastItemDate, failingSince sql.NullTime
+		err = rows.Scan(
+			&feed.FeedID,
+			&reasons,
+			&lastItemDate,
+			&feed.LastStatus,
+			&feed.FinalURL,
+			&feed.Failures,
+			&failingSince,
+			&feed.ReportedAt,
+		)
+		if err != nil {
+			log.Print(err)
+			return report
+		}
+		feed.Reasons = strings.Split(reasons, ",")
+		if lastItemDate.Valid {
+			feed.LastItemDate = &lastItemDate.Time
+		}
+		if failingSince.Valid {
+			feed.FailingSince = &failingSince.Time
+		}
+		report = append(report, feed)
+	}
+	return s.withoutKept(report)
+}
+
+// withoutKept leaves out the feeds marked to keep even if dead.
+func (s *Storage) withoutKept(feeds []DeadFeed) []DeadFeed {
+	result := feeds[:0]
+	for _, feed := range feeds {
+		if !s.GetFeedSettings(feed.FeedID).KeepIfDead {
+			result = append(result, feed)
+		}
+	}
+	return result
+}
+
+// SetFeedGone marks the feed as removed by the publisher, or clears the mark
+// if the feed is back. Returns false if the feed doesn't exist.
+func (s *Storage) SetFeedGone(feedID int64, gone bool) bool {
+	result, err := s.db.Exec(`update feeds set gone = ? where id = ?`, gone, feedID)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	nrows, err := result.RowsAffected()
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return nrows == 1
+}
+
+// SuspendedFeed is the feed suspended for failing, along with the last error.
+type SuspendedFeed struct {
+	FeedID       int64      `json:"feed_id"`
+	Failures     int64      `json:"failures"`
+	FailingSince *time.Time `json:"failing_since"`
+	LastError    string     `json:"last_error"`
+}
+
+// SuspendFailingFeed suspends the feed if its fetch failed `suspend_after_failures`
+// times in a row (0 turns it off). Returns true if the feed got suspended.
+func (s *Storage) SuspendFailingFeed(feedID int64) bool {
+	threshold := s.GetSettingsValueInt64("suspend_after_failures")
+	if threshold <= 0 {
+		return false
+	}
+	result, err := s.db.Exec(`
+		update feeds set suspended = true
+		where id = ? and not suspended and (
+			select failures from feed_health where feed_id = feeds.id
+		) >= ?`,
+		feedID, threshold,
+	)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	nrows, err := result.RowsAffected()
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return nrows == 1
+}
+
+// ReactivateFeed lifts the suspension and starts counting the failures anew.
+// Returns false if the feed isn't suspended.
+func (s *Storage) ReactivateFeed(feedID int64) bool {
+	result, err := s.db.Exec(`update feeds set suspended = false where id = ? and suspended`, feedID)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	if nrows, err := result.RowsAffected(); err != nil || nrows != 1 {
+		return false
+	}
+	_, err = s.db.Exec(`update feed_health set failures = 0, failing_since = null where feed_id = ?`, feedID)
+	if err != nil {
+		log.Print(err)
+	}
+	return true
+}
+
+func (s *Storage) ListSuspendedFeeds() []SuspendedFeed {
+	result := make([]SuspendedFeed, 0)
+	rows, err := s.db.Query(`
+		select f.id, coalesce(h.failures, 0), h.failing_since, coalesce(e.error, '')
+		from feeds f
+		left join feed_health h on h.feed_id = f.id
+		left join feed_errors e on e.feed_id = f.id
+		where f.suspended
+		order by f.id
+	`)
+	if err != nil {
+		log.Print(err)
+		return result
+	}
+	for rows.Next() {
+		var feed SuspendedFeed
+		var failingSince sql.NullTime
+		if err = rows.Scan(&feed.FeedID, &feed.Failures, &failingSince, &feed.LastError); err != nil {
+			log.Print(err)
+			return result
+		}
+		if failingSince.Valid {
+			feed.FailingSince = &failingSince.Time
+		}
+		result = append(result, feed)
+	}
+	return result
+}
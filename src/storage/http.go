@@ -11,11 +11,18 @@ type HTTPState struct {
 
 	LastModified string
 	Etag         string
+	// sha-256 of the last response body, for the servers without the validators
+	BodyHash string
+
+	// seconds the response may be cached for (Cache-Control max-age)
+	// and the feed tells it's updated at most every (rss ttl)
+	MaxAge int64
+	TTL    int64
 }
 
 func (s *Storage) ListHTTPStates() map[int64]HTTPState {
 	result := make(map[int64]HTTPState)
-	rows, err := s.db.Query(`select feed_id, last_refreshed, last_modified, etag from http_states`)
+	rows, err := s.db.Query(`select feed_id, last_refreshed, last_modified, etag, body_hash, max_age, ttl from http_states`)
 	if err != nil {
 		log.Print(err)
 		return result
@@ -27,6 +34,9 @@ func (s *Storage) ListHTTPStates() map[int64]HTTPState {
 			&state.LastRefreshed,
 			&state.LastModified,
 			&state.Etag,
+			&state.BodyHash,
+			&state.MaxAge,
+			&state.TTL,
 		)
 		if err != nil {
 			log.Print(err)
@@ -39,7 +49,7 @@ func (s *Storage) ListHTTPStates() map[int64]HTTPState {
 
 func (s *Storage) GetHTTPState(feedID int64) *HTTPState {
 	row := s.db.QueryRow(`
-		select feed_id, last_refreshed, last_modified, etag
+		select feed_id, last_refreshed, last_modified, etag, body_hash, max_age, ttl
 		from http_states where feed_id = ?
 	`, feedID)
 
@@ -53,21 +63,90 @@ func (s *Storage) GetHTTPState(feedID int64) *HTTPState {
 		&state.LastRefreshed,
 		&state.LastModified,
 		&state.Etag,
+		&state.BodyHash,
+		&state.MaxAge,
+		&state.TTL,
 	)
 	return &state
 }
 
-func (s *Storage) SetHTTPState(feedID int64, lastModified, etag string) {
+func (s *Storage) SetHTTPState(feedID int64, lastModified, etag, bodyHash string) {
 	_, err := s.db.Exec(`
-		insert into http_states (feed_id, last_modified, etag, last_refreshed)
-		values (?, ?, ?, datetime())
-		on conflict (feed_id) do update set last_modified = ?, etag = ?, last_refreshed = datetime()`,
+		insert into http_states (feed_id, last_modified, etag, body_hash, last_refreshed)
+		values (?, ?, ?, ?, datetime())
+		on conflict (feed_id) do update set last_modified = ?, etag = ?, body_hash = ?, last_refreshed = datetime()`,
 		// insert
-		feedID, lastModified, etag,
+		feedID, lastModified, etag, bodyHash,
 		// upsert
-		lastModified, etag,
+		lastModified, etag, bodyHash,
+	)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// SetHTTPFreshness stores how long the server tells the feed stays fresh
+// (in seconds), the ttl only if not negative (the feed not parsed this time).
+func (s *Storage) SetHTTPFreshness(feedID int64, maxAge, ttl int64) {
+	_, err := s.db.Exec(`
+		update http_states
+		set max_age = ?, ttl = case when ? < 0 then ttl else ? end
+		where feed_id = ?`,
+		maxAge, ttl, ttl, feedID,
+	)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// ClearHTTPState forgets the validators, the body hash and the freshness
+// of the feed, so that it's fetched and parsed in full on the next refresh.
+// The refresh time is kept.
+func (s *Storage) ClearHTTPState(feedID int64) bool {
+	_, err := s.db.Exec(`
+		update http_states set last_modified = '', etag = '', body_hash = '', max_age = 0, ttl = 0
+		where feed_id = ?`,
+		feedID,
 	)
 	if err != nil {
 		log.Print(err)
 	}
+	return err == nil
+}
+
+// SetFeedCooldown keeps the feed from being fetched until the time given,
+// as asked by the server.
+func (s *Storage) SetFeedCooldown(feedID int64, until time.Time) {
+	_, err := s.db.Exec(`
+		insert into feed_cooldowns (feed_id, until)
+		values (?, ?)
+		on conflict (feed_id) do update set until = excluded.until`,
+		feedID, until.UTC(),
+	)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// ListFeedCooldowns returns the feeds not to be fetched yet
+// along with the time they can be.
+func (s *Storage) ListFeedCooldowns(now time.Time) map[int64]time.Time {
+	result := make(map[int64]time.Time)
+	rows, err := s.db.Query(`select feed_id, until from feed_cooldowns`)
+	if err != nil {
+		log.Print(err)
+		return result
+	}
+	for rows.Next() {
+		var feedID int64
+		var until time.Time
+		if err = rows.Scan(&feedID, &until); err != nil {
+			log.Print(err)
+			return result
+		}
+		if until.After(now) {
+			result[feedID] = until
+		}
+	}
+	return result
 }
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// CachedImage is the image of a starred item kept for reading offline.
+type CachedImage struct {
+	ContentType string
+	Data        []byte
+}
+
+// CacheItemImage stores the image found at the url in the item content.
+// The images are keyed by the hash of the data, the same image is stored once.
+// Returns the key.
+func (s *Storage) CacheItemImage(itemID int64, url, contentType string, data []byte) string {
+	hash := sha256.Sum256(data)
+	key := hex.EncodeToString(hash[:])
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Print(err)
+		return ""
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		insert into image_cache (key, content_type, data, size, accessed_at)
+		values (?, ?, ?, ?, ?)
+		on conflict (key) do update set accessed_at = excluded.accessed_at`,
+		key, contentType, data, len(data), time.Now().UTC(),
+	)
+	if err != nil {
+		log.Print(err)
+		return ""
+	}
+	_, err = tx.Exec(`
+		insert into item_images (item_id, url, key) values (?, ?, ?)
+		on conflict (item_id, url) do update set key = excluded.key`,
+		itemID, url, key,
+	)
+	if err != nil {
+		log.Print(err)
+		return ""
+	}
+	if err = tx.Commit(); err != nil {
+		log.Print(err)
+		return ""
+	}
+	return key
+}
+
+// ItemImageKeys returns the keys of the item's cached images by their urls.
+func (s *Storage) ItemImageKeys(itemID int64) map[string]string {
+	result := make(map[string]string)
+	rows, err := s.db.Query(`
+		select i.url, i.key
+		from item_images i
+		join image_cache c on c.key = i.key
+		where i.item_id = ?
+	`, itemID)
+	if err != nil {
+		log.Print(err)
+		return result
+	}
+	for rows.Next() {
+		var url, key string
+		if err = rows.Scan(&url, &key); err != nil {
+			log.Print(err)
+			return result
+		}
+		result[url] = key
+	}
+	return result
+}
+
+// GetCachedImage returns the image by the key and marks it as recently used.
+func (s *Storage) GetCachedImage(key string) *CachedImage {
+	var image CachedImage
+	err := s.db.QueryRow(
+		`select content_type, data from image_cache where key = ?`, key,
+	).Scan(&image.ContentType, &image.Data)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Print(err)
+		}
+		return nil
+	}
+	if _, err = s.db.Exec(`update image_cache set accessed_at = ? where key = ?`, time.Now().UTC(), key); err != nil {
+		log.Print(err)
+	}
+	return &image
+}
+
+// UncacheItemImages forgets the images of the item, dropping the ones
+// no other item has.
+func (s *Storage) UncacheItemImages(itemID int64) {
+	res, err := s.db.Exec(`delete from item_images where item_id = ?`, itemID)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		s.deleteOrphanImages()
+	}
+}
+
+// DeleteUnusedImages drops the images of the items no longer starred
+// (or deleted) and returns the number of images deleted.
+func (s *Storage) DeleteUnusedImages() int64 {
+	_, err := s.db.Exec(`
+		delete from item_images
+		where item_id not in (select id from items where status = ?)`,
+		STARRED,
+	)
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	return s.deleteOrphanImages()
+}
+
+func (s *Storage) deleteOrphanImages() int64 {
+	res, err := s.db.Exec(`delete from image_cache where key not in (select key from item_images)`)
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
+
+// EvictCachedImages drops the least recently used images until the cache
+// fits in maxSize bytes and returns the number of images deleted.
+// The items lose the evicted images, they're hot-linked again.
+func (s *Storage) EvictCachedImages(maxSize int64) int64 {
+	var total int64
+	if err := s.db.QueryRow(`select coalesce(sum(size), 0) from image_cache`).Scan(&total); err != nil {
+		log.Print(err)
+		return 0
+	}
+	if total <= maxSize {
+		return 0
+	}
+
+	rows, err := s.db.Query(`select key, size from image_cache order by accessed_at, key`)
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	evict := make([]string, 0)
+	for rows.Next() && total > maxSize {
+		var key string
+		var size int64
+		if err = rows.Scan(&key, &size); err != nil {
+			log.Print(err)
+			rows.Close()
+			return 0
+		}
+		evict = append(evict, key)
+		total -= size
+	}
+	rows.Close()
+
+	for _, key := range evict {
+		if _, err = s.db.Exec(`delete from image_cache where key = ?`, key); err != nil {
+			log.Print(err)
+			return 0
+		}
+		if _, err = s.db.Exec(`delete from item_images where key = ?`, key); err != nil {
+			log.Print(err)
+			return 0
+		}
+	}
+	return int64(len(evict))
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImageCache(t *testing.T) {
+	db := testDB()
+	feed := db.CreateFeed("feed", "", "", "http://test.com/feed.xml", nil)
+	items := db.CreateItems([]Item{
+		{GUID: "1", FeedId: feed.Id, Title: "one"},
+		{GUID: "2", FeedId: feed.Id, Title: "two"},
+	})
+	for _, item := range items {
+		db.UpdateItemStatus(item.Id, STARRED)
+	}
+
+	key := db.CacheItemImage(items[0].Id, "http://test.com/a.png", "image/png", []byte("aaaa"))
+	// the same image under another url
+	if same := db.CacheItemImage(items[1].Id, "http://test.com/copy.png", "image/png", []byte("aaaa")); same != key {
+		t.Fatalf("expected the same key, got %q and %q", key, same)
+	}
+	other := db.CacheItemImage(items[1].Id, "http://test.com/b.png", "image/png", []byte("bbbbbb"))
+
+	keys := db.ItemImageKeys(items[1].Id)
+	if len(keys) != 2 || keys["http://test.com/copy.png"] != key || keys["http://test.com/b.png"] != other {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if image := db.GetCachedImage(key); image == nil || string(image.Data) != "aaaa" || image.ContentType != "image/png" {
+		t.Fatalf("unexpected image: %#v", image)
+	}
+
+	// the image shared with the starred item is kept
+	db.UpdateItemStatus(items[1].Id, READ)
+	if keys := db.ItemImageKeys(items[1].Id); len(keys) != 0 {
+		t.Fatalf("expected no images after unstarring, got %v", keys)
+	}
+	if db.GetCachedImage(key) == nil || db.GetCachedImage(other) != nil {
+		t.Fatal("expected only the unused image to be deleted")
+	}
+
+	// least recently used first
+	db.CacheItemImage(items[0].Id, "http://test.com/c.png", "image/png", []byte(strings.Repeat("c", 10)))
+	db.GetCachedImage(key)
+	if n := db.EvictCachedImages(8); n != 1 {
+		t.Fatalf("expected 1 image evicted, got %d", n)
+	}
+	if keys := db.ItemImageKeys(items[0].Id); len(keys) != 1 || keys["http://test.com/a.png"] != key {
+		t.Fatalf("unexpected keys after eviction: %v", keys)
+	}
+
+	db.db.Exec(`update items set status = ? where id = ?`, UNREAD, items[0].Id)
+	if n := db.DeleteUnusedImages(); n != 1 || db.GetCachedImage(key) != nil {
+		t.Fatalf("expected the image of the unstarred item to be deleted, got %d", n)
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// ImportState is where the interrupted import from another reader left off.
+type ImportState struct {
+	Stage  string
+	Cursor string
+	// the import brings over the items published after it
+	Since time.Time
+}
+
+// GetImportState returns the state of the unfinished import from the source, if any.
+func (s *Storage) GetImportState(source string) *ImportState {
+	var state ImportState
+	err := s.db.QueryRow(
+		`select stage, cursor, since from import_state where source = ?`, source,
+	).Scan(&state.Stage, &state.Cursor, &state.Since)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Print(err)
+		}
+		return nil
+	}
+	return &state
+}
+
+func (s *Storage) SetImportState(source string, state ImportState) bool {
+	_, err := s.db.Exec(`
+		insert into import_state (source, stage, cursor, since) values (?, ?, ?, ?)
+		on conflict (source) do update set stage = excluded.stage, cursor = excluded.cursor, since = excluded.since`,
+		source, state.Stage, state.Cursor, state.Since.UTC(),
+	)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+// DeleteImportState forgets the import from the source, the next one starts over.
+func (s *Storage) DeleteImportState(source string) bool {
+	_, err := s.db.Exec(`delete from import_state where source = ?`, source)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+// ImportItems stores the items brought over from another reader along with
+// their statuses. The items already present in the feed, by guid or by link,
+// get only the status updated. Returns the number of the items created.
+func (s *Storage) ImportItems(items []Item) int64 {
+	type itemKey struct {
+		feedID int64
+		guid   string
+	}
+	statuses := make(map[int64]ItemStatus)
+	missing := make([]Item, 0)
+	missingStatuses := make(map[itemKey]ItemStatus)
+	for _, item := range items {
+		var id int64
+		err := s.db.QueryRow(`
+			select id from items
+			where feed_id = ? and (guid = ? or (link = ? and link != ''))
+			order by guid = ? desc
+			limit 1`,
+			item.FeedId, item.GUID, item.Link, item.GUID,
+		).Scan(&id)
+		switch err {
+		case nil:
+			statuses[id] = item.Status
+		case sql.ErrNoRows:
+			missing = append(missing, item)
+			missingStatuses[itemKey{item.FeedId, item.GUID}] = item.Status
+		default:
+			log.Print(err)
+			return 0
+		}
+	}
+
+	created := s.CreateItems(missing)
+	for _, item := range created {
+		statuses[item.Id] = missingStatuses[itemKey{item.FeedId, item.GUID}]
+	}
+	for id, status := range statuses {
+		s.UpdateItemStatus(id, status)
+	}
+	return int64(len(created))
+}
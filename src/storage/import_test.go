@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportState(t *testing.T) {
+	db := testDB()
+	if db.GetImportState("miniflux http://example.com") != nil {
+		t.Fatal("expected no state")
+	}
+	since := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.SetImportState("miniflux http://example.com", ImportState{Stage: "starred", Since: since})
+	db.SetImportState("miniflux http://example.com", ImportState{Stage: "recent", Cursor: "42", Since: since})
+
+	state := db.GetImportState("miniflux http://example.com")
+	if state == nil || state.Stage != "recent" || state.Cursor != "42" || !state.Since.Equal(since) {
+		t.Fatalf("unexpected state: %#v", state)
+	}
+	db.DeleteImportState("miniflux http://example.com")
+	if db.GetImportState("miniflux http://example.com") != nil {
+		t.Fatal("expected the state deleted")
+	}
+}
+
+func TestImportItems(t *testing.T) {
+	db := testDB()
+	feed := db.CreateFeed("", "", "", "http://example.com/feed", nil)
+	db.CreateItems([]Item{
+		{GUID: "1", FeedId: feed.Id, Link: "http://example.com/1"},
+		{GUID: "http://example.com/2", FeedId: feed.Id, Link: "http://example.com/2"},
+	})
+
+	created := db.ImportItems([]Item{
+		// matched by link
+		{GUID: "http://example.com/1", FeedId: feed.Id, Link: "http://example.com/1", Status: STARRED},
+		// matched by guid
+		{GUID: "http://example.com/2", FeedId: feed.Id, Status: READ},
+		{GUID: "http://example.com/3", FeedId: feed.Id, Link: "http://example.com/3", Status: READ},
+	})
+	if created != 1 {
+		t.Fatalf("expected 1 item created, got %d", created)
+	}
+	statuses := make(map[string]ItemStatus)
+	for _, item := range db.ListItems(ItemFilter{}, 10, false, false) {
+		statuses[item.GUID] = item.Status
+	}
+	want := map[string]ItemStatus{"1": STARRED, "http://example.com/2": READ, "http://example.com/3": READ}
+	if len(statuses) != len(want) {
+		t.Fatalf("unexpected items: %#v", statuses)
+	}
+	for guid, status := range want {
+		if statuses[guid] != status {
+			t.Fatalf("unexpected statuses: %#v", statuses)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+)
+
+type Integration struct {
+	Id          int64             `json:"id"`
+	Kind        string            `json:"kind"`
+	Title       string            `json:"title"`
+	Endpoint    string            `json:"endpoint"`
+	Credentials map[string]string `json:"-"`
+	SaveStarred bool              `json:"save_starred"`
+}
+
+func (s *Storage) CreateIntegration(kind, title, endpoint string, credentials map[string]string, saveStarred bool) *Integration {
+	if credentials == nil {
+		// stored as {}, not null
+		credentials = make(map[string]string)
+	}
+	credentialsEncoded, err := json.Marshal(credentials)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	row := s.db.QueryRow(`
+		insert into integrations (kind, title, endpoint, credentials, save_starred)
+		values (?, ?, ?, ?, ?)
+		returning id`,
+		kind, title, endpoint, credentialsEncoded, saveStarred,
+	)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		log.Print(err)
+		return nil
+	}
+	return &Integration{
+		Id:          id,
+		Kind:        kind,
+		Title:       title,
+		Endpoint:    endpoint,
+		Credentials: credentials,
+		SaveStarred: saveStarred,
+	}
+}
+
+func (s *Storage) UpdateIntegration(i Integration) bool {
+	credentialsEncoded, err := json.Marshal(i.Credentials)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	_, err = s.db.Exec(`
+		update integrations
+		set title = ?, endpoint = ?, credentials = ?, save_starred = ?
+		where id = ?`,
+		i.Title, i.Endpoint, credentialsEncoded, i.SaveStarred, i.Id,
+	)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+func (s *Storage) DeleteIntegration(id int64) bool {
+	_, err := s.db.Exec(`delete from integrations where id = ?`, id)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+func scanIntegration(row interface{ Scan(...interface{}) error }) (*Integration, error) {
+	var i Integration
+	var credentials []byte
+	if err := row.Scan(&i.Id, &i.Kind, &i.Title, &i.Endpoint, &credentials, &i.SaveStarred); err != nil {
+		return nil, err
+	}
+	if len(credentials) > 0 {
+		if err := json.Unmarshal(credentials, &i.Credentials); err != nil {
+			return nil, err
+		}
+	}
+	// null stored by the older versions
+	if i.Credentials == nil {
+		i.Credentials = make(map[string]string)
+	}
+	return &i, nil
+}
+
+func (s *Storage) GetIntegration(id int64) *Integration {
+	i, err := scanIntegration(s.db.QueryRow(`
+		select id, kind, title, endpoint, credentials, save_starred
+		from integrations where id = ?
+	`, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Print(err)
+		}
+		return nil
+	}
+	return i
+}
+
+func (s *Storage) ListIntegrations() []Integration {
+	result := make([]Integration, 0)
+	rows, err := s.db.Query(`
+		select id, kind, title, endpoint, credentials, save_starred
+		from integrations
+		order by title collate nocase
+	`)
+	if err != nil {
+		log.Print(err)
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		i, err := scanIntegration(rows)
+		if err != nil {
+			log.Print(err)
+			return result
+		}
+		result = append(result, *i)
+	}
+	return result
+}
@@ -1,14 +1,18 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/logger"
 )
 
 type ItemStatus int
@@ -51,12 +55,22 @@ type Item struct {
 	Title    string     `json:"title"`
 	Link     string     `json:"link"`
 	Content  string     `json:"content,omitempty"`
-	Date     time.Time  `json:"date"`
+	Snippet  string     `json:"snippet"`
+	Date     time.Time  `json:"date"` // in UTC
 	Status   ItemStatus `json:"status"`
 	ImageURL *string    `json:"image"`
 	AudioURL *string    `json:"podcast_url"`
+
+	// id of the group of the similar items from the other feeds
+	DuplicateGroup *int64 `json:"duplicate_group,omitempty"`
 }
 
+// snippetLength is the max length of the item plain-text preview
+const snippetLength = 300
+
+// createItemsChunkSize is the number of items inserted in one transaction
+const createItemsChunkSize = 250
+
 type ItemFilter struct {
 	FolderID *int64
 	FeedID   *int64
@@ -67,6 +81,8 @@ type ItemFilter struct {
 	SinceID  *int64
 	MaxID    *int64
 	Before   *time.Time
+	// leave out the items with an earlier duplicate
+	CollapseDuplicates bool
 }
 
 type MarkFilter struct {
@@ -95,45 +111,153 @@ func (list ItemList) Swap(i, j int) {
 }
 
 
-func (s *Storage) CreateItems(items []Item) bool {
-	tx, err := s.db.Begin()
-	if err != nil {
-		log.Print(err)
-		return false
-	}
-
+// CreateItems stores the items not seen before and returns them
+// with the ids set. Items already present in the feed are skipped.
+// The items are inserted in chunks of createItemsChunkSize, each in its own
+// transaction, so that the write lock isn't held for the whole of a large feed.
+// A failed chunk is logged and skipped, the rest are stored anyway.
+// Returns nil if nothing could be stored.
+// The new items are grouped with the similar ones if `group_similar_titles` is on.
+func (s *Storage) CreateItems(items []Item) []Item {
 	now := time.Now().UTC()
 
     itemsSorted := ItemList(items)
     sort.Sort(itemsSorted)
 
-	for _, item := range itemsSorted {
-		_, err = tx.Exec(`
+	created := make([]Item, 0)
+	failed := 0
+	for start := 0; start < len(itemsSorted); start += createItemsChunkSize {
+		end := start + createItemsChunkSize
+		if end > len(itemsSorted) {
+			end = len(itemsSorted)
+		}
+		chunk, err := s.createItemsChunk(itemsSorted[start:end], now)
+		if err != nil {
+			failed += end - start
+			logger.With(logger.Fields{"items": end - start, "error": err}).Warn("failed to store the items")
+			continue
+		}
+		created = append(created, chunk...)
+	}
+	if failed > 0 && len(created) == 0 {
+		return nil
+	}
+	if len(created) > 0 && s.GetSettingsValueBool("group_similar_titles") {
+		s.groupSimilarItems(created, now)
+	}
+	return created
+}
+
+// createItemsChunk stores the items in one transaction, all or none of them.
+func (s *Storage) createItemsChunk(items []Item, now time.Time) ([]Item, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created := make([]Item, 0)
+	for _, item := range items {
+		item.Snippet = htmlutil.Snippet(item.Content, snippetLength)
+		res, err := tx.Exec(`
 			insert into items (
 				guid, feed_id, title, link, date,
-				content, image, podcast_url,
+				content, snippet, image, podcast_url,
 				date_arrived, status
 			)
-			values (?, ?, ?, ?, strftime('%Y-%m-%d %H:%M:%f', ?), ?, ?, ?, ?, ?)
+			values (?, ?, ?, ?, strftime('%Y-%m-%d %H:%M:%f', ?), ?, ?, ?, ?, ?, ?)
 			on conflict (feed_id, guid) do nothing`,
 			item.GUID, item.FeedId, item.Title, item.Link, item.Date,
-			item.Content, item.ImageURL, item.AudioURL,
+			item.Content, item.Snippet, item.ImageURL, item.AudioURL,
 			now, UNREAD,
 		)
 		if err != nil {
-			log.Print(err)
-			if err = tx.Rollback(); err != nil {
-				log.Print(err)
-				return false
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			if item.Id, err = res.LastInsertId(); err != nil {
+				return nil, err
 			}
-			return false
+			item.Status = UNREAD
+			created = append(created, item)
 		}
 	}
 	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// itemHash is the hash the changes of the item are detected by.
+func itemHash(title, content string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(title + "\x00" + content))
+}
+
+// UpdateChangedItems updates the title, content, image and audio of the stored
+// items with the same guid whose title or content changed since. The status,
+// the date and the image found on the article page (if the feed has none) are kept.
+// Returns the updated items, they're indexed anew with the next SyncSearch.
+func (s *Storage) UpdateChangedItems(items []Item) []Item {
+	tx, err := s.db.Begin()
+	if err != nil {
 		log.Print(err)
-		return false
+		return nil
+	}
+	defer tx.Rollback()
+
+	updated := make([]Item, 0)
+	for _, item := range items {
+		var id int64
+		var title, content, fullContent string
+		err := tx.QueryRow(`
+			select id, title, content, ifnull(full_content, '')
+			from items where feed_id = ? and guid = ?`,
+			item.FeedId, item.GUID,
+		).Scan(&id, &title, &content, &fullContent)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		if itemHash(title, content) == itemHash(item.Title, item.Content) {
+			continue
+		}
+		// the full content fetched from the page takes precedence
+		item.Snippet = htmlutil.Snippet(item.Content, snippetLength)
+		if fullContent != "" {
+			item.Snippet = htmlutil.Snippet(fullContent, snippetLength)
+		}
+		_, err = tx.Exec(`
+			delete from search where rowid = (select search_rowid from items where id = ?)`, id,
+		)
+		if err == nil {
+			_, err = tx.Exec(`
+				update items set
+					title = ?, content = ?, snippet = ?,
+					image = coalesce(?, image), podcast_url = ?, search_rowid = null
+				where id = ?`,
+				item.Title, item.Content, item.Snippet,
+				item.ImageURL, item.AudioURL, id,
+			)
+		}
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		item.Id = id
+		updated = append(updated, item)
+	}
+	if err = tx.Commit(); err != nil {
+		log.Print(err)
+		return nil
 	}
-	return true
+	return updated
 }
 
 func listQueryPredicate(filter ItemFilter, newestFirst bool) (string, []interface{}) {
@@ -152,14 +276,26 @@ func listQueryPredicate(filter ItemFilter, newestFirst bool) (string, []interfac
 		args = append(args, *filter.Status)
 	}
 	if filter.Search != nil {
-		words := strings.Fields(*filter.Search)
-		terms := make([]string, len(words))
-		for idx, word := range words {
-			terms[idx] = word + "*"
+		terms := make([]string, 0)
+		for _, word := range strings.Fields(*filter.Search) {
+			// feed:name and folder:name narrow down to the feeds with the name in the title
+			if name := strings.TrimPrefix(word, "feed:"); name != word && name != "" {
+				cond = append(cond, "i.feed_id in (select id from feeds where title like ?)")
+				args = append(args, "%"+name+"%")
+				continue
+			}
+			if name := strings.TrimPrefix(word, "folder:"); name != word && name != "" {
+				cond = append(cond, "i.feed_id in (select f.id from feeds f join folders d on d.id = f.folder_id where d.title like ?)")
+				args = append(args, "%"+name+"%")
+				continue
+			}
+			terms = append(terms, word+"*")
 		}
 
-		cond = append(cond, "i.search_rowid in (select rowid from search where search match ?)")
-		args = append(args, strings.Join(terms, " "))
+		if len(terms) > 0 {
+			cond = append(cond, "i.search_rowid in (select rowid from search where search match ?)")
+			args = append(args, strings.Join(terms, " "))
+		}
 	}
 	if filter.After != nil {
 		compare := ">"
@@ -191,6 +327,13 @@ func listQueryPredicate(filter ItemFilter, newestFirst bool) (string, []interfac
 		cond = append(cond, "i.date < ?")
 		args = append(args, filter.Before)
 	}
+	if filter.CollapseDuplicates {
+		cond = append(cond, `not exists (
+			select 1 from item_duplicates d
+			join item_duplicates o on o.group_id = d.group_id
+			where d.item_id = i.id and o.item_id < i.id
+		)`)
+	}
 
 	predicate := "1"
 	if len(cond) > 0 {
@@ -206,7 +349,7 @@ func (s *Storage) CountItems(filter ItemFilter) int {
 	var count int
 	query := fmt.Sprintf(`
 		select count(*)
-		from items
+		from items i
 		where %s
 		`, predicate)
 	err := s.db.QueryRow(query, args...).Scan(&count)
@@ -232,7 +375,7 @@ func (s *Storage) ListItems(filter ItemFilter, limit int, newestFirst bool, with
 		order = "i.id desc"
 	}
 
-	selectCols := "i.id, i.guid, i.feed_id, i.title, i.link, i.date, i.status, i.image, i.podcast_url"
+	selectCols := "i.id, i.guid, i.feed_id, i.title, i.link, i.date, i.status, i.image, i.podcast_url, i.snippet, d.group_id"
 	if withContent {
 		selectCols += ", i.content"
 	} else {
@@ -241,6 +384,7 @@ func (s *Storage) ListItems(filter ItemFilter, limit int, newestFirst bool, with
 	query := fmt.Sprintf(`
 		select %s
 		from items i
+		left join item_duplicates d on d.item_id = i.id
 		where %s
 		order by %s
 		limit %d
@@ -255,7 +399,7 @@ func (s *Storage) ListItems(filter ItemFilter, limit int, newestFirst bool, with
 		err = rows.Scan(
 			&x.Id, &x.GUID, &x.FeedId,
 			&x.Title, &x.Link, &x.Date,
-			&x.Status, &x.ImageURL, &x.AudioURL, &x.Content,
+			&x.Status, &x.ImageURL, &x.AudioURL, &x.Snippet, &x.DuplicateGroup, &x.Content,
 		)
 		if err != nil {
 			log.Print(err)
@@ -266,17 +410,50 @@ func (s *Storage) ListItems(filter ItemFilter, limit int, newestFirst bool, with
 	return result
 }
 
+// RandomItem returns a random item matching the filter, nil if there's none.
+// Instead of shuffling the whole table it picks a random id and takes the first
+// matching item from there on, or the last one before it. With olderFirst
+// the picked ids lean towards the older items.
+func (s *Storage) RandomItem(filter ItemFilter, olderFirst bool) *Item {
+	var minID, maxID int64
+	if err := s.db.QueryRow(`select coalesce(min(id), 0), coalesce(max(id), 0) from items`).Scan(&minID, &maxID); err != nil {
+		log.Print(err)
+		return nil
+	}
+	if maxID == 0 {
+		return nil
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano())).Float64()
+	if olderFirst {
+		r = r * r
+	}
+	probe := minID + int64(r*float64(maxID-minID+1))
+
+	after := probe - 1
+	filter.SinceID, filter.MaxID = &after, nil
+	if items := s.ListItems(filter, 1, false, false); len(items) > 0 {
+		return &items[0]
+	}
+	filter.SinceID, filter.MaxID = nil, &probe
+	if items := s.ListItems(filter, 1, false, false); len(items) > 0 {
+		return &items[0]
+	}
+	return nil
+}
+
 func (s *Storage) GetItem(id int64) *Item {
 	i := &Item{}
 	err := s.db.QueryRow(`
 		select
-			i.id, i.guid, i.feed_id, i.title, i.link, i.content,
-			i.date, i.status, i.image, i.podcast_url
+			i.id, i.guid, i.feed_id, i.title, i.link, i.content, i.snippet,
+			i.date, i.status, i.image, i.podcast_url, d.group_id
 		from items i
+		left join item_duplicates d on d.item_id = i.id
 		where i.id = ?
 	`, id).Scan(
-		&i.Id, &i.GUID, &i.FeedId, &i.Title, &i.Link, &i.Content,
-		&i.Date, &i.Status, &i.ImageURL, &i.AudioURL,
+		&i.Id, &i.GUID, &i.FeedId, &i.Title, &i.Link, &i.Content, &i.Snippet,
+		&i.Date, &i.Status, &i.ImageURL, &i.AudioURL, &i.DuplicateGroup,
 	)
 	if err != nil {
 		log.Print(err)
@@ -285,8 +462,54 @@ func (s *Storage) GetItem(id int64) *Item {
 	return i
 }
 
+func (s *Storage) GetItemFullContent(id int64) string {
+	var content string
+	err := s.db.QueryRow(
+		`select ifnull(full_content, '') from items where id = ?`, id,
+	).Scan(&content)
+	if err != nil && err != sql.ErrNoRows {
+		log.Print(err)
+	}
+	return content
+}
+
+// UpdateItemFullContent stores the content fetched from the article page.
+// The snippet (and its copy in the search index) is regenerated from it,
+// the feed content is often just a teaser.
+func (s *Storage) UpdateItemFullContent(id int64, content string) bool {
+	snippet := htmlutil.Snippet(content, snippetLength)
+	_, err := s.db.Exec(`
+		update items set full_content = ?, snippet = ? where id = ?`,
+		content, snippet, id,
+	)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	_, err = s.db.Exec(`
+		update search set description = ?
+		where rowid = (select search_rowid from items where id = ?)`,
+		snippet, id,
+	)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+func (s *Storage) UpdateItemImage(id int64, image string) bool {
+	_, err := s.db.Exec(`update items set image = ? where id = ?`, image, id)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
 func (s *Storage) UpdateItemStatus(item_id int64, status ItemStatus) bool {
 	_, err := s.db.Exec(`update items set status = ? where id = ?`, status, item_id)
+	if err == nil && status != STARRED {
+		s.UncacheItemImages(item_id)
+	}
 	return err == nil
 }
 
@@ -307,6 +530,33 @@ func (s *Storage) MarkItemsRead(filter MarkFilter) bool {
 	return err == nil
 }
 
+// MarkItemsReadOlderThan marks all the items published before the given date as read
+// and returns the number of affected items. Starred items are left untouched,
+// as with MarkItemsRead (the status is either starred or read).
+func (s *Storage) MarkItemsReadOlderThan(filter MarkFilter, olderThan time.Time) int64 {
+	olderThan = olderThan.UTC()
+	predicate, args := listQueryPredicate(ItemFilter{
+		FolderID: filter.FolderID,
+		FeedID:   filter.FeedID,
+		Before:   &olderThan,
+	}, false)
+	query := fmt.Sprintf(`
+		update items as i set status = %d
+		where %s and i.status = %d
+		`, READ, predicate, UNREAD)
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	numrows, err := result.RowsAffected()
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	return numrows
+}
+
 type FeedStat struct {
 	FeedId       int64 `json:"feed_id"`
 	UnreadCount  int64 `json:"unread"`
@@ -337,7 +587,7 @@ func (s *Storage) FeedStats() []FeedStat {
 
 func (s *Storage) SyncSearch() {
 	rows, err := s.db.Query(`
-		select id, title, content
+		select id, title, content, snippet
 		from items
 		where search_rowid is null;
 	`)
@@ -349,14 +599,14 @@ func (s *Storage) SyncSearch() {
 	items := make([]Item, 0)
 	for rows.Next() {
 		var item Item
-		rows.Scan(&item.Id, &item.Title, &item.Content)
+		rows.Scan(&item.Id, &item.Title, &item.Content, &item.Snippet)
 		items = append(items, item)
 	}
 
 	for _, item := range items {
 		result, err := s.db.Exec(`
-			insert into search (title, description, content) values (?, "", ?)`,
-			item.Title, htmlutil.ExtractText(item.Content),
+			insert into search (title, description, content) values (?, ?, ?)`,
+			item.Title, item.Snippet, htmlutil.ExtractText(item.Content),
 		)
 		if err != nil {
 			log.Print(err)
@@ -373,6 +623,60 @@ func (s *Storage) SyncSearch() {
 	}
 }
 
+// RebuildSearch recreates the full-text index from scratch
+// and returns the number of items indexed.
+func (s *Storage) RebuildSearch() int64 {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	for _, query := range []string{
+		`drop table if exists search`,
+		`create virtual table search using fts4(title, description, content)`,
+		`update items set search_rowid = null`,
+	} {
+		if _, err = tx.Exec(query); err != nil {
+			log.Print(err)
+			if err = tx.Rollback(); err != nil {
+				log.Print(err)
+			}
+			return 0
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Print(err)
+		return 0
+	}
+	s.SyncSearch()
+
+	var count int64
+	if err = s.db.QueryRow(`select count(*) from items where search_rowid is not null`).Scan(&count); err != nil {
+		log.Print(err)
+	}
+	return count
+}
+
+// RecomputeCounters drops the derived rows left behind by deleted feeds and items.
+// Unread and starred counts are computed on the fly and need no fixing.
+func (s *Storage) RecomputeCounters() map[string]int64 {
+	result := make(map[string]int64)
+	for key, query := range map[string]string{
+		"feed_sizes_removed":  `delete from feed_sizes where feed_id not in (select id from feeds)`,
+		"search_rows_removed": `delete from search where rowid not in (select search_rowid from items where search_rowid is not null)`,
+		"search_refs_reset":   `update items set search_rowid = null where search_rowid not in (select rowid from search)`,
+	} {
+		res, err := s.db.Exec(query)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		result[key], _ = res.RowsAffected()
+	}
+	s.SyncSearch()
+	return result
+}
+
 var (
 	itemsKeepSize = 50
 	itemsKeepDays = 90
@@ -381,62 +685,109 @@ var (
 // Delete old articles from the database to cleanup space.
 //
 // The rules:
-//   - Never delete starred entries.
+//   - Never delete starred entries, the others are deleted whether read or not.
 //   - Keep at least the same amount of articles the feed provides (default: 50).
 //     This prevents from deleting items for rarely updated and/or ever-growing
 //     feeds which might eventually reappear as unread.
-//   - Keep entries for a certain period (default: 90 days).
-func (s *Storage) DeleteOldItems() {
+//   - Keep entries for a certain period (`retention_days`, default: 90 days).
+//   - Keep at most a certain amount of entries (`retention_max_items`, default: no limit),
+//     but no less than the feed provides.
+//
+// The feed's own `retention_days` and `retention_max_items` take precedence,
+// -1 turns the limit off for the feed.
+//
+// Returns the number of the deleted entries.
+func (s *Storage) DeleteOldItems() int64 {
 	rows, err := s.db.Query(`
 		select
 			i.feed_id,
-			max(coalesce(s.size, 0), ?) as max_items,
+			coalesce(s.size, 0) as feed_size,
 			count(*) as num_items
 		from items i
 		left outer join feed_sizes s on s.feed_id = i.feed_id
 		where status != ?
 		group by i.feed_id
-	`, itemsKeepSize, STARRED)
+	`, STARRED)
 
 	if err != nil {
 		log.Print(err)
-		return
+		return 0
 	}
 
-	feedLimits := make(map[int64]int64, 0)
+	feedSizes := make(map[int64]int64, 0)
 	for rows.Next() {
-		var feedId, limit int64
-		rows.Scan(&feedId, &limit, nil)
-		feedLimits[feedId] = limit
+		var feedId, size int64
+		rows.Scan(&feedId, &size, nil)
+		feedSizes[feedId] = size
 	}
 
-	for feedId, limit := range feedLimits {
-		result, err := s.db.Exec(`
-			delete from items
-			where id in (
-				select i.id
-				from items i
-				where i.feed_id = ? and status != ?
-				order by date desc
-				limit -1 offset ?
-			) and date_arrived < ?
-			`,
-			feedId,
-			STARRED,
-			limit,
-			time.Now().UTC().Add(-time.Hour*time.Duration(24*itemsKeepDays)),
-		)
-		if err != nil {
-			log.Print(err)
-			return
+	globalDays := s.GetSettingsValueInt64("retention_days")
+	globalMaxItems := s.GetSettingsValueInt64("retention_max_items")
+
+	var total int64
+	for feedId, size := range feedSizes {
+		settings := s.GetFeedSettings(feedId)
+		days, maxItems := settings.RetentionDays, settings.RetentionMaxItems
+		if days == 0 {
+			days = globalDays
 		}
-		numDeleted, err := result.RowsAffected()
-		if err != nil {
-			log.Print(err)
-			return
+		if maxItems == 0 {
+			maxItems = globalMaxItems
 		}
+
+		var numDeleted int64
+		if days > 0 {
+			keep := size
+			if keep < int64(itemsKeepSize) {
+				keep = int64(itemsKeepSize)
+			}
+			deleted, err := s.deleteItemsBeyond(feedId, keep, time.Now().UTC().Add(-time.Hour*time.Duration(24*days)))
+			if err != nil {
+				log.Print(err)
+				return total
+			}
+			numDeleted += deleted
+		}
+		if maxItems > 0 {
+			keep := size
+			if keep < maxItems {
+				keep = maxItems
+			}
+			deleted, err := s.deleteItemsBeyond(feedId, keep, time.Now().UTC())
+			if err != nil {
+				log.Print(err)
+				return total
+			}
+			numDeleted += deleted
+		}
+		total += numDeleted
 		if numDeleted > 0 {
-			log.Printf("Deleted %d old items (feed: %d)", numDeleted, feedId)
+			logger.With(logger.Fields{"feed_id": feedId, "deleted": numDeleted}).Info("deleted old items")
 		}
 	}
+	return total
+}
+
+// deleteItemsBeyond deletes the items of the feed, but the newest `keep` ones
+// and the starred ones, arrived before the time given.
+func (s *Storage) deleteItemsBeyond(feedId, keep int64, arrivedBefore time.Time) (int64, error) {
+	result, err := s.db.Exec(`
+		delete from items
+		where id in (
+			select i.id
+			from items i
+			where i.feed_id = ? and status != ?
+			order by date desc
+			limit -1 offset ?
+		) and date_arrived < ?
+		`,
+		feedId,
+		STARRED,
+		keep,
+		arrivedBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
@@ -273,6 +273,35 @@ func TestMarkItemsRead(t *testing.T) {
 	}
 }
 
+func TestMarkItemsReadOlderThan(t *testing.T) {
+	var read ItemStatus = READ
+
+	db := testDB()
+	scope := testItemsSetup(db)
+	olderThan := time.Now().Add(time.Hour * 24 * 5).Add(time.Hour * 12)
+
+	if n := db.MarkItemsReadOlderThan(MarkFilter{FolderID: &scope.folder1.Id}, olderThan); n != 2 {
+		t.Fatalf("expected 2 items to be marked, got %d", n)
+	}
+	have := getItemGuids(db.ListItems(ItemFilter{Status: &read}, 10, false, false))
+	want := []string{
+		"item111", "item112", "item121", "item122",
+		"item211", "item012",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Logf("want: %#v", want)
+		t.Logf("have: %#v", have)
+		t.Fail()
+	}
+
+	var starred ItemStatus = STARRED
+	stars := getItemGuids(db.ListItems(ItemFilter{Status: &starred}, 10, false, false))
+	db.MarkItemsReadOlderThan(MarkFilter{}, olderThan)
+	if have := getItemGuids(db.ListItems(ItemFilter{Status: &starred}, 10, false, false)); len(stars) == 0 || !reflect.DeepEqual(have, stars) {
+		t.Fatalf("expected the starred items kept, want %#v, have %#v", stars, have)
+	}
+}
+
 func TestDeleteOldItems(t *testing.T) {
 	extraItems := 10
 
@@ -328,3 +357,205 @@ func TestDeleteOldItems(t *testing.T) {
 		)
 	}
 }
+
+func TestDeleteOldItemsRetention(t *testing.T) {
+	now := time.Now().UTC()
+	db := testDB()
+	createFeed := func(name string, settings FeedSettings, arrived time.Time) *Feed {
+		feed := db.CreateFeed(name, "", "", "http://test.com/"+name+".xml", nil)
+		db.UpdateFeedSettings(feed.Id, settings)
+		items := make([]Item, 0)
+		for i := 0; i < 60; i++ {
+			istr := strconv.Itoa(i)
+			items = append(items, Item{GUID: istr, FeedId: feed.Id, Date: now.Add(time.Hour * time.Duration(i))})
+		}
+		db.CreateItems(items)
+		db.SetFeedSize(feed.Id, 10)
+		db.db.Exec(`update items set date_arrived = ? where feed_id = ?`, arrived, feed.Id)
+		return feed
+	}
+	forever := createFeed("forever", FeedSettings{RetentionDays: -1}, now.AddDate(0, 0, -100))
+	week := createFeed("week", FeedSettings{RetentionDays: 7}, now.AddDate(0, 0, -10))
+	capped := createFeed("capped", FeedSettings{RetentionMaxItems: 20}, now)
+	global := createFeed("global", FeedSettings{}, now.AddDate(0, 0, -10))
+	db.UpdateSettings(map[string]interface{}{"retention_days": 30, "retention_max_items": 55})
+
+	// the oldest one
+	starred := db.ListItems(ItemFilter{FeedID: &week.Id}, 1, false, false)[0]
+	db.UpdateItemStatus(starred.Id, STARRED)
+
+	db.DeleteOldItems()
+	testcases := []struct {
+		feed *Feed
+		want int
+	}{
+		{forever, 55},
+		{week, itemsKeepSize + 1},
+		{capped, 20},
+		{global, 55},
+	}
+	for _, tc := range testcases {
+		if count := db.CountItems(ItemFilter{FeedID: &tc.feed.Id}); count != tc.want {
+			t.Errorf("%s: expected %d items kept, got %d", tc.feed.Title, tc.want, count)
+		}
+	}
+	if db.GetItem(starred.Id) == nil {
+		t.Fatal("expected the starred item kept")
+	}
+}
+
+func TestItemSnippet(t *testing.T) {
+	db := testDB()
+	feed := db.CreateFeed("feed", "", "", "http://test.com/feed.xml", nil)
+	db.CreateItems([]Item{
+		{GUID: "item1", FeedId: feed.Id, Title: "title1", Content: "<p>Teaser &amp; more</p><p>Read on</p>"},
+	})
+	item := db.ListItems(ItemFilter{FeedID: &feed.Id}, 1, true, false)[0]
+	if item.Snippet != "Teaser & more Read on" {
+		t.Fatalf("invalid snippet: %#v", item.Snippet)
+	}
+
+	db.SyncSearch()
+	db.UpdateItemFullContent(item.Id, "<article><p>The whole story</p></article>")
+	if snippet := db.GetItem(item.Id).Snippet; snippet != "The whole story" {
+		t.Fatalf("snippet not regenerated: %#v", snippet)
+	}
+	var description string
+	err := db.db.QueryRow(`
+		select description from search
+		where rowid = (select search_rowid from items where id = ?)`, item.Id,
+	).Scan(&description)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if description != "The whole story" {
+		t.Fatalf("search description not updated: %#v", description)
+	}
+}
+
+func TestCreateItemsChunked(t *testing.T) {
+	db := testDB()
+	feed := db.CreateFeed("feed", "", "", "http://test.com/feed.xml", nil)
+	// fails the chunk it lands in
+	db.db.Exec(`
+		create trigger fail_item before insert on items when new.guid = 'bad'
+		begin select raise(abort, 'bad item'); end`)
+
+	now := time.Now()
+	items := make([]Item, 0)
+	for i := 0; i < 3*createItemsChunkSize; i++ {
+		items = append(items, Item{GUID: "item" + strconv.Itoa(i), FeedId: feed.Id, Date: now.Add(time.Duration(i) * time.Second)})
+	}
+	items[0].GUID = "bad"
+	created := db.CreateItems(items)
+	if len(created) != 2*createItemsChunkSize || created[0].GUID != "item"+strconv.Itoa(createItemsChunkSize) {
+		t.Fatalf("expected the chunks after the failed one stored, got %d", len(created))
+	}
+	if count := db.CountItems(ItemFilter{FeedID: &feed.Id}); count != 2*createItemsChunkSize {
+		t.Fatalf("expected the failed chunk rolled back, got %d items", count)
+	}
+
+	created = db.CreateItems([]Item{{GUID: "bad", FeedId: feed.Id}})
+	if created != nil {
+		t.Fatalf("expected nil if nothing stored, got %#v", created)
+	}
+}
+
+func TestUpdateChangedItems(t *testing.T) {
+	db := testDB()
+	feed := db.CreateFeed("feed", "", "", "http://test.com/feed.xml", nil)
+	date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	image := "http://test.com/image.png"
+	item := db.CreateItems([]Item{
+		{GUID: "item1", FeedId: feed.Id, Title: "Tpyo", Content: "<p>first draft</p>", Date: date, ImageURL: &image},
+	})[0]
+	db.UpdateItemStatus(item.Id, STARRED)
+	db.SyncSearch()
+
+	if updated := db.UpdateChangedItems([]Item{
+		{GUID: "item1", FeedId: feed.Id, Title: "Tpyo", Content: "<p>first draft</p>"},
+		{GUID: "item2", FeedId: feed.Id, Title: "New"},
+	}); len(updated) != 0 {
+		t.Fatalf("expected nothing updated, got %#v", updated)
+	}
+	updated := db.UpdateChangedItems([]Item{
+		{GUID: "item1", FeedId: feed.Id, Title: "Typo", Content: "<p>expanded version</p>", Date: time.Now()},
+	})
+	if len(updated) != 1 || updated[0].Id != item.Id {
+		t.Fatalf("expected the item updated, got %#v", updated)
+	}
+	stored := db.GetItem(item.Id)
+	if stored.Title != "Typo" || stored.Content != "<p>expanded version</p>" || stored.Snippet != "expanded version" {
+		t.Fatalf("expected the content updated, got %#v", stored)
+	}
+	if stored.Status != STARRED || !stored.Date.Equal(date) || stored.ImageURL == nil || *stored.ImageURL != image {
+		t.Fatalf("expected the status, date and image kept, got %#v", stored)
+	}
+
+	db.SyncSearch()
+	search := func(query string) int {
+		return len(db.ListItems(ItemFilter{Search: &query}, 10, false, false))
+	}
+	if search("expanded") != 1 || search("draft") != 0 {
+		t.Fatal("expected the search index updated")
+	}
+}
+
+func TestListItemsMixedTimezones(t *testing.T) {
+	db := testDB()
+	feed := db.CreateFeed("feed", "", "", "http://test.com/feed.xml", nil)
+
+	cet := time.FixedZone("CET", 3600)
+	est := time.FixedZone("EST", -5*3600)
+	db.CreateItems([]Item{
+		// 13:00 UTC
+		{GUID: "cet", FeedId: feed.Id, Title: "cet", Date: time.Date(2021, 1, 1, 14, 0, 0, 0, cet)},
+		// 15:00 UTC
+		{GUID: "utc", FeedId: feed.Id, Title: "utc", Date: time.Date(2021, 1, 1, 15, 0, 0, 0, time.UTC)},
+		// 14:00 UTC
+		{GUID: "est", FeedId: feed.Id, Title: "est", Date: time.Date(2021, 1, 1, 9, 0, 0, 0, est)},
+	})
+
+	have := make([]string, 0)
+	for _, item := range db.ListItems(ItemFilter{FeedID: &feed.Id}, 10, false, false) {
+		have = append(have, item.GUID)
+		if item.Date.Location() != time.UTC {
+			t.Errorf("%s: expected the date in UTC, got %s", item.GUID, item.Date)
+		}
+	}
+	want := []string{"cet", "est", "utc"}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("invalid order\nwant: %v\nhave: %v", want, have)
+	}
+}
+
+func TestRandomItem(t *testing.T) {
+	db := testDB()
+	scope := testItemsSetup(db)
+	unread := UNREAD
+
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		item := db.RandomItem(ItemFilter{Status: &unread}, i%2 == 0)
+		if item == nil || item.Status != UNREAD {
+			t.Fatalf("expected an unread item, got %#v", item)
+		}
+		seen[item.GUID] = true
+	}
+	if want := map[string]bool{"item111": true, "item121": true, "item011": true}; !reflect.DeepEqual(seen, want) {
+		t.Fatalf("expected every unread item to come up\nwant: %v\nhave: %v", want, seen)
+	}
+
+	for i := 0; i < 20; i++ {
+		item := db.RandomItem(ItemFilter{Status: &unread, FolderID: &scope.folder1.Id}, false)
+		if item == nil || (item.GUID != "item111" && item.GUID != "item121") {
+			t.Fatalf("unexpected item: %#v", item)
+		}
+	}
+	if item := db.RandomItem(ItemFilter{Status: &unread, FeedID: &scope.feed21.Id}, false); item != nil {
+		t.Fatalf("expected no item, got %#v", item)
+	}
+	if item := testDB().RandomItem(ItemFilter{}, false); item != nil {
+		t.Fatalf("expected no item in the empty db, got %#v", item)
+	}
+}
@@ -2,9 +2,13 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"time"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/logger"
 )
 
 var migrations = []func(*sql.Tx) error{
@@ -17,6 +21,30 @@ var migrations = []func(*sql.Tx) error{
 	m07_add_feed_size,
 	m08_normalize_datetime,
     m09_change_item_index,
+	m10_item_full_content,
+	m11_integrations,
+	m12_feed_settings,
+	m13_item_snippet,
+	m14_feed_health,
+	m15_item_duplicates,
+	m16_saved_searches,
+	m17_feed_transfers,
+	m18_image_cache,
+	m19_import_state,
+	m20_feed_suspended,
+	m21_feed_cooldowns,
+	m22_feed_refresh_stats,
+	m23_feed_gone,
+	m24_feed_unstable_guids,
+	m25_http_state_body_hash,
+	m26_feed_icon_fetched,
+	m27_feed_error_kind,
+	m28_feed_error_history,
+	m29_feed_cookies,
+	m30_http_freshness,
+	m31_feed_link_credentials,
+	m32_page_cache,
+	m33_feed_https_checked,
 }
 
 var maxVersion = int64(len(migrations))
@@ -31,7 +59,7 @@ func migrate(db *sql.DB) error {
 		return nil
 	}
 
-	log.Printf("db version is %d. migrating to %d", version, maxVersion)
+	logger.Infof("db version is %d. migrating to %d", version, maxVersion)
 
 	for v := version + 1; v <= maxVersion; v++ {
 		// Migrations altering schema using a sequence of steps due to SQLite limitations.
@@ -40,7 +68,7 @@ func migrate(db *sql.DB) error {
 		// https://www.sqlite.org/lang_altertable.html
 		trickyAlteration := (v == 3)
 
-		log.Printf("[migration:%d] starting", v)
+		logger.Debugf("[migration:%d] starting", v)
 
 		if trickyAlteration {
 			db.Exec("pragma foreign_keys=off;")
@@ -56,7 +84,7 @@ func migrate(db *sql.DB) error {
 			return err
 		}
 
-		log.Printf("[migration:%d] done", v)
+		logger.Infof("[migration:%d] done", v)
 	}
 	return nil
 }
@@ -306,3 +334,377 @@ func m09_change_item_index(tx *sql.Tx) error {
 	_, err := tx.Exec(sql)
 	return err
 }
+
+func m10_item_full_content(tx *sql.Tx) error {
+	sql := `
+		alter table items add column full_content text;
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m11_integrations(tx *sql.Tx) error {
+	sql := `
+		create table if not exists integrations (
+		 id             integer primary key autoincrement,
+		 kind           text not null,
+		 title          text not null,
+		 endpoint       text not null,
+		 credentials    blob,
+		 save_starred   boolean not null default false
+		);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m12_feed_settings(tx *sql.Tx) error {
+	sql := `
+		create table if not exists feed_settings (
+		 feed_id        references feeds(id) on delete cascade unique,
+		 settings       blob not null
+		);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m13_item_snippet(tx *sql.Tx) error {
+	_, err := tx.Exec(`alter table items add column snippet text not null default ''`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`
+		select id, case when ifnull(full_content, '') != '' then full_content else ifnull(content, '') end
+		from items
+	`)
+	if err != nil {
+		return err
+	}
+	snippets := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var content string
+		if err = rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		snippets[id] = htmlutil.Snippet(content, snippetLength)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, snippet := range snippets {
+		if _, err = tx.Exec(`update items set snippet = ? where id = ?`, snippet, id); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`
+		update search set description = (select snippet from items where search_rowid = search.rowid)
+		where rowid in (select search_rowid from items)
+	`)
+	return err
+}
+
+func m14_feed_health(tx *sql.Tx) error {
+	sql := `
+		create table if not exists feed_health (
+		 feed_id        references feeds(id) on delete cascade unique,
+		 last_status    integer not null default 0,
+		 final_url      text not null default '',
+		 parked         boolean not null default false,
+		 failures       integer not null default 0,
+		 failing_since  datetime
+		);
+
+		create table if not exists dead_feeds (
+		 feed_id        references feeds(id) on delete cascade unique,
+		 reasons        text not null,
+		 last_item_date datetime,
+		 last_status    integer not null default 0,
+		 final_url      text not null default '',
+		 failures       integer not null default 0,
+		 failing_since  datetime,
+		 reported_at    datetime not null
+		);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m15_item_duplicates(tx *sql.Tx) error {
+	sql := `
+		create table if not exists item_duplicates (
+		 item_id        references items(id) on delete cascade unique,
+		 group_id       integer
+		);
+
+		create index if not exists idx_item_duplicates_group_id on item_duplicates(group_id);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m16_saved_searches(tx *sql.Tx) error {
+	sql := `
+		create table if not exists saved_searches (
+		 id             integer primary key autoincrement,
+		 name           text not null,
+		 query          text not null,
+		 show_count     boolean not null default false
+		);
+
+		create table if not exists search_history (
+		 query          text not null unique,
+		 searched_at    datetime not null
+		);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m17_feed_transfers(tx *sql.Tx) error {
+	sql := `
+		create table if not exists feed_transfers (
+		 feed_id        references feeds(id) on delete cascade,
+		 day            date not null,
+		 bytes          integer not null default 0,
+		 requests       integer not null default 0,
+		 unique (feed_id, day)
+		);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m18_image_cache(tx *sql.Tx) error {
+	sql := `
+		create table if not exists image_cache (
+		 key            text primary key,
+		 content_type   text not null,
+		 data           blob not null,
+		 size           integer not null,
+		 accessed_at    datetime not null
+		);
+
+		create table if not exists item_images (
+		 item_id        references items(id) on delete cascade,
+		 url            text not null,
+		 key            text not null,
+		 unique (item_id, url)
+		);
+
+		create index if not exists idx_item_images_key on item_images(key);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m19_import_state(tx *sql.Tx) error {
+	sql := `
+		create table if not exists import_state (
+		 source         text primary key,
+		 stage          text not null,
+		 cursor         text not null default '',
+		 since          datetime not null
+		);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m20_feed_suspended(tx *sql.Tx) error {
+	sql := `
+		alter table feeds add column suspended boolean not null default false;
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m21_feed_cooldowns(tx *sql.Tx) error {
+	sql := `
+		create table if not exists feed_cooldowns (
+		 feed_id        references feeds(id) on delete cascade unique,
+		 until          datetime not null
+		);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m22_feed_refresh_stats(tx *sql.Tx) error {
+	sql := `
+		alter table feeds add column last_refreshed datetime;
+		alter table feeds add column last_refresh_duration integer not null default 0;
+		alter table feeds add column last_new_items integer not null default 0;
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m23_feed_gone(tx *sql.Tx) error {
+	sql := `
+		alter table feeds add column gone boolean not null default false;
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m24_feed_unstable_guids(tx *sql.Tx) error {
+	// null until detected
+	sql := `
+		alter table feeds add column unstable_guids boolean;
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m25_http_state_body_hash(tx *sql.Tx) error {
+	sql := `
+		alter table http_states add column body_hash text not null default '';
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m26_feed_icon_fetched(tx *sql.Tx) error {
+	sql := `
+		alter table feeds add column icon_fetched datetime;
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m27_feed_error_kind(tx *sql.Tx) error {
+	sql := `
+		alter table feed_errors add column kind text not null default '';
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m28_feed_error_history(tx *sql.Tx) error {
+	sql := `
+		create table if not exists feed_error_history (
+			id       integer primary key autoincrement,
+			feed_id  references feeds(id) on delete cascade,
+			time     datetime not null,
+			status   integer not null default 0,
+			kind     text not null default '',
+			error    text not null,
+			failures integer not null default 0
+		);
+
+		create index if not exists idx_feed_error_history_feed_id on feed_error_history(feed_id);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m29_feed_cookies(tx *sql.Tx) error {
+	sql := `
+		alter table feeds add column cookies text not null default '';
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m30_http_freshness(tx *sql.Tx) error {
+	sql := `
+		alter table http_states add column max_age integer not null default 0;
+		alter table http_states add column ttl integer not null default 0;
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+// the credentials embedded in the feed links go to the feed settings,
+// the feeds already subscribed to without them are left as they are
+func m31_feed_link_credentials(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		select f.id, f.feed_link, coalesce(s.settings, '{}')
+		from feeds f left join feed_settings s on s.feed_id = f.id
+		where f.feed_link like '%@%'`)
+	if err != nil {
+		return err
+	}
+	type feedLink struct {
+		id       int64
+		link     string
+		settings FeedSettings
+	}
+	links := make([]feedLink, 0)
+	for rows.Next() {
+		var f feedLink
+		var val []byte
+		if err = rows.Scan(&f.id, &f.link, &val); err != nil {
+			rows.Close()
+			return err
+		}
+		if err = json.Unmarshal(val, &f.settings); err != nil {
+			rows.Close()
+			return err
+		}
+		links = append(links, f)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, f := range links {
+		link, settings := StripCredentials(f.link, f.settings)
+		if link == f.link {
+			continue
+		}
+		var taken bool
+		err = tx.QueryRow(`select exists (select 1 from feeds where feed_link = ?)`, link).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			continue
+		}
+		val, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`update feeds set feed_link = ? where id = ?`, link, f.id); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			insert into feed_settings (feed_id, settings) values (?, ?)
+			on conflict (feed_id) do update set settings = excluded.settings`,
+			f.id, val,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func m32_page_cache(tx *sql.Tx) error {
+	sql := `
+		create table if not exists page_cache (
+		 url            text primary key,
+		 etag           text not null,
+		 last_modified  text not null,
+		 content_type   text not null,
+		 body           blob not null,
+		 size           integer not null,
+		 accessed_at    datetime not null
+		);
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
+
+func m33_feed_https_checked(tx *sql.Tx) error {
+	sql := `
+		alter table feeds add column https_checked datetime;
+	`
+	_, err := tx.Exec(sql)
+	return err
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// CachedPage is the article page fetched for its full content, kept along
+// with its validators to be fetched again conditionally.
+type CachedPage struct {
+	ETag         string
+	LastModified string
+	ContentType  string
+	Body         []byte
+}
+
+// CachePage stores the page fetched from the url, replacing the previous one.
+func (s *Storage) CachePage(url string, page CachedPage) bool {
+	_, err := s.db.Exec(`
+		insert into page_cache (url, etag, last_modified, content_type, body, size, accessed_at)
+		values (?, ?, ?, ?, ?, ?, ?)
+		on conflict (url) do update set
+			etag = excluded.etag,
+			last_modified = excluded.last_modified,
+			content_type = excluded.content_type,
+			body = excluded.body,
+			size = excluded.size,
+			accessed_at = excluded.accessed_at`,
+		url, page.ETag, page.LastModified, page.ContentType, page.Body, len(page.Body), time.Now().UTC(),
+	)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return true
+}
+
+// GetCachedPage returns the page fetched from the url and marks it as recently used.
+func (s *Storage) GetCachedPage(url string) *CachedPage {
+	var page CachedPage
+	err := s.db.QueryRow(
+		`select etag, last_modified, content_type, body from page_cache where url = ?`, url,
+	).Scan(&page.ETag, &page.LastModified, &page.ContentType, &page.Body)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Print(err)
+		}
+		return nil
+	}
+	if _, err = s.db.Exec(`update page_cache set accessed_at = ? where url = ?`, time.Now().UTC(), url); err != nil {
+		log.Print(err)
+	}
+	return &page
+}
+
+// EvictCachedPages drops the least recently used pages until the cache
+// fits in maxSize bytes and returns the number of pages deleted.
+func (s *Storage) EvictCachedPages(maxSize int64) int64 {
+	var total int64
+	if err := s.db.QueryRow(`select coalesce(sum(size), 0) from page_cache`).Scan(&total); err != nil {
+		log.Print(err)
+		return 0
+	}
+	if total <= maxSize {
+		return 0
+	}
+
+	rows, err := s.db.Query(`select url, size from page_cache order by accessed_at, url`)
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	evict := make([]string, 0)
+	for rows.Next() && total > maxSize {
+		var url string
+		var size int64
+		if err = rows.Scan(&url, &size); err != nil {
+			log.Print(err)
+			rows.Close()
+			return 0
+		}
+		evict = append(evict, url)
+		total -= size
+	}
+	rows.Close()
+
+	for _, url := range evict {
+		if _, err = s.db.Exec(`delete from page_cache where url = ?`, url); err != nil {
+			log.Print(err)
+			return 0
+		}
+	}
+	return int64(len(evict))
+}
+
+// ClearPageCache drops all the cached pages.
+func (s *Storage) ClearPageCache() {
+	if _, err := s.db.Exec(`delete from page_cache`); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,35 @@
+package storage
+
+import "testing"
+
+func TestPageCache(t *testing.T) {
+	db := testDB()
+	if db.GetCachedPage("http://test.com/a") != nil {
+		t.Fatal("expected no page")
+	}
+	db.CachePage("http://test.com/a", CachedPage{ETag: `"a"`, ContentType: "text/html", Body: []byte("aaaa")})
+	db.CachePage("http://test.com/b", CachedPage{LastModified: "Mon, 02 Jan 2006 15:04:05 GMT", Body: []byte("bbbbbb")})
+	// replaced
+	db.CachePage("http://test.com/a", CachedPage{ETag: `"a2"`, ContentType: "text/html", Body: []byte("aaa")})
+
+	page := db.GetCachedPage("http://test.com/a")
+	if page == nil || page.ETag != `"a2"` || page.ContentType != "text/html" || string(page.Body) != "aaa" {
+		t.Fatalf("unexpected page: %#v", page)
+	}
+
+	// a used last
+	if n := db.EvictCachedPages(9); n != 0 {
+		t.Fatalf("expected nothing evicted, got %d", n)
+	}
+	if n := db.EvictCachedPages(8); n != 1 {
+		t.Fatalf("expected 1 page evicted, got %d", n)
+	}
+	if db.GetCachedPage("http://test.com/b") != nil || db.GetCachedPage("http://test.com/a") == nil {
+		t.Fatal("expected the least recently used page evicted")
+	}
+
+	db.ClearPageCache()
+	if db.GetCachedPage("http://test.com/a") != nil {
+		t.Fatal("expected the cache cleared")
+	}
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// searchHistorySize is the number of the recent queries kept.
+const searchHistorySize = 20
+
+type SavedSearch struct {
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	// show the number of the unread matching items
+	ShowCount bool `json:"show_count"`
+}
+
+func (s *Storage) CreateSavedSearch(name, query string, showCount bool) *SavedSearch {
+	row := s.db.QueryRow(`
+		insert into saved_searches (name, query, show_count)
+		values (?, ?, ?)
+		returning id`,
+		name, query, showCount,
+	)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		log.Print(err)
+		return nil
+	}
+	return &SavedSearch{Id: id, Name: name, Query: query, ShowCount: showCount}
+}
+
+func (s *Storage) UpdateSavedSearch(search SavedSearch) bool {
+	_, err := s.db.Exec(`
+		update saved_searches
+		set name = ?, query = ?, show_count = ?
+		where id = ?`,
+		search.Name, search.Query, search.ShowCount, search.Id,
+	)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+func (s *Storage) DeleteSavedSearch(id int64) bool {
+	_, err := s.db.Exec(`delete from saved_searches where id = ?`, id)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+func (s *Storage) GetSavedSearch(id int64) *SavedSearch {
+	var search SavedSearch
+	err := s.db.QueryRow(`
+		select id, name, query, show_count
+		from saved_searches where id = ?
+	`, id).Scan(&search.Id, &search.Name, &search.Query, &search.ShowCount)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Print(err)
+		}
+		return nil
+	}
+	return &search
+}
+
+func (s *Storage) ListSavedSearches() []SavedSearch {
+	result := make([]SavedSearch, 0)
+	rows, err := s.db.Query(`
+		select id, name, query, show_count
+		from saved_searches
+		order by name collate nocase
+	`)
+	if err != nil {
+		log.Print(err)
+		return result
+	}
+	for rows.Next() {
+		var search SavedSearch
+		if err = rows.Scan(&search.Id, &search.Name, &search.Query, &search.ShowCount); err != nil {
+			log.Print(err)
+			return result
+		}
+		result = append(result, search)
+	}
+	return result
+}
+
+// AddSearchHistory records the query as the most recent one,
+// dropping the oldest beyond `searchHistorySize`.
+func (s *Storage) AddSearchHistory(query string) bool {
+	_, err := s.db.Exec(`
+		insert into search_history (query, searched_at) values (?, ?)
+		on conflict (query) do update set searched_at = excluded.searched_at`,
+		query, time.Now().UTC(),
+	)
+	if err == nil {
+		_, err = s.db.Exec(`
+			delete from search_history where query not in (
+				select query from search_history order by searched_at desc limit ?
+			)`,
+			searchHistorySize,
+		)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
+
+// ListSearchHistory returns the recent queries, the latest first.
+func (s *Storage) ListSearchHistory() []string {
+	result := make([]string, 0)
+	rows, err := s.db.Query(`select query from search_history order by searched_at desc`)
+	if err != nil {
+		log.Print(err)
+		return result
+	}
+	for rows.Next() {
+		var query string
+		if err = rows.Scan(&query); err != nil {
+			log.Print(err)
+			return result
+		}
+		result = append(result, query)
+	}
+	return result
+}
+
+func (s *Storage) ClearSearchHistory() bool {
+	_, err := s.db.Exec(`delete from search_history`)
+	if err != nil {
+		log.Print(err)
+	}
+	return err == nil
+}
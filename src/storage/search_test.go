@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSavedSearches(t *testing.T) {
+	db := testDB()
+
+	kernel := db.CreateSavedSearch("kernel", "feed:LWN kernel", true)
+	db.CreateSavedSearch("Databases", "sqlite", false)
+	if kernel == nil || db.GetSavedSearch(kernel.Id) == nil {
+		t.Fatal("failed to create the saved search")
+	}
+
+	kernel.Query = "feed:lwn kernel"
+	if !db.UpdateSavedSearch(*kernel) {
+		t.Fatal("failed to update the saved search")
+	}
+	have := db.ListSavedSearches()
+	if len(have) != 2 || have[0].Name != "Databases" || have[1].Query != "feed:lwn kernel" || !have[1].ShowCount {
+		t.Fatalf("unexpected list: %#v", have)
+	}
+
+	db.DeleteSavedSearch(kernel.Id)
+	if db.GetSavedSearch(kernel.Id) != nil || len(db.ListSavedSearches()) != 1 {
+		t.Fatal("expected the saved search to be deleted")
+	}
+}
+
+func TestSearchQualifiers(t *testing.T) {
+	db := testDB()
+	folder := db.CreateFolder("Linux")
+	lwn := db.CreateFeed("LWN.net", "", "", "http://lwn.net/feed.xml", &folder.Id)
+	other := db.CreateFeed("Other", "", "", "http://other.com/feed.xml", nil)
+	db.CreateItems([]Item{
+		{GUID: "1", FeedId: lwn.Id, Title: "kernel release"},
+		{GUID: "2", FeedId: lwn.Id, Title: "python release"},
+		{GUID: "3", FeedId: other.Id, Title: "kernel news"},
+	})
+	db.SyncSearch()
+
+	for query, want := range map[string][]string{
+		"kernel":                {"1", "3"},
+		"feed:lwn kernel":       {"1"},
+		"feed:LWN":              {"1", "2"},
+		"folder:linux release":  {"1", "2"},
+		"folder:linux feed:oth": {},
+	} {
+		query := query
+		have := make([]string, 0)
+		for _, item := range db.ListItems(ItemFilter{Search: &query}, 10, false, false) {
+			have = append(have, item.GUID)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%q\nwant: %v\nhave: %v", query, want, have)
+		}
+		if count := db.CountItems(ItemFilter{Search: &query}); count != len(want) {
+			t.Errorf("%q: expected count %d, got %d", query, len(want), count)
+		}
+	}
+}
+
+func TestSearchHistory(t *testing.T) {
+	db := testDB()
+
+	for i := 0; i < searchHistorySize+5; i++ {
+		db.AddSearchHistory(fmt.Sprintf("query %d", i))
+	}
+	db.AddSearchHistory("query 10")
+
+	have := db.ListSearchHistory()
+	if len(have) != searchHistorySize {
+		t.Fatalf("expected %d queries, got %d", searchHistorySize, len(have))
+	}
+	if have[0] != "query 10" || have[1] != fmt.Sprintf("query %d", searchHistorySize+4) {
+		t.Fatalf("unexpected order: %v", have)
+	}
+
+	db.ClearSearchHistory()
+	if have := db.ListSearchHistory(); len(have) != 0 {
+		t.Fatalf("expected the history to be cleared, got %v", have)
+	}
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 )
 
@@ -16,6 +18,40 @@ func settingsDefaults() map[string]interface{} {
 		"theme_font":        "",
 		"theme_size":        1,
 		"refresh_rate":      0,
+		"refresh_spread":    false,
+		"workers":           0,
+		"requests_per_host": 1,
+		"image_proxy":       false,
+		"iframe_hosts":      "",
+		"strip_trackers":    true,
+		"notifications":     true,
+		"quiet_hours_start": "",
+		"quiet_hours_end":   "",
+
+		"dead_feed_inactive_months": 6,
+		"dead_feed_failing_weeks":   4,
+		"suspend_after_failures":    30,
+
+		"favicon_refresh_days": 30,
+
+		"ignore_freshness_hints": false,
+
+		"circuit_breaker_failures": 3,
+
+		"https_upgrade": true,
+
+		"retention_days":      itemsKeepDays,
+		"retention_max_items": 0,
+
+		"max_items_per_refresh": 0,
+		"max_item_age_days":     0,
+		"first_fetch_read_days": 0,
+
+		"group_similar_titles":    false,
+		"similar_title_threshold": 90,
+
+		"offline_images":        false,
+		"offline_images_max_mb": 256,
 	}
 }
 
@@ -44,7 +80,16 @@ func (s *Storage) GetSettingsValueInt64(key string) int64 {
 			return int64(fval)
 		}
 	}
-	return 0
+	def, _ := settingsDefaults()[key].(int)
+	return int64(def)
+}
+
+func (s *Storage) GetSettingsValueBool(key string) bool {
+	if val, ok := s.GetSettingsValue(key).(bool); ok {
+		return val
+	}
+	val, _ := settingsDefaults()[key].(bool)
+	return val
 }
 
 func (s *Storage) GetSettings() map[string]interface{} {
@@ -92,3 +137,97 @@ func (s *Storage) UpdateSettings(kv map[string]interface{}) bool {
 	}
 	return true
 }
+
+// ValidateSettings checks that all the keys are known
+// and the values have the same type as the defaults.
+func ValidateSettings(kv map[string]interface{}) error {
+	defaults := settingsDefaults()
+	for key, val := range kv {
+		def, ok := defaults[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %#v", key)
+		}
+		valid := false
+		switch def.(type) {
+		case string:
+			_, valid = val.(string)
+		case bool:
+			_, valid = val.(bool)
+		case int, float64:
+			_, valid = val.(float64)
+		}
+		if !valid {
+			return fmt.Errorf("invalid value for setting %#v", key)
+		}
+	}
+	return nil
+}
+
+// ImportSettings stores the settings and the integrations in one go.
+// Integrations with a non-zero id replace the existing ones,
+// keeping the stored credentials if none provided.
+func (s *Storage) ImportSettings(kv map[string]interface{}, integrations []Integration) bool {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	if err = importSettings(tx, kv, integrations); err != nil {
+		log.Print(err)
+		if err = tx.Rollback(); err != nil {
+			log.Print(err)
+		}
+		return false
+	}
+	if err = tx.Commit(); err != nil {
+		log.Print(err)
+		return false
+	}
+	return true
+}
+
+func importSettings(tx *sql.Tx, kv map[string]interface{}, integrations []Integration) error {
+	for key, val := range kv {
+		valEncoded, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			insert into settings (key, val) values (?, ?)
+			on conflict (key) do update set val=?`,
+			key, valEncoded, valEncoded,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, i := range integrations {
+		credentialsEncoded, err := json.Marshal(i.Credentials)
+		if err != nil {
+			return err
+		}
+		if i.Id == 0 {
+			_, err = tx.Exec(`
+				insert into integrations (kind, title, endpoint, credentials, save_starred)
+				values (?, ?, ?, ?, ?)`,
+				i.Kind, i.Title, i.Endpoint, credentialsEncoded, i.SaveStarred,
+			)
+		} else if len(i.Credentials) == 0 {
+			_, err = tx.Exec(`
+				update integrations set title = ?, endpoint = ?, save_starred = ?
+				where id = ?`,
+				i.Title, i.Endpoint, i.SaveStarred, i.Id,
+			)
+		} else {
+			_, err = tx.Exec(`
+				update integrations set title = ?, endpoint = ?, credentials = ?, save_starred = ?
+				where id = ?`,
+				i.Title, i.Endpoint, credentialsEncoded, i.SaveStarred, i.Id,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
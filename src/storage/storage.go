@@ -2,10 +2,10 @@ package storage
 
 import (
 	"database/sql"
-	"log"
 	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/nkanaev/yarr/src/logger"
 )
 
 type Storage struct {
@@ -15,7 +15,7 @@ type Storage struct {
 func New(path string) (*Storage, error) {
 	if pos := strings.IndexRune(path, '?'); pos == -1 {
         params := "_journal=WAL&_sync=NORMAL&_busy_timeout=5000&cache=shared"
-        log.Printf("opening db with params: %s", params)
+        logger.Debugf("opening db with params: %s", params)
         path = path + "?" + params
     }
 
@@ -29,3 +29,7 @@ func New(path string) (*Storage, error) {
 	}
 	return &Storage{db: db}, nil
 }
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
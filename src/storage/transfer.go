@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"log"
+	"time"
+)
+
+// the daily transfer totals are kept for that many days
+var transfersKeepDays = 90
+
+// FeedTransfer is the traffic of the feed over a period.
+type FeedTransfer struct {
+	FeedID   int64 `json:"feed_id"`
+	Bytes    int64 `json:"bytes"`
+	Requests int64 `json:"requests"`
+}
+
+// AddFeedTransfer adds the bytes downloaded for the feed to the day's total.
+func (s *Storage) AddFeedTransfer(feedID int64, bytes int64, at time.Time) {
+	_, err := s.db.Exec(`
+		insert into feed_transfers (feed_id, day, bytes, requests)
+		values (?, ?, ?, 1)
+		on conflict (feed_id, day) do update set
+			bytes = bytes + excluded.bytes,
+			requests = requests + 1`,
+		feedID, at.UTC().Format("2006-01-02"), bytes,
+	)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// ListFeedTransfers returns the totals of the feeds since the day given,
+// the most expensive first.
+func (s *Storage) ListFeedTransfers(since time.Time) []FeedTransfer {
+	result := make([]FeedTransfer, 0)
+	rows, err := s.db.Query(`
+		select feed_id, sum(bytes), sum(requests)
+		from feed_transfers
+		where day >= ?
+		group by feed_id
+		order by sum(bytes) desc, feed_id
+	`, since.UTC().Format("2006-01-02"))
+	if err != nil {
+		log.Print(err)
+		return result
+	}
+	for rows.Next() {
+		var t FeedTransfer
+		if err = rows.Scan(&t.FeedID, &t.Bytes, &t.Requests); err != nil {
+			log.Print(err)
+			return result
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// DeleteOldTransfers drops the daily totals older than `transfersKeepDays`
+// and returns the number of rows deleted.
+func (s *Storage) DeleteOldTransfers() int64 {
+	before := time.Now().UTC().AddDate(0, 0, -transfersKeepDays).Format("2006-01-02")
+	res, err := s.db.Exec(`delete from feed_transfers where day < ?`, before)
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
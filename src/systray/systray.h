@@ -14,4 +14,5 @@ void add_or_update_menu_item(int menuId, int parentMenuId, char* title, char* to
 void add_separator(int menuId);
 void hide_menu_item(int menuId);
 void show_menu_item(int menuId);
+void show_notification(char* title, char* message);
 void quit();
@@ -208,6 +208,17 @@ NSMenuItem *find_menu_item(NSMenu *ourMenu, NSNumber *menuId) {
   [NSApp terminate:self];
 }
 
+#pragma clang diagnostic push
+#pragma clang diagnostic ignored "-Wdeprecated-declarations"
+- (void)show_notification:(NSArray*)args
+{
+  NSUserNotification *notification = [[NSUserNotification alloc] init];
+  notification.title = args[0];
+  notification.informativeText = args[1];
+  [[NSUserNotificationCenter defaultUserNotificationCenter] deliverNotification:notification];
+}
+#pragma clang diagnostic pop
+
 @end
 
 void registerSystray(void) {
@@ -288,6 +299,16 @@ void show_menu_item(int menuId) {
   runInMainThread(@selector(show_menu_item:), (id)mId);
 }
 
+void show_notification(char* ctitle, char* cmessage) {
+  NSString* title = [[NSString alloc] initWithCString:ctitle
+                                             encoding:NSUTF8StringEncoding];
+  NSString* message = [[NSString alloc] initWithCString:cmessage
+                                               encoding:NSUTF8StringEncoding];
+  free(ctitle);
+  free(cmessage);
+  runInMainThread(@selector(show_notification:), (id)@[title, message]);
+}
+
 void quit() {
   runInMainThread(@selector(quit), nil);
 }
@@ -45,6 +45,11 @@ func SetTooltip(tooltip string) {
 	C.setTooltip(C.CString(tooltip))
 }
 
+// ShowNotification shows the native notification with the title and the message.
+func ShowNotification(title, message string) {
+	C.show_notification(C.CString(title), C.CString(message))
+}
+
 func addOrUpdateMenuItem(item *MenuItem) {
 	var disabled C.short
 	if item.disabled {
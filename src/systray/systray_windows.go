@@ -243,6 +243,36 @@ func (t *winTray) setTooltip(src string) error {
 	return t.nid.modify()
 }
 
+// Shows the balloon notification next to the icon.
+// Shell_NotifyIcon: https://msdn.microsoft.com/en-us/library/windows/desktop/bb762159(v=vs.85).aspx
+func (t *winTray) showNotification(title, message string) error {
+	const NIF_INFO = 0x00000010
+	const NIIF_INFO = 0x00000001
+	titleUTF16, err := windows.UTF16FromString(title)
+	if err != nil {
+		return err
+	}
+	messageUTF16, err := windows.UTF16FromString(message)
+	if err != nil {
+		return err
+	}
+
+	t.muNID.Lock()
+	defer t.muNID.Unlock()
+	t.nid.InfoTitle = [64]uint16{}
+	t.nid.Info = [256]uint16{}
+	copy(t.nid.InfoTitle[:len(t.nid.InfoTitle)-1], titleUTF16)
+	copy(t.nid.Info[:len(t.nid.Info)-1], messageUTF16)
+	t.nid.InfoFlags = NIIF_INFO
+	t.nid.Flags |= NIF_INFO
+	t.nid.Size = uint32(unsafe.Sizeof(*t.nid))
+
+	err = t.nid.modify()
+	// otherwise the balloon is shown again on the next icon update
+	t.nid.Flags &^= NIF_INFO
+	return err
+}
+
 var wt winTray
 
 // WindowProc callback function that processes messages sent to a window.
@@ -903,6 +933,14 @@ func SetTooltip(tooltip string) {
 	}
 }
 
+// ShowNotification shows the native notification with the title and the message.
+func ShowNotification(title, message string) {
+	if err := wt.showNotification(title, message); err != nil {
+		log.Printf("Unable to show notification: %v", err)
+		return
+	}
+}
+
 func addOrUpdateMenuItem(item *MenuItem) {
 	err := wt.addOrUpdateMenuItem(uint32(item.id), item.parentId(), item.title, item.disabled, item.checked)
 	if err != nil {
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// circuitBreaker stops fetching from the hosts down during a refresh: once
+// a host fails `threshold` times in a row, the rest of its feeds fail at once
+// instead of each waiting for the timeout. The next refresh tries the host
+// again, the first failure opening it straight away.
+type circuitBreaker struct {
+	mu sync.Mutex
+	// 0 if off
+	threshold int
+	hosts     map[string]*hostCircuit
+}
+
+type hostCircuit struct {
+	// the failures in a row
+	failures int
+	open     bool
+}
+
+// circuitOpenError is the request not made, the host having failed already.
+type circuitOpenError struct {
+	host     string
+	failures int
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("%s failed %d times in a row during this refresh, trying again on the next one", e.host, e.failures)
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{hosts: make(map[string]*hostCircuit)}
+}
+
+// reset starts the next refresh: the open hosts are half-open,
+// the others start over.
+func (b *circuitBreaker) reset(threshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = threshold
+	for host, circuit := range b.hosts {
+		if !circuit.open || threshold <= 0 {
+			delete(b.hosts, host)
+			continue
+		}
+		circuit.open = false
+		circuit.failures = threshold - 1
+	}
+}
+
+// allow returns the error the request fails with if the host's circuit is open.
+func (b *circuitBreaker) allow(host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if circuit, ok := b.hosts[host]; ok && circuit.open {
+		return &circuitOpenError{host: host, failures: circuit.failures}
+	}
+	return nil
+}
+
+// record counts the host's failure or, if it answered, forgets the previous ones.
+func (b *circuitBreaker) record(host string, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 {
+		return
+	}
+	if !failed {
+		delete(b.hosts, host)
+		return
+	}
+	circuit, ok := b.hosts[host]
+	if !ok {
+		circuit = &hostCircuit{}
+		b.hosts[host] = circuit
+	}
+	circuit.failures++
+	if circuit.failures >= b.threshold {
+		circuit.open = true
+	}
+}
+
+// hostFailed tells whether the request failed because of the host being down
+// (not reachable or its gateway failing) rather than of the feed itself.
+func hostFailed(err error, res *http.Response) bool {
+	if fetchErr, ok := err.(*FetchError); ok {
+		switch fetchErr.Kind {
+		case FetchDNS, FetchTimeout, FetchConnRefused, FetchOther:
+			return true
+		}
+		return false
+	}
+	if res != nil {
+		switch res.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// isCircuitOpen tells whether the request wasn't made, its host failing.
+func isCircuitOpen(err error) bool {
+	var open *circuitOpenError
+	return errors.As(err, &open)
+}
+
+type circuitBreakerKey struct{}
+
+// withCircuitBreaker makes the requests made with the context skip the hosts
+// the breaker has given up on for now.
+func withCircuitBreaker(ctx context.Context, breaker *circuitBreaker) context.Context {
+	return context.WithValue(ctx, circuitBreakerKey{}, breaker)
+}
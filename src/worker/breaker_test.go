@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker()
+	b.reset(3)
+	for i := 0; i < 2; i++ {
+		b.record("example.com", true)
+	}
+	b.record("example.com", false)
+	b.record("example.com", true)
+	if b.allow("example.com") != nil {
+		t.Fatal("expected the success to start the count over")
+	}
+	b.record("example.com", true)
+	b.record("example.com", true)
+	if err := b.allow("example.com"); err == nil || err.Error() != "example.com failed 3 times in a row during this refresh, trying again on the next one" {
+		t.Fatalf("expected the circuit open, got %v", err)
+	}
+	if b.allow("example.org") != nil {
+		t.Fatal("expected the other hosts allowed")
+	}
+
+	// half-open
+	b.reset(3)
+	if b.allow("example.com") != nil {
+		t.Fatal("expected the host tried again on the next refresh")
+	}
+	b.record("example.com", true)
+	if b.allow("example.com") == nil {
+		t.Fatal("expected the circuit open after the first failure")
+	}
+	b.reset(3)
+	b.record("example.com", false)
+	b.reset(3)
+	b.record("example.com", true)
+	if b.allow("example.com") != nil {
+		t.Fatal("expected the circuit closed after the success")
+	}
+
+	b.reset(0)
+	for i := 0; i < 5; i++ {
+		b.record("example.com", true)
+	}
+	if b.allow("example.com") != nil {
+		t.Fatal("expected the breaker off")
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	requests := int64(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if r.URL.Path == "/up" {
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	c, err := newClient(ClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newCircuitBreaker()
+	b.reset(2)
+	ctx := withCircuitBreaker(context.Background(), b)
+	for i := 0; i < 2; i++ {
+		res, err := c.getContext(ctx, server.URL+"/down")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	_, err = c.getContext(ctx, server.URL+"/up")
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) || fetchErr.Kind != FetchCircuitOpen || !isCircuitOpen(err) ||
+		!strings.HasPrefix(err.Error(), "host skipped: 127.0.0.1 failed 2 times in a row") {
+		t.Fatalf("expected the host skipped, got %v", err)
+	}
+	if got := atomic.LoadInt64(&requests); got != 2 {
+		t.Fatalf("expected 2 requests, got %d", got)
+	}
+
+	// not without the breaker
+	res, err := c.getContext(context.Background(), server.URL+"/up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// the next refresh
+	b.reset(2)
+	res, err = c.getContext(ctx, server.URL+"/up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if b.allow("127.0.0.1") != nil {
+		t.Fatal("expected the circuit closed")
+	}
+}
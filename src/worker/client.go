@@ -1,52 +1,419 @@
 package worker
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
 )
 
 type Client struct {
 	httpClient *http.Client
 	userAgent  string
+
+	// the default proxy and client certificate (if any)
+	proxy func(*http.Request) (*url.URL, error)
+	cert  *clientCert
+
+	dial              dialFunc
+	network           string
+	maxRedirects      int
+	disableKeepAlives bool
+	// tracing the fetches of all the feeds
+	debug bool
+
+	mu sync.Mutex
+	// the transports of the feeds with their own proxy or client certificate
+	transports map[transportKey]*feedTransport
 }
 
 func (c *Client) get(url string) (*http.Response, error) {
-	return c.getConditional(url, "", "")
+	return c.getConditional(context.Background(), url, "", "")
 }
 
-func (c *Client) getConditional(url, lastModified, etag string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func (c *Client) getContext(ctx context.Context, url string) (*http.Response, error) {
+	return c.getConditional(ctx, url, "", "")
+}
+
+func (c *Client) getConditional(ctx context.Context, url, lastModified, etag string) (*http.Response, error) {
+	header := make(http.Header)
+	if lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	return c.do(ctx, url, header)
+}
+
+// do performs a GET request with the headers given,
+// using the default user agent unless the headers have one.
+func (c *Client) do(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	return c.request(ctx, "GET", url, header)
+}
+
+func (c *Client) request(ctx context.Context, method, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", c.userAgent)
-	if lastModified != "" {
-		req.Header.Set("If-Modified-Since", lastModified)
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
 	}
-	if etag != "" {
-		req.Header.Set("If-None-Match", etag)
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	// the ranges are of the encoded content, not asking for one
+	if req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && method != "HEAD" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	httpClient := c.httpClient
+	_, ownTimeout := ctx.Value(timeoutKey{}).(time.Duration)
+	jar, ownJar := ctx.Value(cookieJarKey{}).(http.CookieJar)
+	proxy, ownProxy := ctx.Value(proxyKey{}).(string)
+	certFiles, ownCert := ctx.Value(clientCertKey{}).(clientCertFiles)
+	network, ownNetwork := ctx.Value(networkKey{}).(string)
+	cert := c.cert
+	if ownTimeout || ownJar || ownProxy || ownCert || ownNetwork {
+		own := *c.httpClient
+		if ownTimeout {
+			// the context's deadline applies instead
+			own.Timeout = 0
+		}
+		if ownJar {
+			own.Jar = jar
+		}
+		if ownProxy || ownCert || ownNetwork {
+			transport, err := c.feedTransport(transportKey{proxy: proxy, cert: certFiles, network: network})
+			if err != nil {
+				return nil, err
+			}
+			own.Transport = transport.Transport
+			cert = transport.cert
+		}
+		httpClient = &own
+	}
+	// telling why rather than failing the handshake
+	if cert != nil && req.URL.Scheme == "https" {
+		if _, err := cert.load(); err != nil {
+			return nil, &FetchError{Kind: FetchTLS, URL: url, Err: err}
+		}
+	}
+	breaker, _ := ctx.Value(circuitBreakerKey{}).(*circuitBreaker)
+	host := strings.ToLower(req.URL.Hostname())
+	if breaker != nil {
+		if err := breaker.allow(host); err != nil {
+			return nil, &FetchError{Kind: FetchCircuitOpen, URL: url, Err: err}
+		}
+	}
+	start := time.Now()
+	res, err := httpClient.Do(req)
+	if err != nil {
+		// the chain tells where it went wrong rather than the url
+		var redirect *redirectError
+		if errors.As(err, &redirect) {
+			err = redirect
+		}
+		err = newFetchError(url, err)
+	}
+	// cancelled on stop, not the host's fault
+	if breaker != nil && !errors.Is(ctx.Err(), context.Canceled) {
+		breaker.record(host, hostFailed(err, res))
+	}
+	traceRequest(ctx, req, res, err, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	meterResponse(ctx, res)
+	decodeBody(res)
+	return res, nil
+}
+
+type timeoutKey struct{}
+
+// withTimeout limits the requests made with the context to the timeout
+// instead of the client's own one, be it shorter or longer.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return context.WithValue(ctx, timeoutKey{}, timeout), cancel
+}
+
+type cookieJarKey struct{}
+
+// withCookieJar makes the requests made with the context keep their cookies
+// in the jar, sending them along the redirects too.
+func withCookieJar(ctx context.Context, jar http.CookieJar) context.Context {
+	return context.WithValue(ctx, cookieJarKey{}, jar)
+}
+
+type proxyKey struct{}
+
+// WithProxy makes the requests made with the context go through the proxy
+// (http, https or socks5) instead of the default one. Empty changes nothing.
+func WithProxy(ctx context.Context, proxy string) context.Context {
+	if proxy == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, proxyKey{}, proxy)
+}
+
+type clientCertKey struct{}
+
+type clientCertFiles struct {
+	certFile string
+	keyFile  string
+}
+
+// WithClientCert makes the requests made with the context authenticate with
+// the certificate instead of the default one. Empty changes nothing.
+func WithClientCert(ctx context.Context, certFile, keyFile string) context.Context {
+	if certFile == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, clientCertKey{}, clientCertFiles{certFile: certFile, keyFile: keyFile})
+}
+
+// Network preferences of the connections.
+const (
+	NetworkAuto = "auto"
+	NetworkIPv4 = "ipv4"
+	NetworkIPv6 = "ipv6"
+)
+
+// ValidNetwork tells whether the network preference is one of the known ones.
+func ValidNetwork(network string) bool {
+	return network == NetworkAuto || network == NetworkIPv4 || network == NetworkIPv6
+}
+
+type networkKey struct{}
+
+// WithNetwork makes the requests made with the context connect over
+// the network given instead of the default one. Empty changes nothing.
+func WithNetwork(ctx context.Context, network string) context.Context {
+	if network == "" {
+		return ctx
 	}
-	return c.httpClient.Do(req)
+	return context.WithValue(ctx, networkKey{}, network)
+}
+
+// WithFeedConnection applies the proxy, the client certificate
+// and the network preference of the feed settings.
+func WithFeedConnection(ctx context.Context, settings storage.FeedSettings) context.Context {
+	ctx = WithClientCert(WithProxy(ctx, settings.Proxy), settings.ClientCert, settings.ClientKey)
+	return WithNetwork(ctx, settings.Network)
+}
+
+// transportKey is the proxy, the client certificate and the network preference
+// of the feed, the client's own ones are used for those left empty.
+type transportKey struct {
+	proxy   string
+	cert    clientCertFiles
+	network string
+}
+
+type feedTransport struct {
+	*http.Transport
+	cert *clientCert
+}
+
+// feedTransport returns the transport with the feed's own proxy, client
+// certificate or network, created once per combination of them.
+func (c *Client) feedTransport(key transportKey) (*feedTransport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if transport, ok := c.transports[key]; ok {
+		return transport, nil
+	}
+	proxy := c.proxy
+	if key.proxy != "" {
+		u, err := ParseProxyURL(key.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	cert := c.cert
+	if key.cert.certFile != "" {
+		cert = newClientCert(key.cert.certFile, key.cert.keyFile)
+	}
+	network := c.network
+	if key.network != "" {
+		if !ValidNetwork(key.network) {
+			return nil, fmt.Errorf("invalid network %q", key.network)
+		}
+		network = key.network
+	}
+	if c.transports == nil {
+		c.transports = make(map[transportKey]*feedTransport)
+	}
+	c.transports[key] = &feedTransport{Transport: c.newTransport(proxy, cert, network), cert: cert}
+	return c.transports[key], nil
+}
+
+// Fetch performs a GET request using the shared client.
+// The headers (if any) are sent along with the default ones.
+func Fetch(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	return client.do(ctx, url, header)
 }
 
 var client *Client
 
-func init() {
+// ClientOptions are the settings of the http client used for fetching
+// the feeds, the favicons and the images.
+type ClientOptions struct {
+	// proxy url, the proxy environment variables are used if empty
+	Proxy string
+	// time limit for the whole request, including reading the body
+	Timeout time.Duration
+	// pem files of the certificate authenticating the client (mutual tls)
+	ClientCert string
+	ClientKey  string
+	// opening a new connection for every request, over http/1.1
+	DisableKeepAlives bool
+	// how long the addresses of the hosts are cached, 5m if 0
+	DNSCacheTTL time.Duration
+	// resolving the host on every connection instead
+	DisableDNSCache bool
+	// dns server (`host[:port]`) resolving the hosts instead of the system's one
+	DNSServer string
+	// connecting over ipv4 or ipv6 only, both if empty or `auto`
+	Network string
+	// the redirects followed before giving up, 10 if 0
+	MaxRedirects int
+	// logging the requests of all the feed fetches, as if debugged each
+	DebugHTTP bool
+}
+
+// ParseProxyURL parses the http(s) or socks5 proxy url.
+func ParseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing proxy host")
+	}
+	return u, nil
+}
+
+// SetClientOptions replaces the shared client. Must be called before the workers are started.
+func SetClientOptions(opts ClientOptions) error {
+	c, err := newClient(opts)
+	if err != nil {
+		return err
+	}
+	client = c
+	return nil
+}
+
+func newClient(opts ClientOptions) (*Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		u, err := ParseProxyURL(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	var cert *clientCert
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if err := CheckClientCert(opts.ClientCert, opts.ClientKey); err != nil {
+			return nil, err
+		}
+		cert = newClientCert(opts.ClientCert, opts.ClientKey)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultClientTimeout
+	}
+	if opts.DNSCacheTTL <= 0 {
+		opts.DNSCacheTTL = defaultDNSCacheTTL
+	}
+	if opts.Network == "" {
+		opts.Network = NetworkAuto
+	}
+	if !ValidNetwork(opts.Network) {
+		return nil, fmt.Errorf("invalid network %q", opts.Network)
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = defaultMaxRedirects
+	}
+	resolver := net.DefaultResolver
+	if opts.DNSServer != "" {
+		var err error
+		if resolver, err = newResolver(opts.DNSServer); err != nil {
+			return nil, fmt.Errorf("invalid dns server: %w", err)
+		}
+	}
+	var dial dialFunc = (&net.Dialer{
+		Timeout:  10 * time.Second,
+		Resolver: resolver,
+	}).DialContext
+	if !opts.DisableDNSCache {
+		dial = newDNSCache(opts.DNSCacheTTL, resolver).dial(dial)
+	}
+	c := &Client{
+		userAgent: "Yarr/1.0",
+		proxy:     proxy,
+		cert:      cert,
+
+		dial:              countedDial(dial),
+		network:           opts.Network,
+		maxRedirects:      opts.MaxRedirects,
+		disableKeepAlives: opts.DisableKeepAlives,
+		debug:             opts.DebugHTTP,
+	}
+	c.httpClient = &http.Client{
+		Timeout:       opts.Timeout,
+		Transport:     c.newTransport(proxy, cert, c.network),
+		CheckRedirect: c.checkRedirect,
+	}
+	return c, nil
+}
+
+func (c *Client) newTransport(proxy func(*http.Request) (*url.URL, error), cert *clientCert, network string) *http.Transport {
+	dial := c.dial
+	if network != NetworkAuto {
+		tcp := map[string]string{NetworkIPv4: "tcp4", NetworkIPv6: "tcp6"}[network]
+		dial = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return c.dial(ctx, tcp, addr)
+		}
+	}
 	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout: 10 * time.Second,
-		}).DialContext,
-		DisableKeepAlives:   true,
+		Proxy:               proxy,
+		DialContext:         dial,
+		DisableCompression:  true,
 		TLSHandshakeTimeout: time.Second * 10,
+		// the idle connections are closed after a while,
+		// not to hold on to a file descriptor per host visited
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+		// the custom dialer turns it off otherwise
+		ForceAttemptHTTP2: !c.disableKeepAlives,
+		DisableKeepAlives: c.disableKeepAlives,
 	}
-	httpClient := &http.Client{
-		Timeout:   time.Second * 30,
-		Transport: transport,
-	}
-	client = &Client{
-		httpClient: httpClient,
-		userAgent:  "Yarr/1.0",
+	if cert != nil {
+		cert.attach(transport)
 	}
+	return transport
+}
+
+const defaultClientTimeout = time.Second * 30
+
+func init() {
+	client, _ = newClient(ClientOptions{})
 }
@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// clientCert is the certificate the client authenticates with (mutual tls),
+// read again whenever either file changes so that the short-lived
+// certificates keep working without a restart.
+type clientCert struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	version [2]fileVersion
+	// the transports using the certificate, their idle connections
+	// (authenticated with the previous one) are closed once it changes
+	transports []*http.Transport
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func newClientCert(certFile, keyFile string) *clientCert {
+	return &clientCert{certFile: certFile, keyFile: keyFile}
+}
+
+// load returns the key pair, read from the files if they changed since the last time.
+func (c *clientCert) load() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var version [2]fileVersion
+	for i, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		version[i] = fileVersion{modTime: info.ModTime(), size: info.Size()}
+	}
+	if c.cert != nil && version == c.version {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+	}
+	if c.cert != nil {
+		for _, transport := range c.transports {
+			transport.CloseIdleConnections()
+		}
+	}
+	c.cert, c.version = &cert, version
+	return c.cert, nil
+}
+
+func (c *clientCert) attach(transport *http.Transport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transports = append(c.transports, transport)
+	transport.TLSClientConfig = &tls.Config{GetClientCertificate: c.getClientCertificate}
+}
+
+func (c *clientCert) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.load()
+}
+
+// CheckClientCert tells whether the key pair can be loaded from the files.
+func CheckClientCert(certFile, keyFile string) error {
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("both the certificate and the key files are required")
+	}
+	_, err := newClientCert(certFile, keyFile).load()
+	return err
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self-signed key pair with the common name to the files.
+func writeClientCert(t *testing.T, certFile, keyFile, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+func TestClientCert(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := newClient(ClientOptions{ClientCert: certFile, ClientKey: keyFile}); err == nil {
+		t.Fatal("expected the missing key pair to be reported")
+	}
+	writeClientCert(t, certFile, keyFile, "first")
+
+	c, err := newClient(ClientOptions{ClientCert: certFile, ClientKey: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.httpClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+	name := func(ctx context.Context) (string, error) {
+		res, err := c.getContext(ctx, server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		return string(body), err
+	}
+	if got, err := name(context.Background()); err != nil || got != "first" {
+		t.Fatalf("expected the first certificate, got %q (%v)", got, err)
+	}
+
+	// renewed
+	writeClientCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if got, err := name(context.Background()); err != nil || got != "second" {
+		t.Fatalf("expected the renewed certificate, got %q (%v)", got, err)
+	}
+
+	// the feed's own one
+	feedCert, feedKey := filepath.Join(dir, "feed.pem"), filepath.Join(dir, "feed.key")
+	writeClientCert(t, feedCert, feedKey, "feed")
+	transport, _ := c.feedTransport(transportKey{cert: clientCertFiles{certFile: feedCert, keyFile: feedKey}})
+	transport.TLSClientConfig.RootCAs = roots
+	if got, err := name(WithClientCert(context.Background(), feedCert, feedKey)); err != nil || got != "feed" {
+		t.Fatalf("expected the feed's certificate, got %q (%v)", got, err)
+	}
+
+	// broken
+	os.WriteFile(keyFile, []byte("garbage"), 0600)
+	_, err = name(context.Background())
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) || fetchErr.Kind != FetchTLS || !strings.Contains(err.Error(), "failed to load the client certificate") {
+		t.Fatalf("expected the key pair error, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The encodings the client asks for, decoded by itself rather than by
+// the transport, so that the feeds setting their own Accept-Encoding
+// are decoded too.
+const acceptEncoding = "gzip, deflate"
+
+// decodeBody replaces the compressed body of the response with the decoded one.
+// The unknown encodings are left as is.
+func decodeBody(res *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "gzip", "x-gzip", "deflate":
+	default:
+		return
+	}
+	res.Body = &decodedBody{body: res.Body, encoding: encoding}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+}
+
+// decodedBody starts decoding on the first read, the empty bodies
+// (e.g. of the 304 responses) aren't valid streams.
+type decodedBody struct {
+	body     io.ReadCloser
+	encoding string
+	r        io.Reader
+	err      error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		if b.encoding == "deflate" {
+			b.r, b.err = newDeflateReader(b.body)
+		} else {
+			b.r, b.err = gzip.NewReader(b.body)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.r.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	return b.body.Close()
+}
+
+// newDeflateReader reads the zlib stream, or the raw deflate one
+// sent by some servers instead.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+const compressedFeed = `<rss><channel><item><guid>1</guid><title>compressed</title></item></channel></rss>`
+
+func compress(encoding string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	}
+	w.Write([]byte(compressedFeed))
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	var accepted string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted = r.Header.Get("Accept-Encoding")
+		encoding := r.URL.Query().Get("encoding")
+		if encoding == "raw-deflate" {
+			w.Header().Set("Content-Encoding", "deflate")
+		} else {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Write(compress(encoding))
+	}))
+	defer server.Close()
+
+	for _, encoding := range []string{"gzip", "deflate", "raw-deflate"} {
+		res, err := client.get(server.URL + "?encoding=" + encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(body) != compressedFeed || res.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: unexpected body %q %v", encoding, body, err)
+		}
+		if accepted != acceptEncoding {
+			t.Errorf("%s: unexpected Accept-Encoding %q", encoding, accepted)
+		}
+	}
+
+	// decoded with the feed's own Accept-Encoding as well
+	header := http.Header{"Accept-Encoding": {"gzip"}}
+	res, err := client.do(context.Background(), server.URL+"?encoding=gzip", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if body, _ := io.ReadAll(res.Body); string(body) != compressedFeed || accepted != "gzip" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+func TestListItemsCompressedEtag(t *testing.T) {
+	var conditional string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the etag of the compressed variant, not matched by the server itself
+		conditional = r.Header.Get("If-None-Match")
+		w.Header().Set("Etag", `"v1-gzip"`)
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compress("gzip"))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL, nil)
+
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 1 || items[0].Title != "compressed" {
+		t.Fatalf("unexpected items: %#v %v", items, err)
+	}
+	if state := db.GetHTTPState(feed.Id); state == nil || state.Etag != `"v1-gzip"` {
+		t.Fatalf("expected the etag stored, got %#v", state)
+	}
+	// the decoded body is the same, so it's not parsed again
+	if items, err := listItems(context.Background(), *feed, db); err != nil || items != nil {
+		t.Fatalf("expected the unchanged feed skipped, got %#v %v", items, err)
+	}
+	if conditional != `"v1-gzip"` {
+		t.Fatalf("expected the stored etag sent, got %q", conditional)
+	}
+}
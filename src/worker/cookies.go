@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// feedCookieJar returns the jar for fetching the feed, holding the cookies
+// of the Cookie header (if any) and the ones the feed set before, the latter
+// winning. The header is removed, the jar sends the cookies instead.
+func feedCookieJar(feedURL *url.URL, header http.Header, stored string) http.CookieJar {
+	jar, _ := cookiejar.New(nil)
+	cookies := parseCookies(header.Get("Cookie"))
+	header.Del("Cookie")
+	cookies = append(cookies, parseCookies(stored)...)
+	jar.SetCookies(feedURL, cookies)
+	return jar
+}
+
+// parseCookies parses the Cookie header value ("a=1; b=2").
+func parseCookies(value string) []*http.Cookie {
+	if value == "" {
+		return nil
+	}
+	req := http.Request{Header: http.Header{"Cookie": {value}}}
+	return req.Cookies()
+}
+
+// jarCookies returns the cookies of the jar sent to the url, as a Cookie header value.
+func jarCookies(jar http.CookieJar, u *url.URL) string {
+	cookies := make([]string, 0)
+	for _, cookie := range jar.Cookies(u) {
+		cookies = append(cookies, cookie.String())
+	}
+	return strings.Join(cookies, "; ")
+}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestListItemsCookies(t *testing.T) {
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Header.Get("Cookie"))
+		session, err := r.Cookie("session")
+		if err != nil {
+			// the login wall setting the cookie and sending back
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+			http.Redirect(w, r, r.URL.Path, http.StatusFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: session.Value + "1"})
+		w.Write([]byte(`<rss><channel><item><guid>1</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL+"/feed.xml", nil)
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{Headers: map[string]string{"Cookie": "token=abc"}})
+
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 1 {
+		t.Fatalf("expected the feed fetched past the redirect, got %v %v", items, err)
+	}
+	if !equalStrings(sent, []string{"token=abc", "token=abc; session=1"}) {
+		t.Fatalf("unexpected cookies sent: %q", sent)
+	}
+	if cookies := db.GetFeedCookies(feed.Id); cookies != "token=abc; session=11" {
+		t.Fatalf("expected the rotated cookie stored, got %q", cookies)
+	}
+
+	sent = nil
+	listItems(context.Background(), *feed, db)
+	if !equalStrings(sent, []string{"token=abc; session=11"}) {
+		t.Fatalf("expected the stored cookies sent, got %q", sent)
+	}
+
+	// the changed settings start over
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{})
+	if cookies := db.GetFeedCookies(feed.Id); cookies != "" {
+		t.Fatalf("expected the cookies dropped, got %q", cookies)
+	}
+}
@@ -2,6 +2,10 @@ package worker
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -10,8 +14,13 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/content/sanitizer"
 	"github.com/nkanaev/yarr/src/content/scraper"
+	"github.com/nkanaev/yarr/src/content/silo"
+	"github.com/nkanaev/yarr/src/logger"
 	"github.com/nkanaev/yarr/src/parser"
 	"github.com/nkanaev/yarr/src/storage"
 	"golang.org/x/net/html/charset"
@@ -26,14 +35,58 @@ type DiscoverResult struct {
 	Feed     *parser.Feed
 	FeedLink string
 	Sources  []FeedSource
+	// why the url, looking like a feed, isn't one,
+	// set if no feeds are found at all
+	ParseError error
+	BodyStart  string
 }
 
-func DiscoverFeed(candidateUrl string) (*DiscoverResult, error) {
+// DiscoverFeed looks for the feed at the url, sending the request headers
+// of the feed settings along with the default ones, through its proxy and
+// with its client certificate if any. The credentials (if any)
+// are sent only once asked for, not to the pages linking to the feed.
+// The file:// urls and the absolute paths are read from the disk.
+func DiscoverFeed(candidateUrl string, settings storage.FeedSettings) (*DiscoverResult, error) {
+	return DiscoverFeedWithContext(context.Background(), candidateUrl, settings)
+}
+
+// DiscoverFeedWithContext is DiscoverFeed given up on (with ctx.Err())
+// once the context is done, the pages linked to and the probes included.
+func DiscoverFeedWithContext(ctx context.Context, candidateUrl string, settings storage.FeedSettings) (*DiscoverResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if path, ok := localFeedPath(candidateUrl); ok {
+		return discoverLocalFeed(path)
+	}
+	// the sites with the feeds told by the link, no need to look into the page
+	// (checked, unless several to choose from), looked into if it's not there after all
+	if feeds := silo.KnownFeeds(candidateUrl); len(feeds) == 1 && feeds[0].URL != candidateUrl {
+		if result, err := DiscoverFeedWithContext(ctx, feeds[0].URL, settings); err == nil && result.ParseError == nil {
+			return result, nil
+		}
+	} else if len(feeds) > 1 {
+		sources := make([]FeedSource, 0, len(feeds))
+		for _, feed := range feeds {
+			sources = append(sources, FeedSource{Title: feed.Title, Url: feed.URL})
+		}
+		return &DiscoverResult{Sources: sources}, nil
+	}
 	result := &DiscoverResult{}
+	anonymous := settings
+	anonymous.Username, anonymous.Password = "", ""
+	fetchCtx := WithFeedConnection(ctx, settings)
 	// Query URL
-	res, err := client.get(candidateUrl)
+	res, err := client.do(fetchCtx, candidateUrl, feedHeader(anonymous))
 	if err != nil {
-		return nil, err
+		return nil, discoverError(ctx, err)
+	}
+	if res.StatusCode == http.StatusUnauthorized && (settings.Username != "" || settings.Password != "") {
+		res.Body.Close()
+		res, err = client.do(fetchCtx, candidateUrl, feedHeader(settings))
+		if err != nil {
+			return nil, discoverError(ctx, err)
+		}
 	}
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
@@ -43,16 +96,20 @@ func DiscoverFeed(candidateUrl string) (*DiscoverResult, error) {
 
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return nil, discoverError(ctx, err)
 	}
 
 	// Try to feed into parser
-	feed, err := parser.ParseAndFix(bytes.NewReader(body), candidateUrl, cs)
+	feed, err := parser.ParseAndFix(bytes.NewReader(body), candidateUrl, cs, nil)
 	if err == nil {
 		result.Feed = feed
 		result.FeedLink = candidateUrl
 		return result, nil
 	}
+	parseErr := err
+	if !looksLikeFeed(res, body) {
+		parseErr = nil
+	}
 
 	// Possibly an html link. Search for feed links
 	content := string(body)
@@ -63,24 +120,50 @@ func DiscoverFeed(candidateUrl string) (*DiscoverResult, error) {
 			}
 		}
 	}
+	// the youtube pages don't link to the channel's feed anymore
+	if silo.IsYouTube(res.Request.URL.String()) {
+		if channelID := silo.YouTubeChannelID(content); channelID != "" {
+			return DiscoverFeedWithContext(ctx, silo.YouTubeChannelFeed(channelID), settings)
+		}
+	}
 	sources := make([]FeedSource, 0)
 	for url, title := range scraper.FindFeeds(content, candidateUrl) {
 		sources = append(sources, FeedSource{Title: title, Url: url})
 	}
+	if len(sources) == 0 {
+		// not linked to, maybe at the usual place
+		sources = probeFeeds(fetchCtx, res.Request.URL, feedHeader(anonymous))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
 	switch {
+	case len(sources) == 0 && parseErr != nil:
+		result.ParseError = parseErr
+		result.BodyStart = bodyStart(content)
+		return result, nil
 	case len(sources) == 0:
 		return nil, errors.New("No feeds found at the given url")
 	case len(sources) == 1:
 		if sources[0].Url == candidateUrl {
 			return nil, errors.New("Recursion!")
 		}
-		return DiscoverFeed(sources[0].Url)
+		return DiscoverFeedWithContext(ctx, sources[0].Url, settings)
 	}
 
 	result.Sources = sources
 	return result, nil
 }
 
+// discoverError is the error of the request, or the context's if it's done,
+// not to tell the timeout of the whole discovery for the site's.
+func discoverError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
 var emptyIcon = make([]byte, 0)
 var imageTypes = map[string]bool{
 	"image/x-icon": true,
@@ -89,19 +172,20 @@ var imageTypes = map[string]bool{
 	"image/gif":    true,
 }
 
-func findFavicon(siteUrl, feedUrl string) (*[]byte, error) {
+func findFavicon(ctx context.Context, siteUrl, feedUrl string) (*[]byte, error) {
 	urls := make([]string, 0)
 
 	favicon := func(link string) string {
 		u, err := url.Parse(link)
-		if err != nil {
+		// none for the local feeds
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
 			return ""
 		}
 		return fmt.Sprintf("%s://%s/favicon.ico", u.Scheme, u.Host)
 	}
 
-	if siteUrl != "" {
-		if res, err := client.get(siteUrl); err == nil {
+	if _, local := localFeedPath(siteUrl); siteUrl != "" && !local {
+		if res, err := client.getContext(ctx, siteUrl); err == nil {
 			defer res.Body.Close()
 			if body, err := ioutil.ReadAll(res.Body); err == nil {
 				urls = append(urls, scraper.FindIcons(string(body), siteUrl)...)
@@ -117,7 +201,7 @@ func findFavicon(siteUrl, feedUrl string) (*[]byte, error) {
 	}
 
 	for _, u := range urls {
-		res, err := client.get(u)
+		res, err := client.getContext(ctx, u)
 		if err != nil {
 			continue
 		}
@@ -139,7 +223,7 @@ func findFavicon(siteUrl, feedUrl string) (*[]byte, error) {
 	return &emptyIcon, nil
 }
 
-func ConvertItems(items []parser.Item, feed storage.Feed) []storage.Item {
+func ConvertItems(items []parser.Item, feed storage.Feed, stripTrackers bool) []storage.Item {
 	result := make([]storage.Item, len(items))
 	for i, item := range items {
 		item := item
@@ -151,12 +235,16 @@ func ConvertItems(items []parser.Item, feed storage.Feed) []storage.Item {
 		if item.ImageURL != "" {
 			imageURL = &item.ImageURL
 		}
+		content := sanitizer.RewriteURLs(item.Content, itemBaseURL(item, feed))
+		if stripTrackers {
+			content = sanitizer.StripTrackers(content)
+		}
 		result[i] = storage.Item{
 			GUID:     item.GUID,
 			FeedId:   feed.Id,
 			Title:    item.Title,
 			Link:     item.URL,
-			Content:  item.Content,
+			Content:  content,
 			Date:     item.Date,
 			Status:   storage.UNREAD,
 			ImageURL: imageURL,
@@ -166,41 +254,232 @@ func ConvertItems(items []parser.Item, feed storage.Feed) []storage.Item {
 	return result
 }
 
-func listItems(f storage.Feed, db *storage.Storage) ([]storage.Item, error) {
+// itemBaseURL returns the url the relative links in the item content
+// are resolved against: the item link if any, otherwise the feed's.
+func itemBaseURL(item parser.Item, feed storage.Feed) string {
+	for _, link := range []string{item.URL, feed.Link, feed.FeedLink} {
+		if htmlutil.IsAPossibleLink(link) {
+			return link
+		}
+	}
+	return ""
+}
+
+func listItems(ctx context.Context, f storage.Feed, db *storage.Storage) (items []storage.Item, err error) {
+	status, finalURL, parked := 0, "", false
+	ctx, meter := WithTransferMeter(ctx)
+	defer func() {
+		// cancelled on stop, not the feed's fault
+		// nor skipped for the host failing, the feed not fetched
+		if ctx.Err() == nil && !isCircuitOpen(err) {
+			var later *retryLaterError
+			failed := err != nil && !errors.As(err, &later)
+			db.SetFeedHealth(f.Id, status, finalURL, parked, failed)
+			db.AddFeedTransfer(f.Id, meter.Bytes(), time.Now())
+		}
+	}()
+
+	// read fresh every time, so that the changes apply on the next fetch
+	settings := db.GetFeedSettings(f.Id)
+	if path, ok := localFeedPath(f.FeedLink); ok {
+		return listLocalItems(f, path, settings, db)
+	}
+
 	lmod := ""
 	etag := ""
-	if state := db.GetHTTPState(f.Id); state != nil {
+	bodyHash := ""
+	if state := db.GetHTTPState(f.Id); state != nil && !settings.IgnoreCaching {
 		lmod = state.LastModified
 		etag = state.Etag
+		bodyHash = state.BodyHash
+	}
+	header := feedHeader(settings)
+	if lmod != "" {
+		header.Set("If-Modified-Since", lmod)
+	}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
 	}
 
-	res, err := client.getConditional(f.FeedLink, lmod, etag)
+	feedURL, err := url.Parse(f.FeedLink)
+	if err != nil {
+		return nil, err
+	}
+	cookies := db.GetFeedCookies(f.Id)
+	jar := feedCookieJar(feedURL, header, cookies)
+
+	// the outer context tells the stop from the feed's own timeout
+	fetchCtx := WithFeedConnection(withCookieJar(ctx, jar), settings)
+	if settings.Timeout > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = withTimeout(fetchCtx, time.Duration(settings.Timeout)*time.Second)
+		defer cancel()
+	}
+	res, err := client.do(fetchCtx, f.FeedLink, header)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
+	// kept as the server rotates them
+	if latest := jarCookies(jar, feedURL); latest != cookies {
+		db.SetFeedCookies(f.Id, latest)
+	}
+	status, finalURL = res.StatusCode, res.Request.URL.String()
+
+	// the validators along with how long the feed stays fresh, the ttl only if
+	// parsed (-1 otherwise); only the refresh time if the caching is ignored
+	setHTTPState := func(lastModified, etag, bodyHash string, ttl int64) {
+		maxAge := cacheMaxAge(res.Header)
+		if settings.IgnoreCaching {
+			lastModified, etag, bodyHash, maxAge = "", "", "", 0
+		}
+		db.SetHTTPState(f.Id, lastModified, etag, bodyHash)
+		db.SetHTTPFreshness(f.Id, maxAge, ttl)
+	}
 
 	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable:
+		// without a valid Retry-After it's an error like any other
+		if until, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+			db.SetFeedCooldown(f.Id, until)
+			return nil, &retryLaterError{status: res.StatusCode, until: until}
+		}
+		return nil, fmt.Errorf("status code %d", res.StatusCode)
+	case res.StatusCode == http.StatusGone:
+		return nil, errFeedGone
 	case res.StatusCode < 200 || res.StatusCode > 399:
 		if res.StatusCode == 404 {
 			return nil, fmt.Errorf("feed not found")
 		}
 		return nil, fmt.Errorf("status code %d", res.StatusCode)
 	case res.StatusCode == http.StatusNotModified:
+		// keep the validators, only the refresh time changes
+		setHTTPState(lmod, etag, bodyHash, -1)
+		updateFeedLink(f, res, db)
 		return nil, nil
 	}
 
-	feed, err := parser.ParseAndFix(res.Body, f.FeedLink, getCharset(res))
+	var body io.Reader = res.Body
+	if redirectedElsewhere(f.FeedLink, res) {
+		head, err := io.ReadAll(io.LimitReader(res.Body, parkedPageMaxSize))
+		if err != nil {
+			return nil, err
+		}
+		if isParkedPage(res, head) {
+			parked = true
+			return nil, fmt.Errorf("redirected to a parked domain page at %s", res.Request.URL.Host)
+		}
+		body = io.MultiReader(bytes.NewReader(head), res.Body)
+	}
+
+	// the raw bytes, before the charset conversion
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	newHash := hex.EncodeToString(sum[:])
+	if newHash == bodyHash {
+		// the same document as the last time, like a 304
+		setHTTPState(res.Header.Get("Last-Modified"), res.Header.Get("Etag"), newHash, -1)
+		updateFeedLink(f, res, db)
+		return nil, nil
+	}
+
+	reportStage(ctx, FeedParsing)
+	feed, err := parser.ParseAndFix(bytes.NewReader(data), f.FeedLink, getCharset(res), feedLocation(settings))
 	if err != nil {
 		return nil, err
 	}
 
-	lmod = res.Header.Get("Last-Modified")
-	etag = res.Header.Get("Etag")
-	if lmod != "" || etag != "" {
-		db.SetHTTPState(f.Id, lmod, etag)
+	// stored even without the validators, for the refresh time
+	setHTTPState(res.Header.Get("Last-Modified"), res.Header.Get("Etag"), newHash, int64(feed.TTL)*60)
+	updateFeedLink(f, res, db)
+	return ConvertItems(feed.Items, f, db.GetSettingsValueBool("strip_trackers")), nil
+}
+
+// errFeedGone is the 410 response, the feed was removed on purpose
+// and isn't coming back.
+var errFeedGone = errors.New("feed removed by the publisher (410 Gone), unsubscribe or look for a replacement")
+
+// permanentLocation returns the url the feed request was permanently redirected to:
+// the last one reached by the 301 and 308 redirects only. Empty if there's none.
+func permanentLocation(res *http.Response) string {
+	hops := make([]*http.Request, 0)
+	for req := res.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		hops = append([]*http.Request{req}, hops...)
+	}
+	location := ""
+	for _, req := range hops {
+		status := req.Response.StatusCode
+		if status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect {
+			break
+		}
+		location = req.URL.String()
+	}
+	return location
+}
+
+// updateFeedLink stores the new link of the feed fetched successfully
+// after a permanent redirect.
+func updateFeedLink(f storage.Feed, res *http.Response, db *storage.Storage) {
+	location := permanentLocation(res)
+	if location == "" || location == f.FeedLink {
+		return
+	}
+	fields := logger.Fields{"feed_id": f.Id, "from": f.FeedLink, "to": location}
+	if !db.UpdateFeedLink(f.Id, location) {
+		// most likely subscribed to the new link already
+		logger.With(fields).Warn("failed to update the link of the moved feed")
+		return
+	}
+	logger.With(fields).Info("feed moved permanently")
+}
+
+// feedHeader returns the request headers for the feed settings.
+func feedHeader(settings storage.FeedSettings) http.Header {
+	header := make(http.Header)
+	for name, value := range settings.Headers {
+		header.Set(name, value)
+	}
+	if settings.UserAgent != "" {
+		header.Set("User-Agent", settings.UserAgent)
+	}
+	if settings.Username != "" || settings.Password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(settings.Username + ":" + settings.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+	return header
+}
+
+// EnclosureHeader returns the request headers of the feed settings for the
+// file linked from the feed (e.g. the podcast episode): the credentials and
+// the custom headers are sent only if the file is on the feed's host, not
+// to the cdns.
+func EnclosureHeader(settings storage.FeedSettings, feedLink, link string) http.Header {
+	header := feedHeader(settings)
+	feedURL, err1 := url.Parse(feedLink)
+	fileURL, err2 := url.Parse(link)
+	if err1 != nil || err2 != nil || !strings.EqualFold(feedURL.Host, fileURL.Host) {
+		for name := range header {
+			if !redirectHeaders[name] {
+				header.Del(name)
+			}
+		}
+	}
+	return header
+}
+
+// feedLocation returns the time zone of the feed dates without one, if set.
+func feedLocation(settings storage.FeedSettings) *time.Location {
+	if settings.Timezone == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return nil
 	}
-	return ConvertItems(feed.Items, f), nil
+	return loc
 }
 
 func getCharset(res *http.Response) string {
@@ -216,22 +495,54 @@ func getCharset(res *http.Response) string {
 }
 
 func GetBody(url string) (string, error) {
-	res, err := client.get(url)
+	return GetBodyWithContext(context.Background(), url)
+}
+
+// GetBodyWithContext fetches the page, reusing the cached one if not modified
+// since (unless the page cache is off).
+func GetBodyWithContext(ctx context.Context, url string) (string, error) {
+	cache := pageCache
+	var cached *storage.CachedPage
+	header := make(http.Header)
+	if cache != nil {
+		if cached = cache.db.GetCachedPage(url); cached != nil {
+			if cached.LastModified != "" {
+				header.Set("If-Modified-Since", cached.LastModified)
+			}
+			if cached.ETag != "" {
+				header.Set("If-None-Match", cached.ETag)
+			}
+		}
+	}
+	res, err := client.do(ctx, url, header)
 	if err != nil {
 		return "", err
 	}
 	defer res.Body.Close()
 
-	var r io.Reader
+	var ctype string
+	var data []byte
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached != nil:
+		ctype, data = cached.ContentType, cached.Body
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return "", fmt.Errorf("status code %d", res.StatusCode)
+	default:
+		if data, err = io.ReadAll(res.Body); err != nil {
+			return "", err
+		}
+		ctype = res.Header.Get("Content-Type")
+		if cache != nil {
+			cache.store(url, res, data)
+		}
+	}
 
-	ctype := res.Header.Get("Content-Type")
+	var r io.Reader = bytes.NewReader(data)
 	if strings.Contains(ctype, "charset") {
-		r, err = charset.NewReader(res.Body, ctype)
+		r, err = charset.NewReader(r, ctype)
 		if err != nil {
 			return "", err
 		}
-	} else {
-		r = res.Body
 	}
 	body, err := io.ReadAll(r)
 	if err != nil {
@@ -0,0 +1,480 @@
+package worker
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestListItemsFeedSettings(t *testing.T) {
+	var userAgent, username, password, apiKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		username, password, _ = r.BasicAuth()
+		apiKey = r.Header.Get("X-Api-Key")
+		w.Write([]byte(`<rss><channel><item><guid>1</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL, nil)
+
+	listItems(context.Background(), *feed, db)
+	if userAgent != client.userAgent || username != "" {
+		t.Fatalf("unexpected request: %q %q", userAgent, username)
+	}
+
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{UserAgent: "custom", Username: "user", Password: "pass"})
+	items, err := listItems(context.Background(), *feed, db)
+	if err != nil || len(items) != 1 {
+		t.Fatal(items, err)
+	}
+	if userAgent != "custom" || username != "user" || password != "pass" {
+		t.Fatalf("unexpected request: %q %q %q", userAgent, username, password)
+	}
+
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{Headers: map[string]string{"User-Agent": "Mozilla/5.0", "X-Api-Key": "key"}})
+	listItems(context.Background(), *feed, db)
+	if userAgent != "Mozilla/5.0" || apiKey != "key" {
+		t.Fatalf("unexpected request: %q %q", userAgent, apiKey)
+	}
+
+	// sent when subscribing as well
+	apiKey = ""
+	if _, err := DiscoverFeed(server.URL, storage.FeedSettings{Headers: map[string]string{"X-Api-Key": "key"}}); err != nil || apiKey != "key" {
+		t.Fatalf("unexpected discovery: %q %v", apiKey, err)
+	}
+}
+
+func TestListItemsTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`<rss><channel><item><guid>1</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL, nil)
+
+	defer func(c *Client) { client = c }(client)
+	client, _ = newClient(ClientOptions{Timeout: 50 * time.Millisecond})
+
+	var fetchErr *FetchError
+	if _, err := listItems(context.Background(), *feed, db); !errors.As(err, &fetchErr) || fetchErr.Kind != FetchTimeout {
+		t.Fatalf("expected the global timeout, got %v", err)
+	}
+	// the feed's own limit is longer
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{Timeout: 1})
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 1 {
+		t.Fatalf("expected the feed fetched, got %v %v", items, err)
+	}
+}
+
+func TestListItemsParkedDomain(t *testing.T) {
+	parking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed.xml" {
+			w.Write([]byte(`<rss><channel><item><guid>1</guid></item></channel></rss>`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><body><h1>This domain is for sale!</h1></body></html>`))
+	}))
+	defer parking.Close()
+	// another host name for the same server
+	elsewhere := strings.Replace(parking.URL, "127.0.0.1", "localhost", 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/parked.xml":
+			http.Redirect(w, r, elsewhere+"/", http.StatusMovedPermanently)
+		case "/moved.xml":
+			http.Redirect(w, r, elsewhere+"/feed.xml", http.StatusMovedPermanently)
+		}
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	parked := db.CreateFeed("", "", "", server.URL+"/parked.xml", nil)
+	moved := db.CreateFeed("", "", "", server.URL+"/moved.xml", nil)
+
+	if _, err := listItems(context.Background(), *parked, db); err == nil || !strings.Contains(err.Error(), "parked domain") {
+		t.Fatalf("expected the parked domain error, got %v", err)
+	}
+	if items, err := listItems(context.Background(), *moved, db); err != nil || len(items) != 1 {
+		t.Fatal(items, err)
+	}
+
+	report := db.ReportDeadFeeds(time.Now())
+	if len(report) != 1 || report[0].FeedID != parked.Id || report[0].Reasons[0] != storage.DeadParked {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+	if report[0].LastStatus != 200 || report[0].FinalURL != elsewhere+"/" || report[0].Failures != 1 {
+		t.Fatalf("unexpected evidence: %#v", report[0])
+	}
+}
+
+func TestListItemsTransfer(t *testing.T) {
+	body := "<rss><channel>" + strings.Repeat("<item><guid>1</guid><title>same title</title></item>", 1000) + "</channel></rss>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == "v1" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Etag", "v1")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			gz.Write([]byte(body))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL, nil)
+
+	if _, err := listItems(context.Background(), *feed, db); err != nil {
+		t.Fatal(err)
+	}
+	first := db.ListFeedTransfers(time.Now())
+	if len(first) != 1 || first[0].Bytes == 0 || first[0].Bytes >= int64(len(body)) {
+		t.Fatalf("expected the compressed size, got %#v (uncompressed %d)", first, len(body))
+	}
+
+	if _, err := listItems(context.Background(), *feed, db); err != nil {
+		t.Fatal(err)
+	}
+	second := db.ListFeedTransfers(time.Now())
+	if second[0].Requests != 2 || second[0].Bytes-first[0].Bytes > 512 {
+		t.Fatalf("expected only the headers of the 304 response, got %#v", second)
+	}
+}
+
+func TestListItemsRetryAfter(t *testing.T) {
+	retryAfter := "120"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL, nil)
+	w := NewWorker(db)
+	defer w.Stop()
+
+	done, err := w.RefreshFeed(feed.Id)
+	if err != nil || done.Error != "" || len(db.GetFeedErrors()) != 0 {
+		t.Fatalf("expected no error when asked to retry later, got %#v %v", done, err)
+	}
+	until, ok := db.ListFeedCooldowns(time.Now())[feed.Id]
+	if !ok || until.Before(time.Now().Add(time.Minute)) || until.After(time.Now().Add(2*time.Minute)) {
+		t.Fatalf("unexpected cooldown: %v", until)
+	}
+	if due := w.DueFeeds(db.ListFeeds(), time.Now()); len(due) != 0 {
+		t.Fatalf("expected the feed in cooldown skipped, got %#v", due)
+	}
+	if due := w.DueFeeds(db.ListFeeds(), time.Now().Add(3*time.Minute)); len(due) != 1 {
+		t.Fatalf("expected the feed due after the cooldown, got %#v", due)
+	}
+	db.UpdateSettings(map[string]interface{}{"suspend_after_failures": 1})
+	if db.SuspendFailingFeed(feed.Id) {
+		t.Fatal("expected the fetch not counted as failed")
+	}
+
+	// falls back to the usual error
+	retryAfter = "soon"
+	if _, err := listItems(context.Background(), *feed, db); err == nil || err.Error() != "status code 429" {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+func TestListItemsPermanentRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved-again", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved-again", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/feed.xml", http.StatusPermanentRedirect)
+	})
+	mux.HandleFunc("/temporary", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/feed.xml", http.StatusFound)
+	})
+	mux.HandleFunc("/moved-then-temporary", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/temporary?moved", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved-broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/broken", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<rss><channel></channel></rss>`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+
+	testcases := []struct {
+		path string
+		want string
+	}{
+		{"/moved", "/feed.xml"},
+		{"/temporary", "/temporary"},
+		{"/moved-then-temporary", "/temporary?moved"},
+		{"/moved-broken", "/moved-broken"},
+	}
+	for _, tc := range testcases {
+		feed := db.CreateFeed("", "", "", server.URL+tc.path, nil)
+		listItems(context.Background(), *feed, db)
+		if link := db.GetFeed(feed.Id).FeedLink; link != server.URL+tc.want {
+			t.Errorf("%s: expected the feed link %s, got %s", tc.path, server.URL+tc.want, link)
+		}
+	}
+}
+
+func TestListItemsGone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL, nil)
+	w := NewWorker(db)
+	defer w.Stop()
+
+	done, err := w.RefreshFeed(feed.Id)
+	if err != nil || done.Error != errFeedGone.Error() || db.GetFeedErrors()[feed.Id] != errFeedGone.Error() {
+		t.Fatalf("expected the feed gone, got %#v %v", done, err)
+	}
+	if !db.GetFeed(feed.Id).Gone {
+		t.Fatal("expected the feed marked as gone")
+	}
+	if due := w.DueFeeds(db.ListFeeds(), time.Now()); len(due) != 0 {
+		t.Fatalf("expected the gone feed skipped, got %#v", due)
+	}
+
+	if !db.SetFeedGone(feed.Id, false) {
+		t.Fatal("expected the mark cleared")
+	}
+	if due := w.DueFeeds(db.ListFeeds(), time.Now()); len(due) != 1 {
+		t.Fatalf("expected the feed due once back, got %#v", due)
+	}
+}
+
+func TestListItemsUnchangedBody(t *testing.T) {
+	body := `<rss><channel><item><guid>1</guid></item></channel></rss>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// neither Etag nor Last-Modified
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL, nil)
+	w := NewWorker(db)
+	defer w.Stop()
+
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 1 {
+		t.Fatal(items, err)
+	}
+	if db.GetHTTPState(feed.Id).BodyHash == "" {
+		t.Fatal("expected the body hash stored")
+	}
+
+	before := time.Now()
+	done, err := w.RefreshFeed(feed.Id)
+	if err != nil || done.Error != "" || done.NewItems != 0 {
+		t.Fatalf("unexpected result: %#v %v", done, err)
+	}
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 0 {
+		t.Fatalf("expected the unchanged body skipped, got %v %v", items, err)
+	}
+	if refreshed := db.ListFeeds()[0].LastRefreshed; refreshed == nil || refreshed.Before(before.Truncate(time.Second)) {
+		t.Fatalf("expected the refresh recorded, got %v", refreshed)
+	}
+
+	body = `<rss><channel><item><guid>2</guid></item></channel></rss>`
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 1 {
+		t.Fatalf("expected the changed body parsed, got %v %v", items, err)
+	}
+}
+
+func TestListItemsIgnoreCaching(t *testing.T) {
+	body := `<rss><channel><item><guid>1</guid></item></channel></rss>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// never telling the changes
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Etag", `"v1"`)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL, nil)
+
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 1 {
+		t.Fatal(items, err)
+	}
+	body = `<rss><channel><item><guid>2</guid></item></channel></rss>`
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 0 {
+		t.Fatalf("expected the stale 304, got %v %v", items, err)
+	}
+
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{IgnoreCaching: true})
+	for i := 0; i < 2; i++ {
+		if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 1 || items[0].GUID != "2" {
+			t.Fatalf("expected the feed fetched in full, got %v %v", items, err)
+		}
+	}
+	if state := db.GetHTTPState(feed.Id); state.Etag != "" || state.BodyHash != "" || state.LastRefreshed.IsZero() {
+		t.Fatalf("expected the refresh time stored only, got %#v", state)
+	}
+}
+
+func TestDiscoverFeedWellKnownPaths(t *testing.T) {
+	feeds := map[string]string{
+		"/feed":    `<rss><channel><title>posts</title></channel></rss>`,
+		"/rss":     "",
+		"/rss.xml": `<not a feed>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/rss":
+			// the same feed
+			http.Redirect(w, r, "/feed", http.StatusMovedPermanently)
+		case feeds[r.URL.Path] != "":
+			w.Write([]byte(feeds[r.URL.Path]))
+		case r.URL.Path == "/blog/":
+			w.Write([]byte(`<html><head><title>blog</title></head></html>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	result, err := DiscoverFeed(server.URL+"/blog/", storage.FeedSettings{})
+	if err != nil || result.Feed == nil || result.Feed.Title != "posts" || result.FeedLink != server.URL+"/feed" {
+		t.Fatalf("expected the feed found at /feed, got %+v %v", result, err)
+	}
+
+	feeds["/index.xml"] = `<feed xmlns="http://www.w3.org/2005/Atom"><title>notes</title></feed>`
+	result, err = DiscoverFeed(server.URL+"/blog/", storage.FeedSettings{})
+	if err != nil || len(result.Sources) != 2 || result.Sources[0].Url != server.URL+"/feed" || result.Sources[1].Title != "notes" {
+		t.Fatalf("expected both feeds found, got %+v %v", result, err)
+	}
+
+	delete(feeds, "/feed")
+	delete(feeds, "/index.xml")
+	if _, err := DiscoverFeed(server.URL+"/blog/", storage.FeedSettings{}); err == nil {
+		t.Fatal("expected no feeds found")
+	}
+}
+
+func TestDiscoverFeedParseError(t *testing.T) {
+	body := "<?xml version=\"1.0\"?>\n<rss><channel><title>truncated</title><item>"
+	contentType := "application/rss+xml"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	result, err := DiscoverFeed(server.URL, storage.FeedSettings{})
+	if err != nil || result.Feed != nil || result.ParseError == nil || result.BodyStart != body {
+		t.Fatalf("expected the parse error, got %+v %v", result, err)
+	}
+
+	// binary
+	body = "<?xml\x00\x01\x02"
+	if result, err := DiscoverFeed(server.URL, storage.FeedSettings{}); err != nil || result.ParseError == nil || result.BodyStart != "" {
+		t.Fatalf("expected the parse error without the body, got %+v %v", result, err)
+	}
+
+	// not meant to be a feed
+	body, contentType = "<html><body>hello</body></html>", "text/html"
+	if result, err := DiscoverFeed(server.URL, storage.FeedSettings{}); err == nil {
+		t.Fatalf("expected no feeds found, got %+v", result)
+	}
+}
+
+func TestBodyStart(t *testing.T) {
+	long := strings.Repeat("ж", parseErrorBodySize)
+	if have := bodyStart(long); len(have) != parseErrorBodySize || !strings.HasPrefix(long, have) {
+		t.Fatalf("expected the body cut between the characters, got %d bytes", len(have))
+	}
+	if have := bodyStart("\xff\xfe"); have != "" {
+		t.Fatalf("expected no binary body, got %q", have)
+	}
+}
+
+func TestDiscoverFeedWithContext(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`<html><head><link rel="alternate" type="application/rss+xml" href="/feed.xml"></head></html>`))
+			return
+		}
+		// hanging
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := DiscoverFeedWithContext(ctx, server.URL, storage.FeedSettings{}); err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline exceeded, got %v", err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("expected given up promptly, took %s", took)
+	}
+	if _, err := DiscoverFeedWithContext(ctx, server.URL, storage.FeedSettings{}); err != context.DeadlineExceeded {
+		t.Fatalf("expected not fetched once done, got %v", err)
+	}
+}
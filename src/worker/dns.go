@@ -0,0 +1,149 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// The hosts cached at most and for how long by default.
+const (
+	dnsCacheSize       = 1000
+	defaultDNSCacheTTL = 5 * time.Minute
+)
+
+// The lookups answered by the cache and the ones made, telling its hit rate.
+var dnsHits, dnsMisses int64
+
+// dnsCache keeps the addresses of the hosts for a while, sparing the lookups
+// of the same hosts on every refresh. The resolver doesn't tell the ttls
+// of the records, the same one applies to all.
+type dnsCache struct {
+	ttl      time.Duration
+	resolver *net.Resolver
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration, resolver *net.Resolver) *dnsCache {
+	return &dnsCache{
+		ttl:      ttl,
+		resolver: resolver,
+		entries:  make(map[string]dnsEntry),
+	}
+}
+
+// lookup returns the addresses of the host, resolving it if not cached.
+// The failed lookups aren't cached.
+func (d *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	now := time.Now()
+	d.mu.Lock()
+	entry, ok := d.entries[host]
+	d.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		atomic.AddInt64(&dnsHits, 1)
+		return entry.addrs, nil
+	}
+	atomic.AddInt64(&dnsMisses, 1)
+	addrs, err := d.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.entries) >= dnsCacheSize {
+		for host, entry := range d.entries {
+			if !now.Before(entry.expires) {
+				delete(d.entries, host)
+			}
+		}
+	}
+	if len(d.entries) >= dnsCacheSize {
+		// any one will do
+		for host := range d.entries {
+			delete(d.entries, host)
+			break
+		}
+	}
+	d.entries[host] = dnsEntry{addrs: addrs, expires: now.Add(d.ttl)}
+	return addrs, nil
+}
+
+// dial resolves the host with the cache and connects to the first of its
+// addresses (of the network's family if constrained) accepting the connection.
+func (d *dnsCache) dial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		addrs, err := d.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		addrs = filterAddrs(addrs, network)
+		if len(addrs) == 0 {
+			return nil, &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+		}
+		var conn net.Conn
+		for _, ip := range addrs {
+			conn, err = dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		return conn, err
+	}
+}
+
+// filterAddrs leaves out the addresses of the other family than the network's (tcp4, tcp6).
+func filterAddrs(addrs []string, network string) []string {
+	if network != "tcp4" && network != "tcp6" {
+		return addrs
+	}
+	result := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip != nil && (ip.To4() != nil) == (network == "tcp4") {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+// newResolver returns the resolver querying the dns server (`host[:port]`,
+// 53 by default) instead of the system's one, over udp with a fallback to tcp
+// for the truncated answers.
+func newResolver(server string) (*net.Resolver, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = server, "53"
+	}
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("%q isn't an ip address", host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	addr := net.JoinHostPort(host, port)
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, addr)
+		},
+	}, nil
+}
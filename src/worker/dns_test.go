@@ -0,0 +1,212 @@
+package worker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDNSCache(t *testing.T) {
+	cache := newDNSCache(time.Minute, net.DefaultResolver)
+	ctx := context.Background()
+
+	hits, misses := atomic.LoadInt64(&dnsHits), atomic.LoadInt64(&dnsMisses)
+	for i := 0; i < 3; i++ {
+		if addrs, err := cache.lookup(ctx, "localhost"); err != nil || len(addrs) == 0 {
+			t.Fatalf("failed to resolve localhost: %v", err)
+		}
+	}
+	if got := atomic.LoadInt64(&dnsHits) - hits; got != 2 {
+		t.Errorf("expected 2 hits, got %d", got)
+	}
+	if got := atomic.LoadInt64(&dnsMisses) - misses; got != 1 {
+		t.Errorf("expected 1 miss, got %d", got)
+	}
+
+	// expired
+	cache.entries["localhost"] = dnsEntry{addrs: []string{"192.0.2.1"}, expires: time.Now()}
+	if addrs, _ := cache.lookup(ctx, "localhost"); len(addrs) == 0 || addrs[0] == "192.0.2.1" {
+		t.Fatalf("expected localhost resolved again, got %v", addrs)
+	}
+
+	if _, err := cache.lookup(ctx, "nonexistent.invalid"); err == nil {
+		t.Fatal("expected the lookup to fail")
+	}
+	if _, ok := cache.entries["nonexistent.invalid"]; ok {
+		t.Fatal("expected the failed lookup not cached")
+	}
+}
+
+func TestDNSCacheSize(t *testing.T) {
+	cache := newDNSCache(time.Minute, net.DefaultResolver)
+	for i := 0; i < dnsCacheSize; i++ {
+		expires := time.Now().Add(time.Minute)
+		if i%2 == 0 {
+			expires = time.Now().Add(-time.Minute)
+		}
+		cache.entries[strings.Repeat("x", i+1)] = dnsEntry{addrs: []string{"192.0.2.1"}, expires: expires}
+	}
+	cache.lookup(context.Background(), "localhost")
+	if len(cache.entries) != dnsCacheSize/2+1 {
+		t.Fatalf("expected the expired entries dropped, got %d", len(cache.entries))
+	}
+}
+
+func TestDNSCacheDial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	addr := server.Listener.Addr().(*net.TCPAddr)
+	if !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Skip("the test server isn't listening on 127.0.0.1")
+	}
+
+	cache := newDNSCache(time.Minute, net.DefaultResolver)
+	// the first address refusing the connection
+	cache.entries["feeds.example.com"] = dnsEntry{
+		addrs:   []string{"127.0.0.2", "127.0.0.1"},
+		expires: time.Now().Add(time.Minute),
+	}
+	dial := cache.dial((&net.Dialer{}).DialContext)
+	conn, err := dial(context.Background(), "tcp", net.JoinHostPort("feeds.example.com", strconv.Itoa(addr.Port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+// serveDNS answers the queries of the a records of the hosts, the others
+// not found, until the connection is closed.
+func serveDNS(conn net.PacketConn, hosts map[string]net.IP) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 12 {
+			continue
+		}
+		// the question's name, type and class
+		end, labels := 12, make([]string, 0)
+		for end < n && buf[end] != 0 {
+			labels = append(labels, string(buf[end+1:end+1+int(buf[end])]))
+			end += 1 + int(buf[end])
+		}
+		end += 5
+		qtype := binary.BigEndian.Uint16(buf[end-4:])
+		ip, found := hosts[strings.Join(labels, ".")]
+
+		res := append([]byte{}, buf[:end]...)
+		binary.BigEndian.PutUint16(res[2:], 0x8180)
+		binary.BigEndian.PutUint16(res[6:], 0)
+		binary.BigEndian.PutUint16(res[8:], 0)
+		binary.BigEndian.PutUint16(res[10:], 0)
+		switch {
+		case !found:
+			res[3] |= 3 // nxdomain
+		case qtype == 1:
+			binary.BigEndian.PutUint16(res[6:], 1)
+			res = append(res, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+			res = append(res, ip.To4()...)
+		}
+		conn.WriteTo(res, addr)
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go serveDNS(conn, map[string]net.IP{"feeds.example.com": net.IPv4(127, 0, 0, 1)})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	port := strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port)
+
+	for _, opts := range []ClientOptions{
+		{DNSServer: conn.LocalAddr().String()},
+		{DNSServer: conn.LocalAddr().String(), DisableDNSCache: true},
+	} {
+		c, err := newClient(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.get("http://feeds.example.com:" + port + "/feed.xml")
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		res.Body.Close()
+
+		_, err = c.get("http://missing.example.com:" + port + "/feed.xml")
+		var fetchErr *FetchError
+		if !errors.As(err, &fetchErr) || fetchErr.Kind != FetchDNS || !strings.HasPrefix(err.Error(), "dns lookup failed: ") {
+			t.Fatalf("%+v: expected the dns error, got %v", opts, err)
+		}
+	}
+
+	for _, server := range []string{"dns.example.com", "9.9.9.9:dns", "9.9.9.9:0"} {
+		if _, err := newClient(ClientOptions{DNSServer: server}); err == nil {
+			t.Errorf("%s: expected an error", server)
+		}
+	}
+	for _, server := range []string{"9.9.9.9", "9.9.9.9:5353", "2620:fe::fe", "[2620:fe::fe]:53"} {
+		if _, err := newResolver(server); err != nil {
+			t.Errorf("%s: unexpected error %v", server, err)
+		}
+	}
+}
+
+func TestFilterAddrs(t *testing.T) {
+	addrs := []string{"2001:db8::1", "192.0.2.1", "::ffff:192.0.2.2"}
+	if got := filterAddrs(addrs, "tcp4"); !reflect.DeepEqual(got, []string{"192.0.2.1", "::ffff:192.0.2.2"}) {
+		t.Errorf("unexpected ipv4 addresses: %v", got)
+	}
+	if got := filterAddrs(addrs, "tcp6"); !reflect.DeepEqual(got, []string{"2001:db8::1"}) {
+		t.Errorf("unexpected ipv6 addresses: %v", got)
+	}
+	if got := filterAddrs(addrs, "tcp"); len(got) != 3 {
+		t.Errorf("expected all the addresses, got %v", got)
+	}
+}
+
+func TestClientNetwork(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	url := "http://localhost:" + strconv.Itoa(listener.Addr().(*net.TCPAddr).Port) + "/feed.xml"
+
+	for _, opts := range []ClientOptions{{}, {DisableDNSCache: true}} {
+		opts.Network = NetworkIPv6
+		c, err := newClient(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.get(url); err == nil {
+			t.Fatalf("%+v: expected the ipv4 server unreachable", opts)
+		}
+		res, err := c.getContext(WithNetwork(context.Background(), NetworkIPv4), url)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		res.Body.Close()
+	}
+	if _, err := newClient(ClientOptions{Network: "tcp4"}); err == nil {
+		t.Fatal("expected the invalid network rejected")
+	}
+}
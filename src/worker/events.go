@@ -0,0 +1,93 @@
+package worker
+
+import "sync"
+
+const (
+	EventRefreshStarted  = "refresh-started"
+	EventFeedDone        = "feed-done"
+	EventRefreshFinished = "refresh-finished"
+	EventNewItems        = "new-items"
+)
+
+// Number of events buffered per subscriber before it's considered stuck.
+const eventBufferSize = 64
+
+type Event struct {
+	Name string
+	Data interface{}
+}
+
+type Subscription struct {
+	// C is closed when the subscription is cancelled
+	// or dropped for not keeping up with the events.
+	C <-chan Event
+	c chan Event
+}
+
+// EventBus fans out worker events to the subscribers.
+// Publishing never blocks: the subscriber whose buffer is full is dropped.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]bool)}
+}
+
+func (b *EventBus) Subscribe() *Subscription {
+	c := make(chan Event, eventBufferSize)
+	sub := &Subscription{C: c, c: c}
+
+	b.mu.Lock()
+	b.subs[sub] = true
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *EventBus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+func (b *EventBus) Publish(name string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{Name: name, Data: data}
+	for sub := range b.subs {
+		select {
+		case sub.c <- event:
+		default:
+			delete(b.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+type FeedDoneEvent struct {
+	FeedID   int64  `json:"feed_id"`
+	NewItems int    `json:"new_items"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RefreshFinishedEvent is the summary of the refresh run.
+type RefreshFinishedEvent struct {
+	Feeds    int `json:"feeds"`
+	NewItems int `json:"new_items"`
+	Errors   int `json:"errors"`
+	// the feeds left out because the refresh was stopped
+	Skipped int `json:"skipped"`
+	// the feeds with the new items, in the order they were refreshed
+	Updated []NewItemsEvent `json:"updated"`
+}
+
+type NewItemsEvent struct {
+	FeedID int64 `json:"feed_id"`
+	Count  int   `json:"count"`
+}
@@ -0,0 +1,45 @@
+package worker
+
+import "testing"
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe()
+
+	bus.Publish(EventRefreshStarted, 3)
+	event := <-sub.C
+	if event.Name != EventRefreshStarted || event.Data != 3 {
+		t.Fatalf("unexpected event: %#v", event)
+	}
+
+	bus.Unsubscribe(sub)
+	if _, ok := <-sub.C; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+	// must not panic
+	bus.Unsubscribe(sub)
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	slow := bus.Subscribe()
+	fast := bus.Subscribe()
+
+	for i := 0; i < eventBufferSize+1; i++ {
+		bus.Publish(EventNewItems, i)
+		<-fast.C
+	}
+
+	count := 0
+	for range slow.C {
+		count++
+	}
+	if count != eventBufferSize {
+		t.Fatalf("expected %d buffered events, got %d", eventBufferSize, count)
+	}
+
+	bus.Publish(EventNewItems, 0)
+	if _, ok := <-fast.C; !ok {
+		t.Fatal("fast subscriber should still be subscribed")
+	}
+}
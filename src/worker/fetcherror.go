@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// Kinds of the failed requests.
+const (
+	FetchDNS              = "dns"
+	FetchTimeout          = "timeout"
+	FetchTLS              = "tls"
+	FetchConnRefused      = "conn_refused"
+	FetchTooManyRedirects = "too_many_redirects"
+	FetchRedirectLoop     = "redirect_loop"
+	FetchCircuitOpen      = "circuit_open"
+	FetchOther            = "other"
+)
+
+var fetchKindDescriptions = map[string]string{
+	FetchDNS:              "dns lookup failed",
+	FetchTimeout:          "timed out",
+	FetchTLS:              "tls error",
+	FetchConnRefused:      "connection refused",
+	FetchTooManyRedirects: "too many redirects",
+	FetchRedirectLoop:     "redirect loop",
+	FetchCircuitOpen:      "host skipped",
+}
+
+// The redirects followed before giving up by default, the same as the http client's.
+const defaultMaxRedirects = 10
+
+// redirectError is the redirects given up on, along with the urls followed.
+type redirectError struct {
+	loop  bool
+	chain []string
+}
+
+func (e *redirectError) Error() string {
+	chain := strings.Join(e.chain, " → ")
+	if e.loop {
+		return chain
+	}
+	// the last one not followed
+	return fmt.Sprintf("stopped after %d redirects: %s", len(e.chain)-2, chain)
+}
+
+// FetchError is the request that failed without a response.
+type FetchError struct {
+	Kind string
+	URL  string
+	Err  error
+}
+
+func (e *FetchError) Error() string {
+	if description, ok := fetchKindDescriptions[e.Kind]; ok {
+		return description + ": " + e.Err.Error()
+	}
+	return e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKind is the kind the feed errors are grouped by.
+func (e *FetchError) ErrorKind() string {
+	return e.Kind
+}
+
+func newFetchError(url string, err error) *FetchError {
+	return &FetchError{Kind: fetchErrorKind(err), URL: url, Err: err}
+}
+
+func fetchErrorKind(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+	var redirect *redirectError
+	switch {
+	case errors.As(err, &redirect) && redirect.loop:
+		return FetchRedirectLoop
+	case errors.As(err, &redirect):
+		return FetchTooManyRedirects
+	case errors.As(err, &dnsErr):
+		return FetchDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FetchConnRefused
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostname),
+		errors.As(err, &invalid), errors.As(err, &recordHeader):
+		return FetchTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return FetchTimeout
+	}
+	return FetchOther
+}
+
+// The headers sent along the redirects to the other hosts.
+var redirectHeaders = map[string]bool{
+	"User-Agent":      true,
+	"Accept":          true,
+	"Accept-Language": true,
+	"Accept-Encoding": true,
+	"Range":           true,
+	"Referer":         true,
+}
+
+// checkRedirect stops at the url redirected to twice already (once is fine,
+// e.g. back from the page setting a cookie) or after the client's max
+// redirects, with the error listing the urls followed. Only the generic
+// headers are sent to the other hosts: neither the feed's own ones (e.g. the
+// api keys) nor the conditional ones, their validators being the ones
+// of the original host.
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	chain := make([]string, 0, len(via)+1)
+	visits := 0
+	for _, prev := range via {
+		chain = append(chain, prev.URL.String())
+		if prev.URL.String() == req.URL.String() {
+			visits++
+		}
+	}
+	chain = append(chain, req.URL.String())
+	loop := visits >= 2
+	if loop || len(via) > c.maxRedirects {
+		return &redirectError{loop: loop, chain: chain}
+	}
+	if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		for name := range req.Header {
+			if !redirectHeaders[name] {
+				req.Header.Del(name)
+			}
+		}
+	}
+	return nil
+}
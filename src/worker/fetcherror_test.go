@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestFetchErrorKind(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/chain", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		http.Redirect(w, r, "/chain?n="+strconv.Itoa(n+1), http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(mux)
+	defer tlsServer.Close()
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := "http://" + listener.Addr().String()
+	listener.Close()
+
+	testcases := []struct {
+		url  string
+		kind string
+	}{
+		{"http://nonexistent.invalid/feed.xml", FetchDNS},
+		{closed, FetchConnRefused},
+		{server.URL + "/loop", FetchRedirectLoop},
+		{server.URL + "/chain", FetchTooManyRedirects},
+		{server.URL + "/slow", FetchTimeout},
+		{tlsServer.URL, FetchTLS},
+	}
+	for _, tc := range testcases {
+		timeout := 5 * time.Second
+		if tc.kind == FetchTimeout {
+			timeout = 50 * time.Millisecond
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_, err := client.getContext(ctx, tc.url)
+		cancel()
+
+		var fetchErr *FetchError
+		if !errors.As(err, &fetchErr) || fetchErr.Kind != tc.kind || fetchErr.URL != tc.url {
+			t.Errorf("%s: expected %s, got %#v", tc.url, tc.kind, err)
+		}
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	var conditional []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer other.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/b", http.StatusFound)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/a", http.StatusFound)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/feed.xml", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		w.WriteHeader(http.StatusNotModified)
+	})
+	mux.HandleFunc("/elsewhere", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/feed.xml", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c, _ := newClient(ClientOptions{})
+	_, err := c.get(server.URL + "/a")
+	a, b := server.URL+"/a", server.URL+"/b"
+	if want := "redirect loop: " + strings.Join([]string{a, b, a, b, a}, " → "); err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+	c.maxRedirects = 1
+	_, err = c.get(server.URL + "/a")
+	if want := "too many redirects: stopped after 1 redirects: " + strings.Join([]string{a, b, a}, " → "); err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+
+	conditional = nil
+	for _, path := range []string{"/moved", "/elsewhere"} {
+		res, err := c.getConditional(context.Background(), server.URL+path, "", `"v1"`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if len(conditional) != 2 || conditional[0] != `"v1"` || conditional[1] != "" {
+		t.Fatalf("expected the etag sent to the same host only, got %q", conditional)
+	}
+}
+
+func TestRedirectFeedHeaders(t *testing.T) {
+	var apiKeys, userAgents []string
+	record := func(r *http.Request) {
+		apiKeys = append(apiKeys, r.Header.Get("X-Api-Key"))
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+	}
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Write([]byte("ok"))
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/feed.xml", http.StatusMovedPermanently)
+		case "/elsewhere":
+			http.Redirect(w, r, other.URL+"/feed.xml", http.StatusFound)
+		default:
+			record(r)
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	c, _ := newClient(ClientOptions{})
+	header := feedHeader(storage.FeedSettings{Headers: map[string]string{"X-Api-Key": "secret"}, UserAgent: "reader"})
+	for _, path := range []string{"/moved", "/elsewhere"} {
+		res, err := c.do(context.Background(), server.URL+path, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if len(apiKeys) != 2 || apiKeys[0] != "secret" || apiKeys[1] != "" {
+		t.Fatalf("expected the api key sent to the same host only, got %q", apiKeys)
+	}
+	if len(userAgents) != 2 || userAgents[0] != "reader" || userAgents[1] != "reader" {
+		t.Fatalf("expected the user agent sent to both hosts, got %q", userAgents)
+	}
+}
+
+func TestFeedErrorKind(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", "http://nonexistent.invalid/feed.xml", nil)
+	other := db.CreateFeed("", "", "", "http://nonexistent.invalid/other.xml", nil)
+
+	_, err := listItems(context.Background(), *feed, db)
+	if err == nil || !strings.HasPrefix(err.Error(), "dns lookup failed: ") {
+		t.Fatalf("expected the dns failure, got %v", err)
+	}
+	db.SetFeedError(feed.Id, err)
+	db.SetFeedError(other.Id, errors.New("status code 500"))
+	if kinds := db.CountFeedErrors(); kinds[FetchDNS] != 1 || kinds[FetchOther] != 1 {
+		t.Fatalf("unexpected error kinds: %v", kinds)
+	}
+}
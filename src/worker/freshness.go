@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// The longest the server's hints keep the feed from being fetched,
+// not to stop fetching it for a year if told so.
+const maxFreshness = 24 * time.Hour
+
+// cacheMaxAge returns the seconds the response may be cached for as told by
+// its Cache-Control header, less its age. 0 if not told or not to be cached.
+func cacheMaxAge(header http.Header) int64 {
+	maxAge := int64(0)
+	for _, directive := range strings.Split(strings.Join(header.Values("Cache-Control"), ","), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			n, err := strconv.ParseInt(strings.Trim(directive[len("max-age="):], `"`), 10, 64)
+			if err != nil || n < 0 {
+				return 0
+			}
+			maxAge = n
+		}
+	}
+	if age, err := strconv.ParseInt(strings.TrimSpace(header.Get("Age")), 10, 64); err == nil && age > 0 {
+		maxAge -= age
+	}
+	if maxAge < 0 {
+		return 0
+	}
+	return maxAge
+}
+
+// freshUntil returns the time the feed stays fresh until as told by
+// the server, the larger of the max-age and the ttl since the last refresh,
+// up to maxFreshness.
+func freshUntil(state storage.HTTPState) time.Time {
+	lifetime := state.MaxAge
+	if state.TTL > lifetime {
+		lifetime = state.TTL
+	}
+	d := time.Duration(lifetime) * time.Second
+	if d > maxFreshness {
+		d = maxFreshness
+	}
+	return state.LastRefreshed.Add(d)
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestCacheMaxAge(t *testing.T) {
+	testcases := []struct {
+		cacheControl string
+		age          string
+		want         int64
+	}{
+		{"", "", 0},
+		{"public, max-age=3600", "", 3600},
+		{`max-age="600"`, "", 600},
+		{"max-age=3600", "600", 3000},
+		{"max-age=60", "600", 0},
+		{"no-cache, max-age=3600", "", 0},
+		{"max-age=-1", "", 0},
+		{"max-age=soon", "", 0},
+	}
+	for _, tc := range testcases {
+		header := make(http.Header)
+		if tc.cacheControl != "" {
+			header.Set("Cache-Control", tc.cacheControl)
+		}
+		if tc.age != "" {
+			header.Set("Age", tc.age)
+		}
+		if got := cacheMaxAge(header); got != tc.want {
+			t.Errorf("%q (age %q): expected %d, got %d", tc.cacheControl, tc.age, tc.want, got)
+		}
+	}
+}
+
+func TestFreshUntil(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	testcases := []struct {
+		maxAge, ttl int64
+		want        time.Time
+	}{
+		{0, 0, now},
+		{3600, 0, now.Add(time.Hour)},
+		{600, 7200, now.Add(2 * time.Hour)},
+		{86400 * 365, 0, now.Add(maxFreshness)},
+	}
+	for _, tc := range testcases {
+		state := storage.HTTPState{LastRefreshed: now, MaxAge: tc.maxAge, TTL: tc.ttl}
+		if got := freshUntil(state); !got.Equal(tc.want) {
+			t.Errorf("max-age %d, ttl %d: expected %s, got %s", tc.maxAge, tc.ttl, tc.want, got)
+		}
+	}
+}
+
+func TestDueFeedsFreshness(t *testing.T) {
+	cacheControl := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if r.URL.Path == "/ttl" {
+			if r.Header.Get("If-None-Match") != "" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Etag", `"v1"`)
+			w.Write([]byte(`<rss><channel><ttl>120</ttl><item><guid>1</guid></item></channel></rss>`))
+			return
+		}
+		w.Write([]byte(`<rss><channel><item><guid>1</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	cached := db.CreateFeed("cached", "", "", server.URL+"/cached", nil)
+	ttl := db.CreateFeed("ttl", "", "", server.URL+"/ttl", nil)
+	w := NewWorker(db)
+	defer w.Stop()
+
+	due := func(after time.Duration) []string {
+		titles := make([]string, 0)
+		for _, feed := range w.DueFeeds(db.ListFeeds(), time.Now().Add(after)) {
+			titles = append(titles, feed.Title)
+		}
+		return titles
+	}
+
+	cacheControl = "max-age=3600"
+	listItems(context.Background(), *cached, db)
+	cacheControl = ""
+	listItems(context.Background(), *ttl, db)
+	if got := due(0); len(got) != 0 {
+		t.Fatalf("expected both feeds fresh, got %v", got)
+	}
+	if got := due(90 * time.Minute); !equalStrings(got, []string{"cached"}) {
+		t.Fatalf("expected the cached feed due after an hour, got %v", got)
+	}
+	if got := due(3 * time.Hour); !equalStrings(got, []string{"cached", "ttl"}) {
+		t.Fatalf("expected both feeds due, got %v", got)
+	}
+
+	// the ttl is kept on 304
+	listItems(context.Background(), *ttl, db)
+	if got := due(90 * time.Minute); !equalStrings(got, []string{"cached"}) {
+		t.Fatalf("expected the ttl kept, got %v", got)
+	}
+
+	db.UpdateSettings(map[string]interface{}{"ignore_freshness_hints": true})
+	if got := due(0); !equalStrings(got, []string{"cached", "ttl"}) {
+		t.Fatalf("expected the hints ignored, got %v", got)
+	}
+}
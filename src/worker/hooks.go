@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+const (
+	hookTimeoutDefault = time.Second * 30
+	// only the beginning of the command output goes to the log
+	hookOutputMax = 4096
+)
+
+// the hooks run arbitrary commands, so they're off unless enabled by the operator
+var execHooks bool
+
+// SetExecHooks enables the per-feed commands run on new items.
+func SetExecHooks(enabled bool) {
+	execHooks = enabled
+}
+
+// ExecHooksEnabled reports whether the per-feed commands are run.
+func ExecHooksEnabled() bool {
+	return execHooks
+}
+
+// hookQueue holds the batches of new items waiting for the command, per feed.
+// A feed has a runner while it has an entry in the map.
+type hookQueue struct {
+	mu      sync.Mutex
+	pending map[int64][][]storage.Item
+}
+
+// runHook queues the new items for the feed command, if the feed has one.
+// The batches of the feed are handled one at a time, in order.
+func (w *Worker) runHook(feed storage.Feed, items []storage.Item) {
+	if !execHooks || len(items) == 0 || w.db.GetFeedSettings(feed.Id).HookCommand == "" {
+		return
+	}
+
+	w.hooks.mu.Lock()
+	defer w.hooks.mu.Unlock()
+
+	if w.hooks.pending == nil {
+		w.hooks.pending = make(map[int64][][]storage.Item)
+	}
+	batches, running := w.hooks.pending[feed.Id]
+	w.hooks.pending[feed.Id] = append(batches, items)
+	if !running {
+		w.running.Add(1)
+		go w.hookRunner(feed)
+	}
+}
+
+func (w *Worker) hookRunner(feed storage.Feed) {
+	defer w.running.Done()
+	for {
+		w.hooks.mu.Lock()
+		batches := w.hooks.pending[feed.Id]
+		if len(batches) == 0 || w.ctx.Err() != nil {
+			delete(w.hooks.pending, feed.Id)
+			w.hooks.mu.Unlock()
+			return
+		}
+		items := batches[0]
+		w.hooks.pending[feed.Id] = batches[1:]
+		w.hooks.mu.Unlock()
+
+		// the command may have been changed or removed since
+		settings := w.db.GetFeedSettings(feed.Id)
+		if settings.HookCommand == "" {
+			continue
+		}
+		start := time.Now()
+		stdout, stderr, err := w.execHook(feed, settings, items)
+		fields := logger.Fields{
+			"feed_id":     feed.Id,
+			"items":       len(items),
+			"duration_ms": time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			fields["error"] = err
+			logger.With(fields).Warn("feed hook failed")
+		}
+		fields["stdout"] = stdout
+		fields["stderr"] = stderr
+		logger.With(fields).Debug("feed hook output")
+	}
+}
+
+// execHook runs the command with the items on stdin and returns its output.
+func (w *Worker) execHook(feed storage.Feed, settings storage.FeedSettings, items []storage.Item) (string, string, error) {
+	payload, err := json.Marshal(items)
+	if err != nil {
+		return "", "", err
+	}
+	timeout := hookTimeoutDefault
+	if settings.HookTimeout > 0 {
+		timeout = time.Duration(settings.HookTimeout) * time.Second
+	}
+
+	cmd := hookCommand(settings.HookCommand)
+	cmd.Dir = settings.HookDir
+	cmd.Env = append(
+		os.Environ(),
+		"YARR_FEED_ID="+strconv.FormatInt(feed.Id, 10),
+		"YARR_FEED_TITLE="+feed.Title,
+		"YARR_FEED_URL="+feed.FeedLink,
+		"YARR_ITEM_COUNT="+strconv.Itoa(len(items)),
+	)
+	cmd.Stdin = bytes.NewReader(payload)
+	stdout := &limitedBuffer{max: hookOutputMax}
+	stderr := &limitedBuffer{max: hookOutputMax}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return "", "", err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		killHook(cmd)
+		<-done
+		err = fmt.Errorf("timed out after %s", timeout)
+	case <-w.ctx.Done():
+		killHook(cmd)
+		<-done
+		err = w.ctx.Err()
+	}
+	return stdout.String(), stderr.String(), err
+}
+
+// limitedBuffer keeps the first max bytes written and discards the rest.
+type limitedBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are for sh")
+	}
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("Status", "", "", "https://example.com/feed.xml", nil)
+	first := db.CreateItems([]storage.Item{{GUID: "1", FeedId: feed.Id, Title: "one"}})
+	second := db.CreateItems([]storage.Item{
+		{GUID: "2", FeedId: feed.Id, Title: "two"},
+		{GUID: "3", FeedId: feed.Id, Title: "three"},
+	})
+
+	dir := t.TempDir()
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{
+		// the first batch is slower, the second one must wait for it
+		HookCommand: `sleep 0.$((2 / YARR_ITEM_COUNT)); cat > "batch-$YARR_ITEM_COUNT.json"; echo "$YARR_FEED_ID $YARR_FEED_TITLE $YARR_ITEM_COUNT" >> log.txt`,
+		HookDir:     dir,
+	})
+
+	w := NewWorker(db)
+	defer w.Stop()
+
+	// disabled by default
+	w.runHook(*feed, first)
+	if w.hooks.pending != nil {
+		t.Fatal("expected no commands without --allow-exec-hooks")
+	}
+
+	SetExecHooks(true)
+	defer SetExecHooks(false)
+	w.runHook(*feed, first)
+	w.runHook(*feed, second)
+
+	logfile := filepath.Join(dir, "log.txt")
+	var lines []string
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 20) {
+		body, _ := os.ReadFile(logfile)
+		if lines = strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) == 2 {
+			break
+		}
+	}
+	want := []string{"1 Status 1", "1 Status 2"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Fatalf("want %q, have %q", want, lines)
+	}
+
+	body, _ := os.ReadFile(filepath.Join(dir, "batch-2.json"))
+	var items []storage.Item
+	if err := json.Unmarshal(body, &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Title != "two" || items[1].Title != "three" {
+		t.Fatalf("unexpected items: %s", body)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are for sh")
+	}
+
+	w := NewWorker(nil)
+	defer w.Stop()
+
+	settings := storage.FeedSettings{
+		// the child keeps the output open unless killed along with the shell
+		HookCommand: "echo started; sleep 10 & wait",
+		HookTimeout: 1,
+	}
+	start := time.Now()
+	stdout, _, err := w.execHook(storage.Feed{Id: 1}, settings, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > time.Second*5 {
+		t.Fatalf("the command wasn't killed in time: %s", time.Since(start))
+	}
+	if stdout != "started\n" {
+		t.Fatalf("unexpected output: %q", stdout)
+	}
+}
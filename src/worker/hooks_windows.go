@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package worker
+
+import (
+	"os/exec"
+)
+
+func hookCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+func killHook(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// hostLimiter limits the number of feeds fetched at once from the same host,
+// so that the feeds of one site don't get the ip rate-limited.
+type hostLimiter struct {
+	// 0 for no limit
+	limit int
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+func (l *hostLimiter) slot(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot, ok := l.slots[host]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[host] = slot
+	}
+	return slot
+}
+
+// acquire waits until the host is free, returns false if the ctx is done first.
+func (l *hostLimiter) acquire(ctx context.Context, host string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	select {
+	case l.slot(host) <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *hostLimiter) release(host string) {
+	if l.limit <= 0 {
+		return
+	}
+	<-l.slot(host)
+}
+
+func feedHost(feedLink string) string {
+	u, err := url.Parse(feedLink)
+	if err != nil || u.Host == "" {
+		return feedLink
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// interleaveHosts orders the feeds round-robin by host, keeping the order
+// within the host. The feeds of the same host are spread through the queue,
+// rather than taking up all the workers waiting for each other.
+func interleaveHosts(feeds []storage.Feed) []storage.Feed {
+	hosts := make([]string, 0)
+	byHost := make(map[string][]storage.Feed)
+	for _, feed := range feeds {
+		host := feedHost(feed.FeedLink)
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], feed)
+	}
+
+	result := make([]storage.Feed, 0, len(feeds))
+	for round := 0; len(result) < len(feeds); round++ {
+		for _, host := range hosts {
+			if round < len(byHost[host]) {
+				result = append(result, byHost[host][round])
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestInterleaveHosts(t *testing.T) {
+	feeds := make([]storage.Feed, 0)
+	for _, link := range []string{
+		"https://a.example/1",
+		"https://a.example/2",
+		"https://A.example:8080/3",
+		"https://b.example/1",
+		"https://c.example/1",
+		"https://c.example/2",
+	} {
+		feeds = append(feeds, storage.Feed{FeedLink: link})
+	}
+	links := make([]string, 0)
+	for _, feed := range interleaveHosts(feeds) {
+		links = append(links, feed.FeedLink)
+	}
+	want := []string{
+		"https://a.example/1",
+		"https://b.example/1",
+		"https://c.example/1",
+		"https://a.example/2",
+		"https://c.example/2",
+		"https://A.example:8080/3",
+	}
+	if !equalStrings(links, want) {
+		t.Fatalf("expected %v, got %v", want, links)
+	}
+}
+
+func TestRefreshPerHost(t *testing.T) {
+	var mu sync.Mutex
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	total, maxTotal := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.Split(r.Host, ":")[0]
+		mu.Lock()
+		running[host]++
+		total++
+		if running[host] > maxRunning[host] {
+			maxRunning[host] = running[host]
+		}
+		if total > maxTotal {
+			maxTotal = total
+		}
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		running[host]--
+		total--
+		mu.Unlock()
+		w.Write([]byte(`<rss><channel></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	// the same server under two host names
+	other := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	for _, name := range []string{"1", "2", "3"} {
+		db.CreateFeed(name, "", "", server.URL+"/"+name, nil)
+		db.CreateFeed(name, "", "", other+"/"+name, nil)
+	}
+
+	defer SetNumWorkers(numWorkers)
+	SetNumWorkers(4)
+	w := NewWorker(db)
+	defer w.Stop()
+
+	if summary, ok := w.RefreshFeedsAndWait(db.ListFeeds()); !ok || summary.Errors != 0 {
+		t.Fatalf("unexpected refresh: %#v %v", summary, ok)
+	}
+	if maxRunning["127.0.0.1"] != 1 || maxRunning["localhost"] != 1 || maxTotal != 2 {
+		t.Fatalf("expected one request per host at once, got %v (%d in total)", maxRunning, maxTotal)
+	}
+}
@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/content/scraper"
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+const (
+	imageFetchWorkers   = 2
+	imageFetchQueueSize = 256
+	// only the beginning of the page is read, the meta tags are in the head
+	imagePageMaxSize = 512 * 1024
+	// pages without an image aren't looked at again for that long
+	imageMissTTL = time.Hour * 24
+	imageMissMax = 4096
+)
+
+var errNotHTML = errors.New("not an html page")
+
+type imageFetcher struct {
+	start  sync.Once
+	queue  chan storage.Item
+	mu     sync.Mutex
+	misses map[string]time.Time
+}
+
+// fetchImages queues the items without an image to look it up on the article page,
+// if enabled for the feed. The items that don't fit in the queue are skipped,
+// so the refresh is never held up.
+func (w *Worker) fetchImages(feed storage.Feed, items []storage.Item) {
+	if len(items) == 0 || !w.db.GetFeedSettings(feed.Id).FetchImages {
+		return
+	}
+	w.images.start.Do(func() {
+		w.images.queue = make(chan storage.Item, imageFetchQueueSize)
+		w.images.misses = make(map[string]time.Time)
+		for i := 0; i < imageFetchWorkers; i++ {
+			w.running.Add(1)
+			go w.imageWorker()
+		}
+	})
+	for _, item := range items {
+		if item.ImageURL != nil || !htmlutil.IsAPossibleLink(item.Link) || w.images.missed(item.Link) {
+			continue
+		}
+		select {
+		case w.images.queue <- item:
+		default:
+			logger.With(logger.Fields{"feed_id": feed.Id}).Debug("image queue is full, skipping")
+			return
+		}
+	}
+}
+
+func (w *Worker) imageWorker() {
+	defer w.running.Done()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case item := <-w.images.queue:
+			ctx, meter := WithTransferMeter(WithFeedConnection(w.ctx, w.db.GetFeedSettings(item.FeedId)))
+			image, err := findItemImage(ctx, item.Link)
+			if w.ctx.Err() != nil {
+				return
+			}
+			w.db.AddFeedTransfer(item.FeedId, meter.Bytes(), time.Now())
+			if image == "" {
+				w.images.miss(item.Link)
+				if err != nil {
+					logger.With(logger.Fields{"item_id": item.Id, "url": item.Link, "error": err}).Debug("failed to fetch item image")
+				}
+				continue
+			}
+			w.db.UpdateItemImage(item.Id, image)
+		}
+	}
+}
+
+func (f *imageFetcher) missed(link string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, ok := f.misses[link]
+	if ok && time.Since(t) > imageMissTTL {
+		delete(f.misses, link)
+		return false
+	}
+	return ok
+}
+
+func (f *imageFetcher) miss(link string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.misses) >= imageMissMax {
+		for key, t := range f.misses {
+			if time.Since(t) > imageMissTTL {
+				delete(f.misses, key)
+			}
+		}
+		if len(f.misses) >= imageMissMax {
+			f.misses = make(map[string]time.Time)
+		}
+	}
+	f.misses[link] = time.Now()
+}
+
+// findItemImage returns the preview image of the article page.
+func findItemImage(ctx context.Context, link string) (string, error) {
+	// skip the downloads, videos, etc. without fetching them
+	if res, err := client.request(ctx, "HEAD", link, nil); err == nil {
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK && !isHTML(res) {
+			return "", errNotHTML
+		}
+	}
+
+	res, err := client.getContext(ctx, link)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New(res.Status)
+	}
+	if !isHTML(res) {
+		return "", errNotHTML
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, imagePageMaxSize))
+	if err != nil {
+		return "", err
+	}
+	return scraper.FindImage(string(body), res.Request.URL.String()), nil
+}
+
+func isHTML(res *http.Response) bool {
+	contentType := res.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestFetchImages(t *testing.T) {
+	var pageRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/with-image":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<html><head><meta property="og:image" content="/cover.jpg"></head></html>`))
+		case "/without-image":
+			if r.Method == "GET" {
+				atomic.AddInt32(&pageRequests, 1)
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><head><title>nothing</title></head></html>`))
+		case "/file.pdf":
+			if r.Method == "GET" {
+				t.Error("non-html page fetched")
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+		}
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL+"/feed.xml", nil)
+	image := "https://example.com/existing.jpg"
+	created := db.CreateItems([]storage.Item{
+		{GUID: "1", FeedId: feed.Id, Link: server.URL + "/with-image"},
+		{GUID: "2", FeedId: feed.Id, Link: server.URL + "/without-image"},
+		{GUID: "3", FeedId: feed.Id, Link: server.URL + "/file.pdf"},
+		{GUID: "4", FeedId: feed.Id, Link: server.URL + "/with-image", ImageURL: &image},
+	})
+
+	w := NewWorker(db)
+	defer w.Stop()
+
+	// disabled by default
+	w.fetchImages(*feed, created)
+	if w.images.queue != nil {
+		t.Fatal("expected no lookups without the feed setting")
+	}
+
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{FetchImages: true})
+	w.fetchImages(*feed, created)
+
+	getImage := func(id int64) string {
+		if item := db.GetItem(id); item != nil && item.ImageURL != nil {
+			return *item.ImageURL
+		}
+		return ""
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for getImage(created[0].Id) == "" || !w.images.missed(server.URL+"/without-image") {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the images")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if have := getImage(created[0].Id); have != server.URL+"/cover.jpg" {
+		t.Errorf("unexpected image: %q", have)
+	}
+	if have := getImage(created[3].Id); have != image {
+		t.Errorf("existing image replaced: %q", have)
+	}
+
+	// the miss is remembered
+	w.fetchImages(*feed, created[1:2])
+	time.Sleep(time.Millisecond * 50)
+	if n := atomic.LoadInt32(&pageRequests); n != 1 {
+		t.Errorf("expected the page without an image to be fetched once, got %d", n)
+	}
+}
+
+func TestCacheItemImages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(make([]byte, offlineImageMaxSize+1))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		}
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", server.URL+"/feed.xml", nil)
+	item := db.CreateItems([]storage.Item{{
+		GUID:   "1",
+		FeedId: feed.Id,
+		Content: `<img src="` + server.URL + `/small.png">` +
+			`<img src="` + server.URL + `/large.png">` +
+			`<img src="` + server.URL + `/page.html">` +
+			`<img src="data:image/png;base64,AAAA">`,
+	}})[0]
+
+	w := NewWorker(db)
+	defer w.Stop()
+
+	// not starred
+	w.cacheItemImages(item.Id)
+	if keys := db.ItemImageKeys(item.Id); len(keys) != 0 {
+		t.Fatalf("expected no images, got %v", keys)
+	}
+
+	db.UpdateItemStatus(item.Id, storage.STARRED)
+	w.cacheItemImages(item.Id)
+	keys := db.ItemImageKeys(item.Id)
+	if len(keys) != 1 || keys[server.URL+"/small.png"] == "" {
+		t.Fatalf("expected only the small image to be cached, got %v", keys)
+	}
+	if transfers := db.ListFeedTransfers(time.Now()); len(transfers) != 1 || transfers[0].Requests != 3 {
+		t.Fatalf("expected the downloads to be attributed to the feed, got %v", transfers)
+	}
+}
@@ -0,0 +1,20 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/nkanaev/yarr/src/importer"
+)
+
+// StartImport brings over the subscriptions and the entries from another
+// reader in the background, as the "import" job. The import stopped along
+// with the worker is resumed by starting it again with the same source.
+func (w *Worker) StartImport(im *importer.Importer) (Job, error) {
+	return w.startJob("import", func(ctx context.Context, progress func(map[string]int64)) (map[string]int64, error) {
+		result, err := im.Run(ctx, w.db, progress)
+		if result["feeds_created"] > 0 {
+			w.FindFavicons()
+		}
+		return result, err
+	})
+}
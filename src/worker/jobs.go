@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Number of finished jobs kept around for polling.
+const jobsKeep = 20
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrBusy       = errors.New("refresh or another job in progress")
+	ErrStopped    = errors.New("worker is stopped")
+)
+
+type Job struct {
+	Id         int64     `json:"id"`
+	Kind       string    `json:"kind"`
+	Status     string    `json:"status"`
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"duration_ms"`
+	// the counters of the running job are updated as it goes
+	Result map[string]int64 `json:"result,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// jobRun does the job and reports the counters to progress along the way.
+type jobRun func(ctx context.Context, progress func(map[string]int64)) (map[string]int64, error)
+
+var jobKinds = map[string]func(db *storage.Storage) map[string]int64{
+	"cleanup": func(db *storage.Storage) map[string]int64 {
+		return map[string]int64{"deleted": db.DeleteOldItems()}
+	},
+	"reindex": func(db *storage.Storage) map[string]int64 {
+		return map[string]int64{"indexed": db.RebuildSearch()}
+	},
+	"recount": func(db *storage.Storage) map[string]int64 {
+		return db.RecomputeCounters()
+	},
+	"deadfeeds": func(db *storage.Storage) map[string]int64 {
+		return map[string]int64{"reported": int64(len(db.ReportDeadFeeds(time.Now())))}
+	},
+}
+
+type jobList struct {
+	mu     sync.Mutex
+	lastId int64
+	jobs   []*Job
+}
+
+func (w *Worker) jobRunning() bool {
+	w.jobs.mu.Lock()
+	defer w.jobs.mu.Unlock()
+
+	for _, job := range w.jobs.jobs {
+		if job.Status == JobRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// StartJob runs the maintenance job of the given kind in the background.
+// Jobs don't overlap with each other or with the feed refresh.
+func (w *Worker) StartJob(kind string) (Job, error) {
+	run, ok := jobKinds[kind]
+	if !ok {
+		return Job{}, ErrUnknownJob
+	}
+	return w.startJob(kind, func(context.Context, func(map[string]int64)) (map[string]int64, error) {
+		return run(w.db), nil
+	})
+}
+
+func (w *Worker) startJob(kind string, run jobRun) (Job, error) {
+	w.reflock.Lock()
+	defer w.reflock.Unlock()
+
+	if w.FeedsPending() > 0 || len(w.single) > 0 || w.jobRunning() {
+		return Job{}, ErrBusy
+	}
+	if w.ctx.Err() != nil {
+		return Job{}, ErrStopped
+	}
+
+	w.jobs.mu.Lock()
+	w.jobs.lastId++
+	job := &Job{Id: w.jobs.lastId, Kind: kind, Status: JobRunning, Started: time.Now()}
+	w.jobs.jobs = append(w.jobs.jobs, job)
+	if len(w.jobs.jobs) > jobsKeep {
+		w.jobs.jobs = w.jobs.jobs[len(w.jobs.jobs)-jobsKeep:]
+	}
+	snapshot := *job
+	w.jobs.mu.Unlock()
+
+	w.running.Add(1)
+	go func() {
+		defer w.running.Done()
+		result, err := run(w.ctx, func(progress map[string]int64) {
+			w.jobs.mu.Lock()
+			job.Result = copyCounters(progress)
+			w.jobs.mu.Unlock()
+		})
+		duration := time.Since(snapshot.Started).Milliseconds()
+
+		w.jobs.mu.Lock()
+		job.Status = JobDone
+		job.Result = copyCounters(result)
+		job.DurationMs = duration
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+		}
+		w.jobs.mu.Unlock()
+
+		if err != nil {
+			logger.With(logger.Fields{"job": kind, "duration_ms": duration, "error": err}).Warn("job failed")
+			return
+		}
+		logger.With(logger.Fields{"job": kind, "duration_ms": duration}).Info("finished job")
+	}()
+	return snapshot, nil
+}
+
+func copyCounters(counters map[string]int64) map[string]int64 {
+	if counters == nil {
+		return nil
+	}
+	result := make(map[string]int64, len(counters))
+	for key, val := range counters {
+		result[key] = val
+	}
+	return result
+}
+
+func (w *Worker) GetJob(id int64) *Job {
+	w.jobs.mu.Lock()
+	defer w.jobs.mu.Unlock()
+
+	for _, job := range w.jobs.jobs {
+		if job.Id == id {
+			snapshot := *job
+			return &snapshot
+		}
+	}
+	return nil
+}
+
+func (w *Worker) ListJobs() []Job {
+	w.jobs.mu.Lock()
+	defer w.jobs.mu.Unlock()
+
+	result := make([]Job, 0, len(w.jobs.jobs))
+	for _, job := range w.jobs.jobs {
+		result = append(result, *job)
+	}
+	return result
+}
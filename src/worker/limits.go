@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// itemLimits are the limits on the items accepted per refresh, 0 for no limit.
+type itemLimits struct {
+	maxItems int
+	maxAge   time.Duration
+}
+
+// itemLimits returns the limits of the feed, falling back to
+// `max_items_per_refresh` and `max_item_age_days` if it has none.
+func (w *Worker) itemLimits(feedID int64) itemLimits {
+	settings := w.db.GetFeedSettings(feedID)
+	maxItems := settings.MaxItems
+	if maxItems == 0 {
+		maxItems = w.db.GetSettingsValueInt64("max_items_per_refresh")
+	}
+	maxAgeDays := settings.MaxItemAgeDays
+	if maxAgeDays == 0 {
+		maxAgeDays = w.db.GetSettingsValueInt64("max_item_age_days")
+	}
+	return itemLimits{
+		maxItems: int(maxItems),
+		maxAge:   time.Duration(maxAgeDays) * 24 * time.Hour,
+	}
+}
+
+// limitItems drops the items older than maxAge and all but the newest maxItems.
+// The items without a date are never too old.
+func limitItems(items []storage.Item, limits itemLimits, now time.Time) []storage.Item {
+	result := make([]storage.Item, 0, len(items))
+	for _, item := range items {
+		if limits.maxAge > 0 && !item.Date.IsZero() && now.Sub(item.Date) > limits.maxAge {
+			continue
+		}
+		result = append(result, item)
+	}
+	if limits.maxItems > 0 && len(result) > limits.maxItems {
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Date.After(result[j].Date)
+		})
+		result = result[:limits.maxItems]
+	}
+	return result
+}
+
+// createItems stores the items of the feed within its limits and returns the new ones.
+// The items republished under a new guid are left out, the stored ones that changed
+// are updated if the feed tracks the updates.
+// On the first fetch of the feed, if `first_fetch_read_days` is set, the items older
+// than that are stored as read rather than dropped for their age.
+func (w *Worker) createItems(feed storage.Feed, items []storage.Item) []storage.Item {
+	now := time.Now()
+	limits := w.itemLimits(feed.Id)
+	readDays := w.db.GetSettingsValueInt64("first_fetch_read_days")
+	first := readDays > 0 && w.db.CountItems(storage.ItemFilter{FeedID: &feed.Id}) == 0
+	if first {
+		limits.maxAge = 0
+	}
+
+	items = w.db.DropRepublishedItems(feed.Id, items)
+	if w.db.GetFeedSettings(feed.Id).TrackUpdates {
+		if updated := w.db.UpdateChangedItems(items); len(updated) > 0 {
+			logger.With(logger.Fields{"feed_id": feed.Id, "updated": len(updated)}).Debug("updated changed items")
+		}
+	}
+	accepted := limitItems(items, limits, now)
+	if dropped := len(items) - len(accepted); dropped > 0 {
+		logger.With(logger.Fields{
+			"feed_id":   feed.Id,
+			"dropped":   dropped,
+			"max_items": limits.maxItems,
+			"max_age":   limits.maxAge.String(),
+		}).Debug("dropped items beyond the limits")
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+	created := w.db.CreateItems(accepted)
+	if first && len(created) > 0 {
+		before := now.Add(-time.Duration(readDays) * 24 * time.Hour)
+		w.db.MarkItemsReadOlderThan(storage.MarkFilter{FeedID: &feed.Id}, before)
+		for i := range created {
+			if created[i].Date.Before(before) {
+				created[i].Status = storage.READ
+			}
+		}
+	}
+	return created
+}
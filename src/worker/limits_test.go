@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestLimitItems(t *testing.T) {
+	now := time.Now()
+	items := make([]storage.Item, 0)
+	for i := 0; i < 5; i++ {
+		items = append(items, storage.Item{GUID: strconv.Itoa(i), Date: now.AddDate(0, 0, -10*i)})
+	}
+	items = append(items, storage.Item{GUID: "undated"})
+
+	guids := func(items []storage.Item) []string {
+		result := make([]string, 0)
+		for _, item := range items {
+			result = append(result, item.GUID)
+		}
+		return result
+	}
+	testcases := []struct {
+		limits itemLimits
+		want   []string
+	}{
+		{itemLimits{}, []string{"0", "1", "2", "3", "4", "undated"}},
+		{itemLimits{maxAge: 25 * 24 * time.Hour}, []string{"0", "1", "2", "undated"}},
+		{itemLimits{maxItems: 2}, []string{"0", "1"}},
+		{itemLimits{maxItems: 3, maxAge: 15 * 24 * time.Hour}, []string{"0", "1", "undated"}},
+	}
+	for _, tc := range testcases {
+		if got := guids(limitItems(items, tc.limits, now)); !equalStrings(got, tc.want) {
+			t.Errorf("%+v: expected %v, got %v", tc.limits, tc.want, got)
+		}
+	}
+}
+
+func TestCreateItemsLimits(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", "http://example.com/feed.xml", nil)
+	w := NewWorker(db)
+	defer w.Stop()
+
+	now := time.Now()
+	items := func(days ...int) []storage.Item {
+		result := make([]storage.Item, 0)
+		for _, day := range days {
+			result = append(result, storage.Item{GUID: strconv.Itoa(day), FeedId: feed.Id, Date: now.AddDate(0, 0, -day)})
+		}
+		return result
+	}
+	db.UpdateSettings(map[string]interface{}{
+		"max_item_age_days":     30,
+		"first_fetch_read_days": 7,
+	})
+
+	// the first fetch keeps the old items, read
+	if created := w.createItems(*feed, items(1, 10, 100)); len(created) != 3 || created[0].Status != storage.READ || created[2].Status != storage.UNREAD {
+		t.Fatalf("expected all the items created, got %#v", created)
+	}
+	unread := storage.UNREAD
+	if count := db.CountItems(storage.ItemFilter{FeedID: &feed.Id, Status: &unread}); count != 1 {
+		t.Fatalf("expected the items older than a week read, got %d unread", count)
+	}
+
+	// later on the age limit applies
+	if created := w.createItems(*feed, items(2, 200)); len(created) != 1 || created[0].Status != storage.UNREAD {
+		t.Fatalf("expected the old item dropped, got %#v", created)
+	}
+
+	// the feed's own limit wins
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{MaxItems: 1, MaxItemAgeDays: 365})
+	if created := w.createItems(*feed, items(3, 4, 300)); len(created) != 1 || created[0].GUID != "3" {
+		t.Fatalf("expected only the newest item created, got %#v", created)
+	}
+}
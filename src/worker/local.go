@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/parser"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// the feeds are read from the files on the server only if the operator
+// turns it on, anyone adding feeds could read the server's files otherwise
+var localFeeds = false
+
+// SetLocalFeeds enables the feeds read from the local files (file:// urls).
+func SetLocalFeeds(enabled bool) {
+	localFeeds = enabled
+}
+
+var errLocalFeedsDisabled = errors.New("local feeds are disabled (-allow-local-feeds)")
+
+// localFeedPath returns the path of the file the feed link (a file:// url
+// or an absolute path) points to, false for the other links.
+func localFeedPath(link string) (string, bool) {
+	if !strings.Contains(link, "://") && filepath.IsAbs(link) {
+		return filepath.Clean(link), true
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") || u.Path == "" {
+		return "", false
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		// file:///C:/feed.xml
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path), true
+}
+
+// localFeedLink returns the file:// url of the path.
+func localFeedLink(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// readLocalFeed reads the feed file, the regular files only (not the devices
+// or the pipes, which could be read forever) and up to the size limit of
+// the fetched feeds.
+func readLocalFeed(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxBodySize {
+		return nil, fmt.Errorf("feed exceeds the size limit of %d bytes", MaxBodySize)
+	}
+	return data, nil
+}
+
+// discoverLocalFeed parses the file, there's no page linking to the feeds
+// to look into.
+func discoverLocalFeed(path string) (*DiscoverResult, error) {
+	if !localFeeds {
+		return nil, errLocalFeedsDisabled
+	}
+	data, err := readLocalFeed(path)
+	if err != nil {
+		return nil, err
+	}
+	link := localFeedLink(path)
+	feed, err := parser.ParseAndFix(bytes.NewReader(data), link, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &DiscoverResult{Feed: feed, FeedLink: link}, nil
+}
+
+// listLocalItems reads the feed from the file unless its modification time
+// (kept in place of Last-Modified) and its content are the same as the last time.
+func listLocalItems(f storage.Feed, path string, settings storage.FeedSettings, db *storage.Storage) ([]storage.Item, error) {
+	if !localFeeds {
+		return nil, errLocalFeedsDisabled
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	modTime := info.ModTime().UTC().Format(time.RFC3339Nano)
+	lmod, bodyHash := "", ""
+	if state := db.GetHTTPState(f.Id); state != nil && !settings.IgnoreCaching {
+		lmod, bodyHash = state.LastModified, state.BodyHash
+	}
+	if modTime == lmod {
+		db.SetHTTPState(f.Id, lmod, "", bodyHash)
+		return nil, nil
+	}
+
+	data, err := readLocalFeed(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	newHash := hex.EncodeToString(sum[:])
+	if settings.IgnoreCaching {
+		modTime, newHash = "", ""
+	}
+	if newHash != "" && newHash == bodyHash {
+		// touched only
+		db.SetHTTPState(f.Id, modTime, "", newHash)
+		return nil, nil
+	}
+	feed, err := parser.ParseAndFix(bytes.NewReader(data), f.FeedLink, "", feedLocation(settings))
+	if err != nil {
+		return nil, err
+	}
+	db.SetHTTPState(f.Id, modTime, "", newHash)
+	db.SetHTTPFreshness(f.Id, 0, int64(feed.TTL)*60)
+	return ConvertItems(feed.Items, f, db.GetSettingsValueBool("strip_trackers")), nil
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestLocalFeedPath(t *testing.T) {
+	for link, want := range map[string]string{
+		"file:///tmp/feed.xml":          "/tmp/feed.xml",
+		"file://localhost/tmp/feed.xml": "/tmp/feed.xml",
+		"/tmp/feeds/../feed.xml":        "/tmp/feed.xml",
+	} {
+		if got, ok := localFeedPath(link); !ok || got != filepath.FromSlash(want) {
+			t.Errorf("%s: expected %s, got %q", link, want, got)
+		}
+	}
+	for _, link := range []string{"https://example.com/feed.xml", "file://example.com/feed.xml", "feed.xml", ""} {
+		if _, ok := localFeedPath(link); ok {
+			t.Errorf("%s: expected not local", link)
+		}
+	}
+	if got := localFeedLink("/tmp/my feed.xml"); got != "file:///tmp/my%20feed.xml" {
+		t.Errorf("unexpected link %s", got)
+	}
+}
+
+func TestLocalFeed(t *testing.T) {
+	defer SetLocalFeeds(false)
+	SetLocalFeeds(true)
+	path := filepath.Join(t.TempDir(), "feed.xml")
+	write := func(guids ...string) {
+		content := `<rss><channel><title>local</title>`
+		for _, guid := range guids {
+			content += `<item><guid>` + guid + `</guid></item>`
+		}
+		os.WriteFile(path, []byte(content+`</channel></rss>`), 0600)
+	}
+	write("1")
+
+	result, err := DiscoverFeed(path, storage.FeedSettings{})
+	if err != nil || result.Feed == nil || result.Feed.Title != "local" || result.FeedLink != localFeedLink(path) {
+		t.Fatalf("expected the local feed discovered, got %+v %v", result, err)
+	}
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("local", "", "", result.FeedLink, nil)
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 1 {
+		t.Fatalf("expected the items read, got %v %v", items, err)
+	}
+	if items, err := listItems(context.Background(), *feed, db); err != nil || items != nil {
+		t.Fatalf("expected the unmodified file skipped, got %v %v", items, err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if items, err := listItems(context.Background(), *feed, db); err != nil || items != nil {
+		t.Fatalf("expected the touched file skipped, got %v %v", items, err)
+	}
+	write("1", "2")
+	later = later.Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if items, err := listItems(context.Background(), *feed, db); err != nil || len(items) != 2 {
+		t.Fatalf("expected the changed file read, got %v %v", items, err)
+	}
+
+	if icon, err := findFavicon(context.Background(), "", feed.FeedLink); err != nil || icon == nil || len(*icon) != 0 {
+		t.Fatalf("expected no icon for the local feed, got %v %v", icon, err)
+	}
+
+	SetLocalFeeds(false)
+	if _, err := listItems(context.Background(), *feed, db); err != errLocalFeedsDisabled {
+		t.Fatalf("expected the local feeds disabled, got %v", err)
+	}
+	if _, err := DiscoverFeed(path, storage.FeedSettings{}); err != errLocalFeedsDisabled {
+		t.Fatalf("expected the local feeds disabled, got %v", err)
+	}
+}
+
+func TestLocalFeedFiles(t *testing.T) {
+	defer SetLocalFeeds(false)
+	SetLocalFeeds(true)
+	dir := t.TempDir()
+	if _, err := DiscoverFeed(dir, storage.FeedSettings{}); err == nil {
+		t.Fatal("expected the directory not read")
+	}
+
+	defer func(size int64) { MaxBodySize = size }(MaxBodySize)
+	MaxBodySize = 64
+	path := filepath.Join(dir, "feed.xml")
+	os.WriteFile(path, []byte(`<rss><channel><title>`+strings.Repeat("a", 64)+`</title></channel></rss>`), 0600)
+	if _, err := DiscoverFeed(path, storage.FeedSettings{}); err == nil || !strings.Contains(err.Error(), "size limit") {
+		t.Fatalf("expected the size limit exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/content/sanitizer"
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+const (
+	offlineImageWorkers   = 2
+	offlineImageQueueSize = 256
+	offlineImageMaxSize   = 5 << 20 // 5MB
+	offlineImagesPerItem  = 32
+)
+
+type offlineImages struct {
+	start sync.Once
+	queue chan int64
+}
+
+// CacheItemImages queues the starred item to download the images in its content
+// for reading offline, if enabled with `offline_images`. The cache is bounded
+// by `offline_images_max_mb`, the least recently used images are evicted.
+func (w *Worker) CacheItemImages(itemID int64) {
+	if !w.db.GetSettingsValueBool("offline_images") {
+		return
+	}
+	w.offline.start.Do(func() {
+		w.offline.queue = make(chan int64, offlineImageQueueSize)
+		for i := 0; i < offlineImageWorkers; i++ {
+			w.running.Add(1)
+			go w.offlineImageWorker()
+		}
+	})
+	select {
+	case w.offline.queue <- itemID:
+	default:
+		logger.With(logger.Fields{"item_id": itemID}).Debug("offline image queue is full, skipping")
+	}
+}
+
+func (w *Worker) offlineImageWorker() {
+	defer w.running.Done()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case itemID := <-w.offline.queue:
+			w.cacheItemImages(itemID)
+		}
+	}
+}
+
+// cacheItemImages downloads the images of the item not cached yet.
+func (w *Worker) cacheItemImages(itemID int64) {
+	item := w.db.GetItem(itemID)
+	if item == nil || item.Status != storage.STARRED {
+		return
+	}
+	cached := w.db.ItemImageKeys(itemID)
+	for _, link := range contentImages(item.Content + w.db.GetItemFullContent(itemID)) {
+		if _, ok := cached[link]; ok {
+			continue
+		}
+		ctx, meter := WithTransferMeter(w.ctx)
+		contentType, data, err := fetchOfflineImage(ctx, link)
+		if w.ctx.Err() != nil {
+			return
+		}
+		w.db.AddFeedTransfer(item.FeedId, meter.Bytes(), time.Now())
+		if err != nil {
+			logger.With(logger.Fields{"item_id": itemID, "url": link, "error": err}).Debug("failed to cache image")
+			continue
+		}
+		w.db.CacheItemImage(itemID, link, contentType, data)
+	}
+	maxSize := w.db.GetSettingsValueInt64("offline_images_max_mb") << 20
+	if n := w.db.EvictCachedImages(maxSize); n > 0 {
+		logger.With(logger.Fields{"evicted": n}).Debug("offline image cache is full")
+	}
+}
+
+// contentImages returns the image urls in the html, up to `offlineImagesPerItem`.
+func contentImages(content string) []string {
+	links := make([]string, 0)
+	seen := make(map[string]bool)
+	sanitizer.RewriteImages(content, func(link string) string {
+		if !seen[link] && len(links) < offlineImagesPerItem && htmlutil.IsAPossibleLink(link) {
+			seen[link] = true
+			links = append(links, link)
+		}
+		return link
+	})
+	return links
+}
+
+func fetchOfflineImage(ctx context.Context, link string) (string, []byte, error) {
+	res, err := client.getContext(ctx, link)
+	if err != nil {
+		return "", nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("status code %d", res.StatusCode)
+	}
+	contentType := res.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", nil, fmt.Errorf("not an image: %q", contentType)
+	}
+	if res.ContentLength > offlineImageMaxSize {
+		return "", nil, fmt.Errorf("image too large")
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, offlineImageMaxSize+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) > offlineImageMaxSize {
+		return "", nil, fmt.Errorf("image too large")
+	}
+	return contentType, data, nil
+}
@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// pageCache keeps the article pages fetched for their full content, so that
+// fetching one again is a conditional request; nil if off.
+var pageCache *cachedPages
+
+type cachedPages struct {
+	db      *storage.Storage
+	maxSize int64
+}
+
+// SetPageCache keeps up to maxSize bytes of the article pages in the storage,
+// the least recently used ones evicted. 0 turns the cache off, dropping
+// the pages kept.
+func SetPageCache(db *storage.Storage, maxSize int64) {
+	if maxSize <= 0 {
+		pageCache = nil
+		db.ClearPageCache()
+		return
+	}
+	pageCache = &cachedPages{db: db, maxSize: maxSize}
+	// smaller than the last time
+	db.EvictCachedPages(maxSize)
+}
+
+// store caches the page if it can be validated, the others are fetched
+// in full every time anyway.
+func (c *cachedPages) store(url string, res *http.Response, body []byte) {
+	page := storage.CachedPage{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		ContentType:  res.Header.Get("Content-Type"),
+		Body:         body,
+	}
+	if page.ETag == "" && page.LastModified == "" || int64(len(body)) > c.maxSize {
+		return
+	}
+	if c.db.CachePage(url, page) {
+		c.db.EvictCachedPages(c.maxSize)
+	}
+}
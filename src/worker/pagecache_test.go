@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestPageCache(t *testing.T) {
+	full := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/plain" {
+			full++
+			w.Write([]byte("plain"))
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+		w.Write([]byte("<p>\xcf\xf0\xe8\xe2\xe5\xf2</p>"))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	defer SetPageCache(db, 0)
+	SetPageCache(db, 1<<20)
+
+	for i := 0; i < 2; i++ {
+		body, err := GetBody(server.URL + "/article")
+		if err != nil || body != "<p>Привет</p>" {
+			t.Fatalf("unexpected body %q (%v)", body, err)
+		}
+	}
+	if full != 1 {
+		t.Fatalf("expected the page fetched once, got %d", full)
+	}
+
+	// nothing to validate with
+	GetBody(server.URL + "/plain")
+	if db.GetCachedPage(server.URL+"/plain") != nil {
+		t.Fatal("expected the page without validators not cached")
+	}
+
+	SetPageCache(db, 0)
+	if db.GetCachedPage(server.URL+"/article") != nil {
+		t.Fatal("expected the cache dropped once off")
+	}
+	GetBody(server.URL + "/article")
+	if full != 3 {
+		t.Fatalf("expected the page fetched in full, got %d", full)
+	}
+}
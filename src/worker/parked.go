@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// only the beginning of the page is looked at
+const parkedPageMaxSize = 64 * 1024
+
+// phrases and services found on the domain parking and for-sale pages
+var parkedPageMarkers = [][]byte{
+	[]byte("domain is for sale"),
+	[]byte("domain may be for sale"),
+	[]byte("buy this domain"),
+	[]byte("domain name is for sale"),
+	[]byte("this domain has expired"),
+	[]byte("parked free"),
+	[]byte("parked domain"),
+	[]byte("domain parking"),
+	[]byte("parkingcrew"),
+	[]byte("sedoparking"),
+	[]byte("bodis.com"),
+	[]byte("hugedomains.com"),
+	[]byte("afternic.com"),
+}
+
+// redirectedElsewhere reports whether the feed request ended up on another host.
+func redirectedElsewhere(feedLink string, res *http.Response) bool {
+	u, err := url.Parse(feedLink)
+	if err != nil || res.Request == nil {
+		return false
+	}
+	from := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	to := strings.TrimPrefix(strings.ToLower(res.Request.URL.Hostname()), "www.")
+	return from != to
+}
+
+// isParkedPage reports whether the response is an html page of a parked domain.
+func isParkedPage(res *http.Response, head []byte) bool {
+	if mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err != nil || mediaType != "text/html" {
+		return false
+	}
+	head = bytes.ToLower(head)
+	for _, marker := range parkedPageMarkers {
+		if bytes.Contains(head, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The start of the body shown along with the parse error.
+const parseErrorBodySize = 512
+
+// FeedParseError is the url looking like a feed, but failing to parse.
+type FeedParseError struct {
+	Err error
+	// the start of the body, empty if not text
+	Body string
+}
+
+func (e *FeedParseError) Error() string {
+	return fmt.Sprintf("this looks like a feed but failed to parse: %s", e.Err)
+}
+
+func (e *FeedParseError) Unwrap() error {
+	return e.Err
+}
+
+// looksLikeFeed tells whether the response is meant to be a feed,
+// by its content type or else by the start of the body.
+func looksLikeFeed(res *http.Response, body []byte) bool {
+	contentType := strings.ToLower(res.Header.Get("Content-Type"))
+	if strings.Contains(contentType, "html") {
+		return false
+	}
+	for _, kind := range []string{"rss", "atom", "xml", "json"} {
+		if strings.Contains(contentType, kind) {
+			return true
+		}
+	}
+	start := bytes.ToLower(bytes.TrimSpace(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))))
+	for _, prefix := range []string{"<?xml", "<rss", "<feed", "<rdf", "{"} {
+		if bytes.HasPrefix(start, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyStart returns the start of the (decoded) body to show,
+// empty if it's binary (or in the encoding not known).
+func bodyStart(content string) string {
+	if len(content) > parseErrorBodySize {
+		content = content[:parseErrorBodySize]
+		// not to cut the last character in half
+		for len(content) > 0 && !utf8.ValidString(content) {
+			content = content[:len(content)-1]
+		}
+	}
+	if !utf8.ValidString(content) {
+		return ""
+	}
+	for _, r := range content {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return ""
+		}
+	}
+	return content
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nkanaev/yarr/src/content/htmlutil"
+	"github.com/nkanaev/yarr/src/parser"
+)
+
+// Maximum size of a feed body the worker is willing to read.
+var MaxBodySize int64 = 10 << 20 // 10MB
+
+const (
+	previewTimeout  = time.Second * 15
+	previewMaxItems = 10
+	previewSnippet  = 300
+)
+
+type PreviewItem struct {
+	Title   string    `json:"title"`
+	Link    string    `json:"link"`
+	Date    time.Time `json:"date"`
+	Summary string    `json:"summary"`
+}
+
+type FeedPreview struct {
+	Title    string        `json:"title"`
+	SiteURL  string        `json:"site_url"`
+	FeedLink string        `json:"feed_link"`
+	Format   string        `json:"format"`
+	Items    []PreviewItem `json:"items"`
+}
+
+// PreviewFeed fetches and parses the feed the same way the refresher does,
+// without storing anything.
+func PreviewFeed(ctx context.Context, feedURL string, header http.Header) (*FeedPreview, error) {
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+
+	res, err := Fetch(ctx, feedURL, header)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("status code %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > MaxBodySize {
+		return nil, fmt.Errorf("feed exceeds the size limit of %d bytes", MaxBodySize)
+	}
+
+	feed, err := parser.ParseAndFix(bytes.NewReader(body), feedURL, getCharset(res), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &FeedPreview{
+		Title:    feed.Title,
+		SiteURL:  feed.SiteURL,
+		FeedLink: feedURL,
+		Format:   parser.DetectFormat(body),
+		Items:    make([]PreviewItem, 0, previewMaxItems),
+	}
+	for i, item := range feed.Items {
+		if i == previewMaxItems {
+			break
+		}
+		summary := []rune(htmlutil.ExtractText(item.Content))
+		if len(summary) > previewSnippet {
+			summary = append(summary[:previewSnippet], '…')
+		}
+		preview.Items = append(preview.Items, PreviewItem{
+			Title:   item.Title,
+			Link:    item.URL,
+			Date:    item.Date,
+			Summary: string(summary),
+		})
+	}
+	return preview, nil
+}
@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nkanaev/yarr/src/parser"
+)
+
+// The feed links of the popular site generators (WordPress, Hugo, Ghost,
+// Jekyll and such), tried when the page doesn't link to any feed.
+var wellKnownFeedPaths = []string{
+	"/feed", "/rss", "/rss.xml", "/atom.xml", "/feed.xml", "/index.xml", "/feed.json",
+}
+
+// All the probes together, not to wait for the slow site for minutes.
+const feedProbeTimeout = 10 * time.Second
+
+// probeFeeds looks for the feeds at the well-known links of the site,
+// all at once. The feeds are in the order of the paths, the same feed
+// served at several of them only once.
+func probeFeeds(ctx context.Context, site *url.URL, header http.Header) []FeedSource {
+	ctx, cancel := withTimeout(ctx, feedProbeTimeout)
+	defer cancel()
+
+	type probe struct {
+		link     string
+		finalURL string
+		feed     *parser.Feed
+	}
+	probes := make([]probe, len(wellKnownFeedPaths))
+	var wg sync.WaitGroup
+	for i, path := range wellKnownFeedPaths {
+		link := (&url.URL{Scheme: site.Scheme, Host: site.Host, Path: path}).String()
+		probes[i].link = link
+		wg.Add(1)
+		go func(p *probe) {
+			defer wg.Done()
+			p.feed, p.finalURL, _ = probeFeed(ctx, p.link, header)
+		}(&probes[i])
+	}
+	wg.Wait()
+
+	sources := make([]FeedSource, 0)
+	seen := make(map[string]bool)
+	for _, p := range probes {
+		if p.feed == nil || seen[p.finalURL] {
+			continue
+		}
+		seen[p.finalURL] = true
+		sources = append(sources, FeedSource{Title: p.feed.Title, Url: p.link})
+	}
+	return sources
+}
+
+// probeFeed fetches and parses the feed at the link, returning the url
+// it ended up at after the redirects.
+func probeFeed(ctx context.Context, link string, header http.Header) (*parser.Feed, string, error) {
+	res, err := client.do(ctx, link, header)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("status code %d", res.StatusCode)
+	}
+	feed, err := parser.ParseAndFix(res.Body, link, getCharset(res), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return feed, res.Request.URL.String(), nil
+}
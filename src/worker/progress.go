@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// States of the feed in the refresh.
+const (
+	FeedQueued   = "queued"
+	FeedFetching = "fetching"
+	FeedParsing  = "parsing"
+	FeedDone     = "done"
+	FeedError    = "error"
+	FeedSkipped  = "skipped"
+)
+
+// FeedProgress is the state of the feed in the last refresh,
+// with the time it entered each of the states.
+type FeedProgress struct {
+	FeedID   int64      `json:"feed_id"`
+	State    string     `json:"state"`
+	Queued   time.Time  `json:"queued"`
+	Fetching *time.Time `json:"fetching,omitempty"`
+	Parsing  *time.Time `json:"parsing,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	NewItems int        `json:"new_items"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// RefreshProgress is the state of the last refresh, the one in progress if any.
+type RefreshProgress struct {
+	Started  *time.Time     `json:"started"`
+	Finished *time.Time     `json:"finished"`
+	Feeds    []FeedProgress `json:"feeds"`
+}
+
+type progressTracker struct {
+	mu       sync.Mutex
+	started  *time.Time
+	finished *time.Time
+	// in the order of the feeds given
+	order []int64
+	feeds map[int64]*FeedProgress
+}
+
+type stageKey struct{}
+
+// withStage returns the context reporting the feed entering the next stage
+// of the fetch to the callback.
+func withStage(ctx context.Context, stage func(string)) context.Context {
+	return context.WithValue(ctx, stageKey{}, stage)
+}
+
+func reportStage(ctx context.Context, state string) {
+	if stage, ok := ctx.Value(stageKey{}).(func(string)); ok {
+		stage(state)
+	}
+}
+
+// reset starts tracking the new refresh with all the feeds queued.
+func (p *progressTracker) reset(feedIDs []int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.started, p.finished = &now, nil
+	p.order = feedIDs
+	p.feeds = make(map[int64]*FeedProgress, len(feedIDs))
+	for _, id := range feedIDs {
+		p.feeds[id] = &FeedProgress{FeedID: id, State: FeedQueued, Queued: now}
+	}
+}
+
+func (p *progressTracker) set(feedID int64, state string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	feed, ok := p.feeds[feedID]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	feed.State = state
+	switch state {
+	case FeedFetching:
+		feed.Fetching = &now
+	case FeedParsing:
+		feed.Parsing = &now
+	}
+}
+
+func (p *progressTracker) done(result FeedDoneEvent, skipped bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	feed, ok := p.feeds[result.FeedID]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	feed.State = FeedDone
+	if skipped {
+		feed.State = FeedSkipped
+	} else if result.Error != "" {
+		feed.State = FeedError
+	}
+	feed.Finished = &now
+	feed.NewItems = result.NewItems
+	feed.Error = result.Error
+}
+
+func (p *progressTracker) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.finished = &now
+}
+
+// RefreshProgress returns the state of each feed in the last refresh,
+// in the order of the feeds given. Empty if there was no refresh yet.
+func (w *Worker) RefreshProgress() RefreshProgress {
+	p := &w.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	progress := RefreshProgress{Started: p.started, Finished: p.finished, Feeds: make([]FeedProgress, 0, len(p.order))}
+	for _, id := range p.order {
+		progress.Feeds = append(progress.Feeds, *p.feeds[id])
+	}
+	return progress
+}
@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The longest the feed is left alone when asked to retry later.
+const maxCooldown = 24 * time.Hour
+
+// retryLaterError is the 429 or 503 response telling when to come back.
+type retryLaterError struct {
+	status int
+	until  time.Time
+}
+
+func (e *retryLaterError) Error() string {
+	return fmt.Sprintf("status code %d, retry after %s", e.status, e.until.Format(time.RFC3339))
+}
+
+// parseRetryAfter parses the Retry-After header, either in seconds or the http date,
+// capped at maxCooldown. Returns false if the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	var until time.Time
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return time.Time{}, false
+		}
+		if seconds > int64(maxCooldown/time.Second) {
+			seconds = int64(maxCooldown / time.Second)
+		}
+		until = now.Add(time.Duration(seconds) * time.Second)
+	} else if date, err := http.ParseTime(value); err == nil {
+		until = date
+	} else {
+		return time.Time{}, false
+	}
+	if until.After(now.Add(maxCooldown)) {
+		until = now.Add(maxCooldown)
+	}
+	return until, true
+}
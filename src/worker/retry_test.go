@@ -0,0 +1,29 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"120", now.Add(2 * time.Minute), true},
+		{" 0 ", now, true},
+		{"Mon, 01 Jan 2024 13:30:00 GMT", now.Add(90 * time.Minute), true},
+		{"86400000", now.Add(maxCooldown), true},
+		{"Fri, 01 Jan 2044 00:00:00 GMT", now.Add(maxCooldown), true},
+		{"", time.Time{}, false},
+		{"-5", time.Time{}, false},
+		{"tomorrow", time.Time{}, false},
+	} {
+		got, ok := parseRetryAfter(tc.value, now)
+		if ok != tc.ok || !got.Equal(tc.want) {
+			t.Errorf("%q: expected %v %v, got %v %v", tc.value, tc.want, tc.ok, got, ok)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package worker
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// How often the auto-refresh looks for the feeds due.
+const scheduleTick = time.Minute
+
+// The refresh interval of the feed is varied by up to that fraction either way,
+// so that the instances behind the same proxy don't all fetch at the same minute.
+const scheduleJitter = 0.1
+
+type schedule struct {
+	mu sync.Mutex
+	// the global refresh rate in minutes, 0 if off
+	rate int64
+	// stops the scheduler, nil if it's not running
+	cancel context.CancelFunc
+	// when the feeds were last fetched, successfully or not
+	// (the http state keeps only the successful ones)
+	fetched map[int64]time.Time
+	// the fraction of the interval added to the next one of the feed
+	jitter map[int64]float64
+	rand   *rand.Rand
+}
+
+func newSchedule() schedule {
+	return schedule{
+		fetched: make(map[int64]time.Time),
+		jitter:  make(map[int64]float64),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (s *schedule) setFetched(feedID int64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetched[feedID] = at
+	s.jitter[feedID] = (s.rand.Float64()*2 - 1) * scheduleJitter
+}
+
+// SetRefreshRate sets the refresh interval in minutes of the feeds without
+// their own one, 0 turns their auto-refresh off. Starts the scheduler
+// refreshing each feed once its interval has passed. The refresh in progress
+// isn't affected, the new rate applies from the next check on.
+func (w *Worker) SetRefreshRate(minute int64) {
+	w.sched.mu.Lock()
+	defer w.sched.mu.Unlock()
+
+	w.sched.rate = minute
+	logger.Infof("auto-refresh: global rate %dm", minute)
+	if w.sched.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(w.ctx)
+	w.sched.cancel = cancel
+	go w.scheduler(ctx)
+}
+
+func (w *Worker) scheduler(ctx context.Context) {
+	logger.Infof("auto-refresh: starting")
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.refreshScheduled()
+		case <-ctx.Done():
+			logger.Infof("auto-refresh: stopping")
+			return
+		}
+	}
+}
+
+func (w *Worker) stopScheduler() {
+	w.sched.mu.Lock()
+	defer w.sched.mu.Unlock()
+
+	if w.sched.cancel != nil {
+		w.sched.cancel()
+		w.sched.cancel = nil
+	}
+}
+
+func (w *Worker) refreshScheduled() {
+	w.reflock.Lock()
+	defer w.reflock.Unlock()
+
+	now := time.Now()
+	if w.RefreshQuiet(now) {
+		return
+	}
+	feeds := w.ScheduledFeeds(w.db.ListFeeds(), now)
+	if len(feeds) == 0 || !w.canRefresh() {
+		return
+	}
+	logger.Debugf("auto-refresh: %d feeds due", len(feeds))
+	go w.refresher(w.startRefresh(feeds), feeds)
+}
+
+// RefreshQuiet reports whether the time falls in the quiet hours
+// (`quiet_hours_start` to `quiet_hours_end`, local time), during which
+// the feeds aren't refreshed on their own, the same as the notifications
+// are muted. The manual refresh still works.
+func (w *Worker) RefreshQuiet(now time.Time) bool {
+	start, _ := w.db.GetSettingsValue("quiet_hours_start").(string)
+	end, _ := w.db.GetSettingsValue("quiet_hours_end").(string)
+	return InQuietHours(start, end, now)
+}
+
+// ScheduledFeeds returns the feeds due for the auto-refresh: fetched longer
+// ago than their own refresh interval or, if they have none, the global rate.
+// The feeds with neither and the paused ones are left out.
+// With `refresh_spread` on, the feed is due at its own slot within the interval
+// instead, so that the fetches are spread evenly rather than done at once.
+func (w *Worker) ScheduledFeeds(feeds []storage.Feed, now time.Time) []storage.Feed {
+	w.sched.mu.Lock()
+	rate := w.sched.rate
+	w.sched.mu.Unlock()
+
+	spread := w.db.GetSettingsValueBool("refresh_spread")
+	due := w.dueFeeds(feeds, now, time.Duration(rate)*time.Minute, spread)
+	if rate > 0 {
+		return due
+	}
+	scheduled := make([]storage.Feed, 0, len(due))
+	for _, feed := range due {
+		if w.db.GetFeedSettings(feed.Id).RefreshInterval > 0 {
+			scheduled = append(scheduled, feed)
+		}
+	}
+	return scheduled
+}
+
+// dueFeeds leaves out the paused, the suspended and the gone feeds, the ones asked
+// to retry later, the ones still fresh as told by the server (Cache-Control max-age
+// or the rss ttl, unless `ignore_freshness_hints` is on) and the ones fetched less
+// than their refresh interval (or the fallback, if they have none) ago, or, if spread,
+// fetched since their last slot.
+func (w *Worker) dueFeeds(feeds []storage.Feed, now time.Time, fallback time.Duration, spread bool) []storage.Feed {
+	states := w.db.ListHTTPStates()
+	cooldowns := w.db.ListFeedCooldowns(now)
+	hints := !w.db.GetSettingsValueBool("ignore_freshness_hints")
+	w.sched.mu.Lock()
+	fetched := make(map[int64]time.Time, len(w.sched.fetched))
+	for id, at := range w.sched.fetched {
+		fetched[id] = at
+	}
+	jitter := make(map[int64]float64, len(w.sched.jitter))
+	for id, j := range w.sched.jitter {
+		jitter[id] = j
+	}
+	w.sched.mu.Unlock()
+
+	due := make([]storage.Feed, 0, len(feeds))
+	for _, feed := range feeds {
+		if _, ok := cooldowns[feed.Id]; ok || feed.Suspended || feed.Gone {
+			continue
+		}
+		settings := w.db.GetFeedSettings(feed.Id)
+		if settings.Paused {
+			continue
+		}
+		interval := time.Duration(settings.RefreshInterval) * time.Minute
+		if interval == 0 {
+			interval = fallback
+		}
+		last := fetched[feed.Id]
+		if state, ok := states[feed.Id]; ok {
+			if hints && now.Before(freshUntil(state)) {
+				continue
+			}
+			if state.LastRefreshed.After(last) {
+				last = state.LastRefreshed
+			}
+		}
+		if interval > 0 && spread {
+			if !last.Before(lastSlot(feed.Id, now, interval)) {
+				continue
+			}
+		} else if interval > 0 {
+			interval += time.Duration(float64(interval) * jitter[feed.Id])
+			if now.Sub(last) < interval {
+				continue
+			}
+		}
+		due = append(due, feed)
+	}
+	return due
+}
+
+// lastSlot returns the last time up to now the feed is due if the fetches
+// are spread across the interval. The feed's slot is the offset within
+// the interval (in minutes) derived from its id.
+func lastSlot(feedID int64, now time.Time, interval time.Duration) time.Time {
+	size := int64(interval / time.Minute)
+	if size < 1 {
+		size = 1
+	}
+	h := fnv.New32a()
+	binary.Write(h, binary.LittleEndian, feedID)
+	offset := int64(h.Sum32()) % size
+
+	minute := now.Unix() / 60
+	since := (minute - offset) % size
+	if since < 0 {
+		since += size
+	}
+	return time.Unix((minute-since)*60, 0)
+}
+
+// InQuietHours reports whether the time falls in the quiet hours ("22:00" to "07:30").
+// The window may span midnight. Empty or malformed bounds mean no quiet hours.
+func InQuietHours(start, end string, now time.Time) bool {
+	from, err := time.Parse("15:04", strings.TrimSpace(start))
+	if err != nil {
+		return false
+	}
+	to, err := time.Parse("15:04", strings.TrimSpace(end))
+	if err != nil {
+		return false
+	}
+	fromMinutes := from.Hour()*60 + from.Minute()
+	toMinutes := to.Hour()*60 + to.Minute()
+	minutes := now.Hour()*60 + now.Minute()
+	if fromMinutes <= toMinutes {
+		return minutes >= fromMinutes && minutes < toMinutes
+	}
+	return minutes >= fromMinutes || minutes < toMinutes
+}
@@ -0,0 +1,209 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestScheduledFeeds(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+
+	db.CreateFeed("global", "", "", "http://example.com/global", nil)
+	hourly := db.CreateFeed("hourly", "", "", "http://example.com/hourly", nil)
+	frequent := db.CreateFeed("frequent", "", "", "http://example.com/frequent", nil)
+	paused := db.CreateFeed("paused", "", "", "http://example.com/paused", nil)
+	db.UpdateFeedSettings(hourly.Id, storage.FeedSettings{RefreshInterval: 60})
+	db.UpdateFeedSettings(frequent.Id, storage.FeedSettings{RefreshInterval: 15})
+	db.UpdateFeedSettings(paused.Id, storage.FeedSettings{RefreshInterval: 15, Paused: true})
+	suspended := db.CreateFeed("suspended", "", "", "http://example.com/suspended", nil)
+	db.UpdateSettings(map[string]interface{}{"suspend_after_failures": 1})
+	db.SetFeedHealth(suspended.Id, 500, suspended.FeedLink, false, true)
+	db.SuspendFailingFeed(suspended.Id)
+	feeds := db.ListFeeds()
+	start := time.Now()
+	for _, feed := range feeds {
+		db.SetHTTPState(feed.Id, "", "", "")
+	}
+
+	w := NewWorker(db)
+	w.sched.rate = 30
+	scheduled := func(after time.Duration) []string {
+		titles := make([]string, 0)
+		for _, feed := range w.ScheduledFeeds(feeds, start.Add(after)) {
+			titles = append(titles, feed.Title)
+		}
+		return titles
+	}
+	for _, tc := range []struct {
+		after time.Duration
+		want  []string
+	}{
+		{10 * time.Minute, []string{}},
+		{20 * time.Minute, []string{"frequent"}},
+		{40 * time.Minute, []string{"frequent", "global"}},
+		{70 * time.Minute, []string{"frequent", "global", "hourly"}},
+	} {
+		if got := scheduled(tc.after); !equalStrings(got, tc.want) {
+			t.Errorf("after %s: expected %v, got %v", tc.after, tc.want, got)
+		}
+	}
+
+	// the failed fetches count too
+	w.sched.setFetched(frequent.Id, start.Add(20*time.Minute))
+	if got := scheduled(25 * time.Minute); len(got) != 0 {
+		t.Errorf("expected the feed fetched recently skipped, got %v", got)
+	}
+
+	// with the global auto-refresh off only the feeds with own interval are refreshed
+	w.sched.rate = 0
+	if got := scheduled(70 * time.Minute); !equalStrings(got, []string{"frequent", "hourly"}) {
+		t.Errorf("expected only the feeds with own interval, got %v", got)
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("feed", "", "", "http://example.com/feed", nil)
+	db.UpdateFeedSettings(feed.Id, storage.FeedSettings{RefreshInterval: 60})
+	feeds := db.ListFeeds()
+
+	w := NewWorker(db)
+	defer w.Stop()
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		w.sched.setFetched(feed.Id, start)
+		if j := w.sched.jitter[feed.Id]; j < -scheduleJitter || j > scheduleJitter {
+			t.Fatalf("jitter out of range: %f", j)
+		}
+	}
+
+	w.sched.jitter[feed.Id] = scheduleJitter
+	if due := w.ScheduledFeeds(feeds, start.Add(65*time.Minute)); len(due) != 0 {
+		t.Fatalf("expected the feed due after 66m, got %v", due)
+	}
+	if due := w.ScheduledFeeds(feeds, start.Add(67*time.Minute)); len(due) != 1 {
+		t.Fatalf("expected the feed due after 66m, got %v", due)
+	}
+
+	// restarted right away
+	w.SetRefreshRate(30)
+	w.stopScheduler()
+	w.SetRefreshRate(0)
+	if w.sched.cancel == nil {
+		t.Fatal("expected the scheduler running")
+	}
+}
+
+func TestScheduleSpread(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	db.UpdateSettings(map[string]interface{}{"refresh_spread": true})
+	for i := 0; i < 60; i++ {
+		db.CreateFeed("", "", "", fmt.Sprintf("http://example.com/%d", i), nil)
+	}
+	feeds := db.ListFeeds()
+
+	w := NewWorker(db)
+	defer w.Stop()
+	w.sched.rate = 60
+	start := time.Now()
+	for _, feed := range feeds {
+		w.sched.setFetched(feed.Id, start)
+	}
+
+	fetches := make(map[int64]int)
+	busiest := 0
+	for minute := 1; minute <= 120; minute++ {
+		now := start.Add(time.Duration(minute) * time.Minute)
+		due := w.ScheduledFeeds(feeds, now)
+		for _, feed := range due {
+			fetches[feed.Id]++
+			w.sched.setFetched(feed.Id, now)
+		}
+		if len(due) > busiest {
+			busiest = len(due)
+		}
+	}
+	for _, feed := range feeds {
+		if fetches[feed.Id] != 2 {
+			t.Fatalf("expected every feed fetched twice in 2 intervals, got %v", fetches)
+		}
+	}
+	if busiest > 10 {
+		t.Fatalf("expected the fetches spread, got %d feeds in one minute", busiest)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2021, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	scenarios := []struct {
+		start, end string
+		now        time.Time
+		want       bool
+	}{
+		{"", "", at(12, 0), false},
+		{"22:00", "", at(23, 0), false},
+		{"bedtime", "07:00", at(23, 0), false},
+		{"09:00", "09:00", at(9, 0), false},
+		{"09:00", "17:30", at(9, 0), true},
+		{"09:00", "17:30", at(17, 29), true},
+		{"09:00", "17:30", at(17, 30), false},
+		{"09:00", "17:30", at(8, 59), false},
+		{"22:00", "07:00", at(23, 15), true},
+		{"22:00", "07:00", at(3, 0), true},
+		{"22:00", "07:00", at(7, 0), false},
+		{"22:00", "07:00", at(12, 0), false},
+	}
+	for _, s := range scenarios {
+		if have := InQuietHours(s.start, s.end, s.now); have != s.want {
+			t.Errorf("%s-%s at %s: want %v, have %v", s.start, s.end, s.now.Format("15:04"), s.want, have)
+		}
+	}
+}
+
+func TestRefreshQuiet(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	w := NewWorker(db)
+	defer w.Stop()
+
+	at := time.Date(2021, 1, 1, 23, 30, 0, 0, time.Local)
+	if w.RefreshQuiet(at) {
+		t.Fatal("expected no quiet hours by default")
+	}
+	// the same as the notifications'
+	db.UpdateSettings(map[string]interface{}{"quiet_hours_start": "23:00", "quiet_hours_end": "07:00"})
+	if !w.RefreshQuiet(at) || !w.RefreshQuiet(at.Add(7*time.Hour)) || w.RefreshQuiet(at.Add(8*time.Hour)) {
+		t.Fatal("expected the quiet hours across midnight")
+	}
+	db.UpdateSettings(map[string]interface{}{"quiet_hours_end": "23:00"})
+	if w.RefreshQuiet(at) {
+		t.Fatal("expected the quiet hours off with the same start and end")
+	}
+}
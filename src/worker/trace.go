@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nkanaev/yarr/src/logger"
+)
+
+// FetchTrace is what went over the wire fetching the feed, kept (the last one
+// of each feed) and logged when debugging the feed or all of them.
+type FetchTrace struct {
+	Time time.Time `json:"time"`
+	// the whole fetch, the parsing included
+	DurationMs int64            `json:"duration_ms"`
+	Error      string           `json:"error,omitempty"`
+	Requests   []*TracedRequest `json:"requests"`
+}
+
+// TracedRequest is one of the requests made fetching the feed,
+// the credentials redacted.
+type TracedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// after the redirects
+	FinalURL       string      `json:"final_url"`
+	RequestHeader  http.Header `json:"request_headers"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"response_headers"`
+	// the body as sent, compressed if it was
+	BodySize int64 `json:"body_size"`
+	// until the response headers
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// The response headers telling why the feed is (not) updated.
+var tracedHeaders = []string{
+	"Content-Type", "Content-Encoding", "Content-Length", "ETag", "Last-Modified",
+	"Cache-Control", "Expires", "Age", "Retry-After",
+}
+
+const redacted = "[redacted]"
+
+// redactHeader returns the copy of the header without the credentials,
+// the api keys and such of the feed's own headers included.
+func redactHeader(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		for _, secret := range []string{"auth", "cookie", "token", "key", "secret", "session"} {
+			if strings.Contains(lower, secret) {
+				values = []string{redacted}
+				break
+			}
+		}
+		result[name] = append([]string(nil), values...)
+	}
+	return result
+}
+
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	redactedURL := *u
+	redactedURL.User = url.User(redacted)
+	return redactedURL.String()
+}
+
+type fetchTraceKey struct{}
+
+// withFetchTrace makes the requests made with the context recorded in the trace.
+func withFetchTrace(ctx context.Context, trace *FetchTrace) context.Context {
+	return context.WithValue(ctx, fetchTraceKey{}, trace)
+}
+
+// traceRequest records the request in the trace of the context (if any),
+// along with the response (unless it failed) and its body as it's read.
+func traceRequest(ctx context.Context, req *http.Request, res *http.Response, err error, took time.Duration) {
+	trace, ok := ctx.Value(fetchTraceKey{}).(*FetchTrace)
+	if !ok {
+		return
+	}
+	traced := &TracedRequest{
+		Method:        req.Method,
+		URL:           redactURL(req.URL),
+		FinalURL:      redactURL(req.URL),
+		RequestHeader: redactHeader(req.Header),
+		DurationMs:    took.Milliseconds(),
+	}
+	trace.Requests = append(trace.Requests, traced)
+	if err != nil {
+		traced.Error = err.Error()
+		return
+	}
+	// the last request of the redirects, the conditional headers dropped if to another host
+	traced.FinalURL = redactURL(res.Request.URL)
+	traced.RequestHeader = redactHeader(res.Request.Header)
+	traced.Status = res.StatusCode
+	traced.ResponseHeader = make(http.Header)
+	for _, name := range tracedHeaders {
+		for _, value := range res.Header.Values(name) {
+			traced.ResponseHeader.Add(name, value)
+		}
+	}
+	traced.ResponseHeader = redactHeader(traced.ResponseHeader)
+	res.Body = &tracedBody{ReadCloser: res.Body, traced: traced}
+}
+
+type tracedBody struct {
+	io.ReadCloser
+	traced *TracedRequest
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.traced.BodySize += int64(n)
+	return n, err
+}
+
+// log writes the requests of the feed's fetch to the log, one line each.
+func (t *FetchTrace) log(feedID int64) {
+	for _, traced := range t.Requests {
+		fields := logger.Fields{
+			"feed_id":          feedID,
+			"method":           traced.Method,
+			"url":              traced.URL,
+			"final_url":        traced.FinalURL,
+			"request_headers":  traced.RequestHeader,
+			"status":           traced.Status,
+			"response_headers": traced.ResponseHeader,
+			"body_size":        traced.BodySize,
+			"duration_ms":      traced.DurationMs,
+		}
+		if traced.Error != "" {
+			fields["error"] = traced.Error
+		}
+		logger.With(fields).Info("http trace")
+	}
+}
+
+// fetchTraces are the last traces of the feeds debugged.
+type fetchTraces struct {
+	mu   sync.Mutex
+	last map[int64]*FetchTrace
+}
+
+func (t *fetchTraces) set(feedID int64, trace *FetchTrace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last == nil {
+		t.last = make(map[int64]*FetchTrace)
+	}
+	t.last[feedID] = trace
+}
+
+func (t *fetchTraces) get(feedID int64) *FetchTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last[feedID]
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+type transferMeterKey struct{}
+
+// TransferMeter counts the bytes received by the requests made with the context
+// it's attached to: the status lines, the headers and the bodies as sent,
+// compressed if they were. The connections being shared by the requests,
+// the tls overhead and the redirects followed aren't included.
+type TransferMeter struct {
+	bytes int64
+}
+
+// WithTransferMeter returns the context with a new meter attached.
+func WithTransferMeter(ctx context.Context) (context.Context, *TransferMeter) {
+	meter := &TransferMeter{}
+	return context.WithValue(ctx, transferMeterKey{}, meter), meter
+}
+
+// Bytes returns the number of bytes received so far.
+func (m *TransferMeter) Bytes() int64 {
+	return atomic.LoadInt64(&m.bytes)
+}
+
+type meteredBody struct {
+	io.ReadCloser
+	meter *TransferMeter
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.meter.bytes, int64(n))
+	return n, err
+}
+
+// meterResponse counts the response's headers with the meter of the context
+// (if any) and its body as it's read, before it's decoded.
+func meterResponse(ctx context.Context, res *http.Response) {
+	meter, ok := ctx.Value(transferMeterKey{}).(*TransferMeter)
+	if !ok {
+		return
+	}
+	size := len(res.Proto) + len(res.Status) + 4
+	for name, values := range res.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
+	}
+	atomic.AddInt64(&meter.bytes, int64(size))
+	res.Body = &meteredBody{ReadCloser: res.Body, meter: meter}
+}
+
+// dialedConns is the number of connections opened so far,
+// telling how many the refreshes take.
+var dialedConns int64
+
+func countedDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err == nil {
+			atomic.AddInt64(&dialedConns, 1)
+		}
+		return conn, err
+	}
+}
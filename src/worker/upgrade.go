@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/parser"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+// The http feeds are tried over https once in that long.
+const httpsUpgradeInterval = 7 * 24 * time.Hour
+
+// upgradeToHTTPS switches the http feed to its https link if the site serves
+// the feed there as well (unless `https_upgrade` is off or the feed keeps http).
+// Tried once in httpsUpgradeInterval, the failures aren't reported, most
+// likely the site isn't served over https. The https link redirecting back
+// to http is no good either, not to flip-flop between the two.
+func (w *Worker) upgradeToHTTPS(feedID int64) {
+	// the link may have changed, redirected permanently
+	feed := w.db.GetFeed(feedID)
+	if feed == nil || !strings.HasPrefix(feed.FeedLink, "http://") || !w.db.GetSettingsValueBool("https_upgrade") {
+		return
+	}
+	settings := w.db.GetFeedSettings(feed.Id)
+	if settings.KeepHTTP {
+		return
+	}
+	now := time.Now()
+	if !w.db.StartFeedHTTPSCheck(feed.Id, now, now.Add(-httpsUpgradeInterval)) {
+		return
+	}
+
+	link := "https://" + strings.TrimPrefix(feed.FeedLink, "http://")
+	fields := logger.Fields{"feed_id": feed.Id, "from": feed.FeedLink, "to": link}
+	// not the refresh's context, the failures aren't the host's nor the feed's
+	if err := checkFeedLink(w.ctx, link, settings); err != nil {
+		fields["error"] = err
+		logger.With(fields).Debug("feed not served over https")
+		return
+	}
+	if !w.db.UpdateFeedLink(feed.Id, link) {
+		// most likely subscribed to the https link already
+		logger.With(fields).Warn("failed to upgrade the feed to https")
+		return
+	}
+	logger.With(fields).Info("feed upgraded to https")
+}
+
+// checkFeedLink tells whether the feed is served at the https link.
+func checkFeedLink(ctx context.Context, link string, settings storage.FeedSettings) error {
+	ctx = WithFeedConnection(ctx, settings)
+	if settings.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withTimeout(ctx, time.Duration(settings.Timeout)*time.Second)
+		defer cancel()
+	}
+	res, err := client.do(ctx, link, feedHeader(settings))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d", res.StatusCode)
+	}
+	if res.Request.URL.Scheme != "https" {
+		return errors.New("redirected back to http")
+	}
+	_, err = parser.ParseAndFix(res.Body, link, getCharset(res), nil)
+	return err
+}
@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestUpgradeToHTTPS(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/back.xml":
+			http.Redirect(w, r, "http://"+r.Host+"/feed.xml", http.StatusFound)
+		case "/feed.xml":
+			w.Write([]byte(`<rss><channel><item><guid>1</guid></item></channel></rss>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func(c *Client) { client = c }(client)
+	client = newTestClient(server, ClientOptions{})
+	// the same host and port over http
+	base := "http://" + strings.TrimPrefix(server.URL, "https://")
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	w := NewWorker(db)
+	defer w.Stop()
+	link := func(feed *storage.Feed) string {
+		return db.GetFeed(feed.Id).FeedLink
+	}
+
+	feed := db.CreateFeed("", "", "", base+"/feed.xml", nil)
+	w.upgradeToHTTPS(feed.Id)
+	if got := link(feed); got != server.URL+"/feed.xml" {
+		t.Fatalf("expected the feed upgraded, got %s", got)
+	}
+
+	for _, path := range []string{"/back.xml", "/missing.xml"} {
+		feed := db.CreateFeed("", "", "", base+path, nil)
+		w.upgradeToHTTPS(feed.Id)
+		if got := link(feed); got != base+path {
+			t.Fatalf("expected the feed kept, got %s", got)
+		}
+	}
+
+	// tried once in a while
+	requests = 0
+	feed = db.CreateFeed("", "", "", base+"/missing.xml?again", nil)
+	w.upgradeToHTTPS(feed.Id)
+	w.upgradeToHTTPS(feed.Id)
+	if requests != 1 {
+		t.Fatalf("expected a single try, got %d requests", requests)
+	}
+
+	requests = 0
+	kept := db.CreateFeed("", "", "", base+"/kept.xml", nil)
+	db.UpdateFeedSettings(kept.Id, storage.FeedSettings{KeepHTTP: true})
+	w.upgradeToHTTPS(kept.Id)
+	other := db.CreateFeed("", "", "", base+"/other.xml", nil)
+	db.UpdateSettings(map[string]interface{}{"https_upgrade": false})
+	w.upgradeToHTTPS(other.Id)
+	if requests != 0 {
+		t.Fatalf("expected no tries, got %d requests", requests)
+	}
+}
@@ -1,27 +1,180 @@
 package worker
 
 import (
-	"log"
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/nkanaev/yarr/src/logger"
 	"github.com/nkanaev/yarr/src/storage"
 )
 
-const NUM_WORKERS = 4
+const (
+	NUM_WORKERS = 4
+	MAX_WORKERS = 64
+)
+
+// default number of feeds fetched at once
+var numWorkers = defaultNumWorkers()
+
+// defaultNumWorkers is the number of cpus, but no less than NUM_WORKERS:
+// the fetch is mostly waiting for the network.
+func defaultNumWorkers() int {
+	if n := runtime.NumCPU(); n > NUM_WORKERS {
+		return clampWorkers(n)
+	}
+	return NUM_WORKERS
+}
+
+// SetNumWorkers sets the default number of feeds fetched at once
+// by the refreshes started afterwards, clamped to 1..MAX_WORKERS.
+func SetNumWorkers(n int) {
+	if n > 0 {
+		numWorkers = clampWorkers(n)
+	}
+}
+
+func clampWorkers(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > MAX_WORKERS {
+		return MAX_WORKERS
+	}
+	return n
+}
+
+// Feeds taking longer than that to fetch are reported in the logs.
+const slowFeedDuration = time.Second * 10
+
+var (
+	ErrUnknownFeed   = errors.New("unknown feed")
+	ErrUnknownFolder = errors.New("unknown folder")
+)
 
 type Worker struct {
 	db      *storage.Storage
 	pending *int32
-	refresh *time.Ticker
 	reflock sync.Mutex
-	stopper chan bool
+	// the last refresh started, guarded by reflock
+	current *refreshRun
+	// the feeds refreshed on their own by RefreshFeed, guarded by reflock
+	single map[int64]bool
+	// number of feeds fetched at once set by SetWorkers, 0 for the default
+	workers  int32
+	favicons faviconSearch
+	sched    schedule
+	progress progressTracker
+	events   *EventBus
+	jobs     jobList
+	images   imageFetcher
+	offline  offlineImages
+	hooks    hookQueue
+	traces   fetchTraces
+	// the hosts failing during the refreshes
+	breaker *circuitBreaker
+
+	// cancelled on Stop, aborting the requests in flight
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+type feedResult struct {
+	feed  storage.Feed
+	items []storage.Item
+	err   error
+	// not fetched (or aborted) because the refresh was stopped,
+	// or asked to retry later
+	skipped  bool
+	duration time.Duration
+}
+
+// faviconSearch is the lookup of the missing feed icons.
+type faviconSearch struct {
+	// 1 while the lookup is running
+	running int32
+	// the feeds left to look up
+	pending int32
+}
+
+// refreshRun is the refresh in progress, cancelled by StopRefresh.
+type refreshRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 func NewWorker(db *storage.Storage) *Worker {
 	pending := int32(0)
-	return &Worker{db: db, pending: &pending}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Worker{
+		db:      db,
+		pending: &pending,
+		events:  NewEventBus(),
+		sched:   newSchedule(),
+		single:  make(map[int64]bool),
+		breaker: newCircuitBreaker(),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Stop stops the auto-refresh and the feed cleaner, cancels the refresh in progress
+// and waits for the refresher and the maintenance jobs to store what they've got.
+func (w *Worker) Stop() {
+	w.Shutdown(context.Background())
+}
+
+// Shutdown is Stop giving up on waiting once the ctx is done.
+// Once everything is stored, indexes the items not in the search yet.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.stopScheduler()
+
+	w.reflock.Lock()
+	w.cancel()
+	w.reflock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if w.db != nil {
+		w.db.SyncSearch()
+	}
+	return nil
+}
+
+// SetWorkers sets the number of feeds fetched at once by the refreshes started
+// afterwards, clamped to 1..MAX_WORKERS. 0 goes back to the default set by SetNumWorkers.
+func (w *Worker) SetWorkers(n int64) {
+	if n > 0 {
+		n = int64(clampWorkers(int(n)))
+	} else {
+		n = 0
+	}
+	atomic.StoreInt32(&w.workers, int32(n))
+}
+
+func (w *Worker) numWorkers() int {
+	if n := atomic.LoadInt32(&w.workers); n > 0 {
+		return int(n)
+	}
+	return numWorkers
+}
+
+func (w *Worker) Events() *EventBus {
+	return w.events
 }
 
 func (w *Worker) FeedsPending() int32 {
@@ -29,118 +182,538 @@ func (w *Worker) FeedsPending() int32 {
 }
 
 func (w *Worker) StartFeedCleaner() {
-	go w.db.DeleteOldItems()
+	go w.cleanup()
 	ticker := time.NewTicker(time.Hour * 24)
 	go func() {
+		defer ticker.Stop()
 		for {
-			<-ticker.C
-			w.db.DeleteOldItems()
+			select {
+			case <-ticker.C:
+				w.cleanup()
+			case <-w.ctx.Done():
+				return
+			}
 		}
 	}()
 }
 
+// cleanup is the daily maintenance: deletes the old items, transfer totals
+// and the images of the items no longer starred, reports the dead feeds
+// and refreshes the stale favicons.
+func (w *Worker) cleanup() {
+	w.db.DeleteOldItems()
+	w.db.DeleteOldTransfers()
+	w.db.DeleteOldFeedErrors(time.Now())
+	w.db.DeleteUnusedImages()
+	if dead := w.db.ReportDeadFeeds(time.Now()); len(dead) > 0 {
+		logger.With(logger.Fields{"feeds": len(dead)}).Info("found dead feeds")
+	}
+	w.refreshFavicons(time.Now())
+}
+
+// FindFavicons looks up the icons of the feeds without one in the background,
+// as many at once as the feeds fetched by the refresh and limited per host the same way.
+// Does nothing if the lookup is running already.
 func (w *Worker) FindFavicons() {
+	w.findFavicons(w.db.ListFeedsMissingIcons)
+}
+
+// refreshFavicons looks up anew the icons fetched more than `favicon_refresh_days`
+// ago (0 turns it off) and the ones not found last time.
+func (w *Worker) refreshFavicons(now time.Time) {
+	days := w.db.GetSettingsValueInt64("favicon_refresh_days")
+	if days <= 0 {
+		return
+	}
+	w.findFavicons(func() []storage.Feed {
+		return w.db.ListFeedsStaleIcons(now.AddDate(0, 0, -int(days)))
+	})
+}
+
+func (w *Worker) findFavicons(list func() []storage.Feed) {
+	if !atomic.CompareAndSwapInt32(&w.favicons.running, 0, 1) {
+		return
+	}
+	w.reflock.Lock()
+	if w.ctx.Err() != nil {
+		w.reflock.Unlock()
+		atomic.StoreInt32(&w.favicons.running, 0)
+		return
+	}
+	w.running.Add(1)
+	w.reflock.Unlock()
+
 	go func() {
-		for _, feed := range w.db.ListFeedsMissingIcons() {
-			w.FindFeedFavicon(feed)
+		defer w.running.Done()
+		defer atomic.StoreInt32(&w.favicons.running, 0)
+
+		feeds := interleaveHosts(list())
+		atomic.StoreInt32(&w.favicons.pending, int32(len(feeds)))
+		queue := make(chan storage.Feed, len(feeds))
+		for _, feed := range feeds {
+			queue <- feed
+		}
+		close(queue)
+
+		hosts := newHostLimiter(int(w.db.GetSettingsValueInt64("requests_per_host")))
+		var wg sync.WaitGroup
+		for i := 0; i < w.numWorkers(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for feed := range queue {
+					host := feedHost(feed.FeedLink)
+					if w.ctx.Err() == nil && hosts.acquire(w.ctx, host) {
+						w.FindFeedFavicon(feed)
+						hosts.release(host)
+					}
+					atomic.AddInt32(&w.favicons.pending, -1)
+				}
+			}()
 		}
+		wg.Wait()
 	}()
 }
 
+// FaviconsPending returns the number of the feeds left to look up the icons of.
+func (w *Worker) FaviconsPending() int32 {
+	return atomic.LoadInt32(&w.favicons.pending)
+}
+
 func (w *Worker) FindFeedFavicon(feed storage.Feed) {
-	icon, err := findFavicon(feed.Link, feed.FeedLink)
+	ctx, meter := WithTransferMeter(WithFeedConnection(w.ctx, w.db.GetFeedSettings(feed.Id)))
+	icon, err := findFavicon(ctx, feed.Link, feed.FeedLink)
+	w.db.AddFeedTransfer(feed.Id, meter.Bytes(), time.Now())
+	if ctx.Err() != nil {
+		// stopping
+		return
+	}
 	if err != nil {
-		log.Printf("Failed to find favicon for %s (%s): %s", feed.FeedLink, feed.Link, err)
+		logger.With(logger.Fields{
+			"feed_id": feed.Id,
+			"url":     feed.FeedLink,
+			"link":    feed.Link,
+			"error":   err,
+		}).Warn("failed to find favicon")
 	}
-	if icon != nil {
+	if icon != nil && len(*icon) == 0 && feed.HasIcon {
+		// not found this time, the old one is better than none
+		w.db.TouchFeedIcon(feed.Id)
+	} else if icon != nil {
 		w.db.UpdateFeedIcon(feed.Id, icon)
 	}
 }
 
-func (w *Worker) SetRefreshRate(minute int64) {
-	if w.stopper != nil {
-		w.refresh.Stop()
-		w.refresh = nil
-		w.stopper <- true
-		w.stopper = nil
+// AddFeed discovers the feed at the url and subscribes to it, storing its current items.
+// If the page links to several feeds, nothing is added and the candidates are returned instead.
+// The url looking like a feed but failing to parse is told by *FeedParseError.
+// The feed settings (e.g. the credentials) are used for the discovery and kept,
+// the credentials embedded in the url are moved to them.
+// The discovery is given up on once the context is done.
+func (w *Worker) AddFeed(ctx context.Context, url string, folderID *int64, settings storage.FeedSettings) (*storage.Feed, []FeedSource, error) {
+	url, settings = storage.StripCredentials(url, settings)
+	result, err := DiscoverFeedWithContext(ctx, url, settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(result.Sources) > 0 {
+		return nil, result.Sources, nil
+	}
+	if result.ParseError != nil {
+		return nil, nil, &FeedParseError{Err: result.ParseError, Body: result.BodyStart}
+	}
+	if result.Feed == nil {
+		return nil, nil, errors.New("No feeds found at the given url")
+	}
+
+	feed := w.db.CreateFeed(
+		result.Feed.Title,
+		"",
+		result.Feed.SiteURL,
+		result.FeedLink,
+		folderID,
+	)
+	if feed == nil {
+		return nil, nil, errors.New("failed to store the feed")
+	}
+	if !reflect.DeepEqual(settings, storage.FeedSettings{}) {
+		w.db.UpdateFeedSettings(feed.Id, settings)
+	}
+	items := ConvertItems(result.Feed.Items, *feed, w.db.GetSettingsValueBool("strip_trackers"))
+	if len(items) > 0 {
+		created := w.createItems(*feed, items)
+		w.db.SetFeedSize(feed.Id, len(items))
+		w.db.SyncSearch()
+		if len(created) > 0 {
+			w.events.Publish(EventNewItems, NewItemsEvent{
+				FeedID: feed.Id,
+				Count:  len(created),
+			})
+		}
 	}
+	w.FindFeedFavicon(*feed)
+	return feed, nil, nil
+}
+
+func (w *Worker) RefreshFeeds() {
+	w.reflock.Lock()
+	defer w.reflock.Unlock()
 
-	if minute == 0 {
+	if !w.canRefresh() {
+		return
+	}
+	feeds := w.DueFeeds(w.db.ListFeeds(), time.Now())
+	if len(feeds) == 0 {
+		logger.Debugf("nothing to refresh")
 		return
 	}
+	go w.refresher(w.startRefresh(feeds), feeds)
+}
 
-	w.stopper = make(chan bool)
-	w.refresh = time.NewTicker(time.Minute * time.Duration(minute))
+// RefreshFolder refreshes the feeds of the folder in the background,
+// the same way RefreshFeeds does for all of them. Returns ErrBusy
+// if another refresh or a maintenance job is in progress.
+func (w *Worker) RefreshFolder(folderID int64) error {
+	known := false
+	for _, folder := range w.db.ListFolders() {
+		if folder.Id == folderID {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return ErrUnknownFolder
+	}
 
-	go func(fire <-chan time.Time, stop <-chan bool, m int64) {
-		log.Printf("auto-refresh %dm: starting", m)
-		for {
-			select {
-			case <-fire:
-				log.Printf("auto-refresh %dm: firing", m)
-				w.RefreshFeeds()
-			case <-stop:
-				log.Printf("auto-refresh %dm: stopping", m)
-				return
-			}
+	w.reflock.Lock()
+	defer w.reflock.Unlock()
+
+	if w.FeedsPending() > 0 || w.jobRunning() {
+		return ErrBusy
+	}
+	if w.ctx.Err() != nil {
+		return ErrStopped
+	}
+	feeds := make([]storage.Feed, 0)
+	for _, feed := range w.db.ListFeeds() {
+		if feed.FolderId != nil && *feed.FolderId == folderID {
+			feeds = append(feeds, feed)
 		}
-	}(w.refresh.C, w.stopper, minute)
+	}
+	feeds = w.DueFeeds(feeds, time.Now())
+	if len(feeds) == 0 {
+		logger.Debugf("nothing to refresh in folder %d", folderID)
+		return nil
+	}
+	go w.refresher(w.startRefresh(feeds), feeds)
+	return nil
 }
 
-func (w *Worker) RefreshFeeds() {
+// RefreshFailedFeeds refreshes in the background the feeds that failed last time
+// they were fetched, leaving out the ones DueFeeds does. The ones failing again
+// keep counting their failures. Returns ErrBusy if another refresh or
+// a maintenance job is in progress.
+func (w *Worker) RefreshFailedFeeds() error {
 	w.reflock.Lock()
 	defer w.reflock.Unlock()
 
-	if *w.pending > 0 {
-		log.Print("Refreshing already in progress")
-		return
+	if w.FeedsPending() > 0 || w.jobRunning() {
+		return ErrBusy
+	}
+	if w.ctx.Err() != nil {
+		return ErrStopped
+	}
+	failed := w.db.GetFeedErrors()
+	feeds := make([]storage.Feed, 0, len(failed))
+	for _, feed := range w.db.ListFeeds() {
+		if _, ok := failed[feed.Id]; ok {
+			feeds = append(feeds, feed)
+		}
+	}
+	feeds = w.DueFeeds(feeds, time.Now())
+	if len(feeds) == 0 {
+		logger.Debugf("no failed feeds to refresh")
+		return nil
 	}
+	go w.refresher(w.startRefresh(feeds), feeds)
+	return nil
+}
+
+// DueFeeds leaves out the paused, the suspended and the gone feeds, the feeds asked
+// to retry later, the feeds still fresh as told by the server and the feeds with
+// their own refresh interval refreshed less than the interval ago.
+func (w *Worker) DueFeeds(feeds []storage.Feed, now time.Time) []storage.Feed {
+	return w.dueFeeds(feeds, now, 0, false)
+}
 
-	feeds := w.db.ListFeeds()
+// RefreshFeedsAndWait refreshes the feeds and returns the summary once done.
+// Returns false if another refresh or a maintenance job is in progress.
+func (w *Worker) RefreshFeedsAndWait(feeds []storage.Feed) (RefreshFinishedEvent, bool) {
+	w.reflock.Lock()
+	if !w.canRefresh() {
+		w.reflock.Unlock()
+		return RefreshFinishedEvent{}, false
+	}
 	if len(feeds) == 0 {
-		log.Print("Nothing to refresh")
-		return
+		w.reflock.Unlock()
+		return RefreshFinishedEvent{Updated: make([]NewItemsEvent, 0)}, true
+	}
+	run := w.startRefresh(feeds)
+	w.reflock.Unlock()
+	return w.refresher(run, feeds), true
+}
+
+// RefreshFeed fetches the feed and stores its new items, returning once done.
+// Runs independently of the refresh in progress, leaving the pending counter
+// alone. Returns ErrBusy if the feed is being refreshed on its own already
+// or a maintenance job is in progress.
+func (w *Worker) RefreshFeed(feedID int64) (FeedDoneEvent, error) {
+	feed := w.db.GetFeed(feedID)
+	if feed == nil {
+		return FeedDoneEvent{}, ErrUnknownFeed
 	}
 
-	log.Print("Refreshing feeds")
+	w.reflock.Lock()
+	if w.single[feedID] || w.jobRunning() {
+		w.reflock.Unlock()
+		return FeedDoneEvent{}, ErrBusy
+	}
+	if w.ctx.Err() != nil {
+		w.reflock.Unlock()
+		return FeedDoneEvent{}, ErrStopped
+	}
+	w.single[feedID] = true
+	w.running.Add(1)
+	w.reflock.Unlock()
+
+	defer func() {
+		w.reflock.Lock()
+		delete(w.single, feedID)
+		w.reflock.Unlock()
+		w.running.Done()
+	}()
+
+	done := w.storeResult(w.fetchFeed(w.ctx, *feed))
+	if done.NewItems > 0 {
+		w.events.Publish(EventNewItems, NewItemsEvent{FeedID: done.FeedID, Count: done.NewItems})
+	}
+	return done, nil
+}
+
+// canRefresh must be called with reflock held.
+func (w *Worker) canRefresh() bool {
+	if *w.pending > 0 {
+		logger.Infof("refreshing already in progress")
+		return false
+	}
+	if w.jobRunning() {
+		logger.Infof("maintenance job in progress, skipping refresh")
+		return false
+	}
+	return w.ctx.Err() == nil
+}
+
+// startRefresh must be called with reflock held, followed by the refresher.
+func (w *Worker) startRefresh(feeds []storage.Feed) *refreshRun {
+	logger.With(logger.Fields{"feeds": len(feeds)}).Info("refreshing feeds")
 	atomic.StoreInt32(w.pending, int32(len(feeds)))
-	go w.refresher(feeds)
+	w.events.Publish(EventRefreshStarted, map[string]int{"total": len(feeds)})
+	w.running.Add(1)
+
+	ids := make([]int64, len(feeds))
+	for i, feed := range feeds {
+		ids[i] = feed.Id
+	}
+	w.progress.reset(ids)
+
+	ctx, cancel := context.WithCancel(w.ctx)
+	w.current = &refreshRun{ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	return w.current
 }
 
-func (w *Worker) refresher(feeds []storage.Feed) {
-	w.db.ResetFeedErrors()
+// StopRefresh cancels the refresh in progress and waits for it to wind down:
+// the requests in flight are aborted and the feeds not fetched yet are skipped,
+// the items stored so far are kept. Returns false if there's nothing to stop.
+func (w *Worker) StopRefresh() bool {
+	w.reflock.Lock()
+	defer w.reflock.Unlock()
+
+	run := w.current
+	if run == nil {
+		return false
+	}
+	select {
+	case <-run.done:
+		return false
+	default:
+	}
+	logger.Infof("stopping the refresh")
+	run.cancel()
+	// holding the lock, the next refresh doesn't start until this one is over
+	<-run.done
+	return true
+}
 
+func (w *Worker) refresher(run *refreshRun, feeds []storage.Feed) RefreshFinishedEvent {
+	defer w.running.Done()
+	defer close(run.done)
+	defer run.cancel()
+	start := time.Now()
+	conns := atomic.LoadInt64(&dialedConns)
+	hits, misses := atomic.LoadInt64(&dnsHits), atomic.LoadInt64(&dnsMisses)
 	srcqueue := make(chan storage.Feed, len(feeds))
-	dstqueue := make(chan []storage.Item)
+	dstqueue := make(chan feedResult)
+	hosts := newHostLimiter(int(w.db.GetSettingsValueInt64("requests_per_host")))
+	w.breaker.reset(int(w.db.GetSettingsValueInt64("circuit_breaker_failures")))
+	ctx := withCircuitBreaker(run.ctx, w.breaker)
 
-	for i := 0; i < NUM_WORKERS; i++ {
-		go w.worker(srcqueue, dstqueue)
+	for i := 0; i < w.numWorkers(); i++ {
+		go w.worker(ctx, hosts, srcqueue, dstqueue)
 	}
 
-	for _, feed := range feeds {
+	for _, feed := range interleaveHosts(feeds) {
 		srcqueue <- feed
 	}
+	summary := RefreshFinishedEvent{Feeds: len(feeds), Updated: make([]NewItemsEvent, 0)}
 	for i := 0; i < len(feeds); i++ {
-		items := <-dstqueue
-		if len(items) > 0 {
-			w.db.CreateItems(items)
-			w.db.SetFeedSize(items[0].FeedId, len(items))
+		result := <-dstqueue
+		done := w.storeResult(result)
+		w.progress.done(done, result.skipped)
+		if result.skipped {
+			summary.Skipped++
+		}
+		if done.Error != "" {
+			summary.Errors++
+		}
+		if done.NewItems > 0 {
+			summary.NewItems += done.NewItems
+			summary.Updated = append(summary.Updated, NewItemsEvent{FeedID: done.FeedID, Count: done.NewItems})
 		}
 		atomic.AddInt32(w.pending, -1)
-		w.db.SyncSearch()
+		w.events.Publish(EventFeedDone, done)
 	}
 	close(srcqueue)
 	close(dstqueue)
 
-	log.Printf("Finished refreshing %d feeds", len(feeds))
+	logger.With(logger.Fields{
+		"feeds":       summary.Feeds,
+		"new_items":   summary.NewItems,
+		"errors":      summary.Errors,
+		"skipped":     summary.Skipped,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"connections": atomic.LoadInt64(&dialedConns) - conns,
+		"dns_hits":    atomic.LoadInt64(&dnsHits) - hits,
+		"dns_misses":  atomic.LoadInt64(&dnsMisses) - misses,
+	}).Info("finished refreshing feeds")
+	w.progress.finish()
+	w.events.Publish(EventRefreshFinished, summary)
+	return summary
 }
 
-func (w *Worker) worker(srcqueue <-chan storage.Feed, dstqueue chan<- []storage.Item) {
+func (w *Worker) worker(ctx context.Context, hosts *hostLimiter, srcqueue <-chan storage.Feed, dstqueue chan<- feedResult) {
 	for feed := range srcqueue {
-		items, err := listItems(feed, w.db)
+		host := feedHost(feed.FeedLink)
+		if ctx.Err() != nil || !hosts.acquire(ctx, host) {
+			dstqueue <- feedResult{feed: feed, skipped: true}
+			continue
+		}
+		w.progress.set(feed.Id, FeedFetching)
+		stage := func(state string) { w.progress.set(feed.Id, state) }
+		result := w.fetchFeed(withStage(ctx, stage), feed)
+		hosts.release(host)
+		dstqueue <- result
+	}
+}
+
+// FeedTrace returns the trace of the last fetch of the feed debugged
+// (since started), nil if none.
+func (w *Worker) FeedTrace(feedID int64) *FetchTrace {
+	return w.traces.get(feedID)
+}
+
+// fetchFeed fetches and parses the feed, recording the error if it fails.
+func (w *Worker) fetchFeed(ctx context.Context, feed storage.Feed) feedResult {
+	start := time.Now()
+	var trace *FetchTrace
+	if client.debug || w.db.GetFeedSettings(feed.Id).Debug {
+		trace = &FetchTrace{Time: start, Requests: make([]*TracedRequest, 0)}
+		ctx = withFetchTrace(ctx, trace)
+	}
+	items, err := listItems(ctx, feed, w.db)
+	duration := time.Since(start)
+	if trace != nil {
+		trace.DurationMs = duration.Milliseconds()
 		if err != nil {
-			w.db.SetFeedError(feed.Id, err)
+			trace.Error = err.Error()
 		}
-		dstqueue <- items
+		trace.log(feed.Id)
+		w.traces.set(feed.Id, trace)
+	}
+
+	fields := logger.Fields{
+		"feed_id":     feed.Id,
+		"url":         feed.FeedLink,
+		"duration_ms": duration.Milliseconds(),
+	}
+	skipped := false
+	var later *retryLaterError
+	if err != nil && ctx.Err() != nil {
+		// stopping, not the feed's fault
+		err, skipped = nil, true
+	} else if errors.As(err, &later) {
+		// not an error, the feed is left alone until then
+		fields["retry_after"] = later.until.Format(time.RFC3339)
+		logger.With(fields).Info("feed asked to retry later")
+		err, skipped = nil, true
+	} else if errors.Is(err, errFeedGone) {
+		w.db.SetFeedError(feed.Id, err)
+		w.db.SetFeedGone(feed.Id, true)
+		logger.With(fields).Warn("feed is gone, not refreshing it anymore")
+	} else if isCircuitOpen(err) {
+		// not fetched, not counting towards the suspension
+		w.db.SetFeedError(feed.Id, err)
+		fields["error"] = err
+		logger.With(fields).Warn("skipped feed of failing host")
+	} else if err != nil {
+		w.db.SetFeedError(feed.Id, err)
+		fields["error"] = err
+		logger.With(fields).Warn("failed to refresh feed")
+		if w.db.SuspendFailingFeed(feed.Id) {
+			logger.With(fields).Warn("suspended failing feed")
+		}
+	} else if duration > slowFeedDuration {
+		logger.With(fields).Warn("slow feed")
+	} else {
+		logger.With(fields).Debug("refreshed feed")
+	}
+	if err == nil && !skipped {
+		w.upgradeToHTTPS(feed.Id)
+	}
+	return feedResult{feed: feed, items: items, err: err, skipped: skipped, duration: duration}
+}
+
+// storeResult stores the new items of the fetched feed
+// and passes them on to the image fetcher and the hook.
+func (w *Worker) storeResult(result feedResult) FeedDoneEvent {
+	done := FeedDoneEvent{FeedID: result.feed.Id}
+	if !result.skipped {
+		w.sched.setFetched(result.feed.Id, time.Now())
+	}
+	if len(result.items) > 0 {
+		created := w.createItems(result.feed, result.items)
+		done.NewItems = len(created)
+		w.fetchImages(result.feed, created)
+		w.runHook(result.feed, created)
+		w.db.SetFeedSize(result.feed.Id, len(result.items))
+	}
+	if result.err != nil {
+		done.Error = result.err.Error()
+	} else if !result.skipped {
+		// the skipped feeds (stopped, asked to retry later) keep the last error,
+		// to be retried with the failed ones
+		w.db.ResetFeedErrors([]int64{result.feed.Id})
+		w.db.SetFeedRefreshed(result.feed.Id, time.Now(), result.duration, done.NewItems)
 	}
+	w.db.SyncSearch()
+	return done
 }
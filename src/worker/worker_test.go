@@ -0,0 +1,591 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nkanaev/yarr/src/logger"
+	"github.com/nkanaev/yarr/src/storage"
+)
+
+func TestStopRefresh(t *testing.T) {
+	slowStarted := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			slowStarted <- true
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte(`<rss><channel><item><guid>` + r.URL.Path + `</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	fast := db.CreateFeed("1", "", "", server.URL+"/fast", nil)
+	slow := db.CreateFeed("2", "", "", server.URL+"/slow", nil)
+	queued := db.CreateFeed("3", "", "", server.URL+"/queued", nil)
+	for _, feed := range []*storage.Feed{fast, slow, queued} {
+		db.SetFeedError(feed.Id, errors.New("timed out"))
+	}
+
+	defer SetNumWorkers(numWorkers)
+	SetNumWorkers(1)
+	w := NewWorker(db)
+	defer w.Stop()
+	sub := w.Events().Subscribe()
+
+	if w.StopRefresh() {
+		t.Fatal("expected nothing to stop")
+	}
+	w.RefreshFeeds()
+	<-slowStarted
+	start := time.Now()
+	if !w.StopRefresh() {
+		t.Fatal("expected the refresh stopped")
+	}
+	if time.Since(start) > 2*time.Second || w.FeedsPending() != 0 {
+		t.Fatalf("expected the refresh over, took %s, %d pending", time.Since(start), w.FeedsPending())
+	}
+
+	var summary RefreshFinishedEvent
+	for event := range sub.C {
+		if event.Name == EventRefreshFinished {
+			summary = event.Data.(RefreshFinishedEvent)
+			break
+		}
+	}
+	if summary.NewItems != 1 || summary.Skipped != 2 || summary.Errors != 0 {
+		t.Fatalf("unexpected summary: %#v", summary)
+	}
+	// the skipped feeds keep the last error, to be retried with the failed ones
+	if errors := db.GetFeedErrors(); len(errors) != 2 || errors[slow.Id] == "" || errors[queued.Id] == "" {
+		t.Fatalf("expected the errors of the skipped feeds kept, got %#v", errors)
+	}
+
+	// the next refresh starts right away
+	if summary, ok := w.RefreshFeedsAndWait([]storage.Feed{*queued}); !ok || summary.NewItems != 1 {
+		t.Fatalf("unexpected refresh after stop: %#v %v", summary, ok)
+	}
+}
+
+func TestRefreshFeed(t *testing.T) {
+	broken := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`<rss><channel><item><guid>` + r.URL.Path + `</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("1", "", "", server.URL+"/1", nil)
+	other := db.CreateFeed("2", "", "", server.URL+"/2", nil)
+
+	w := NewWorker(db)
+	defer w.Stop()
+
+	if _, err := w.RefreshFeed(100); err != ErrUnknownFeed {
+		t.Fatalf("expected unknown feed, got %v", err)
+	}
+
+	broken = true
+	done, err := w.RefreshFeed(feed.Id)
+	if err != nil || done.Error == "" || db.GetFeedErrors()[feed.Id] == "" {
+		t.Fatalf("expected the feed error stored, got %#v %v", done, err)
+	}
+
+	broken = false
+	done, err = w.RefreshFeed(feed.Id)
+	if err != nil || done.NewItems != 1 || done.Error != "" {
+		t.Fatalf("unexpected result: %#v %v", done, err)
+	}
+	if len(db.GetFeedErrors()) != 0 {
+		t.Fatalf("expected the feed error reset, got %#v", db.GetFeedErrors())
+	}
+	if db.CountItems(storage.ItemFilter{FeedID: &other.Id}) != 0 {
+		t.Fatal("expected the other feed left alone")
+	}
+	refreshed := db.ListFeeds()[0]
+	if refreshed.LastRefreshed == nil || refreshed.LastNewItems != 1 {
+		t.Fatalf("expected the refresh recorded, got %#v", refreshed)
+	}
+
+	w.RefreshFeed(feed.Id)
+	again := db.ListFeeds()[0]
+	if again.LastNewItems != 0 || again.LastRefreshed.Before(*refreshed.LastRefreshed) {
+		t.Fatalf("expected the refresh without new items recorded, got %#v", again)
+	}
+	broken = true
+	w.RefreshFeed(feed.Id)
+	if failed := db.ListFeeds()[0]; failed.LastRefreshed == nil || !failed.LastRefreshed.Equal(*again.LastRefreshed) {
+		t.Fatalf("expected the failed refresh not recorded, got %#v", failed)
+	}
+	if w.FeedsPending() != 0 {
+		t.Fatalf("expected nothing pending, got %d", w.FeedsPending())
+	}
+}
+
+func TestRefreshLargeFeed(t *testing.T) {
+	var feed bytes.Buffer
+	feed.WriteString(`<rss><channel>`)
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&feed, `<item><guid>%d</guid><title>item %d</title></item>`, i, i)
+	}
+	feed.WriteString(`</channel></rss>`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(feed.Bytes())
+	}))
+	defer server.Close()
+
+	var logs, entries bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	logger.SetOutput(&entries)
+	defer logger.SetOutput(os.Stderr)
+	db, err := storage.New(filepath.Join(t.TempDir(), "yarr.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := db.CreateFeed("", "", "", server.URL, nil)
+	other := db.CreateFeed("", "", "", "http://other.example/feed.xml", nil)
+
+	w := NewWorker(db)
+	defer w.Stop()
+
+	// the ui keeps reading and writing meanwhile
+	stop := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				db.ListItems(storage.ItemFilter{FeedID: &large.Id}, 20, true, false)
+				db.SetFeedError(other.Id, errors.New("status code 500"))
+			}
+		}
+	}()
+	done, err := w.RefreshFeed(large.Id)
+	close(stop)
+	<-stopped
+
+	if err != nil || done.Error != "" || done.NewItems != 5000 {
+		t.Fatalf("unexpected result: %#v %v", done, err)
+	}
+	if count := db.CountItems(storage.ItemFilter{FeedID: &large.Id}); count != 5000 {
+		t.Fatalf("expected all the items stored, got %d", count)
+	}
+	if strings.Contains(logs.String()+entries.String(), "database is locked") {
+		t.Fatalf("unexpected lock error:\n%s%s", logs.String(), entries.String())
+	}
+}
+
+func TestRefreshFolder(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`<rss><channel><item><guid>` + r.URL.Path + `</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	folder := db.CreateFolder("News")
+	inside := db.CreateFeed("1", "", "", server.URL+"/1", &folder.Id)
+	outside := db.CreateFeed("2", "", "", server.URL+"/2", nil)
+	db.SetFeedError(inside.Id, errors.New("old"))
+	db.SetFeedError(outside.Id, errors.New("old"))
+
+	w := NewWorker(db)
+	defer w.Stop()
+	sub := w.Events().Subscribe()
+
+	if err := w.RefreshFolder(100); err != ErrUnknownFolder {
+		t.Fatalf("expected unknown folder, got %v", err)
+	}
+	if err := w.RefreshFolder(folder.Id); err != nil {
+		t.Fatal(err)
+	}
+	if w.FeedsPending() != 1 {
+		t.Fatalf("expected 1 feed pending, got %d", w.FeedsPending())
+	}
+	if err := w.RefreshFolder(folder.Id); err != ErrBusy {
+		t.Fatalf("expected the overlapping refresh refused, got %v", err)
+	}
+	close(release)
+
+	var summary RefreshFinishedEvent
+	for event := range sub.C {
+		if event.Name == EventRefreshFinished {
+			summary = event.Data.(RefreshFinishedEvent)
+			break
+		}
+	}
+	if summary.Feeds != 1 || summary.NewItems != 1 {
+		t.Fatalf("unexpected summary: %#v", summary)
+	}
+	if errors := db.GetFeedErrors(); len(errors) != 1 || errors[outside.Id] != "old" {
+		t.Fatalf("expected the error outside the folder kept, got %#v", errors)
+	}
+}
+
+func TestRefreshFailedFeeds(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		if r.URL.Path == "/failing" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`<rss><channel><item><guid>` + r.URL.Path + `</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	db.CreateFeed("ok", "", "", server.URL+"/ok", nil)
+	recovered := db.CreateFeed("recovered", "", "", server.URL+"/recovered", nil)
+	failing := db.CreateFeed("failing", "", "", server.URL+"/failing", nil)
+	for _, feed := range []*storage.Feed{recovered, failing} {
+		db.SetFeedHealth(feed.Id, 0, feed.FeedLink, false, true)
+		db.SetFeedError(feed.Id, errors.New("timed out"))
+	}
+
+	w := NewWorker(db)
+	defer w.Stop()
+	sub := w.Events().Subscribe()
+
+	if err := w.RefreshFailedFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	if w.FeedsPending() != 2 {
+		t.Fatalf("expected 2 feeds pending, got %d", w.FeedsPending())
+	}
+	if err := w.RefreshFailedFeeds(); err != ErrBusy {
+		t.Fatalf("expected the overlapping refresh refused, got %v", err)
+	}
+	close(release)
+
+	for event := range sub.C {
+		if event.Name == EventRefreshFinished {
+			break
+		}
+	}
+	if errors := db.GetFeedErrors(); len(errors) != 1 || errors[failing.Id] != "status code 500" {
+		t.Fatalf("expected only the failing feed's error, got %#v", errors)
+	}
+	if history := db.ListFeedErrorHistory(failing.Id); len(history) != 2 || history[0].Failures != 2 {
+		t.Fatalf("expected the failures counted on, got %#v", history)
+	}
+}
+
+func TestAddFeedCredentials(t *testing.T) {
+	var pageAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			pageAuth = r.Header.Get("Authorization")
+			w.Write([]byte(`<html><head><link rel="alternate" type="application/rss+xml" href="/feed.xml"></head></html>`))
+			return
+		}
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`<rss><channel><title>private</title><item><guid>1</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	w := NewWorker(db)
+	defer w.Stop()
+
+	if _, _, err := w.AddFeed(context.Background(), server.URL+"/feed.xml", nil, storage.FeedSettings{}); err == nil {
+		t.Fatal("expected the feed to require the credentials")
+	}
+	feed, _, err := w.AddFeed(context.Background(), server.URL, nil, storage.FeedSettings{Username: "user", Password: "pass"})
+	if err != nil || feed.FeedLink != server.URL+"/feed.xml" {
+		t.Fatalf("expected the feed added, got %#v %v", feed, err)
+	}
+	if pageAuth != "" {
+		t.Fatalf("expected the credentials sent to the feed only, got %q", pageAuth)
+	}
+	if settings := db.GetFeedSettings(feed.Id); settings.Username != "user" || settings.Password != "pass" {
+		t.Fatalf("expected the credentials kept, got %#v", settings)
+	}
+
+	// moved out of the url
+	db.DeleteFeed(feed.Id)
+	embedded := strings.Replace(server.URL, "http://", "http://user:pass@", 1) + "/feed.xml"
+	feed, _, err = w.AddFeed(context.Background(), embedded, nil, storage.FeedSettings{})
+	if err != nil || feed.FeedLink != server.URL+"/feed.xml" {
+		t.Fatalf("expected the feed added without the credentials in the link, got %#v %v", feed, err)
+	}
+	if settings := db.GetFeedSettings(feed.Id); settings.Username != "user" || settings.Password != "pass" {
+		t.Fatalf("expected the credentials moved to the settings, got %#v", settings)
+	}
+}
+
+func TestNumWorkers(t *testing.T) {
+	defer SetNumWorkers(numWorkers)
+	SetNumWorkers(8)
+	w := NewWorker(nil)
+
+	for _, tc := range []struct {
+		set  int64
+		want int
+	}{
+		{0, 8},
+		{2, 2},
+		{1000, MAX_WORKERS},
+		{-1, 8},
+	} {
+		w.SetWorkers(tc.set)
+		if got := w.numWorkers(); got != tc.want {
+			t.Errorf("set %d: expected %d workers, got %d", tc.set, tc.want, got)
+		}
+	}
+	if SetNumWorkers(100); numWorkers != MAX_WORKERS {
+		t.Errorf("expected the default clamped, got %d", numWorkers)
+	}
+}
+
+func TestRefreshProgress(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			<-release
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`<rss><channel><item><guid>` + r.URL.Path + `</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	db.UpdateSettings(map[string]interface{}{"requests_per_host": 0})
+	slow := db.CreateFeed("1", "", "", server.URL+"/slow", nil)
+	fast := db.CreateFeed("2", "", "", server.URL+"/fast", nil)
+	broken := db.CreateFeed("3", "", "", server.URL+"/broken", nil)
+
+	w := NewWorker(db)
+	defer w.Stop()
+	if progress := w.RefreshProgress(); progress.Started != nil || len(progress.Feeds) != 0 {
+		t.Fatalf("expected no progress before the refresh, got %#v", progress)
+	}
+
+	sub := w.Events().Subscribe()
+	w.RefreshFeeds()
+	states := func() map[int64]string {
+		result := make(map[int64]string)
+		for _, feed := range w.RefreshProgress().Feeds {
+			result[feed.FeedID] = feed.State
+		}
+		return result
+	}
+	for done := 0; done < 2; {
+		if event := <-sub.C; event.Name == EventFeedDone {
+			done++
+		}
+	}
+	if got := states(); got[slow.Id] != FeedFetching || got[fast.Id] != FeedDone || got[broken.Id] != FeedError {
+		t.Fatalf("unexpected states during the refresh: %v", got)
+	}
+	close(release)
+	for event := range sub.C {
+		if event.Name == EventRefreshFinished {
+			break
+		}
+	}
+
+	progress := w.RefreshProgress()
+	if progress.Finished == nil || len(progress.Feeds) != 3 {
+		t.Fatalf("unexpected progress: %#v", progress)
+	}
+	for _, feed := range progress.Feeds {
+		if feed.Finished == nil || feed.Fetching == nil {
+			t.Fatalf("expected the timestamps set: %#v", feed)
+		}
+		if feed.FeedID == slow.Id && (feed.State != FeedDone || feed.NewItems != 1 || feed.Parsing == nil) {
+			t.Fatalf("unexpected progress of the slow feed: %#v", feed)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	feed := db.CreateFeed("", "", "", "http://example.com/feed", nil)
+
+	w := NewWorker(db)
+	release := make(chan bool)
+	_, err := w.startJob("stuck", func(context.Context, func(map[string]int64)) (map[string]int64, error) {
+		<-release
+		db.CreateItems([]storage.Item{{GUID: "1", FeedId: feed.Id, Title: "stored on the way out"}})
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the shutdown to give up, got %v", err)
+	}
+
+	close(release)
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	search := "stored"
+	if items := db.ListItems(storage.ItemFilter{Search: &search}, 10, false, false); len(items) != 1 {
+		t.Fatalf("expected the item indexed on shutdown, got %#v", items)
+	}
+}
+
+func TestFindFavicons(t *testing.T) {
+	var mu sync.Mutex
+	requests, running, maxRunning := 0, 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	for i := 0; i < 8; i++ {
+		db.CreateFeed("", "", "", fmt.Sprintf("%s/%d", server.URL, i), nil)
+	}
+	db.UpdateSettings(map[string]interface{}{"requests_per_host": 0})
+
+	defer SetNumWorkers(numWorkers)
+	SetNumWorkers(4)
+	w := NewWorker(db)
+	defer w.Stop()
+
+	w.FindFavicons()
+	// already running
+	w.FindFavicons()
+	for atomic.LoadInt32(&w.favicons.running) == 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if missing := db.ListFeedsMissingIcons(); len(missing) != 0 {
+		t.Fatalf("expected all the icons found, got %d missing", len(missing))
+	}
+	if requests != 8 || maxRunning != 4 {
+		t.Fatalf("expected 8 requests, 4 at once, got %d, %d at once", requests, maxRunning)
+	}
+	if w.FaviconsPending() != 0 {
+		t.Fatalf("expected nothing pending, got %d", w.FaviconsPending())
+	}
+}
+
+func TestRefreshFavicons(t *testing.T) {
+	found := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("\x89PNG\r\n\x1a\nnew"))
+	}))
+	defer server.Close()
+
+	log.SetOutput(io.Discard)
+	db, _ := storage.New(":memory:")
+	log.SetOutput(os.Stderr)
+	old := []byte("\x89PNG\r\n\x1a\nold")
+	rebranded := db.CreateFeed("", "", "", server.URL+"/rebranded", nil)
+	db.UpdateFeedIcon(rebranded.Id, &old)
+	missing := db.CreateFeed("", "", "", server.URL+"/missing", nil)
+	db.UpdateFeedIcon(missing.Id, &[]byte{})
+
+	w := NewWorker(db)
+	defer w.Stop()
+	refresh := func(now time.Time) {
+		w.refreshFavicons(now)
+		for atomic.LoadInt32(&w.favicons.running) == 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	icon := func(feed *storage.Feed) string {
+		if icon := db.GetFeed(feed.Id).Icon; icon != nil {
+			return string(*icon)
+		}
+		return ""
+	}
+
+	// the icon not found is looked up anew, the recent one is left alone
+	refresh(time.Now())
+	if icon(rebranded) != string(old) || icon(missing) != "" {
+		t.Fatalf("unexpected icons: %q %q", icon(rebranded), icon(missing))
+	}
+
+	// the failed lookup keeps the old icon
+	refresh(time.Now().AddDate(0, 0, 31))
+	if icon(rebranded) != string(old) {
+		t.Fatalf("expected the old icon kept, got %q", icon(rebranded))
+	}
+
+	found = true
+	refresh(time.Now().AddDate(0, 0, 31))
+	if icon(rebranded) != "\x89PNG\r\n\x1a\nnew" || icon(missing) != "\x89PNG\r\n\x1a\nnew" {
+		t.Fatalf("expected the icons updated, got %q %q", icon(rebranded), icon(missing))
+	}
+
+	db.UpdateSettings(map[string]interface{}{"favicon_refresh_days": 0})
+	found = false
+	db.UpdateFeedIcon(missing.Id, &[]byte{})
+	refresh(time.Now().AddDate(0, 0, 31))
+	if icon(rebranded) == "" || len(db.ListFeedsStaleIcons(time.Now().AddDate(0, 0, -1))) != 1 {
+		t.Fatal("expected the refresh turned off")
+	}
+}